@@ -1,23 +1,34 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/cloudflare/cloudflare-ingress-controller/internal/argotunnel"
 	"github.com/cloudflare/cloudflare-ingress-controller/internal/cloudflare"
 	"github.com/cloudflare/cloudflare-ingress-controller/internal/k8s"
+	"github.com/fsnotify/fsnotify"
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -25,13 +36,23 @@ import (
 	"golang.org/x/net/netutil"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
 )
 
 var version = "UNKNOWN"
 
+var started = time.Now()
+
+// serviceaccountnamespace holds the namespace of the pod in a cluster
+const serviceaccountnamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
 func main() {
 	name := filepath.Base(os.Args[0])
 	app := kingpin.New(name, "Cloudflare Argo-Tunnel Kubernetes ingress controller.")
@@ -40,26 +61,121 @@ func main() {
 	// variant (print version information)
 	variant := app.Command("version", "print version")
 
+	// rbac (print the controller rbac manifests)
+	rbac := app.Command("print-rbac", "print the ServiceAccount, ClusterRole, and ClusterRoleBinding used by the controller")
+	rbacname := rbac.Flag("name", "name of the rbac resources").Default("argo-tunnel").String()
+	rbacnamespace := rbac.Flag("namespace", "namespace of the service account").Default(v1.NamespaceDefault).String()
+	rbacadoptunclassed := rbac.Flag("adopt-unclassed-ingresses", "include the access used by --adopt-unclassed-ingresses").Bool()
+	rbacstatusenable := rbac.Flag("ingress-status-enable", "include the access used by --ingress-status-enable").Bool()
+	rbacdebugenable := rbac.Flag("debug-enable", "include the access used by the host drain of --debug-enable").Bool()
+	rbacduplicatepolicy := rbac.Flag("duplicate-controller-policy", "include the access used by the ingress class lease of --duplicate-controller-policy").Default(argotunnel.DuplicateControllerPolicyDefault).Enum(argotunnel.DuplicateControllerIgnore, argotunnel.DuplicateControllerWarn, argotunnel.DuplicateControllerRefuse)
+
+	// export (print the cloudflared configuration of an ingress)
+	export := app.Command("export", "print the cloudflared configuration of the tunnels derived from an ingress")
+	exportincluster := export.Flag("incluster", "use in-cluster configuration.").Bool()
+	exportkubeconfig := export.Flag("kubeconfig", "path to kubeconfig (if not in running inside a cluster)").Default(filepath.Join(os.Getenv("HOME"), ".kube", "config")).String()
+	exportkubeinsecure := export.Flag("kube-insecure-skip-tls-verify", "DEV ONLY: skip verification of the kubernetes api server certificate").Bool()
+	exportnamespace := export.Flag("namespace", "namespace of the ingress").Required().String()
+	exportingress := export.Flag("ingress", "name of the ingress").Required().String()
+	exportallowmissing := export.Flag("allow-missing-backend", "derive tunnels for backend services that do not exist yet or have no ready endpoints").Bool()
+	exportoriginsecret := k8s.ObjMixin(export.Flag("default-origin-secret", "default origin certificate secret <namespace>/<name>"))
+	exportoriginconfig := export.Flag("origin-secret-config", "host specific origin certificate defaults").String()
+	exportcompressionquality := export.Flag("compression-quality", "cross-stream compression used when an ingress omits the compression-quality annotation (0-3)").Default("0").Uint64()
+	exportedgeaddrs := export.Flag("edge-host-port", "edge address <host>:<port> dialed by tunnels, overrides edge discovery (repeatable)").Strings()
+	exportdefaulthostname := export.Flag("default-hostname", "host serving ingress rules without a host").String()
+	exportdefaultproto := export.Flag("default-proto", "origin protocol used when an ingress omits the proto annotation").Enum(argotunnel.ProtoHTTP, argotunnel.ProtoHTTPS, argotunnel.ProtoTCP)
+	exportupstreammode := export.Flag("upstream-mode", "how requests reach the origin when an ingress omits the upstream-mode annotation").Enum(argotunnel.UpstreamModeService, argotunnel.UpstreamModeEndpoint)
+
+	// validate (check the controller environment)
+	validate := app.Command("validate", "check the controller can reach the edge, and read its origin certificate")
+	validateedgeaddrs := validate.Flag("edge-host-port", "edge address <host>:<port> checked, overrides edge discovery (repeatable)").Strings()
+	validateedgeproxy := validate.Flag("edge-proxy-url", "HTTP CONNECT proxy <scheme>://[user:password@]<host>:<port> of the edge connections, defaults to HTTPS_PROXY").String()
+	validateoriginsecret := k8s.ObjMixin(validate.Flag("origin-secret", "origin certificate secret <namespace>/<name> checked before the edge"))
+	validateincluster := validate.Flag("incluster", "use in-cluster configuration.").Bool()
+	validatekubeconfig := validate.Flag("kubeconfig", "path to kubeconfig (if not in running inside a cluster)").Default(filepath.Join(os.Getenv("HOME"), ".kube", "config")).String()
+
+	// status (print the state of a running controller)
+	status := app.Command("status", "print the tunnels, or the origin secrets in use, of a running controller")
+	statusdebugurl := status.Flag("debug-url", "url of the debug listener of the controller, see --debug-enable").Default("http://127.0.0.1:8081").String()
+	statussecrets := status.Flag("secrets", "print the routes using each origin secret").Bool()
+
 	// couple (build tunnels to services/endpoints)
 	couple := app.Command("couple", "Couple services with argo tunnels")
 	incluster := couple.Flag("incluster", "use in-cluster configuration.").Bool()
 	kubeconfig := couple.Flag("kubeconfig", "path to kubeconfig (if not in running inside a cluster)").Default(filepath.Join(os.Getenv("HOME"), ".kube", "config")).String()
-	ingressclass := couple.Flag("ingress-class", "ingress class name").Default(argotunnel.IngressClassDefault).String()
-	originsecret := k8s.ObjMixin(couple.Flag("default-origin-secret", "default origin certificate secret <namespace>/<name>"))
-	originconfig := couple.Flag("origin-secret-config", "host specific origin certificate defaults").String()
+	kubeinsecure := couple.Flag("kube-insecure-skip-tls-verify", "DEV ONLY: skip verification of the kubernetes api server certificate").Bool()
+	ingressclass := couple.Flag("ingress-class", "ingress class name, a comma-separated list adopts legacy classes after the current one").Envar("ARGOT_INGRESS_CLASS").Default(argotunnel.IngressClassDefault).String()
+	adoptunclassed := couple.Flag("adopt-unclassed-ingresses", "manage ingresses that do not claim any ingress class").Bool()
+	allowmissing := couple.Flag("allow-missing-backend", "create tunnels for backend services that do not exist yet or have no ready endpoints").Bool()
+	annotationdefaults := k8s.ObjMixin(couple.Flag("annotation-defaults-configmap", "configmap <namespace>/<name> of the annotation defaults applied when an ingress omits them"))
+	originsecret := k8s.ObjMixin(couple.Flag("default-origin-secret", "default origin certificate secret <namespace>/<name>").Envar("ARGOT_DEFAULT_ORIGIN_SECRET"))
+	onlyingress := k8s.ObjMixin(couple.Flag("only-ingress", "DEBUGGING ONLY, not for production: reconcile the single ingress <namespace>/<name>"))
+	originconfig := couple.Flag("origin-secret-config", "host specific origin certificate defaults").Envar("ARGOT_ORIGIN_SECRET_CONFIG").String()
+	compressionquality := couple.Flag("compression-quality", "cross-stream compression used when an ingress omits the compression-quality annotation (0-3)").Default("0").Uint64()
+	allowedhosts := couple.Flag("allowed-hostname-pattern", "hostname pattern allowed to be exposed, a leading '.' matches a domain suffix, otherwise a regular expression (repeatable)").Strings()
+	deniedhosts := couple.Flag("denied-hostname-pattern", "hostname pattern denied from being exposed, a leading '.' matches a domain suffix, otherwise a regular expression (repeatable)").Strings()
+	edgeaddrs := couple.Flag("edge-host-port", "edge address <host>:<port> dialed by tunnels, overrides edge discovery (repeatable)").Strings()
+	edgeprotocol := couple.Flag("edge-protocol", "protocol of the edge connections: auto, h2mux, http2 or quic").Default(argotunnel.EdgeProtocolDefault).String()
+	edgeproxy := couple.Flag("edge-proxy-url", "HTTP CONNECT proxy <scheme>://[user:password@]<host>:<port> of the edge connections, defaults to HTTPS_PROXY").String()
+	duplicatepolicy := couple.Flag("duplicate-controller-policy", "handling of another controller serving the ingress class: ignore, warn, or refuse to start").Default(argotunnel.DuplicateControllerPolicyDefault).Enum(argotunnel.DuplicateControllerIgnore, argotunnel.DuplicateControllerWarn, argotunnel.DuplicateControllerRefuse)
+	controllerid := couple.Flag("controller-id", "identity shared by the replicas of the controller in the ingress class lease, derived from the pod name when omitted").String()
+	leasenamespace := couple.Flag("lease-namespace", "namespace of the ingress class lease, defaults to the pod namespace").String()
+	defaulthostname := couple.Flag("default-hostname", "host serving ingress rules without a host").Envar("ARGOT_DEFAULT_HOSTNAME").String()
+	defaultproto := couple.Flag("default-proto", "origin protocol used when an ingress omits the proto annotation").Enum(argotunnel.ProtoHTTP, argotunnel.ProtoHTTPS, argotunnel.ProtoTCP)
+	eventwebhookurl := couple.Flag("event-webhook-url", "url the tunnel connection events are posted to as json").String()
+	eventcomponent := couple.Flag("event-component", "source component of the events recorded by the controller").Default(argotunnel.EventComponentDefault).String()
 	debugaddr := couple.Flag("debug-address", "profiling bind address").Default("127.0.0.1:8081").String()
 	debugenable := couple.Flag("debug-enable", "enable profiling handler").Bool()
+	debugtlscert := couple.Flag("debug-tls-cert-file", "certificate served by the debug listener, enables https").String()
+	debugtlskey := couple.Flag("debug-tls-key-file", "private key of the debug listener certificate").String()
+	debugclientca := couple.Flag("debug-client-ca-file", "CA verifying the client certificates required by the debug listener").String()
+	keepcredential := couple.Flag("keep-credential-on-refresh-failure", "keep the origin certificate of running tunnels when a rotation of its secret is invalid").Default(strconv.FormatBool(argotunnel.KeepCredentialOnRefreshFailureDefault)).Bool()
+	logfields := couple.Flag("log-field", "field <key>=<value> added to every controller log entry (repeatable)").StringMap()
+	makebeforebreak := couple.Flag("make-before-break", "bring up the tunnel of a changed backend before retiring the old one").Default(strconv.FormatBool(argotunnel.MakeBeforeBreakDefault)).Bool()
+	missinggrace := couple.Flag("missing-backend-grace", "period the tunnels of a deleted backend service stay registered, answering 503, before they are removed, 0 removes them at once").Default(argotunnel.MissingBackendGraceDefault.String()).Duration()
 	metricsaddr := couple.Flag("metrics-address", "metrics bind address").Default("0.0.0.0:8080").String()
-	metricsenable := couple.Flag("metrics-enable", "enable metrics handler").Bool()
+	metricsenable := couple.Flag("metrics-enable", "enable metrics handler").Envar("ARGOT_METRICS_ENABLE").Bool()
+	metricstlscert := couple.Flag("metrics-tls-cert-file", "certificate served by the metrics listener, enables https").String()
+	metricstlskey := couple.Flag("metrics-tls-key-file", "private key of the metrics listener certificate").String()
+	metricsclientca := couple.Flag("metrics-client-ca-file", "CA verifying the client certificates required by the metrics listener").String()
+	chaosenable := couple.Flag("chaos-enabled", "allow the chaos flags to inject faults, for resilience testing only").Hidden().Bool()
+	chaosdrop := couple.Flag("chaos-drop-tunnel-every", "period after which every tunnel is dropped and repaired, requires --chaos-enabled").Hidden().Duration()
+	chaosfail := couple.Flag("chaos-fail-registration-percent", "percentage of tunnel registrations failed, requires --chaos-enabled").Hidden().Int()
+	chaosdial := couple.Flag("chaos-delay-origin-dial", "delay added to every origin dial, requires --chaos-enabled").Hidden().Duration()
+	clockskew := couple.Flag("clock-skew-threshold", "system clock skew reported as the cause of failed tunnel registrations, 0 disables the check").Default(argotunnel.ClockSkewThresholdDefault.String()).Duration()
 	connlimit := couple.Flag("connection-limit", "profiling bind address").Default("512").Int()
 	repairdelay := couple.Flag("repair-delay", "period between tunnel repair attempts").Default(argotunnel.RepairDelayDefault.String()).Duration()
 	repairjitter := couple.Flag("repair-jitter", "linear jitter as a fraction of repair-delay").Default(strconv.FormatFloat(argotunnel.RepairJitterDefault, 'E', -1, 64)).Float64()
+	repaircycles := couple.Flag("repair-cycles", "number of repair-steps cycles a tunnel fails before it stops repairing until the next resync, 0 repairs forever").Default(strconv.FormatUint(argotunnel.RepairCyclesDefault, 10)).Uint()
 	repairsteps := couple.Flag("repair-steps", "number of exponential steps used during tunnel repair").Default(strconv.FormatUint(argotunnel.RepairStepsDefault, 10)).Uint()
-	resyncperiod := couple.Flag("resync-period", "period between synchronization attempts").Default(argotunnel.ResyncPeriodDefault.String()).Duration()
+	repairstrategy := couple.Flag("repair-strategy", "strategy of the waits between tunnel repairs").Default(argotunnel.RepairStrategyDefault).Enum(argotunnel.RepairStrategies...)
+	readinessgate := couple.Flag("readiness-gate-timeout", "period the first registration of a new host waits on its readiness probe before registering anyway, 0 does not wait").Default(argotunnel.ReadinessGateTimeoutDefault.String()).Duration()
+	reconcilebasedelay := couple.Flag("reconcile-base-delay", "delay before the first retry of a failed reconcile, doubled on each failure").Default(argotunnel.ReconcileBaseDelayDefault.String()).Duration()
+	reconcilemaxdelay := couple.Flag("reconcile-max-delay", "bound of the delay between the retries of a failed reconcile").Default(argotunnel.ReconcileMaxDelayDefault.String()).Duration()
+	reconciletimeout := couple.Flag("reconcile-timeout", "period a reconcile runs before it is canceled and the item requeued, 0 waits forever").Default(argotunnel.ReconcileTimeoutDefault.String()).Duration()
+	requiretls := couple.Flag("require-tls-block", "only create tunnels for hosts listed in the ingress tls section").Bool()
+	recentloglines := couple.Flag("recent-log-lines", "log lines kept in memory for /logs of the debug listener, 0 disables /logs").Default("1000").Int()
+	historysize := couple.Flag("reconcile-history-size", "reconcile decisions kept per host for /debug/tunnels/<host>/history, 0 disables the history").Default(strconv.Itoa(argotunnel.HistorySizeDefault)).Int()
+	resyncperiod := couple.Flag("resync-period", "period between synchronization attempts").Envar("ARGOT_RESYNC_PERIOD").Default(argotunnel.ResyncPeriodDefault.String()).Duration()
+	resyncbatchsize := couple.Flag("resync-batch-size", "number of items enqueued at once by a resync burst, 0 disables batching").Default("0").Int()
+	resyncbatchinterval := couple.Flag("resync-batch-interval", "period between the batches of a resync burst").Default(argotunnel.ResyncBatchIntervalDefault.String()).Duration()
+	stalewatch := couple.Flag("stale-watch-threshold", "period an informer may go without a list or watch event before /healthz fails, 0 disables the check").Default(argotunnel.StaleWatchThresholdDefault.String()).Duration()
+	statusenable := couple.Flag("ingress-status-enable", "record ingress reconcile results as IngressStatus resources").Bool()
+	securitytxtfile := couple.Flag("security-txt-file", "path to the /.well-known/security.txt served on hosts whose origin does not serve its own").String()
+	shutdowndeadline := couple.Flag("shutdown-deadline", "period the shutdown may take before the process is forced to exit, keep it below the pod terminationGracePeriodSeconds, 0 waits forever").Default("25s").Duration()
+	shardcount := couple.Flag("shard-count", "number of controller shards splitting the hosts, 0 disables sharding").Default("0").Int()
+	shardindex := couple.Flag("shard-index", "shard owned by the controller, derived from the StatefulSet pod ordinal when omitted").Default("-1").Int()
 	taglimit := couple.Flag("tag-limit", "number of tags allowed per tunnel").Default(strconv.Itoa(argotunnel.TagLimitDefault)).Int()
+	upstreammode := couple.Flag("upstream-mode", "how requests reach the origin when an ingress omits the upstream-mode annotation").Envar("ARGOT_UPSTREAM_MODE").Enum(argotunnel.UpstreamModeService, argotunnel.UpstreamModeEndpoint)
 	transportlogenable := couple.Flag("transport-log-enable", "enable transport logging").Bool()
-	watchNamespace := couple.Flag("watch-namespace", "restrict resource watches to namespace").Default(v1.NamespaceAll).String()
-	workers := couple.Flag("workers", "number of workers processing updates").Default(strconv.Itoa(argotunnel.WorkersDefault)).Int()
+	transportloglevelset := false
+	transportloglevel := couple.Flag("transport-log-level", "enable transport logging at specified level, defaults to the v flag").Action(func(*kingpin.ParseContext) error {
+		transportloglevelset = true
+		return nil
+	}).Int()
+	auditlogfile := couple.Flag("audit-log-file", "path to the tunnel audit log (defaults to stderr)").String()
+	watchNamespace := couple.Flag("watch-namespace", "restrict resource watches to namespace").Envar("ARGOT_WATCH_NAMESPACE").Default(v1.NamespaceAll).String()
+	workers := couple.Flag("workers", "number of workers processing updates").Envar("ARGOT_WORKERS").Default(strconv.Itoa(argotunnel.WorkersDefault)).Int()
 
 	args := os.Args[1:]
 	switch kingpin.MustParse(app.Parse(args)) {
@@ -67,23 +183,235 @@ func main() {
 	case variant.FullCommand():
 		fmt.Printf("%s %s %s/%s\n", name, version, runtime.GOOS, runtime.GOARCH)
 
+	// rbac (print the controller rbac manifests)
+	case rbac.FullCommand():
+		manifest, err := rbacmanifest(*rbacname, *rbacnamespace, argotunnel.PolicyRules(*rbacadoptunclassed, *rbacstatusenable, *rbacdebugenable, *rbacduplicatepolicy != argotunnel.DuplicateControllerIgnore))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot render rbac: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(manifest)
+
+	// export (print the cloudflared configuration of an ingress)
+	case export.FullCommand():
+		log := logrus.StandardLogger()
+		log.SetLevel(logruslevel(*verbose))
+		log.Out = os.Stderr
+		setklog(log, *verbose)
+
+		if err := argotunnel.ValidateDefaultProto(*exportdefaultproto); err != nil {
+			log.Fatalf("invalid default proto: %v", err)
+		}
+
+		kconfig, err := kubeconfigfor(*exportkubeconfig, *exportincluster)
+		if err != nil {
+			log.Fatalf("failed to create kubernetes client: %v", err)
+		}
+		if *exportkubeinsecure {
+			log.Warnf("INSECURE: --kube-insecure-skip-tls-verify is set, the kubernetes api server %s is not verified, for development only", kconfig.Host)
+			insecurekubeconfig(kconfig)
+		}
+		kclient, err := kubernetes.NewForConfig(kconfig)
+		if err != nil {
+			log.Fatalf("failed to create kubernetes client: %v", err)
+		}
+		secretgroups, err := originsecrets(*exportoriginconfig)
+		if err != nil {
+			log.Fatalf("failed to parse origin secrets: %v", err)
+		}
+
+		config, err := argotunnel.ExportIngress(kclient, log, *exportnamespace, *exportingress,
+			argotunnel.AllowMissingBackend(*exportallowmissing),
+			argotunnel.CompressionQuality(*exportcompressionquality),
+			argotunnel.DefaultHostname(*exportdefaulthostname),
+			argotunnel.DefaultProto(*exportdefaultproto),
+			argotunnel.EdgeAddrs(*exportedgeaddrs),
+			argotunnel.SecretGroups(*secretgroups),
+			argotunnel.Secret(exportoriginsecret.Name, exportoriginsecret.Namespace),
+			argotunnel.UpstreamMode(*exportupstreammode),
+		)
+		if err != nil {
+			log.Fatalf("failed to export ingress: %v", err)
+		}
+		os.Stdout.Write(config)
+
+	// validate (check the controller environment)
+	case validate.FullCommand():
+		if len(validateoriginsecret.Name) > 0 {
+			kconfig, err := kubeconfigfor(*validatekubeconfig, *validateincluster)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "FAIL kubernetes client: %v\n", err)
+				os.Exit(1)
+			}
+			kclient, err := kubernetes.NewForConfig(kconfig)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "FAIL kubernetes client: %v\n", err)
+				os.Exit(1)
+			}
+			encodings, err := checkoriginsecret(kclient, validateoriginsecret.Namespace, validateoriginsecret.Name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "FAIL origin secret %s: %v\n", validateoriginsecret.String(), err)
+				os.Exit(1)
+			}
+			if encodings > 0 {
+				fmt.Fprintf(os.Stderr, "WARN origin secret %s: 'cert.pem' is base64 encoded %d more time(s) than expected, decoded by the controller, set it under stringData, or encode it once under data\n", validateoriginsecret.String(), encodings)
+			}
+			fmt.Printf("ok origin secret %s\n", validateoriginsecret.String())
+		}
+		proxy, err := argotunnel.SetEdgeProxy(*validateedgeproxy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL edge proxy: %v\n", err)
+			os.Exit(1)
+		}
+		path := "directly"
+		if proxy != nil {
+			path = "through proxy " + proxy.Redacted()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		edge, err := argotunnel.CheckEdge(ctx, *validateedgeaddrs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL edge %s %s: %v\n", edge, path, err)
+			os.Exit(1)
+		}
+		fmt.Printf("ok edge %s reached %s\n", edge, path)
+
+	// status (print the state of a running controller)
+	case status.FullCommand():
+		path := "/debug/tunnels"
+		if *statussecrets {
+			path = "/debug/secrets"
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(strings.TrimSuffix(*statusdebugurl, "/") + path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot reach the debug listener: %v\n", err)
+			os.Exit(1)
+		}
+		err = printstatus(os.Stdout, resp, *statussecrets)
+		resp.Body.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot read %s: %v\n", path, err)
+			os.Exit(1)
+		}
+
 	// couple (build tunnels to services/endpoints)
 	case couple.FullCommand():
-		// mirror verbosity between glog and logrus
-		flag.Set("logtostderr", "true")
-		flag.Set("v", strconv.Itoa(*verbose))
-		flag.Parse()
+		if *shardcount > 0 && *shardindex < 0 {
+			hostname, err := os.Hostname()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read hostname for shard index: %v\n", err)
+				os.Exit(1)
+			}
+			if *shardindex, err = argotunnel.ParseShardOrdinal(hostname); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to derive shard index: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		secretgroups, err := originsecrets(*originconfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse origin secrets: %v\n", err)
+			os.Exit(1)
+		}
+		if problems := validatecouple(coupleflags{
+			annotationDefaults:    *annotationdefaults,
+			chaosDelayOriginDial:  *chaosdial,
+			chaosDropTunnelEvery:  *chaosdrop,
+			chaosEnabled:          *chaosenable,
+			chaosFailRegistration: *chaosfail,
+			compressionQuality:    *compressionquality,
+			debugAddr:             *debugaddr,
+			debugClientCAFile:     *debugclientca,
+			debugEnable:           *debugenable,
+			debugTLSCertFile:      *debugtlscert,
+			debugTLSKeyFile:       *debugtlskey,
+			edgeAddrs:             *edgeaddrs,
+			eventComponent:        *eventcomponent,
+			eventWebhookURL:       *eventwebhookurl,
+			ingressClass:          *ingressclass,
+			metricsAddr:           *metricsaddr,
+			metricsClientCAFile:   *metricsclientca,
+			metricsEnable:         *metricsenable,
+			metricsTLSCertFile:    *metricstlscert,
+			metricsTLSKeyFile:     *metricstlskey,
+			onlyIngress:           *onlyingress,
+			originSecret:          *originsecret,
+			originSecretGroups:    secretgroups.Groups,
+			reconcileBaseDelay:    *reconcilebasedelay,
+			reconcileMaxDelay:     *reconcilemaxdelay,
+			reconcileTimeout:      *reconciletimeout,
+			recentLogLines:        *recentloglines,
+			repairJitter:          *repairjitter,
+			shardCount:            *shardcount,
+			shutdownDeadline:      *shutdowndeadline,
+			shardIndex:            *shardindex,
+			tagLimit:              *taglimit,
+			watchNamespace:        *watchNamespace,
+			workers:               *workers,
+		}); len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: invalid flags:\n", name)
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "  - %s\n", p)
+			}
+			os.Exit(1)
+		}
 
 		log := logrus.StandardLogger()
 		log.SetLevel(logruslevel(*verbose))
 		log.Out = os.Stderr
+		setklog(log, *verbose)
+
+		var recentlogs *logring
+		if *debugenable && *recentloglines > 0 {
+			recentlogs = newlogring(*recentloglines)
+			log.AddHook(recentlogs)
+		}
 
 		if *transportlogenable {
 			transportlog := argotunnel.TransportLogger()
-			transportlog.SetLevel(logruslevel(*verbose))
+			if transportloglevelset {
+				transportlog.SetLevel(logruslevel(*transportloglevel))
+			} else {
+				transportlog.SetLevel(logruslevel(*verbose))
+			}
 			transportlog.Out = os.Stderr
 		}
 
+		if err := argotunnel.ValidateEdgeProtocol(*edgeprotocol); err != nil {
+			log.Fatalf("invalid edge protocol: %v", err)
+		}
+		log.Infof("edge connections over %s, edge protocol: %s", argotunnel.EdgeProtocolH2mux, *edgeprotocol)
+
+		if err := argotunnel.ValidateDefaultProto(*defaultproto); err != nil {
+			log.Fatalf("invalid default proto: %v", err)
+		}
+
+		proxy, err := argotunnel.SetEdgeProxy(*edgeproxy)
+		if err != nil {
+			log.Fatalf("failed to set edge proxy: %v", err)
+		}
+		if proxy != nil {
+			log.Infof("edge connections through proxy %s", proxy.Redacted())
+		} else {
+			log.Infof("edge connections dialed directly, no proxy")
+		}
+
+		if len(*auditlogfile) > 0 {
+			f, err := os.OpenFile(*auditlogfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+			if err != nil {
+				log.Fatalf("cannot open audit log file: %v", err)
+			}
+			defer f.Close()
+			argotunnel.AuditLogger().Out = f
+		}
+
+		var securitytxt []byte
+		if len(*securitytxtfile) > 0 {
+			if securitytxt, err = ioutil.ReadFile(*securitytxtfile); err != nil {
+				log.Fatalf("cannot read security.txt file: %v", err)
+			}
+		}
+
 		var g run.Group
 		{
 			ctx, cancel := context.WithCancel(context.Background())
@@ -100,15 +428,19 @@ func main() {
 				return ctx.Err()
 			}, func(_ error) {
 				cancel()
+				forceexitafter(*shutdowndeadline, log, os.Exit)
 			})
 		}
+		debugServerMux := http.NewServeMux()
 		if *debugenable {
-			debugServerMux := http.NewServeMux()
 			debugServerMux.HandleFunc("/debug/pprof/", pprof.Index)
 			debugServerMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 			debugServerMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 			debugServerMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 			debugServerMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+			if recentlogs != nil {
+				debugServerMux.Handle("/logs", recentlogs)
+			}
 
 			debugListener, err := net.Listen("tcp", *debugaddr)
 			if err != nil {
@@ -117,6 +449,20 @@ func main() {
 			}
 
 			debugListener = netutil.LimitListener(debugListener, *connlimit)
+			if len(*debugtlscert) > 0 {
+				config, reloader, err := servertls(*debugtlscert, *debugtlskey, *debugclientca, log)
+				if err != nil {
+					log.Fatalf("cannot load debug listener tls: %v", err)
+					os.Exit(1)
+				}
+				debugListener = tls.NewListener(debugListener, config)
+				stop := make(chan struct{})
+				g.Add(func() error {
+					return reloader.run(stop)
+				}, func(_ error) {
+					close(stop)
+				})
+			}
 			debugServer := &http.Server{
 				Handler:      debugServerMux,
 				ReadTimeout:  5 * time.Second,
@@ -130,14 +476,19 @@ func main() {
 				debugServer.Shutdown(context.Background())
 			})
 		}
+		metricServerMux := http.NewServeMux()
 		if *metricsenable {
 			// TODO: replace cloudflared metrics with go-kit metrics
-			// cloudflared metrics currently assumes prometheus, uses the global registry
-			// and does not differential by tunnel (e.g. assumes a daemon per tunnel)
+			// cloudflared metrics assume the global registry and a daemon per tunnel,
+			// they are registered here per tunnel, prefixed and labeled by hostname
 			promregistry := prometheus.NewRegistry()
+			if err := argotunnel.RegisterMetrics(promregistry); err != nil {
+				log.Fatalf("cannot register metrics: %v", err)
+				os.Exit(1)
+			}
 
-			metricServerMux := http.NewServeMux()
-			metricServerMux.Handle("/metrics", promhttp.HandlerFor(promregistry, promhttp.HandlerOpts{}))
+			metricServerMux.Handle("/metrics", promhttp.HandlerFor(promregistry, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+			metricServerMux.HandleFunc("/stats", statsHandler(started))
 
 			metricsListener, err := net.Listen("tcp", *metricsaddr)
 			if err != nil {
@@ -146,6 +497,20 @@ func main() {
 			}
 
 			metricsListener = netutil.LimitListener(metricsListener, *connlimit)
+			if len(*metricstlscert) > 0 {
+				config, reloader, err := servertls(*metricstlscert, *metricstlskey, *metricsclientca, log)
+				if err != nil {
+					log.Fatalf("cannot load metrics listener tls: %v", err)
+					os.Exit(1)
+				}
+				metricsListener = tls.NewListener(metricsListener, config)
+				stop := make(chan struct{})
+				g.Add(func() error {
+					return reloader.run(stop)
+				}, func(_ error) {
+					close(stop)
+				})
+			}
 			metricsServer := &http.Server{
 				Handler:      metricServerMux,
 				ReadTimeout:  5 * time.Second,
@@ -160,36 +525,111 @@ func main() {
 			})
 		}
 		{
-			kclient, err := kubeclient(*kubeconfig, *incluster)
+			if len(onlyingress.Name) > 0 {
+				log.Warnf("DEBUGGING: --only-ingress is set, only the ingress %s is reconciled, not for production", onlyingress.String())
+			}
+			if *duplicatepolicy != argotunnel.DuplicateControllerIgnore {
+				podnamespace := podnamespace()
+				if len(*leasenamespace) == 0 {
+					*leasenamespace = podnamespace
+				}
+				if len(*controllerid) == 0 {
+					hostname, _ := os.Hostname()
+					*controllerid = argotunnel.DefaultControllerID(podnamespace, hostname)
+				}
+				log.Debugf("claiming ingress class %s as controller: %s, lease namespace: %s", *ingressclass, *controllerid, *leasenamespace)
+			}
+			kconfig, err := kubeconfigfor(*kubeconfig, *incluster)
+			if err != nil {
+				log.Fatalf("failed to create kubernetes client: %v", err)
+				os.Exit(1)
+			}
+			if *kubeinsecure {
+				log.Warnf("INSECURE: --kube-insecure-skip-tls-verify is set, the kubernetes api server %s is not verified, for development only", kconfig.Host)
+				insecurekubeconfig(kconfig)
+			}
+
+			kclient, err := kubernetes.NewForConfig(kconfig)
 			if err != nil {
 				log.Fatalf("failed to create kubernetes client: %v", err)
 				os.Exit(1)
 			}
 
-			secretgroups, err := originsecrets(*originconfig)
+			var statusclient dynamic.Interface
+			if *statusenable {
+				if statusclient, err = dynamic.NewForConfig(kconfig); err != nil {
+					log.Fatalf("failed to create kubernetes status client: %v", err)
+					os.Exit(1)
+				}
+			}
+
+			hostpolicy, err := argotunnel.NewHostnamePolicy(*allowedhosts, *deniedhosts)
 			if err != nil {
-				log.Fatalf("failed to parse origin secrets: %v", err)
+				log.Fatalf("failed to parse hostname policy: %v", err)
 				os.Exit(1)
 			}
 
 			argotunnel.EnableMetrics(5 * time.Second)
+			if *chaosenable {
+				argotunnel.SetChaos(*chaosdrop, *chaosfail, *chaosdial, log)
+			}
+			argotunnel.SetClockSkewThreshold(*clockskew)
+			argotunnel.SetReadinessGateTimeout(*readinessgate)
 			argotunnel.SetRepairBackoff(*repairdelay, *repairjitter, *repairsteps)
+			argotunnel.SetRepairBreaker(*repaircycles)
+			argotunnel.SetRepairStrategy(*repairstrategy)
+			log.Infof("tunnel repair backoff, %s", argotunnel.GetRepairBackoff())
 			argotunnel.SetTagLimit(*taglimit)
 			argotunnel.SetVersion(version)
 
 			ctx, cancel := context.WithCancel(context.Background())
 			argo := argotunnel.NewController(kclient, log,
+				argotunnel.AdoptUnclassedIngresses(*adoptunclassed),
+				argotunnel.AllowMissingBackend(*allowmissing),
+				argotunnel.AnnotationDefaults(annotationdefaults.Name, annotationdefaults.Namespace),
+				argotunnel.CompressionQuality(*compressionquality),
+				argotunnel.ControllerID(*controllerid, *leasenamespace),
+				argotunnel.DefaultHostname(*defaulthostname),
+				argotunnel.DefaultProto(*defaultproto),
+				argotunnel.DuplicateControllerPolicy(*duplicatepolicy),
+				argotunnel.EdgeAddrs(*edgeaddrs),
+				argotunnel.EdgeProtocol(*edgeprotocol),
+				argotunnel.EventComponent(*eventcomponent),
+				argotunnel.EventWebhookURL(*eventwebhookurl),
+				argotunnel.HistorySize(*historysize),
+				argotunnel.HostPolicy(hostpolicy),
 				argotunnel.IngressClass(*ingressclass),
+				argotunnel.KeepCredentialOnRefreshFailure(*keepcredential),
+				argotunnel.LogFields(logrusfields(*logfields)),
+				argotunnel.MakeBeforeBreak(*makebeforebreak),
+				argotunnel.MissingBackendGrace(*missinggrace),
+				argotunnel.OnlyIngress(onlyingress.Name, onlyingress.Namespace),
 				argotunnel.SecretGroups(*secretgroups),
 				argotunnel.Secret(originsecret.Name, originsecret.Namespace),
+				argotunnel.SecurityTxt(string(securitytxt)),
+				argotunnel.ReconcileDelay(*reconcilebasedelay, *reconcilemaxdelay),
+				argotunnel.ReconcileTimeout(*reconciletimeout),
+				argotunnel.RequireTLSBlock(*requiretls),
+				argotunnel.ResyncBatch(*resyncbatchsize, *resyncbatchinterval),
 				argotunnel.ResyncPeriod(*resyncperiod),
+				argotunnel.Shard(*shardindex, *shardcount),
+				argotunnel.StaleWatchThreshold(*stalewatch),
+				argotunnel.StatusClient(statusclient),
+				argotunnel.UpstreamMode(*upstreammode),
 				argotunnel.WatchNamespace(*watchNamespace),
 				argotunnel.Workers(*workers),
 			)
 
+			debugServerMux.Handle("/debug/tunnels", argo.TunnelsHandler())
+			debugServerMux.Handle("/debug/tunnels/", argo.TunnelPathHandler())
+			debugServerMux.Handle("/debug/export", argo.ExportHandler())
+			debugServerMux.Handle("/debug/info", infoHandler(version))
+			debugServerMux.Handle("/debug/secrets", argo.SecretsHandler())
+			debugServerMux.Handle("/debug/reload", argo.ReloadHandler())
+			metricServerMux.Handle("/healthz", argo.HealthHandler())
+
 			g.Add(func() error {
-				argo.Run(ctx.Done())
-				return nil
+				return argo.RunContext(ctx)
 			}, func(error) {
 				cancel()
 			})
@@ -202,19 +642,173 @@ func main() {
 	}
 }
 
-// select a kubernetes client
-func kubeclient(kubeconfigpath string, incluster bool) (*kubernetes.Clientset, error) {
-	kubeconfig, err := func() (*rest.Config, error) {
-		if kubeconfigpath != "" && !incluster {
-			return clientcmd.BuildConfigFromFlags("", kubeconfigpath)
+// coupleflags are the couple flags checked against each other before
+// the controller starts
+type coupleflags struct {
+	annotationDefaults    k8s.ObjValue
+	chaosDelayOriginDial  time.Duration
+	chaosDropTunnelEvery  time.Duration
+	chaosEnabled          bool
+	chaosFailRegistration int
+	compressionQuality    uint64
+	debugAddr             string
+	debugClientCAFile     string
+	debugEnable           bool
+	debugTLSCertFile      string
+	debugTLSKeyFile       string
+	edgeAddrs             []string
+	eventComponent        string
+	eventWebhookURL       string
+	ingressClass          string
+	metricsAddr           string
+	metricsClientCAFile   string
+	metricsEnable         bool
+	metricsTLSCertFile    string
+	metricsTLSKeyFile     string
+	onlyIngress           k8s.ObjValue
+	originSecret          k8s.ObjValue
+	originSecretGroups    []cloudflare.OriginSecretGroup
+	reconcileBaseDelay    time.Duration
+	reconcileMaxDelay     time.Duration
+	reconcileTimeout      time.Duration
+	recentLogLines        int
+	repairJitter          float64
+	shardCount            int
+	shardIndex            int
+	shutdownDeadline      time.Duration
+	tagLimit              int
+	watchNamespace        string
+	workers               int
+}
+
+// validatecouple lists every problem of the couple flags, with a fix,
+// rather than failing on the first
+func validatecouple(f coupleflags) (problems []string) {
+	if f.chaosDropTunnelEvery != 0 || f.chaosFailRegistration != 0 || f.chaosDelayOriginDial != 0 {
+		if !f.chaosEnabled {
+			problems = append(problems, "--chaos-drop-tunnel-every, --chaos-fail-registration-percent and --chaos-delay-origin-dial inject faults, set --chaos-enabled to allow them")
 		}
-		return rest.InClusterConfig()
-	}()
+		if f.chaosDropTunnelEvery < 0 || f.chaosDelayOriginDial < 0 {
+			problems = append(problems, fmt.Sprintf("--chaos-drop-tunnel-every=%s or --chaos-delay-origin-dial=%s is negative, use 0 to disable the fault", f.chaosDropTunnelEvery, f.chaosDelayOriginDial))
+		}
+		if f.chaosFailRegistration < 0 || f.chaosFailRegistration > 100 {
+			problems = append(problems, fmt.Sprintf("--chaos-fail-registration-percent=%d is out of range, use a value between 0 and 100", f.chaosFailRegistration))
+		}
+	}
+	if f.compressionQuality > argotunnel.CompressionQualityMax {
+		problems = append(problems, fmt.Sprintf("--compression-quality=%d is out of range, use a value between 0 and %d", f.compressionQuality, argotunnel.CompressionQualityMax))
+	}
+	for _, addr := range f.edgeAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			problems = append(problems, fmt.Sprintf("--edge-host-port=%q is invalid (%v), use <host>:<port>", addr, err))
+		}
+	}
+	if len(strings.TrimSpace(f.eventComponent)) == 0 {
+		problems = append(problems, fmt.Sprintf("--event-component is empty, name the source of the recorded events, e.g. %q", argotunnel.EventComponentDefault))
+	}
+	if len(f.eventWebhookURL) > 0 {
+		if u, err := url.Parse(f.eventWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			problems = append(problems, fmt.Sprintf("--event-webhook-url=%q is invalid, use an absolute http or https url", f.eventWebhookURL))
+		}
+	}
+	seen := map[string]bool{}
+	for _, class := range strings.Split(f.ingressClass, ",") {
+		if class = strings.TrimSpace(class); len(class) == 0 {
+			problems = append(problems, fmt.Sprintf("--ingress-class=%q lists an empty class, list the current class first, e.g. %q", f.ingressClass, "cloudflare,argo-tunnel"))
+			break
+		} else if seen[class] {
+			problems = append(problems, fmt.Sprintf("--ingress-class=%q lists the class %s twice, list each class once", f.ingressClass, class))
+			break
+		}
+		seen[class] = true
+	}
+	if f.debugEnable && f.metricsEnable && f.debugAddr == f.metricsAddr {
+		problems = append(problems, fmt.Sprintf("--debug-address and --metrics-address are both %q, bind them to different ports", f.debugAddr))
+	}
+	problems = append(problems, validatelistenertls("debug", f.debugTLSCertFile, f.debugTLSKeyFile, f.debugClientCAFile)...)
+	problems = append(problems, validatelistenertls("metrics", f.metricsTLSCertFile, f.metricsTLSKeyFile, f.metricsClientCAFile)...)
+	if f.reconcileBaseDelay <= 0 {
+		problems = append(problems, fmt.Sprintf("--reconcile-base-delay=%s retries failed reconciles without delay, use a positive delay, e.g. %s", f.reconcileBaseDelay, argotunnel.ReconcileBaseDelayDefault))
+	} else if f.reconcileMaxDelay < f.reconcileBaseDelay {
+		problems = append(problems, fmt.Sprintf("--reconcile-max-delay=%s is below --reconcile-base-delay=%s, use a max delay of at least the base delay", f.reconcileMaxDelay, f.reconcileBaseDelay))
+	}
+	if f.reconcileTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("--reconcile-timeout=%s is negative, use 0 to wait forever or the default %s", f.reconcileTimeout, argotunnel.ReconcileTimeoutDefault))
+	}
+	if f.recentLogLines < 0 {
+		problems = append(problems, fmt.Sprintf("--recent-log-lines=%d is negative, use 0 to disable /logs", f.recentLogLines))
+	}
+	if f.repairJitter <= 0 || f.repairJitter > 1 {
+		problems = append(problems, fmt.Sprintf("--repair-jitter=%g is out of range, use a fraction above 0 and at most 1, e.g. %g", f.repairJitter, argotunnel.RepairJitterDefault))
+	}
+	if f.shardCount < 0 {
+		problems = append(problems, fmt.Sprintf("--shard-count=%d is negative, use 0 to disable sharding", f.shardCount))
+	} else if f.shardCount > 0 && f.shardIndex >= f.shardCount {
+		problems = append(problems, fmt.Sprintf("--shard-index=%d is out of range, use a value between 0 and %d", f.shardIndex, f.shardCount-1))
+	}
+	if f.shutdownDeadline < 0 {
+		problems = append(problems, fmt.Sprintf("--shutdown-deadline=%s is negative, use 0 to wait for the shutdown to finish", f.shutdownDeadline))
+	}
+	if f.tagLimit < 0 {
+		problems = append(problems, fmt.Sprintf("--tag-limit=%d is negative, use 0 to disable tags or the default %d", f.tagLimit, argotunnel.TagLimitDefault))
+	}
+	if len(f.watchNamespace) > 0 && len(f.originSecret.Namespace) > 0 && f.originSecret.Namespace != f.watchNamespace {
+		problems = append(problems, fmt.Sprintf("--default-origin-secret=%s is outside --watch-namespace=%s and cannot be read, move the secret to %s", f.originSecret.String(), f.watchNamespace, f.watchNamespace))
+	}
+	if len(f.watchNamespace) > 0 && len(f.annotationDefaults.Namespace) > 0 && f.annotationDefaults.Namespace != f.watchNamespace {
+		problems = append(problems, fmt.Sprintf("--annotation-defaults-configmap=%s is outside --watch-namespace=%s and cannot be read, move the configmap to %s", f.annotationDefaults.String(), f.watchNamespace, f.watchNamespace))
+	}
+	if len(f.watchNamespace) > 0 && len(f.onlyIngress.Namespace) > 0 && f.onlyIngress.Namespace != f.watchNamespace {
+		problems = append(problems, fmt.Sprintf("--only-ingress=%s is outside --watch-namespace=%s and cannot be read, drop --watch-namespace while debugging", f.onlyIngress.String(), f.watchNamespace))
+	}
+	for i, group := range f.originSecretGroups {
+		if len(f.watchNamespace) > 0 && group.Secret.Namespace != f.watchNamespace {
+			problems = append(problems, fmt.Sprintf("--origin-secret-config group at index %d references secret %s outside --watch-namespace=%s and cannot be read, move the secret to %s", i, group.Secret.String(), f.watchNamespace, f.watchNamespace))
+		}
+	}
+	if f.workers < 1 {
+		problems = append(problems, fmt.Sprintf("--workers=%d leaves the queue unprocessed, use at least 1, e.g. %d", f.workers, argotunnel.WorkersDefault))
+	}
+	return
+}
 
-	if err != nil {
-		return nil, err
+// validatelistenertls checks the tls flags of a listener are set together
+func validatelistenertls(listener, certfile, keyfile, cafile string) (problems []string) {
+	if (len(certfile) > 0) != (len(keyfile) > 0) {
+		problems = append(problems, fmt.Sprintf("--%[1]s-tls-cert-file and --%[1]s-tls-key-file must be set together, set both to serve https", listener))
+	} else if len(cafile) > 0 && len(certfile) == 0 {
+		problems = append(problems, fmt.Sprintf("--%[1]s-client-ca-file requires https, set --%[1]s-tls-cert-file and --%[1]s-tls-key-file", listener))
+	}
+	return
+}
+
+// forceexitafter exits the process once the deadline elapses, a shutdown
+// stuck draining tunnels still ends before the pod is killed. A deadline
+// of 0 never exits.
+func forceexitafter(deadline time.Duration, log *logrus.Logger, exit func(int)) *time.Timer {
+	if deadline <= 0 {
+		return nil
 	}
-	return kubernetes.NewForConfig(kubeconfig)
+	return time.AfterFunc(deadline, func() {
+		log.Warnf("shutdown did not finish within --shutdown-deadline=%s, forcing exit", deadline)
+		exit(1)
+	})
+}
+
+// select a kubernetes client
+func kubeconfigfor(kubeconfigpath string, incluster bool) (*rest.Config, error) {
+	if kubeconfigpath != "" && !incluster {
+		return clientcmd.BuildConfigFromFlags("", kubeconfigpath)
+	}
+	return rest.InClusterConfig()
+}
+
+// insecurekubeconfig skips verification of the api server certificate,
+// the CA is cleared as client-go rejects an insecure config with one
+func insecurekubeconfig(c *rest.Config) {
+	c.Insecure = true
+	c.CAFile = ""
+	c.CAData = nil
 }
 
 // bridge verbose flag into a logrus.Level
@@ -229,6 +823,127 @@ func logruslevel(v int) (l logrus.Level) {
 	return
 }
 
+// bridge verbose flag into a klog verbosity, the client-go traces start
+// past the logrus debug level
+func klogverbosity(v int) int {
+	if v > 5 {
+		return v - 5
+	}
+	return 0
+}
+
+// funnel klog, the client-go logger, into logrus with a source=klog field.
+// klog is configured on its own flag set, the global one is left alone.
+func setklog(log *logrus.Logger, v int) {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	for name, value := range map[string]string{
+		"logtostderr":     "false",
+		"alsologtostderr": "false",
+		"stderrthreshold": "FATAL",
+		"one_output":      "true",
+		"skip_headers":    "true",
+		"v":               strconv.Itoa(klogverbosity(v)),
+	} {
+		fs.Set(name, value)
+	}
+	for severity, level := range map[string]logrus.Level{
+		"INFO":    logrus.InfoLevel,
+		"WARNING": logrus.WarnLevel,
+		"ERROR":   logrus.ErrorLevel,
+		"FATAL":   logrus.ErrorLevel, // klog exits on its own
+	} {
+		klog.SetOutputBySeverity(severity, klogwriter{log: log, level: level})
+	}
+}
+
+// klogwriter logs each klog line at the level of its severity
+type klogwriter struct {
+	log   *logrus.Logger
+	level logrus.Level
+}
+
+func (w klogwriter) Write(p []byte) (int, error) {
+	w.log.WithField("source", "klog").Log(w.level, strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// bridge log-field flags into logrus.Fields
+func logrusfields(m map[string]string) logrus.Fields {
+	if len(m) == 0 {
+		return nil
+	}
+	fields := make(logrus.Fields, len(m))
+	for k, v := range m {
+		fields[k] = v
+	}
+	return fields
+}
+
+// render the service account, cluster role, and cluster role binding
+// granting the rules
+func rbacmanifest(name, namespace string, rules []rbacv1.PolicyRule) ([]byte, error) {
+	objs := []interface{}{
+		&v1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Rules:      rules,
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     name,
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      name,
+				Namespace: namespace,
+			}},
+		},
+	}
+	var buf bytes.Buffer
+	for i, obj := range objs {
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// checkoriginsecret reads the origin certificate of a secret as the
+// controller does, and returns the base64 layers decoded from 'cert.pem'
+func checkoriginsecret(client kubernetes.Interface, namespace, name string) (encodings int, err error) {
+	sec, err := client.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+	if cert, ok := k8s.GetSecretCert(sec); ok {
+		_, encodings, err = cloudflare.DecodeOriginCert(cert)
+		if err != nil {
+			err = fmt.Errorf("'%s' %v", k8s.CertPem, err)
+		}
+		return
+	}
+	cert, key, token, ok := k8s.GetSecretTLS(sec)
+	if !ok {
+		return 0, fmt.Errorf("missing '%s' or '%s' and '%s'", k8s.CertPem, k8s.TLSCert, k8s.TLSKey)
+	}
+	_, err = cloudflare.AssembleOriginCert(cert, key, token)
+	return
+}
+
 // parse origin secrets
 func originsecrets(originsecretspath string) (*cloudflare.OriginSecrets, error) {
 	if len(originsecretspath) > 0 {
@@ -236,3 +951,369 @@ func originsecrets(originsecretspath string) (*cloudflare.OriginSecrets, error)
 	}
 	return &cloudflare.OriginSecrets{}, nil
 }
+
+type memStats struct {
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"totalAlloc"`
+	Sys          uint64 `json:"sys"`
+	HeapAlloc    uint64 `json:"heapAlloc"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	NumGC        uint32 `json:"numGC"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+}
+
+// tlsreloaddelay lets the writes of a rotation settle before the files
+// are reloaded, e.g. a certificate written ahead of its key
+const tlsreloaddelay = 100 * time.Millisecond
+
+// servertls returns the tls config of a listener, client certificates
+// signed by the CA are required when a CA file is set. The directories of
+// the files are watched, run the reloader to serve a rotated secret to new
+// connections without restarting the listener.
+func servertls(certfile, keyfile, cafile string, log *logrus.Logger) (*tls.Config, *tlsreloader, error) {
+	r := &tlsreloader{
+		certfile: certfile,
+		keyfile:  keyfile,
+		cafile:   cafile,
+		log:      log,
+	}
+	if err := r.load(); err != nil {
+		return nil, nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	dirs := map[string]bool{}
+	for _, file := range []string{certfile, keyfile, cafile} {
+		if dir := filepath.Dir(file); len(file) > 0 && !dirs[dir] {
+			dirs[dir] = true
+			if err := watcher.Add(dir); err != nil {
+				watcher.Close()
+				return nil, nil, fmt.Errorf("cannot watch tls directory %s: %v", dir, err)
+			}
+		}
+	}
+	r.watcher = watcher
+
+	config := &tls.Config{
+		GetCertificate: r.certificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if len(cafile) > 0 {
+		config.GetConfigForClient = r.configforclient
+	}
+	return config, r, nil
+}
+
+// tlsreloader holds the certificate and client CAs of the last loaded
+// files
+type tlsreloader struct {
+	certfile string
+	keyfile  string
+	cafile   string
+	log      *logrus.Logger
+	watcher  *fsnotify.Watcher
+	mu       sync.Mutex
+	stamp    string
+	failed   string
+	cert     *tls.Certificate
+	config   *tls.Config
+}
+
+// certificate serves the last loaded certificate
+func (r *tlsreloader) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+// configforclient serves the last loaded client CAs
+func (r *tlsreloader) configforclient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config, nil
+}
+
+// run reloads the files once their directories change, until stop. A
+// rotation that cannot be loaded keeps the previous certificate.
+func (r *tlsreloader) run(stop <-chan struct{}) error {
+	defer r.watcher.Close()
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case _, ok := <-r.watcher.Events:
+			if !ok {
+				return nil
+			}
+			if settle == nil {
+				settle = time.After(tlsreloaddelay)
+			}
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			r.log.Errorf("cannot watch tls files: %v", err)
+		case <-settle:
+			settle = nil
+			if err := r.load(); err != nil {
+				r.log.Errorf("cannot reload tls files, serving the previous certificate: %v", err)
+			}
+		}
+	}
+}
+
+// load reads the files when they changed since the last load, files
+// that failed to load are read again once they change
+func (r *tlsreloader) load() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp, err := filestamp(r.certfile, r.keyfile, r.cafile)
+	if err != nil || stamp == r.stamp || stamp == r.failed {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			r.failed = stamp
+		}
+	}()
+	cert, err := tls.LoadX509KeyPair(r.certfile, r.keyfile)
+	if err != nil {
+		return err
+	}
+	var config *tls.Config
+	if len(r.cafile) > 0 {
+		b, err := ioutil.ReadFile(r.cafile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("client ca file %s contains no certificate", r.cafile)
+		}
+		config = &tls.Config{
+			GetCertificate: r.certificate,
+			ClientCAs:      pool,
+			ClientAuth:     tls.RequireAndVerifyClientCert,
+			MinVersion:     tls.VersionTLS12,
+		}
+	}
+	if len(r.stamp) > 0 {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+			r.log.Infof("reloaded tls certificate %s: %s, expires: %s", r.certfile, leaf.Subject.CommonName, leaf.NotAfter.UTC().Format(time.RFC3339))
+		}
+	}
+	r.stamp, r.cert, r.config = stamp, &cert, config
+	return nil
+}
+
+// filestamp identifies the content of the files by modification time
+// and size
+func filestamp(files ...string) (string, error) {
+	var b strings.Builder
+	for _, file := range files {
+		if len(file) == 0 {
+			continue
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", file, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return b.String(), nil
+}
+
+type stats struct {
+	Goroutines    int      `json:"goroutines"`
+	Memory        memStats `json:"memory"`
+	UptimeSeconds float64  `json:"uptimeSeconds"`
+}
+
+func statsHandler(start time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats{
+			Goroutines: runtime.NumGoroutine(),
+			Memory: memStats{
+				Alloc:        m.Alloc,
+				TotalAlloc:   m.TotalAlloc,
+				Sys:          m.Sys,
+				HeapAlloc:    m.HeapAlloc,
+				HeapInuse:    m.HeapInuse,
+				HeapObjects:  m.HeapObjects,
+				NumGC:        m.NumGC,
+				PauseTotalNs: m.PauseTotalNs,
+			},
+			UptimeSeconds: time.Since(start).Seconds(),
+		})
+	}
+}
+
+// printstatus writes the tunnels, or the origin secrets in use, reported
+// by the debug listener as a table
+func printstatus(w io.Writer, resp *http.Response, secrets bool) error {
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if secrets {
+		var usages []struct {
+			Secret     string `json:"secret"`
+			References int    `json:"references"`
+			Routes     []struct {
+				Ingress  string `json:"ingress"`
+				Hostname string `json:"hostname"`
+			} `json:"routes"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&usages); err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "SECRET\tREFERENCES\tINGRESS\tHOSTNAME")
+		for _, usage := range usages {
+			for _, route := range usage.Routes {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", usage.Secret, usage.References, route.Ingress, route.Hostname)
+			}
+		}
+		return tw.Flush()
+	}
+
+	var report struct {
+		Tunnels []struct {
+			Hostname string `json:"hostname"`
+			Ingress  string `json:"ingress"`
+			Service  string `json:"service"`
+			Port     int32  `json:"port"`
+			Secret   string `json:"secret"`
+		} `json:"tunnels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return err
+	}
+	fmt.Fprintln(tw, "HOSTNAME\tINGRESS\tSERVICE\tSECRET")
+	for _, tunnel := range report.Tunnels {
+		fmt.Fprintf(tw, "%s\t%s\t%s:%d\t%s\n", tunnel.Hostname, tunnel.Ingress, tunnel.Service, tunnel.Port, tunnel.Secret)
+	}
+	return tw.Flush()
+}
+
+// logline is a log entry kept by the logring
+type logline struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// logring keeps the last log entries in memory, served as json by /logs
+// for the context of an incident without a shell in the pod
+type logring struct {
+	mu    sync.Mutex
+	lines []logline
+	next  int
+	full  bool
+}
+
+func newlogring(size int) *logring {
+	return &logring{lines: make([]logline, size)}
+}
+
+// Levels implements logrus.Hook
+func (r *logring) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (r *logring) Fire(entry *logrus.Entry) error {
+	line := logline{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	if len(entry.Data) > 0 {
+		line.Fields = make(map[string]interface{}, len(entry.Data))
+		for k, v := range entry.Data {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			line.Fields[k] = v
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	return nil
+}
+
+// last returns up to n of the last entries, oldest first, all with n 0
+func (r *logring) last(n int) []logline {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lines := append([]logline{}, r.lines[:r.next]...)
+	if r.full {
+		lines = append(append([]logline{}, r.lines[r.next:]...), lines...)
+	}
+	if n > 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// ServeHTTP answers GET /logs, the lines parameter bounds the entries
+func (r *logring) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "logs requires GET", http.StatusMethodNotAllowed)
+		return
+	}
+	var n int
+	if s := req.URL.Query().Get("lines"); len(s) > 0 {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("lines=%q is invalid, use a count of lines", s), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.last(n))
+}
+
+// info is served by the info debug handler
+type info struct {
+	Version string                   `json:"version"`
+	Repair  argotunnel.RepairBackoff `json:"repair"`
+}
+
+func infoHandler(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info{
+			Version: version,
+			Repair:  argotunnel.GetRepairBackoff(),
+		})
+	}
+}
+
+// podnamespace reads the namespace of the pod from its service account,
+// falling back to the default namespace outside a cluster
+func podnamespace() string {
+	if b, err := ioutil.ReadFile(serviceaccountnamespace); err == nil {
+		if ns := strings.TrimSpace(string(b)); len(ns) > 0 {
+			return ns
+		}
+	}
+	return v1.NamespaceDefault
+}
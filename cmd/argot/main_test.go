@@ -1,10 +1,40 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cloudflare/cloudflare-ingress-controller/internal/argotunnel"
+	"github.com/cloudflare/cloudflare-ingress-controller/internal/cloudflare"
+	"github.com/cloudflare/cloudflare-ingress-controller/internal/k8s"
 	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
 )
 
 func TestLogrusLevel(t *testing.T) {
@@ -50,3 +80,702 @@ func TestLogrusLevel(t *testing.T) {
 		assert.Equalf(t, test.out, out, "test '%s' logrus level mismatch", name)
 	}
 }
+
+func TestKlogVerbosity(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  int
+		out int
+	}{
+		"verbose-flag-lt-0": {
+			in:  -100,
+			out: 0,
+		},
+		"verbose-flag-5": {
+			in:  5,
+			out: 0,
+		},
+		"verbose-flag-6": {
+			in:  6,
+			out: 1,
+		},
+		"verbose-flag-9": {
+			in:  9,
+			out: 4,
+		},
+	} {
+		out := klogverbosity(test.in)
+		assert.Equalf(t, test.out, out, "test '%s' klog verbosity mismatch", name)
+	}
+}
+
+// TestSetKlog swaps os.Stderr, it must not run in parallel
+func TestSetKlog(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	stderr := os.Stderr
+	os.Stderr = w
+
+	log, hook := logtest.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+	setklog(log, 6)
+	klog.Info("info")
+	klog.Warning("warning")
+	klog.Error("error")
+	klog.V(1).Info("verbose")
+	klog.V(2).Info("too verbose")
+	klog.Flush()
+
+	os.Stderr = stderr
+	w.Close()
+	out, _ := io.ReadAll(r)
+	assert.Emptyf(t, string(out), "klog wrote to stderr")
+
+	type entry struct {
+		level   logrus.Level
+		message string
+		source  interface{}
+	}
+	entries := []entry{}
+	for _, e := range hook.AllEntries() {
+		entries = append(entries, entry{e.Level, e.Message, e.Data["source"]})
+	}
+	assert.Equal(t, []entry{
+		{logrus.InfoLevel, "info", "klog"},
+		{logrus.WarnLevel, "warning", "klog"},
+		{logrus.ErrorLevel, "error", "klog"},
+		{logrus.InfoLevel, "verbose", "klog"},
+	}, entries)
+}
+
+func TestValidateCouple(t *testing.T) {
+	t.Parallel()
+	valid := func(mutate func(*coupleflags)) coupleflags {
+		f := coupleflags{
+			debugAddr:          "127.0.0.1:8081",
+			eventComponent:     argotunnel.EventComponentDefault,
+			ingressClass:       argotunnel.IngressClassDefault,
+			metricsAddr:        "0.0.0.0:8080",
+			metricsEnable:      true,
+			reconcileBaseDelay: argotunnel.ReconcileBaseDelayDefault,
+			reconcileMaxDelay:  argotunnel.ReconcileMaxDelayDefault,
+			repairJitter:       argotunnel.RepairJitterDefault,
+			shardIndex:         -1,
+			tagLimit:           argotunnel.TagLimitDefault,
+			workers:            argotunnel.WorkersDefault,
+		}
+		if mutate != nil {
+			mutate(&f)
+		}
+		return f
+	}
+	for name, test := range map[string]struct {
+		in  coupleflags
+		out []string
+	}{
+		"flags-valid": {
+			in:  valid(nil),
+			out: nil,
+		},
+		"chaos-enabled": {
+			in: valid(func(f *coupleflags) {
+				f.chaosEnabled = true
+				f.chaosDropTunnelEvery = time.Minute
+				f.chaosFailRegistration = 50
+				f.chaosDelayOriginDial = time.Second
+			}),
+			out: nil,
+		},
+		"chaos-not-enabled": {
+			in:  valid(func(f *coupleflags) { f.chaosFailRegistration = 50 }),
+			out: []string{"--chaos-drop-tunnel-every, --chaos-fail-registration-percent and --chaos-delay-origin-dial inject faults, set --chaos-enabled to allow them"},
+		},
+		"chaos-out-of-range": {
+			in: valid(func(f *coupleflags) {
+				f.chaosEnabled = true
+				f.chaosDelayOriginDial = -time.Second
+				f.chaosFailRegistration = 101
+			}),
+			out: []string{
+				"--chaos-drop-tunnel-every=0s or --chaos-delay-origin-dial=-1s is negative, use 0 to disable the fault",
+				"--chaos-fail-registration-percent=101 is out of range, use a value between 0 and 100",
+			},
+		},
+		"compression-quality-out-of-range": {
+			in:  valid(func(f *coupleflags) { f.compressionQuality = 4 }),
+			out: []string{"--compression-quality=4 is out of range, use a value between 0 and 3"},
+		},
+		"edge-host-port-invalid": {
+			in:  valid(func(f *coupleflags) { f.edgeAddrs = []string{"edge.unit.com:7844", "edge.unit.com"} }),
+			out: []string{`--edge-host-port="edge.unit.com" is invalid (address edge.unit.com: missing port in address), use <host>:<port>`},
+		},
+		"event-component-empty": {
+			in:  valid(func(f *coupleflags) { f.eventComponent = " " }),
+			out: []string{`--event-component is empty, name the source of the recorded events, e.g. "argot"`},
+		},
+		"recent-log-lines-negative": {
+			in:  valid(func(f *coupleflags) { f.recentLogLines = -1 }),
+			out: []string{"--recent-log-lines=-1 is negative, use 0 to disable /logs"},
+		},
+		"event-webhook-url-valid": {
+			in: valid(func(f *coupleflags) { f.eventWebhookURL = "https://hooks.test.com/argot" }),
+		},
+		"event-webhook-url-relative": {
+			in:  valid(func(f *coupleflags) { f.eventWebhookURL = "hooks.test.com/argot" }),
+			out: []string{`--event-webhook-url="hooks.test.com/argot" is invalid, use an absolute http or https url`},
+		},
+		"ingress-classes-valid": {
+			in: valid(func(f *coupleflags) { f.ingressClass = "cloudflare, argo-tunnel" }),
+		},
+		"ingress-class-empty": {
+			in:  valid(func(f *coupleflags) { f.ingressClass = "cloudflare,,argo-tunnel" }),
+			out: []string{`--ingress-class="cloudflare,,argo-tunnel" lists an empty class, list the current class first, e.g. "cloudflare,argo-tunnel"`},
+		},
+		"ingress-class-repeated": {
+			in:  valid(func(f *coupleflags) { f.ingressClass = "cloudflare,cloudflare" }),
+			out: []string{`--ingress-class="cloudflare,cloudflare" lists the class cloudflare twice, list each class once`},
+		},
+		"debug-metrics-same-address": {
+			in: valid(func(f *coupleflags) {
+				f.debugEnable = true
+				f.debugAddr = f.metricsAddr
+			}),
+			out: []string{`--debug-address and --metrics-address are both "0.0.0.0:8080", bind them to different ports`},
+		},
+		"debug-metrics-same-address-debug-disabled": {
+			in:  valid(func(f *coupleflags) { f.debugAddr = f.metricsAddr }),
+			out: nil,
+		},
+		"reconcile-base-delay-zero": {
+			in:  valid(func(f *coupleflags) { f.reconcileBaseDelay = 0 }),
+			out: []string{"--reconcile-base-delay=0s retries failed reconciles without delay, use a positive delay, e.g. 5ms"},
+		},
+		"reconcile-max-delay-below-base": {
+			in: valid(func(f *coupleflags) {
+				f.reconcileBaseDelay = time.Second
+				f.reconcileMaxDelay = 500 * time.Millisecond
+			}),
+			out: []string{"--reconcile-max-delay=500ms is below --reconcile-base-delay=1s, use a max delay of at least the base delay"},
+		},
+		"reconcile-max-delay-equals-base": {
+			in: valid(func(f *coupleflags) {
+				f.reconcileBaseDelay = time.Second
+				f.reconcileMaxDelay = time.Second
+			}),
+			out: nil,
+		},
+		"shutdown-deadline-negative": {
+			in:  valid(func(f *coupleflags) { f.shutdownDeadline = -time.Second }),
+			out: []string{"--shutdown-deadline=-1s is negative, use 0 to wait for the shutdown to finish"},
+		},
+		"repair-jitter-zero": {
+			in:  valid(func(f *coupleflags) { f.repairJitter = 0 }),
+			out: []string{"--repair-jitter=0 is out of range, use a fraction above 0 and at most 1, e.g. 0.5"},
+		},
+		"repair-jitter-above-one": {
+			in:  valid(func(f *coupleflags) { f.repairJitter = 1.5 }),
+			out: []string{"--repair-jitter=1.5 is out of range, use a fraction above 0 and at most 1, e.g. 0.5"},
+		},
+		"repair-jitter-one": {
+			in:  valid(func(f *coupleflags) { f.repairJitter = 1 }),
+			out: nil,
+		},
+		"shard-count-negative": {
+			in:  valid(func(f *coupleflags) { f.shardCount = -1 }),
+			out: []string{"--shard-count=-1 is negative, use 0 to disable sharding"},
+		},
+		"shard-index-out-of-range": {
+			in: valid(func(f *coupleflags) {
+				f.shardCount = 3
+				f.shardIndex = 3
+			}),
+			out: []string{"--shard-index=3 is out of range, use a value between 0 and 2"},
+		},
+		"reconcile-timeout-negative": {
+			in:  valid(func(f *coupleflags) { f.reconcileTimeout = -time.Second }),
+			out: []string{"--reconcile-timeout=-1s is negative, use 0 to wait forever or the default 5m0s"},
+		},
+		"tag-limit-negative": {
+			in:  valid(func(f *coupleflags) { f.tagLimit = -1 }),
+			out: []string{"--tag-limit=-1 is negative, use 0 to disable tags or the default 32"},
+		},
+		"annotation-defaults-outside-watch-namespace": {
+			in: valid(func(f *coupleflags) {
+				f.watchNamespace = "unit"
+				f.annotationDefaults = k8s.ObjValue{Namespace: "other", Name: "argo-defaults"}
+			}),
+			out: []string{"--annotation-defaults-configmap=other/argo-defaults is outside --watch-namespace=unit and cannot be read, move the configmap to unit"},
+		},
+		"only-ingress-outside-watch-namespace": {
+			in: valid(func(f *coupleflags) {
+				f.watchNamespace = "unit"
+				f.onlyIngress = k8s.ObjValue{Namespace: "other", Name: "ing"}
+			}),
+			out: []string{"--only-ingress=other/ing is outside --watch-namespace=unit and cannot be read, drop --watch-namespace while debugging"},
+		},
+		"origin-secret-outside-watch-namespace": {
+			in: valid(func(f *coupleflags) {
+				f.watchNamespace = "unit"
+				f.originSecret = k8s.ObjValue{Namespace: "other", Name: "sec"}
+			}),
+			out: []string{"--default-origin-secret=other/sec is outside --watch-namespace=unit and cannot be read, move the secret to unit"},
+		},
+		"origin-secret-inside-watch-namespace": {
+			in: valid(func(f *coupleflags) {
+				f.watchNamespace = "unit"
+				f.originSecret = k8s.ObjValue{Namespace: "unit", Name: "sec"}
+			}),
+			out: nil,
+		},
+		"origin-secret-config-outside-watch-namespace": {
+			in: valid(func(f *coupleflags) {
+				f.watchNamespace = "unit"
+				f.originSecretGroups = []cloudflare.OriginSecretGroup{
+					{Hosts: []string{"a.unit.com"}, Secret: cloudflare.OriginSecret{Namespace: "unit", Name: "sec-a"}},
+					{Hosts: []string{"b.unit.com"}, Secret: cloudflare.OriginSecret{Namespace: "other", Name: "sec-b"}},
+				}
+			}),
+			out: []string{"--origin-secret-config group at index 1 references secret other/sec-b outside --watch-namespace=unit and cannot be read, move the secret to unit"},
+		},
+		"origin-secret-config-all-namespaces": {
+			in: valid(func(f *coupleflags) {
+				f.originSecretGroups = []cloudflare.OriginSecretGroup{
+					{Hosts: []string{"a.unit.com"}, Secret: cloudflare.OriginSecret{Namespace: "unit", Name: "sec-a"}},
+					{Hosts: []string{"b.unit.com"}, Secret: cloudflare.OriginSecret{Namespace: "other", Name: "sec-b"}},
+				}
+			}),
+			out: nil,
+		},
+		"metrics-tls-key-missing": {
+			in:  valid(func(f *coupleflags) { f.metricsTLSCertFile = "/tls/tls.crt" }),
+			out: []string{"--metrics-tls-cert-file and --metrics-tls-key-file must be set together, set both to serve https"},
+		},
+		"debug-client-ca-without-tls": {
+			in:  valid(func(f *coupleflags) { f.debugClientCAFile = "/tls/ca.crt" }),
+			out: []string{"--debug-client-ca-file requires https, set --debug-tls-cert-file and --debug-tls-key-file"},
+		},
+		"metrics-tls-client-ca": {
+			in: valid(func(f *coupleflags) {
+				f.metricsTLSCertFile = "/tls/tls.crt"
+				f.metricsTLSKeyFile = "/tls/tls.key"
+				f.metricsClientCAFile = "/tls/ca.crt"
+			}),
+			out: nil,
+		},
+		"workers-zero": {
+			in:  valid(func(f *coupleflags) { f.workers = 0 }),
+			out: []string{"--workers=0 leaves the queue unprocessed, use at least 1, e.g. 2"},
+		},
+		"flags-many": {
+			in: valid(func(f *coupleflags) {
+				f.tagLimit = -1
+				f.workers = 0
+			}),
+			out: []string{
+				"--tag-limit=-1 is negative, use 0 to disable tags or the default 32",
+				"--workers=0 leaves the queue unprocessed, use at least 1, e.g. 2",
+			},
+		},
+	} {
+		out := validatecouple(test.in)
+		assert.Equalf(t, test.out, out, "test '%s' problems mismatch", name)
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certfile := filepath.Join(dir, "tls.crt")
+	keyfile := filepath.Join(dir, "tls.key")
+	cafile := filepath.Join(dir, "ca.crt")
+
+	ca, cakey, capem, _ := gencert(t, "unit-ca", nil, nil)
+	_, _, clientpem, clientkeypem := gencert(t, "unit-client", ca, cakey)
+	_, _, serverpem, serverkeypem := gencert(t, "unit-server-a", nil, nil)
+	writefile(t, cafile, capem)
+	writefile(t, certfile, serverpem)
+	writefile(t, keyfile, serverkeypem)
+
+	logger, hook := logtest.NewNullLogger()
+	config, reloader, err := servertls(certfile, keyfile, cafile, logger)
+	assert.Nilf(t, err, "test server tls error mismatch")
+	stop := make(chan struct{})
+	defer close(stop)
+	go reloader.run(stop)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nilf(t, err, "test listen error mismatch")
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(tls.NewListener(l, config))
+	defer srv.Close()
+
+	clientcert, err := tls.X509KeyPair(clientpem, clientkeypem)
+	assert.Nilf(t, err, "test client cert error mismatch")
+	dial := func(certs []tls.Certificate) (string, error) {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+			Certificates:       certs,
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "GET / HTTP/1.0\r\n\r\n")
+		if _, err = io.ReadAll(conn); err != nil {
+			return "", err
+		}
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	_, err = dial(nil)
+	assert.NotNilf(t, err, "test client without certificate mismatch")
+	served, err := dial([]tls.Certificate{clientcert})
+	assert.Nilf(t, err, "test client certificate error mismatch")
+	assert.Equalf(t, "unit-server-a", served, "test served certificate mismatch")
+
+	// the rotation is served once the watched files settle
+	_, _, serverpem, serverkeypem = gencert(t, "unit-server-b", nil, nil)
+	writefile(t, certfile, serverpem)
+	writefile(t, keyfile, serverkeypem)
+	assert.Eventuallyf(t, func() bool {
+		served, err = dial([]tls.Certificate{clientcert})
+		return err == nil && served == "unit-server-b"
+	}, 5*time.Second, 10*time.Millisecond, "test rotated certificate mismatch")
+
+	writefile(t, keyfile, []byte("invalid"))
+	assert.Eventuallyf(t, func() bool {
+		return len(hook.AllEntries()) == 2
+	}, 5*time.Second, 10*time.Millisecond, "test invalid rotation log mismatch")
+	served, err = dial([]tls.Certificate{clientcert})
+	assert.Nilf(t, err, "test invalid rotation error mismatch")
+	assert.Equalf(t, "unit-server-b", served, "test invalid rotation certificate mismatch")
+	_, err = dial([]tls.Certificate{clientcert})
+	assert.Nilf(t, err, "test invalid rotation repeated error mismatch")
+
+	levels := map[logrus.Level]int{}
+	for _, e := range hook.AllEntries() {
+		levels[e.Level]++
+	}
+	assert.Equalf(t, map[logrus.Level]int{logrus.InfoLevel: 1, logrus.ErrorLevel: 1}, levels, "test rotation logs mismatch")
+}
+
+// gencert creates a certificate signed by the parent, self-signed
+// without a parent
+func gencert(t *testing.T, cn string, parent *x509.Certificate, parentkey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentkey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentkey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyder, _ := x509.MarshalECPrivateKey(key)
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder})
+}
+
+func TestCheckOriginSecret(t *testing.T) {
+	t.Parallel()
+	cert, _, certpem, keypem := gencert(t, "unit", nil, nil)
+	_, _, _, otherkeypem := gencert(t, "unit", nil, nil)
+	for name, test := range map[string]struct {
+		data      map[string][]byte
+		encodings int
+		err       string
+	}{
+		"cert-pem": {
+			data: map[string][]byte{k8s.CertPem: certpem},
+		},
+		"cert-pem-encoded": {
+			data:      map[string][]byte{k8s.CertPem: []byte(base64.StdEncoding.EncodeToString(certpem))},
+			encodings: 1,
+		},
+		"cert-pem-der": {
+			data: map[string][]byte{k8s.CertPem: cert.Raw},
+			err:  "'cert.pem' origin certificate is DER encoded, convert it to PEM, e.g. 'openssl x509 -inform der -outform pem'",
+		},
+		"tls": {
+			data: map[string][]byte{k8s.TLSCert: certpem, k8s.TLSKey: keypem},
+		},
+		"tls-key-mismatch": {
+			data: map[string][]byte{k8s.TLSCert: certpem, k8s.TLSKey: otherkeypem},
+			err:  "certificate and key are not a valid origin certificate: tls: private key does not match public key",
+		},
+		"no-cert": {
+			data: map[string][]byte{},
+			err:  "missing 'cert.pem' or 'tls.crt' and 'tls.key'",
+		},
+	} {
+		client := fake.NewSimpleClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "origin"},
+			Data:       test.data,
+		})
+		encodings, err := checkoriginsecret(client, "unit", "origin")
+		assert.Equalf(t, test.encodings, encodings, "test '%s' encodings mismatch", name)
+		if len(test.err) == 0 {
+			assert.Nilf(t, err, "test '%s' error mismatch", name)
+		} else if assert.NotNilf(t, err, "test '%s' error mismatch", name) {
+			assert.Equalf(t, test.err, err.Error(), "test '%s' error mismatch", name)
+		}
+	}
+	_, err := checkoriginsecret(fake.NewSimpleClientset(), "unit", "origin")
+	assert.NotNil(t, err, "test 'missing-secret' error mismatch")
+}
+
+// writefile writes the file with a modification time past the previous
+// write, on file systems with a coarse time resolution
+func writefile(t *testing.T, file string, b []byte) {
+	prev, err := os.Stat(file)
+	if err = os.WriteFile(file, b, 0600); err != nil {
+		t.Fatalf("write %s: %v", file, err)
+	}
+	if fi, err := os.Stat(file); err == nil && prev != nil && !fi.ModTime().After(prev.ModTime()) {
+		mtime := prev.ModTime().Add(time.Second)
+		os.Chtimes(file, mtime, mtime)
+	}
+}
+
+func TestForceExitAfter(t *testing.T) {
+	t.Parallel()
+	assert.Nilf(t, forceexitafter(0, logrus.New(), func(int) {}), "test disabled deadline mismatch")
+
+	logger, hook := logtest.NewNullLogger()
+	codes := make(chan int, 1)
+	forceexitafter(10*time.Millisecond, logger, func(code int) { codes <- code })
+	select {
+	case code := <-codes:
+		assert.Equalf(t, 1, code, "test exit code mismatch")
+	case <-time.After(time.Second):
+		t.Fatalf("test deadline did not force the exit")
+	}
+	assert.Equalf(t, "shutdown did not finish within --shutdown-deadline=10ms, forcing exit", hook.LastEntry().Message, "test warning mismatch")
+}
+
+func TestInsecureKubeconfig(t *testing.T) {
+	t.Parallel()
+	c := &rest.Config{
+		Host: "https://127.0.0.1:6443",
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile: "/tmp/ca.crt",
+			CAData: []byte("ca"),
+		},
+	}
+	insecurekubeconfig(c)
+	assert.Truef(t, c.Insecure, "test insecure mismatch")
+	assert.Emptyf(t, c.CAFile, "test ca file mismatch")
+	assert.Emptyf(t, c.CAData, "test ca data mismatch")
+	_, err := rest.TransportFor(c)
+	assert.Nilf(t, err, "test transport error mismatch")
+}
+
+func TestLogrusFields(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  map[string]string
+		out logrus.Fields
+	}{
+		"log-field-none": {
+			in:  map[string]string{},
+			out: nil,
+		},
+		"log-field-many": {
+			in: map[string]string{
+				"deployment": "blue",
+				"cluster":    "eu-1",
+			},
+			out: logrus.Fields{
+				"deployment": "blue",
+				"cluster":    "eu-1",
+			},
+		},
+	} {
+		out := logrusfields(test.in)
+		assert.Equalf(t, test.out, out, "test '%s' logrus fields mismatch", name)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	statsHandler(time.Now().Add(-time.Minute))(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var out stats
+	err := json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.True(t, out.Goroutines > 0)
+	assert.True(t, out.Memory.Sys > 0)
+	assert.True(t, out.UptimeSeconds >= 60)
+}
+
+func TestPrintStatus(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		code    int
+		body    string
+		secrets bool
+		out     string
+		err     string
+	}{
+		"tunnels": {
+			code: http.StatusOK,
+			body: `{"shard":null,"tunnels":[{"hostname":"a.unit.com","ingress":"unit/a","service":"unit/svc","port":8080,"secret":"unit/sec","upstreamMode":"service"}]}`,
+			out: "HOSTNAME    INGRESS  SERVICE        SECRET\n" +
+				"a.unit.com  unit/a   unit/svc:8080  unit/sec\n",
+		},
+		"secrets": {
+			code:    http.StatusOK,
+			body:    `[{"secret":"unit/sec","references":2,"routes":[{"ingress":"unit/a","hostname":"a.unit.com"},{"ingress":"unit/b","hostname":"b.unit.com"}]}]`,
+			secrets: true,
+			out: "SECRET    REFERENCES  INGRESS  HOSTNAME\n" +
+				"unit/sec  2           unit/a   a.unit.com\n" +
+				"unit/sec  2           unit/b   b.unit.com\n",
+		},
+		"not-found": {
+			code:    http.StatusNotFound,
+			body:    "404 page not found\n",
+			secrets: true,
+			err:     "404 Not Found: 404 page not found",
+		},
+	} {
+		w := httptest.NewRecorder()
+		w.WriteHeader(test.code)
+		w.WriteString(test.body)
+		var out strings.Builder
+		err := printstatus(&out, w.Result(), test.secrets)
+		if len(test.err) == 0 {
+			assert.Nilf(t, err, "test '%s' error mismatch", name)
+			assert.Equalf(t, test.out, out.String(), "test '%s' output mismatch", name)
+		} else if assert.NotNilf(t, err, "test '%s' error mismatch", name) {
+			assert.Equalf(t, test.err, err.Error(), "test '%s' error mismatch", name)
+		}
+	}
+}
+
+func TestInfoHandler(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	infoHandler("test")(w, httptest.NewRequest(http.MethodGet, "/debug/info", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var out info
+	err := json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", out.Version)
+	assert.Equal(t, argotunnel.RepairStrategyDefault, out.Repair.Strategy)
+	assert.Equal(t, argotunnel.RepairDelayDefault.String(), out.Repair.Delay)
+}
+
+func TestLogRing(t *testing.T) {
+	t.Parallel()
+	ring := newlogring(3)
+	assert.Equalf(t, []logline{}, ring.last(0), "test empty lines mismatch")
+
+	log := logrus.New()
+	log.Out = io.Discard
+	log.AddHook(ring)
+	for i := 1; i <= 5; i++ {
+		log.WithField("attempt", i).Infof("line %d", i)
+	}
+	messages := func(lines []logline) (out []string) {
+		for _, line := range lines {
+			out = append(out, line.Message)
+		}
+		return
+	}
+	assert.Equalf(t, []string{"line 3", "line 4", "line 5"}, messages(ring.last(0)), "test all lines mismatch")
+	assert.Equalf(t, []string{"line 4", "line 5"}, messages(ring.last(2)), "test last lines mismatch")
+	assert.Equalf(t, []string{"line 3", "line 4", "line 5"}, messages(ring.last(10)), "test over lines mismatch")
+
+	for name, test := range map[string]struct {
+		method string
+		target string
+		code   int
+		lines  []string
+	}{
+		"all": {
+			method: http.MethodGet,
+			target: "/logs",
+			code:   http.StatusOK,
+			lines:  []string{"line 3", "line 4", "line 5"},
+		},
+		"last": {
+			method: http.MethodGet,
+			target: "/logs?lines=1",
+			code:   http.StatusOK,
+			lines:  []string{"line 5"},
+		},
+		"lines-invalid": {
+			method: http.MethodGet,
+			target: "/logs?lines=-1",
+			code:   http.StatusBadRequest,
+		},
+		"post": {
+			method: http.MethodPost,
+			target: "/logs",
+			code:   http.StatusMethodNotAllowed,
+		},
+	} {
+		w := httptest.NewRecorder()
+		ring.ServeHTTP(w, httptest.NewRequest(test.method, test.target, nil))
+		assert.Equalf(t, test.code, w.Code, "test '%s' status mismatch", name)
+		if test.code != http.StatusOK {
+			continue
+		}
+		var out []logline
+		err := json.NewDecoder(w.Body).Decode(&out)
+		assert.Nilf(t, err, "test '%s' decode mismatch", name)
+		assert.Equalf(t, test.lines, messages(out), "test '%s' lines mismatch", name)
+		assert.Equalf(t, "info", out[len(out)-1].Level, "test '%s' level mismatch", name)
+		assert.Equalf(t, float64(5), out[len(out)-1].Fields["attempt"], "test '%s' fields mismatch", name)
+	}
+}
+
+func TestRbacManifest(t *testing.T) {
+	t.Parallel()
+	rules := []rbacv1.PolicyRule{{
+		APIGroups: []string{""},
+		Resources: []string{"services"},
+		Verbs:     []string{"list", "watch"},
+	}}
+	out, err := rbacmanifest("unit-argo", "unit", rules)
+	assert.Nil(t, err)
+
+	docs := strings.Split(string(out), "---\n")
+	assert.Equal(t, 3, len(docs))
+
+	var account v1.ServiceAccount
+	assert.Nil(t, yaml.Unmarshal([]byte(docs[0]), &account))
+	assert.Equal(t, "ServiceAccount", account.Kind)
+	assert.Equal(t, "unit-argo", account.Name)
+	assert.Equal(t, "unit", account.Namespace)
+
+	var role rbacv1.ClusterRole
+	assert.Nil(t, yaml.Unmarshal([]byte(docs[1]), &role))
+	assert.Equal(t, "ClusterRole", role.Kind)
+	assert.Equal(t, "unit-argo", role.Name)
+	assert.Equal(t, rules, role.Rules)
+
+	var binding rbacv1.ClusterRoleBinding
+	assert.Nil(t, yaml.Unmarshal([]byte(docs[2]), &binding))
+	assert.Equal(t, "ClusterRoleBinding", binding.Kind)
+	assert.Equal(t, "unit-argo", binding.RoleRef.Name)
+	assert.Equal(t, []rbacv1.Subject{{Kind: "ServiceAccount", Name: "unit-argo", Namespace: "unit"}}, binding.Subjects)
+}
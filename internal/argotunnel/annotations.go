@@ -1,7 +1,11 @@
 package argotunnel
 
 import (
+	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	networkingv1 "k8s.io/api/networking/v1"
@@ -11,19 +15,112 @@ import (
 
 const (
 	annotationIngressClass              = "kubernetes.io/ingress.class"
+	annotationIngressClassIsDefault     = "ingressclass.kubernetes.io/is-default-class"
 	annotationIngressCompressionQuality = "argo.cloudflare.com/compression-quality"
+	annotationIngressDrain              = "argo.cloudflare.com/drain"
+	annotationIngressEdgeRegion         = "argo.cloudflare.com/edge-region"
+	annotationIngressFallbackService    = "argo.cloudflare.com/fallback-service"
 	annotationIngressHAConnections      = "argo.cloudflare.com/ha-connections"
 	annotationIngressHeartbeatCount     = "argo.cloudflare.com/heartbeat-count"
 	annotationIngressHeartbeatInterval  = "argo.cloudflare.com/heartbeat-interval"
+	annotationIngressIncludeUnready     = "argo.cloudflare.com/include-unready-endpoints"
+	annotationIngressInitialDelay       = "argo.cloudflare.com/initial-delay"
+	annotationIngressInjectOverride     = "argo.cloudflare.com/inject-override"
+	annotationIngressInjectRobots       = "argo.cloudflare.com/inject-robots"
 	annotationIngressLoadBalancer       = "argo.cloudflare.com/lb-pool"
+	annotationIngressMaintenance        = "argo.cloudflare.com/maintenance"
+	annotationIngressMaintenanceConfig  = "argo.cloudflare.com/maintenance-configmap"
+	annotationIngressMaxBodyBytes       = "argo.cloudflare.com/max-body-bytes"
+	annotationIngressMaxConcurrent      = "argo.cloudflare.com/max-concurrent-requests"
 	annotationIngressNoChunkedEncoding  = "argo.cloudflare.com/no-chunked-encoding"
+	annotationIngressNoForwardedHeaders = "argo.cloudflare.com/no-forwarded-headers"
+	annotationIngressOriginScheme       = "argo.cloudflare.com/origin-scheme"
+	annotationIngressPermanentRedirect  = "argo.cloudflare.com/permanent-redirect"
+	annotationIngressPriority           = "argo.cloudflare.com/priority"
+	annotationIngressProto              = "argo.cloudflare.com/proto"
+	annotationIngressReadinessPath      = "argo.cloudflare.com/readiness-path"
+	annotationIngressResponseHeaders    = "argo.cloudflare.com/response-headers"
 	annotationIngressRetries            = "argo.cloudflare.com/retries"
+	annotationIngressRewriteTarget      = "argo.cloudflare.com/rewrite-target"
+	annotationIngressStreamBufferBytes  = "argo.cloudflare.com/stream-buffer-bytes"
 	annotationIngressTag                = "argo.cloudflare.com/tag"
+	annotationIngressTargetService      = "argo.cloudflare.com/target-service"
+	annotationIngressTemporalRedirect   = "argo.cloudflare.com/temporal-redirect"
+	annotationIngressTrailingSlash      = "argo.cloudflare.com/trailing-slash-redirect"
+	annotationIngressUpstreamMode       = "argo.cloudflare.com/upstream-mode"
+
+	labelACMESolver = "acme.cert-manager.io/http01-solver"
+
+	// annotationPrefix is the prefix of the controller annotations
+	annotationPrefix = "argo.cloudflare.com/"
+	// annotationValueLimit is the largest annotation value translated,
+	// larger values are ignored
+	annotationValueLimit = 8 << 10
 )
 
+// knownAnnotations are the controller annotations kept in the cache, other
+// annotations under the prefix are stripped by the ingress informer
+var knownAnnotations = map[string]bool{
+	annotationIngressCompressionQuality: true,
+	annotationIngressDrain:              true,
+	annotationIngressEdgeRegion:         true,
+	annotationIngressFallbackService:    true,
+	annotationIngressHAConnections:      true,
+	annotationIngressHeartbeatCount:     true,
+	annotationIngressHeartbeatInterval:  true,
+	annotationIngressIncludeUnready:     true,
+	annotationIngressInitialDelay:       true,
+	annotationIngressInjectOverride:     true,
+	annotationIngressInjectRobots:       true,
+	annotationIngressLoadBalancer:       true,
+	annotationIngressMaintenance:        true,
+	annotationIngressMaintenanceConfig:  true,
+	annotationIngressMaxBodyBytes:       true,
+	annotationIngressMaxConcurrent:      true,
+	annotationIngressNoChunkedEncoding:  true,
+	annotationIngressNoForwardedHeaders: true,
+	annotationIngressOriginScheme:       true,
+	annotationIngressPermanentRedirect:  true,
+	annotationIngressPriority:           true,
+	annotationIngressProto:              true,
+	annotationIngressReadinessPath:      true,
+	annotationIngressResponseHeaders:    true,
+	annotationIngressRetries:            true,
+	annotationIngressRewriteTarget:      true,
+	annotationIngressStreamBufferBytes:  true,
+	annotationIngressTag:                true,
+	annotationIngressTargetService:      true,
+	annotationIngressTemporalRedirect:   true,
+	annotationIngressTrailingSlash:      true,
+	annotationIngressUpstreamMode:       true,
+}
+
+// stripUnknownAnnotations drops the annotations under the controller
+// prefix the controller does not read, so junk does not sit in the cache
+func stripUnknownAnnotations(obj metav1.Object) {
+	annotations := obj.GetAnnotations()
+	for key := range annotations {
+		if strings.HasPrefix(key, annotationPrefix) && !knownAnnotations[key] {
+			delete(annotations, key)
+		}
+	}
+}
+
+// getOversizedAnnotations lists, in name order, the controller annotations
+// with a value over the limit
+func getOversizedAnnotations(obj metav1.Object) (keys []string) {
+	for key, val := range obj.GetAnnotations() {
+		if strings.HasPrefix(key, annotationPrefix) && len(val) > annotationValueLimit {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
 func parseIngressTunnelOptions(ing *networkingv1.Ingress) (opts []tunnelOption) {
 	if ingMeta, err := meta.Accessor(ing); err == nil {
-		if val, ok := parseMetaUint64(ingMeta, annotationIngressCompressionQuality); ok {
+		if val, ok := parseMetaUint64(ingMeta, annotationIngressCompressionQuality); ok && val <= CompressionQualityMax {
 			opts = append(opts, compressionQuality(val))
 		}
 		if val, ok := parseMetaInt(ingMeta, annotationIngressHAConnections); ok {
@@ -35,18 +132,69 @@ func parseIngressTunnelOptions(ing *networkingv1.Ingress) (opts []tunnelOption)
 		if val, ok := parseMetaDuration(ingMeta, annotationIngressHeartbeatInterval); ok {
 			opts = append(opts, heartbeatInterval(val))
 		}
+		if val, ok := parseMetaBool(ingMeta, annotationIngressIncludeUnready); ok {
+			opts = append(opts, includeUnreadyEndpoints(val))
+		}
+		if val, ok := parseMetaDuration(ingMeta, annotationIngressInitialDelay); ok && val > 0 {
+			opts = append(opts, initialDelay(val))
+		}
 		if val, ok := ingMeta.GetAnnotations()[annotationIngressLoadBalancer]; ok {
 			opts = append(opts, lbPool(val))
 		}
+		if val, ok := parseMetaBool(ingMeta, annotationIngressInjectOverride); ok {
+			opts = append(opts, injectOverride(val))
+		}
+		if val, ok := parseMetaRobots(ingMeta, annotationIngressInjectRobots); ok {
+			opts = append(opts, injectRobots(val))
+		}
+		if val, ok := parseMetaBool(ingMeta, annotationIngressMaintenance); ok {
+			opts = append(opts, maintenance(val))
+		}
+		if val, ok := parseMetaUint64(ingMeta, annotationIngressMaxBodyBytes); ok {
+			opts = append(opts, maxBodyBytes(val))
+		}
+		if val, ok := parseMetaUint64(ingMeta, annotationIngressMaxConcurrent); ok {
+			opts = append(opts, maxConcurrentRequests(val))
+		}
 		if val, ok := parseMetaBool(ingMeta, annotationIngressNoChunkedEncoding); ok {
 			opts = append(opts, disableChunkedEncoding(val))
 		}
+		if val, ok := parseMetaBool(ingMeta, annotationIngressNoForwardedHeaders); ok {
+			opts = append(opts, disableForwardedHeaders(val))
+		}
+		if val, ok := parseMetaProto(ingMeta, annotationIngressOriginScheme); ok {
+			opts = append(opts, originScheme(val))
+		}
+		if val, ok := parseMetaProto(ingMeta, annotationIngressProto); ok {
+			opts = append(opts, proto(val))
+		}
+		if code, val, ok := parseMetaRedirect(ingMeta); ok {
+			opts = append(opts, redirect(code, val))
+		}
+		if val, ok := parseMetaReadinessPath(ingMeta, annotationIngressReadinessPath); ok {
+			opts = append(opts, readinessPath(val))
+		}
 		if val, ok := parseMetaUint(ingMeta, annotationIngressRetries); ok {
 			opts = append(opts, retries(val))
 		}
+		if val, ok := parseMetaRewriteTarget(ingMeta, annotationIngressRewriteTarget); ok {
+			opts = append(opts, rewriteTarget(val))
+		}
+		if val, ok := parseMetaUint64(ingMeta, annotationIngressStreamBufferBytes); ok {
+			opts = append(opts, streamBufferBytes(val))
+		}
 		if val, ok := ingMeta.GetAnnotations()[annotationIngressTag]; ok {
 			opts = append(opts, tags(val))
 		}
+		if val, ok := ingMeta.GetAnnotations()[annotationIngressTargetService]; ok && len(val) > 0 {
+			opts = append(opts, targetService(val))
+		}
+		if val, ok := parseMetaBool(ingMeta, annotationIngressTrailingSlash); ok {
+			opts = append(opts, trailingSlashRedirect(val))
+		}
+		if val, ok := parseMetaUpstreamMode(ingMeta, annotationIngressUpstreamMode); ok {
+			opts = append(opts, upstreamMode(val))
+		}
 	}
 	return
 }
@@ -81,6 +229,78 @@ func parseMetaInt(obj metav1.Object, key string) (val int, ok bool) {
 	return
 }
 
+func parseMetaProto(obj metav1.Object, key string) (val string, ok bool) {
+	if s, in := obj.GetAnnotations()[key]; in {
+		switch s {
+		case ProtoHTTP, ProtoHTTPS:
+			val, ok = s, true
+		}
+	}
+	return
+}
+
+func parseMetaRobots(obj metav1.Object, key string) (val string, ok bool) {
+	if s, in := obj.GetAnnotations()[key]; in {
+		switch s {
+		case robotsDisallowAll:
+			val, ok = s, true
+		}
+	}
+	return
+}
+
+func parseMetaReadinessPath(obj metav1.Object, key string) (val string, ok bool) {
+	if s, in := obj.GetAnnotations()[key]; in {
+		if s == ReadinessProbeTCP || strings.HasPrefix(s, "/") {
+			val, ok = s, true
+		}
+	}
+	return
+}
+
+// parseMetaRedirect reads the redirect of an ingress, the permanent
+// redirect takes precedence over the temporal one
+func parseMetaRedirect(obj metav1.Object) (code int, val string, ok bool) {
+	if val, ok = parseMetaRedirectURL(obj, annotationIngressPermanentRedirect); ok {
+		return http.StatusMovedPermanently, val, true
+	}
+	if val, ok = parseMetaRedirectURL(obj, annotationIngressTemporalRedirect); ok {
+		return http.StatusFound, val, true
+	}
+	return 0, "", false
+}
+
+// parseMetaRedirectURL accepts an absolute http or https url
+func parseMetaRedirectURL(obj metav1.Object, key string) (val string, ok bool) {
+	if s, in := obj.GetAnnotations()[key]; in {
+		if u, err := url.Parse(s); err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Hostname()) > 0 {
+			val, ok = s, true
+		}
+	}
+	return
+}
+
+// parseMetaRewriteTarget accepts an absolute path, capture groups are
+// not supported
+func parseMetaRewriteTarget(obj metav1.Object, key string) (val string, ok bool) {
+	if s, in := obj.GetAnnotations()[key]; in {
+		if strings.HasPrefix(s, "/") && validRewriteCaptures(s) {
+			val, ok = normalizePath(s), true
+		}
+	}
+	return
+}
+
+func parseMetaUpstreamMode(obj metav1.Object, key string) (val string, ok bool) {
+	if s, in := obj.GetAnnotations()[key]; in {
+		switch s {
+		case UpstreamModeService, UpstreamModeEndpoint:
+			val, ok = s, true
+		}
+	}
+	return
+}
+
 func parseMetaUint(obj metav1.Object, key string) (val uint, ok bool) {
 	if s, in := obj.GetAnnotations()[key]; in {
 		if v, err := strconv.ParseUint(s, 10, 32); err == nil {
@@ -99,9 +319,113 @@ func parseMetaUint64(obj metav1.Object, key string) (val uint64, ok bool) {
 	return
 }
 
+// parseMaintenanceConfigMap reads the name of the ConfigMap holding
+// the maintenance response, the ConfigMap is in the ingress namespace.
+func parseMaintenanceConfigMap(ing *networkingv1.Ingress) (val string, ok bool) {
+	if ingMeta, err := meta.Accessor(ing); err == nil {
+		val, ok = ingMeta.GetAnnotations()[annotationIngressMaintenanceConfig]
+		ok = ok && len(val) > 0
+	}
+	return
+}
+
+// parseResponseHeaders reads the headers set on origin responses, either
+// inline 'Name: value' lines, or the name of a Secret in the ingress
+// namespace mapping header names to values
+func parseResponseHeaders(ing *networkingv1.Ingress) (inline http.Header, secret string, ok bool) {
+	if ingMeta, err := meta.Accessor(ing); err == nil {
+		val := strings.TrimSpace(ingMeta.GetAnnotations()[annotationIngressResponseHeaders])
+		if len(val) == 0 {
+			return
+		}
+		if strings.ContainsRune(val, ':') {
+			inline = parseHeaderLines(val)
+			ok = len(inline) > 0
+		} else {
+			secret, ok = val, true
+		}
+	}
+	return
+}
+
+// parseFallbackService reads the '<service>:<port>' serving requests the
+// backend cannot be reached for, the service is in the ingress namespace
+// and the port is a number or a name.
+func parseFallbackService(ing *networkingv1.Ingress) (name string, port networkingv1.ServiceBackendPort, ok bool) {
+	if ingMeta, err := meta.Accessor(ing); err == nil {
+		val := ingMeta.GetAnnotations()[annotationIngressFallbackService]
+		i := strings.LastIndexByte(val, ':')
+		if i <= 0 || i == len(val)-1 {
+			return
+		}
+		name = val[:i]
+		if n, err := strconv.ParseInt(val[i+1:], 10, 32); err == nil {
+			if n <= 0 {
+				return "", port, false
+			}
+			port.Number = int32(n)
+		} else {
+			port.Name = val[i+1:]
+		}
+		ok = true
+	}
+	return
+}
+
+// parseIngressClass reads the class claimed by the ingress, the
+// annotation takes precedence over the ingressClassName field.
 func parseIngressClass(ing *networkingv1.Ingress) (val string, ok bool) {
 	if ingMeta, err := meta.Accessor(ing); err == nil {
 		val, ok = ingMeta.GetAnnotations()[annotationIngressClass]
 	}
+	if !ok && ing != nil && ing.Spec.IngressClassName != nil && len(*ing.Spec.IngressClassName) > 0 {
+		val, ok = *ing.Spec.IngressClassName, true
+	}
 	return
 }
+
+// isACMESolver verifies the ingress is a cert-manager http-01 solver,
+// served through the tunnel of its host rather than a tunnel of its own
+func isACMESolver(ing *networkingv1.Ingress) bool {
+	if ingMeta, err := meta.Accessor(ing); err == nil {
+		val, ok := ingMeta.GetLabels()[labelACMESolver]
+		return ok && val == "true"
+	}
+	return false
+}
+
+// matchIngressClass verifies the ingress is managed by the controller.
+// An ingress claiming a class is only matched by a class of the
+// controller, an ingress without a class is only matched when adopting
+// unclassed ingresses.
+func matchIngressClass(ing *networkingv1.Ingress, ingressClass string, adoptUnclassed bool) bool {
+	if objIngClass, ok := parseIngressClass(ing); ok {
+		for _, class := range parseIngressClasses(ingressClass) {
+			if class == objIngClass {
+				return true
+			}
+		}
+		return false
+	}
+	return adoptUnclassed
+}
+
+// parseIngressClasses splits the comma-separated ingress classes of the
+// controller. The first class is current, the others are legacy classes
+// adopted while their ingresses migrate to it.
+func parseIngressClasses(s string) (classes []string) {
+	for _, class := range strings.Split(s, ",") {
+		if class = strings.TrimSpace(class); len(class) > 0 {
+			classes = append(classes, class)
+		}
+	}
+	return
+}
+
+// primaryIngressClass returns the current class of the controller
+func primaryIngressClass(s string) string {
+	if classes := parseIngressClasses(s); len(classes) > 0 {
+		return classes[0]
+	}
+	return ""
+}
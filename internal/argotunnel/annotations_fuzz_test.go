@@ -0,0 +1,75 @@
+//go:build go1.18
+// +build go1.18
+
+package argotunnel
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fuzzAnnotationKeys are the annotations set by the fuzz targets, sorted
+// so a seed picks the same key on every run
+func fuzzAnnotationKeys() []string {
+	keys := []string{annotationIngressClass}
+	for key := range knownAnnotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func FuzzParseIngressTunnelOptions(f *testing.F) {
+	keys := fuzzAnnotationKeys()
+	for i := range keys {
+		for _, val := range []string{"", "0", "-1", "true", "1s", "https", "/", "/$1", "svc:80", "ns/name", "18446744073709551616", "\x00\xff"} {
+			f.Add(uint8(i), val, uint8(i+1), val)
+		}
+	}
+	f.Fuzz(func(t *testing.T, ka uint8, va string, kb uint8, vb string) {
+		ing := &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "unit",
+				Name:      "unit",
+				Annotations: map[string]string{
+					keys[int(ka)%len(keys)]: va,
+					keys[int(kb)%len(keys)]: vb,
+				},
+			},
+		}
+		opts := collectTunnelOptions(parseIngressTunnelOptions(ing))
+		if opts.compressionQuality > CompressionQualityMax {
+			t.Errorf("compression quality %d out of range", opts.compressionQuality)
+		}
+		parseIngressClass(ing)
+		parseMaintenanceConfigMap(ing)
+		parseFallbackService(ing)
+		parseMetaRedirect(ing)
+	})
+}
+
+func FuzzAnnotationLimits(f *testing.F) {
+	f.Add("argo.cloudflare.com/request-headers", "x")
+	f.Add(annotationIngressTag, "a,b")
+	f.Add("argo.cloudflare.com/", "")
+	f.Add("kubernetes.io/ingress.class", "argo-tunnel")
+	f.Fuzz(func(t *testing.T, key, val string) {
+		ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{key: val}}}
+		oversized := getOversizedAnnotations(ing)
+		if len(oversized) > 0 && len(val) <= annotationValueLimit {
+			t.Errorf("annotation %q of %d bytes reported oversized", key, len(val))
+		}
+		stripUnknownAnnotations(ing)
+		if _, ok := ing.Annotations[key]; !ok && !isUnknownAnnotation(key) {
+			t.Errorf("annotation %q stripped", key)
+		}
+	})
+}
+
+func isUnknownAnnotation(key string) bool {
+	return strings.HasPrefix(key, annotationPrefix) && !knownAnnotations[key]
+}
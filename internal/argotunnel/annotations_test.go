@@ -1,6 +1,7 @@
 package argotunnel
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +11,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+var testIngressClass = "test"
+
 func TestParseIngressClassAnnotation(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
@@ -56,6 +59,35 @@ func TestParseIngressClassAnnotation(t *testing.T) {
 			out: "test",
 			ok:  true,
 		},
+		"with-ingress-class-name": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+				},
+				Spec: networkingv1.IngressSpec{
+					IngressClassName: &testIngressClass,
+				},
+			},
+			out: "test",
+			ok:  true,
+		},
+		"with-ingress-class-precedence": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						annotationIngressClass: "other",
+					},
+				},
+				Spec: networkingv1.IngressSpec{
+					IngressClassName: &testIngressClass,
+				},
+			},
+			out: "other",
+			ok:  true,
+		},
 	} {
 		out, ok := parseIngressClass(test.in)
 		assert.Equalf(t, test.out, out, "test '%s' value mismatch", name)
@@ -114,6 +146,23 @@ func TestParseIngressTunnelOptions(t *testing.T) {
 				retries:           8,
 			},
 		},
+		"with-compression-quality-out-of-range": {
+			in: &networkingv1.Ingress{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "Ingress",
+					APIVersion: "networking.k8s.io/v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						annotationIngressClass:              "test",
+						annotationIngressCompressionQuality: "4",
+					},
+				},
+			},
+			out: collectTunnelOptions(nil),
+		},
 		"with-all-options": {
 			in: &networkingv1.Ingress{
 				TypeMeta: metav1.TypeMeta{
@@ -129,21 +178,53 @@ func TestParseIngressTunnelOptions(t *testing.T) {
 						annotationIngressHAConnections:      "2",
 						annotationIngressHeartbeatCount:     "4",
 						annotationIngressHeartbeatInterval:  "4ms",
+						annotationIngressIncludeUnready:     "true",
+						annotationIngressInitialDelay:       "30s",
+						annotationIngressInjectOverride:     "true",
+						annotationIngressInjectRobots:       "disallow-all",
 						annotationIngressLoadBalancer:       "test-lb-pool",
+						annotationIngressMaintenance:        "true",
+						annotationIngressMaxBodyBytes:       "1024",
+						annotationIngressMaxConcurrent:      "16",
 						annotationIngressNoChunkedEncoding:  "true",
+						annotationIngressNoForwardedHeaders: "true",
+						annotationIngressOriginScheme:       "http",
+						annotationIngressProto:              "https",
+						annotationIngressReadinessPath:      "/healthz",
 						annotationIngressRetries:            "8",
-						annotationIngressTag:                "key1=val1"},
+						annotationIngressRewriteTarget:      "/v2/",
+						annotationIngressStreamBufferBytes:  "4096",
+						annotationIngressTag:                "key1=val1",
+						annotationIngressTargetService:      "test-deploy",
+						annotationIngressTrailingSlash:      "true",
+						annotationIngressUpstreamMode:       "endpoint"},
 				},
 			},
 			out: tunnelOptions{
-				compressionQuality: 1,
-				haConnections:      2,
-				heartbeatCount:     4,
-				heartbeatInterval:  4 * time.Millisecond,
-				lbPool:             "test-lb-pool",
-				noChunkedEncoding:  true,
-				retries:            8,
-				tags:               "key1=val1",
+				compressionQuality:    1,
+				haConnections:         2,
+				heartbeatCount:        4,
+				heartbeatInterval:     4 * time.Millisecond,
+				initialDelay:          30 * time.Second,
+				injectOverride:        true,
+				injectRobots:          robotsDisallowAll,
+				lbPool:                "test-lb-pool",
+				maintenance:           true,
+				maxBodyBytes:          1024,
+				maxConcurrentRequests: 16,
+				noChunkedEncoding:     true,
+				noForwardedHeaders:    true,
+				originScheme:          "http",
+				proto:                 "https",
+				readinessPath:         "/healthz",
+				retries:               8,
+				rewriteTarget:         "/v2/",
+				streamBufferBytes:     4096,
+				tags:                  "key1=val1",
+				targetService:         "test-deploy",
+				trailingSlashRedirect: true,
+				unreadyEndpoints:      unreadyEndpointsInclude,
+				upstreamMode:          "endpoint",
 			},
 		},
 	} {
@@ -331,6 +412,237 @@ func TestParseMetaInt(t *testing.T) {
 	}
 }
 
+func TestParseMetaProto(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  *networkingv1.Ingress
+		out string
+		ok  bool
+	}{
+		"empty-ingress": {
+			in:  &networkingv1.Ingress{},
+			out: "",
+			ok:  false,
+		},
+		"with-unsupported-proto": {
+			in: &networkingv1.Ingress{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "Ingress",
+					APIVersion: "networking.k8s.io/v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "tcp",
+					},
+				},
+			},
+			out: "",
+			ok:  false,
+		},
+		"with-proto": {
+			in: &networkingv1.Ingress{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "Ingress",
+					APIVersion: "networking.k8s.io/v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "https",
+					},
+				},
+			},
+			out: "https",
+			ok:  true,
+		},
+	} {
+		obj, _ := meta.Accessor(test.in)
+		out, ok := parseMetaProto(obj, "test")
+		assert.Equalf(t, test.out, out, "test '%s' value mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' found mismatch", name)
+	}
+}
+
+func TestParseMetaReadinessPath(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  *networkingv1.Ingress
+		out string
+		ok  bool
+	}{
+		"empty-ingress": {
+			in:  &networkingv1.Ingress{},
+			out: "",
+			ok:  false,
+		},
+		"with-relative-path": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "healthz",
+					},
+				},
+			},
+			out: "",
+			ok:  false,
+		},
+		"with-path": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "/healthz",
+					},
+				},
+			},
+			out: "/healthz",
+			ok:  true,
+		},
+		"with-tcp": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "tcp",
+					},
+				},
+			},
+			out: "tcp",
+			ok:  true,
+		},
+	} {
+		obj, _ := meta.Accessor(test.in)
+		out, ok := parseMetaReadinessPath(obj, "test")
+		assert.Equalf(t, test.out, out, "test '%s' value mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' found mismatch", name)
+	}
+}
+
+func TestParseMetaRewriteTarget(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  *networkingv1.Ingress
+		out string
+		ok  bool
+	}{
+		"empty-ingress": {
+			in:  &networkingv1.Ingress{},
+			out: "",
+			ok:  false,
+		},
+		"with-relative-path": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "healthz",
+					},
+				},
+			},
+			out: "",
+			ok:  false,
+		},
+		"with-unclean-path": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "/v2//api/../",
+					},
+				},
+			},
+			out: "/v2/",
+			ok:  true,
+		},
+		"with-capture-group": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "/$1",
+					},
+				},
+			},
+			out: "/$1",
+			ok:  true,
+		},
+		"with-invalid-reference": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "/$name",
+					},
+				},
+			},
+			out: "",
+			ok:  false,
+		},
+	} {
+		obj, _ := meta.Accessor(test.in)
+		out, ok := parseMetaRewriteTarget(obj, "test")
+		assert.Equalf(t, test.out, out, "test '%s' value mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' found mismatch", name)
+	}
+}
+
+func TestParseMetaUpstreamMode(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  *networkingv1.Ingress
+		out string
+		ok  bool
+	}{
+		"empty-ingress": {
+			in:  &networkingv1.Ingress{},
+			out: "",
+			ok:  false,
+		},
+		"with-unsupported-mode": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "pod",
+					},
+				},
+			},
+			out: "",
+			ok:  false,
+		},
+		"with-mode": {
+			in: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						"test": "endpoint",
+					},
+				},
+			},
+			out: "endpoint",
+			ok:  true,
+		},
+	} {
+		obj, _ := meta.Accessor(test.in)
+		out, ok := parseMetaUpstreamMode(obj, "test")
+		assert.Equalf(t, test.out, out, "test '%s' value mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' found mismatch", name)
+	}
+}
+
 func TestParseMetaUint(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
@@ -438,3 +750,168 @@ func TestParseMetaUint64(t *testing.T) {
 		assert.Equalf(t, test.ok, ok, "test '%s' found mismatch", name)
 	}
 }
+
+func TestParseFallbackService(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in   string
+		name string
+		port networkingv1.ServiceBackendPort
+		ok   bool
+	}{
+		"fallback-empty": {
+			in: "",
+			ok: false,
+		},
+		"fallback-no-port": {
+			in: "maintenance",
+			ok: false,
+		},
+		"fallback-no-name": {
+			in: ":80",
+			ok: false,
+		},
+		"fallback-zero-port": {
+			in: "maintenance:0",
+			ok: false,
+		},
+		"fallback-port-number": {
+			in:   "maintenance:8080",
+			name: "maintenance",
+			port: networkingv1.ServiceBackendPort{Number: 8080},
+			ok:   true,
+		},
+		"fallback-port-name": {
+			in:   "maintenance:http",
+			name: "maintenance",
+			port: networkingv1.ServiceBackendPort{Name: "http"},
+			ok:   true,
+		},
+	} {
+		svc, port, ok := parseFallbackService(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: "test",
+				Annotations: map[string]string{
+					annotationIngressFallbackService: test.in,
+				},
+			},
+		})
+		assert.Equalf(t, test.name, svc, "test '%s' name mismatch", name)
+		assert.Equalf(t, test.port, port, "test '%s' port mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' found mismatch", name)
+	}
+}
+
+func TestParseMetaRedirect(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in   map[string]string
+		code int
+		out  string
+		ok   bool
+	}{
+		"redirect-unset": {
+			in: map[string]string{},
+		},
+		"redirect-relative": {
+			in: map[string]string{annotationIngressPermanentRedirect: "/new"},
+		},
+		"redirect-unsupported-scheme": {
+			in: map[string]string{annotationIngressPermanentRedirect: "ftp://example.com"},
+		},
+		"redirect-permanent": {
+			in:   map[string]string{annotationIngressPermanentRedirect: "https://example.com"},
+			code: 301,
+			out:  "https://example.com",
+			ok:   true,
+		},
+		"redirect-temporal": {
+			in:   map[string]string{annotationIngressTemporalRedirect: "https://example.com/sale?src=www"},
+			code: 302,
+			out:  "https://example.com/sale?src=www",
+			ok:   true,
+		},
+		"redirect-both": {
+			in: map[string]string{
+				annotationIngressPermanentRedirect: "https://example.com",
+				annotationIngressTemporalRedirect:  "https://example.org",
+			},
+			code: 301,
+			out:  "https://example.com",
+			ok:   true,
+		},
+	} {
+		code, out, ok := parseMetaRedirect(&metav1.ObjectMeta{Annotations: test.in})
+		assert.Equalf(t, test.code, code, "test '%s' code mismatch", name)
+		assert.Equalf(t, test.out, out, "test '%s' value mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' found mismatch", name)
+	}
+}
+
+func TestStripUnknownAnnotations(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  map[string]string
+		out map[string]string
+	}{
+		"no-annotations": {},
+		"known-annotations": {
+			in: map[string]string{
+				annotationIngressTag:   "a,b",
+				annotationIngressClass: "argo-tunnel",
+			},
+			out: map[string]string{
+				annotationIngressTag:   "a,b",
+				annotationIngressClass: "argo-tunnel",
+			},
+		},
+		"unknown-annotations": {
+			in: map[string]string{
+				annotationIngressTag:                   "a,b",
+				"argo.cloudflare.com/request-headers":  strings.Repeat("x", 64),
+				"argo.cloudflare.com/":                 "x",
+				"other.cloudflare.com/request-headers": "x",
+			},
+			out: map[string]string{
+				annotationIngressTag:                   "a,b",
+				"other.cloudflare.com/request-headers": "x",
+			},
+		},
+	} {
+		ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: test.in}}
+		stripUnknownAnnotations(ing)
+		assert.Equalf(t, test.out, ing.Annotations, "test '%s' annotations mismatch", name)
+	}
+}
+
+func TestGetOversizedAnnotations(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  map[string]string
+		out []string
+	}{
+		"no-annotations": {},
+		"at-limit": {
+			in: map[string]string{
+				annotationIngressTag: strings.Repeat("x", annotationValueLimit),
+			},
+		},
+		"over-limit": {
+			in: map[string]string{
+				annotationIngressTag:           strings.Repeat("x", annotationValueLimit+1),
+				annotationIngressRewriteTarget: strings.Repeat("x", annotationValueLimit+1),
+				annotationIngressProto:         "https",
+			},
+			out: []string{annotationIngressRewriteTarget, annotationIngressTag},
+		},
+		"over-limit-other-prefix": {
+			in: map[string]string{
+				"kubectl.kubernetes.io/last-applied-configuration": strings.Repeat("x", annotationValueLimit+1),
+			},
+		},
+	} {
+		ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: test.in}}
+		assert.Equalf(t, test.out, getOversizedAnnotations(ing), "test '%s' annotations mismatch", name)
+	}
+}
@@ -0,0 +1,55 @@
+package argotunnel
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	auditActionCreate = "create"
+	auditActionDelete = "delete"
+
+	auditTriggerShutdown = "shutdown"
+)
+
+var (
+	// auditLogger records the tunnel lifecycle, it is not
+	// affected by the controller verbosity.
+	auditLogger = func() *logrus.Logger {
+		log := logrus.New()
+		log.SetLevel(logrus.InfoLevel)
+		log.Formatter = &logrus.JSONFormatter{}
+		log.Out = os.Stderr
+		return log
+	}()
+)
+
+// AuditLogger returns the tunnel lifecycle logger
+func AuditLogger() *logrus.Logger {
+	return auditLogger
+}
+
+// auditLink records a tunnel lifecycle action along with the route
+// and resource that triggered it.
+func auditLink(log *logrus.Logger, action, trigger string, route *tunnelRoute, rule tunnelRule) {
+	if log == nil {
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"action":          action,
+		"hostname":        rule.host,
+		"service":         itemKeyFunc(rule.service.namespace, rule.service.name),
+		"port":            rule.port,
+		"secret":          itemKeyFunc(rule.secret.namespace, rule.secret.name),
+		"ingress":         itemKeyFunc(route.namespace, route.name),
+		"generation":      route.generation,
+		"resourceVersion": route.resourceVersion,
+		"trigger":         trigger,
+	}).Infof("tunnel %s", action)
+}
+
+// auditTrigger formats the resource responsible for a lifecycle action
+func auditTrigger(kind, namespace, name string) string {
+	return kind + "/" + itemKeyFunc(namespace, name)
+}
@@ -0,0 +1,147 @@
+package argotunnel
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// RepairStrategyConstant waits the repair delay, with jitter, between
+	// every repair
+	RepairStrategyConstant = "constant"
+	// RepairStrategyDecorrelatedJitter draws each wait between the repair
+	// delay and thrice the previous wait, capped at the last exponential
+	// step, so links failing together spread their repairs
+	RepairStrategyDecorrelatedJitter = "decorrelated-jitter"
+	// RepairStrategyExponential doubles the repair delay over the repair
+	// steps, with linear jitter, then starts over
+	RepairStrategyExponential = "exponential"
+	// RepairStrategyDefault the default strategy of the repair waits
+	RepairStrategyDefault = RepairStrategyExponential
+)
+
+// RepairStrategies lists the strategies of the repair waits
+var RepairStrategies = []string{
+	RepairStrategyConstant,
+	RepairStrategyDecorrelatedJitter,
+	RepairStrategyExponential,
+}
+
+var repairStrategyConfig = struct {
+	name        string
+	setStrategy sync.Once
+}{
+	name: RepairStrategyDefault,
+}
+
+// SetRepairStrategy configures the strategy of the repair waits used by
+// all tunnels, see RepairStrategies
+func SetRepairStrategy(name string) {
+	repairStrategyConfig.setStrategy.Do(func() {
+		repairStrategyConfig.name = name
+	})
+}
+
+// RepairBackoff describes the repair waits of the tunnels
+type RepairBackoff struct {
+	Strategy string  `json:"strategy"`
+	Delay    string  `json:"delay"`
+	Jitter   float64 `json:"jitter"`
+	Steps    uint    `json:"steps"`
+	Cycles   uint    `json:"cycles"`
+}
+
+func (b RepairBackoff) String() string {
+	return fmt.Sprintf("strategy: %s, delay: %s, jitter: %g, steps: %d, cycles: %d", b.Strategy, b.Delay, b.Jitter, b.Steps, b.Cycles)
+}
+
+// GetRepairBackoff reports the repair waits configured for the tunnels
+func GetRepairBackoff() RepairBackoff {
+	return RepairBackoff{
+		Strategy: repairStrategyConfig.name,
+		Delay:    repairBackoff.delay.String(),
+		Jitter:   repairBackoff.jitter,
+		Steps:    repairBackoff.steps,
+		Cycles:   repairBreaker.cycles,
+	}
+}
+
+// repairStrategy spaces the repairs of a failing link
+type repairStrategy interface {
+	// next is the wait before the repair following step, given the wait
+	// before the previous repair, 0 before the first
+	next(step uint, prev time.Duration) time.Duration
+}
+
+// newRepairStrategy builds the named strategy, the exponential strategy
+// when the name is unknown. random draws the jitter in [0,1).
+func newRepairStrategy(name string, delay time.Duration, jitter float64, steps uint, random func() float64) repairStrategy {
+	switch name {
+	case RepairStrategyConstant:
+		return &constantRepair{delay: delay, jitter: jitter, random: random}
+	case RepairStrategyDecorrelatedJitter:
+		// capped at the last exponential step
+		limit := delay
+		if steps > 0 {
+			limit = delay << (steps - 1)
+		}
+		return &decorrelatedJitterRepair{delay: delay, cap: limit, random: random}
+	}
+	return &exponentialRepair{delay: delay, jitter: jitter, steps: steps, random: random}
+}
+
+// getRepairStrategy is the strategy configured for all tunnels
+func getRepairStrategy() repairStrategy {
+	return newRepairStrategy(repairStrategyConfig.name, repairBackoff.delay, repairBackoff.jitter, repairBackoff.steps, rand.Float64)
+}
+
+type exponentialRepair struct {
+	delay  time.Duration
+	jitter float64
+	steps  uint
+	random func() float64
+}
+
+func (s *exponentialRepair) next(step uint, prev time.Duration) time.Duration {
+	d := s.delay
+	if s.steps > 0 {
+		d = (1 << (step % s.steps)) * s.delay
+	}
+	if s.jitter > 0 {
+		d += time.Duration(s.random() * s.jitter * float64(s.delay))
+	}
+	return d
+}
+
+type constantRepair struct {
+	delay  time.Duration
+	jitter float64
+	random func() float64
+}
+
+func (s *constantRepair) next(step uint, prev time.Duration) time.Duration {
+	d := s.delay
+	if s.jitter > 0 {
+		d += time.Duration(s.random() * s.jitter * float64(s.delay))
+	}
+	return d
+}
+
+type decorrelatedJitterRepair struct {
+	delay  time.Duration
+	cap    time.Duration
+	random func() float64
+}
+
+func (s *decorrelatedJitterRepair) next(step uint, prev time.Duration) time.Duration {
+	if prev < s.delay {
+		prev = s.delay
+	}
+	d := s.delay + time.Duration(s.random()*float64(3*prev-s.delay))
+	if d > s.cap {
+		d = s.cap
+	}
+	return d
+}
@@ -0,0 +1,83 @@
+package argotunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// repairSchedule is the waits of n consecutive repairs of a link
+func repairSchedule(s repairStrategy, n int) []time.Duration {
+	var prev time.Duration
+	waits := make([]time.Duration, 0, n)
+	for step := 0; step < n; step++ {
+		prev = s.next(uint(step), prev)
+		waits = append(waits, prev)
+	}
+	return waits
+}
+
+func TestRepairStrategySchedule(t *testing.T) {
+	t.Parallel()
+	half := func() float64 { return 0.5 }
+	zero := func() float64 { return 0 }
+	ms := time.Millisecond
+	for name, test := range map[string]struct {
+		strategy string
+		random   func() float64
+		out      []time.Duration
+	}{
+		"exponential": {
+			strategy: RepairStrategyExponential,
+			random:   half,
+			out:      []time.Duration{125 * ms, 225 * ms, 425 * ms, 825 * ms, 125 * ms, 225 * ms},
+		},
+		"unknown-exponential": {
+			strategy: "unknown",
+			random:   zero,
+			out:      []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, 100 * ms, 200 * ms},
+		},
+		"constant": {
+			strategy: RepairStrategyConstant,
+			random:   half,
+			out:      []time.Duration{125 * ms, 125 * ms, 125 * ms, 125 * ms, 125 * ms, 125 * ms},
+		},
+		"decorrelated-jitter": {
+			strategy: RepairStrategyDecorrelatedJitter,
+			random:   half,
+			out:      []time.Duration{200 * ms, 350 * ms, 575 * ms, 800 * ms, 800 * ms, 800 * ms},
+		},
+		"decorrelated-jitter-floor": {
+			strategy: RepairStrategyDecorrelatedJitter,
+			random:   zero,
+			out:      []time.Duration{100 * ms, 100 * ms, 100 * ms, 100 * ms, 100 * ms, 100 * ms},
+		},
+	} {
+		s := newRepairStrategy(test.strategy, 100*ms, 0.5, 4, test.random)
+		assert.Equalf(t, test.out, repairSchedule(s, len(test.out)), "test '%s' schedule mismatch", name)
+	}
+}
+
+func TestDecorrelatedJitterSpread(t *testing.T) {
+	t.Parallel()
+	// links failing together draw apart, unlike the exponential steps
+	draws := []float64{0.1, 0.9}
+	schedules := make([][]time.Duration, 0, len(draws))
+	for _, draw := range draws {
+		draw := draw
+		s := newRepairStrategy(RepairStrategyDecorrelatedJitter, 100*time.Millisecond, 0.5, 4, func() float64 { return draw })
+		schedules = append(schedules, repairSchedule(s, 4))
+	}
+	for step := range schedules[0] {
+		assert.Truef(t, schedules[0][step] < schedules[1][step], "test step %d spread mismatch", step)
+	}
+}
+
+func TestSetRepairStrategy(t *testing.T) {
+	for _, name := range []string{RepairStrategyExponential, RepairStrategyConstant, RepairStrategyDecorrelatedJitter} {
+		SetRepairStrategy(name)
+	}
+	assert.Equalf(t, RepairStrategyExponential, repairStrategyConfig.name, "test repair strategy matches first set")
+	assert.Equalf(t, RepairStrategyExponential, GetRepairBackoff().Strategy, "test repair backoff strategy mismatch")
+}
@@ -0,0 +1,122 @@
+package argotunnel
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	chaosFaultDropTunnel       = "drop-tunnel"
+	chaosFaultFailRegistration = "fail-registration"
+	chaosFaultDelayOriginDial  = "delay-origin-dial"
+)
+
+// chaosError is a fault injected for resilience testing
+type chaosError struct {
+	fault string
+}
+
+func (e *chaosError) Error() string {
+	return fmt.Sprintf("chaos: injected %s", e.fault)
+}
+
+// chaosInjector injects faults at the tunnel and origin dial seams, a nil
+// injector injects none. A zero setting leaves its seam alone.
+type chaosInjector struct {
+	dropEvery   time.Duration
+	failPercent int
+	dialDelay   time.Duration
+	intn        func(n int) int
+	log         *logrus.Logger
+}
+
+var chaosConfig = struct {
+	injector *chaosInjector
+	setChaos sync.Once
+}{}
+
+// SetChaos injects faults for resilience testing: tunnels dropped every
+// period, a percentage of registrations failed, and origin dials delayed.
+// Every injected fault is logged and counted.
+func SetChaos(dropEvery time.Duration, failRegistrationPercent int, dialDelay time.Duration, log *logrus.Logger) {
+	chaosConfig.setChaos.Do(func() {
+		chaosConfig.injector = &chaosInjector{
+			dropEvery:   dropEvery,
+			failPercent: failRegistrationPercent,
+			dialDelay:   dialDelay,
+			intn:        rand.Intn,
+			log:         log,
+		}
+		log.Warnf("chaos enabled, drop tunnel every: %s, fail registration: %d%%, delay origin dial: %s", dropEvery, failRegistrationPercent, dialDelay)
+	})
+}
+
+// inject logs and counts a fault injected on the host
+func (c *chaosInjector) inject(fault, host string) {
+	chaosFaults.WithLabelValues(fault).Inc()
+	c.log.WithFields(logrus.Fields{
+		"hostname": host,
+		"fault":    fault,
+	}).Warnf("chaos: injected %s", fault)
+}
+
+// failRegistration fails a share of the tunnel registrations
+func (c *chaosInjector) failRegistration(host string) error {
+	if c == nil || c.failPercent <= 0 || c.intn(100) >= c.failPercent {
+		return nil
+	}
+	c.inject(chaosFaultFailRegistration, host)
+	return &chaosError{fault: chaosFaultFailRegistration}
+}
+
+// dropTunnel returns a channel closed with stopCh, or once the drop period
+// elapses, and a func returning the fault once the tunnel was dropped
+func (c *chaosInjector) dropTunnel(host string, stopCh <-chan struct{}) (<-chan struct{}, func() error) {
+	if c == nil || c.dropEvery <= 0 {
+		return stopCh, func() error { return nil }
+	}
+	daemonCh, dropCh := make(chan struct{}), make(chan struct{})
+	go func() {
+		timer := time.NewTimer(c.dropEvery)
+		defer timer.Stop()
+		select {
+		case <-stopCh:
+		case <-timer.C:
+			c.inject(chaosFaultDropTunnel, host)
+			close(dropCh)
+		}
+		close(daemonCh)
+	}()
+	return daemonCh, func() error {
+		select {
+		case <-dropCh:
+			return &chaosError{fault: chaosFaultDropTunnel}
+		default:
+			return nil
+		}
+	}
+}
+
+// delayDial delays the origin dials of the host
+func (c *chaosInjector) delayDial(host string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c == nil || c.dialDelay <= 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c.inject(chaosFaultDelayOriginDial, host)
+		timer := time.NewTimer(c.dialDelay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		return dial(ctx, network, addr)
+	}
+}
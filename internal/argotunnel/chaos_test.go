@@ -0,0 +1,100 @@
+package argotunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaosFailRegistration(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		injector *chaosInjector
+		roll     int
+		err      error
+	}{
+		"disabled": {
+			injector: nil,
+		},
+		"zero-percent": {
+			injector: &chaosInjector{},
+		},
+		"roll-above": {
+			injector: &chaosInjector{failPercent: 30},
+			roll:     30,
+		},
+		"roll-below": {
+			injector: &chaosInjector{failPercent: 30},
+			roll:     29,
+			err:      &chaosError{fault: chaosFaultFailRegistration},
+		},
+	} {
+		logger, hook := logtest.NewNullLogger()
+		if test.injector != nil {
+			roll := test.roll
+			test.injector.intn = func(int) int { return roll }
+			test.injector.log = logger
+		}
+		err := test.injector.failRegistration("unit.com")
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.err != nil, len(hook.Entries) == 1, "test '%s' log mismatch", name)
+	}
+}
+
+func TestChaosDropTunnel(t *testing.T) {
+	t.Parallel()
+	logger, hook := logtest.NewNullLogger()
+	before := testutil.ToFloat64(chaosFaults.WithLabelValues(chaosFaultDropTunnel))
+
+	stopCh := make(chan struct{})
+	daemonCh, dropped := (&chaosInjector{dropEvery: 10 * time.Millisecond, log: logger}).dropTunnel("unit.com", stopCh)
+	select {
+	case <-daemonCh:
+	case <-time.After(time.Second):
+		t.Fatalf("test drop tunnel timeout")
+	}
+	assert.Equalf(t, &chaosError{fault: chaosFaultDropTunnel}, dropped(), "test drop tunnel error mismatch")
+	assert.Equalf(t, 1, len(hook.Entries), "test drop tunnel log mismatch")
+	assert.GreaterOrEqualf(t, testutil.ToFloat64(chaosFaults.WithLabelValues(chaosFaultDropTunnel))-before, float64(1), "test drop tunnel count mismatch")
+
+	// a stopped tunnel was not dropped
+	stopCh = make(chan struct{})
+	daemonCh, dropped = (&chaosInjector{dropEvery: time.Minute, log: logger}).dropTunnel("unit.com", stopCh)
+	close(stopCh)
+	<-daemonCh
+	assert.Nilf(t, dropped(), "test stopped tunnel error mismatch")
+
+	// a nil injector leaves the stop channel alone
+	stopCh = make(chan struct{})
+	daemonCh, dropped = (*chaosInjector)(nil).dropTunnel("unit.com", stopCh)
+	assert.Equalf(t, (<-chan struct{})(stopCh), daemonCh, "test disabled channel mismatch")
+	assert.Nilf(t, dropped(), "test disabled error mismatch")
+}
+
+func TestChaosDelayDial(t *testing.T) {
+	t.Parallel()
+	logger, hook := logtest.NewNullLogger()
+	var dialed int
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed++
+		return nil, nil
+	}
+
+	start := time.Now()
+	_, err := (&chaosInjector{dialDelay: 20 * time.Millisecond, log: logger}).delayDial("unit.com", dial)(context.Background(), "tcp", "unit:80")
+	assert.Nilf(t, err, "test delay error mismatch")
+	assert.GreaterOrEqualf(t, time.Since(start), 20*time.Millisecond, "test delay mismatch")
+	assert.Equalf(t, 1, dialed, "test delay dial mismatch")
+	assert.Equalf(t, 1, len(hook.Entries), "test delay log mismatch")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = (&chaosInjector{dialDelay: time.Minute, log: logger}).delayDial("unit.com", dial)(ctx, "tcp", "unit:80")
+	assert.Equalf(t, context.Canceled, err, "test canceled error mismatch")
+	assert.Equalf(t, 1, dialed, "test canceled dial mismatch")
+}
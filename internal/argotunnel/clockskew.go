@@ -0,0 +1,128 @@
+package argotunnel
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ClockSkewThresholdDefault defines the default clock skew reported as
+	// the cause of failed tunnel registrations
+	ClockSkewThresholdDefault = 30 * time.Second
+
+	clockSkewCheckInterval = time.Minute
+	clockSkewCheckTimeout  = 5 * time.Second
+)
+
+// clockSkewErrors are the error fragments of registrations rejected for
+// time, by the edge or by the certificate verification of the dial.
+var clockSkewErrors = []string{
+	"certificate has expired or is not yet valid",
+	"clock",
+	"expired",
+	"not yet valid",
+	"signature",
+	"timestamp",
+}
+
+var clockSkew = newClockSkewChecker("http://www.cloudflare.com/cdn-cgi/trace", ClockSkewThresholdDefault)
+
+// SetClockSkewThreshold defines the clock skew reported as the cause of
+// failed tunnel registrations, 0 disables the check
+func SetClockSkewThreshold(threshold time.Duration) {
+	clockSkew.mu.Lock()
+	defer clockSkew.mu.Unlock()
+	clockSkew.threshold = threshold
+}
+
+// isClockSkewError reports whether a tunnel error belongs to the class of
+// errors caused by a skewed system clock
+func isClockSkewError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range clockSkewErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// clockSkewChecker measures the system clock against the Date header of
+// a Cloudflare response. Measurements are shared by every tunnel for the
+// check interval, a repair storm makes a single request.
+type clockSkewChecker struct {
+	mu        sync.Mutex
+	url       string
+	client    *http.Client
+	threshold time.Duration
+	checked   time.Time
+	skew      time.Duration
+	err       error
+}
+
+func newClockSkewChecker(url string, threshold time.Duration) *clockSkewChecker {
+	return &clockSkewChecker{
+		url: url,
+		client: &http.Client{
+			Timeout: clockSkewCheckTimeout,
+			// the Date header of a redirect is enough
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		threshold: threshold,
+	}
+}
+
+// exceeded reports the clock skew when it exceeds the threshold
+func (c *clockSkewChecker) exceeded() (skew time.Duration, ok bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.threshold <= 0 {
+		return 0, false, nil
+	}
+	if c.checked.IsZero() || time.Since(c.checked) > clockSkewCheckInterval {
+		c.skew, c.err = measureClockSkew(c.client, c.url)
+		c.checked = time.Now()
+		if c.err == nil {
+			clockSkewSeconds.Set(c.skew.Seconds())
+		}
+	}
+	if c.err != nil {
+		return 0, false, c.err
+	}
+	abs := c.skew
+	if abs < 0 {
+		abs = -abs
+	}
+	return c.skew, abs > c.threshold, nil
+}
+
+// measureClockSkew returns the local time ahead of the remote Date
+// header, taken halfway through the request
+func measureClockSkew(client *http.Client, url string) (time.Duration, error) {
+	start := time.Now()
+	res, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	rtt := time.Since(start)
+
+	date := res.Header.Get("Date")
+	if len(date) == 0 {
+		return 0, fmt.Errorf("response of %s has no Date header", url)
+	}
+	remote, err := http.ParseTime(date)
+	if err != nil {
+		return 0, err
+	}
+	return start.Add(rtt / 2).Sub(remote).Round(time.Second), nil
+}
@@ -0,0 +1,131 @@
+package argotunnel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsClockSkewError(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		err error
+		out bool
+	}{
+		"err-nil": {
+			err: nil,
+			out: false,
+		},
+		"err-dial": {
+			err: fmt.Errorf("dial tcp 198.41.192.7:7844: i/o timeout"),
+			out: false,
+		},
+		"err-x509-validity": {
+			err: fmt.Errorf("x509: certificate has expired or is not yet valid"),
+			out: true,
+		},
+		"err-server-timestamp": {
+			err: fmt.Errorf("Server error: request Timestamp outside the allowed window"),
+			out: true,
+		},
+		"err-server-signature": {
+			err: fmt.Errorf("Server error: invalid signature"),
+			out: true,
+		},
+	} {
+		out := isClockSkewError(test.err)
+		assert.Equalf(t, test.out, out, "test '%s' clock skew error mismatch", name)
+	}
+}
+
+func TestClockSkewChecker(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		offset    time.Duration
+		date      bool
+		threshold time.Duration
+		exceeded  bool
+		err       bool
+	}{
+		"skew-within-threshold": {
+			offset:    0,
+			date:      true,
+			threshold: 30 * time.Second,
+			exceeded:  false,
+		},
+		"skew-local-ahead": {
+			offset:    -2 * time.Minute,
+			date:      true,
+			threshold: 30 * time.Second,
+			exceeded:  true,
+		},
+		"skew-local-behind": {
+			offset:    2 * time.Minute,
+			date:      true,
+			threshold: 30 * time.Second,
+			exceeded:  true,
+		},
+		"skew-check-disabled": {
+			offset:    2 * time.Minute,
+			date:      true,
+			threshold: 0,
+			exceeded:  false,
+		},
+		"skew-date-missing": {
+			date:      false,
+			threshold: 30 * time.Second,
+			exceeded:  false,
+			err:       true,
+		},
+	} {
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if test.date {
+				w.Header().Set("Date", time.Now().Add(test.offset).UTC().Format(http.TimeFormat))
+			} else {
+				w.Header()["Date"] = nil
+			}
+		}))
+		c := newClockSkewChecker(srv.URL, test.threshold)
+		for i := 0; i < 2; i++ {
+			skew, exceeded, err := c.exceeded()
+			assert.Equalf(t, test.exceeded, exceeded, "test '%s' exceeded mismatch", name)
+			assert.Equalf(t, test.err, err != nil, "test '%s' error mismatch", name)
+			if exceeded {
+				assert.InDeltaf(t, -test.offset.Seconds(), skew.Seconds(), 2, "test '%s' skew mismatch", name)
+			}
+		}
+		if test.threshold > 0 {
+			assert.Equalf(t, 1, requests, "test '%s' requests mismatch", name)
+		}
+		srv.Close()
+	}
+}
+
+func TestLinkFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+	saved := clockSkew
+	clockSkew = newClockSkewChecker(srv.URL, ClockSkewThresholdDefault)
+	defer func() { clockSkew = saved }()
+
+	logger, hook := logtest.NewNullLogger()
+	l := &syncTunnelLink{rule: tunnelRule{host: "a.unit.com"}, log: logger}
+
+	cond := linkFailure(l, fmt.Errorf("dial tcp: i/o timeout"))
+	assert.Equalf(t, statusReasonTunnelFailed, cond.reason, "test dial failure reason mismatch")
+	assert.Nil(t, hook.LastEntry())
+
+	cond = linkFailure(l, fmt.Errorf("x509: certificate has expired or is not yet valid"))
+	assert.Equalf(t, statusReasonClockSkewed, cond.reason, "test skewed failure reason mismatch")
+	assert.Regexpf(t, `^host a.unit.com: system clock skewed by 1h0m[01]s; tunnel registration will fail$`, cond.message, "test skewed failure message mismatch")
+	assert.Regexpf(t, `^system clock skewed by 1h0m[01]s; tunnel registration will fail$`, hook.LastEntry().Message, "test skewed failure log mismatch")
+}
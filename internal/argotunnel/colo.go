@@ -0,0 +1,175 @@
+package argotunnel
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// coloConnectedPrefix starts the cloudflared log reporting the
+	// location of a registered connection
+	coloConnectedPrefix = "Connected to "
+	// coloConnectionField holds the connection of a cloudflared log
+	coloConnectionField = "connectionID"
+)
+
+// tunnelConnection describes a tunnel connection to the edge
+type tunnelConnection struct {
+	ID   string `json:"id"`
+	Colo string `json:"colo"`
+}
+
+// coloTracker follows the edge location of the link connections.
+//
+// cloudflared reports the location of a registration without its
+// connection, the connection is inferred: the first connection
+// registers alone, the next connections register in order, and a
+// connection failing logs its id before registering again.
+type coloTracker struct {
+	mu        sync.Mutex
+	hostname  string
+	colos     map[string]string
+	pending   []string
+	next      int
+	onConnect func()
+	log       *logrus.Logger
+}
+
+func newColoTracker(hostname string, log *logrus.Logger) *coloTracker {
+	return &coloTracker{
+		hostname: hostname,
+		colos:    map[string]string{},
+		log:      log,
+	}
+}
+
+// Levels implements logrus.Hook
+func (t *coloTracker) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (t *coloTracker) Fire(entry *logrus.Entry) error {
+	if strings.HasPrefix(entry.Message, coloConnectedPrefix) {
+		t.connected(strings.TrimPrefix(entry.Message, coloConnectedPrefix))
+		if t.onConnect != nil {
+			t.onConnect()
+		}
+	} else if id, ok := entry.Data[coloConnectionField]; ok {
+		t.disconnected(fmt.Sprint(id))
+	}
+	return nil
+}
+
+func (t *coloTracker) connected(colo string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var id string
+	if len(t.pending) > 0 {
+		id, t.pending = t.pending[0], t.pending[1:]
+	} else {
+		id = strconv.Itoa(t.next)
+		t.next++
+	}
+
+	prev, ok := t.colos[id]
+	if ok && prev == colo {
+		return
+	}
+	if len(prev) > 0 {
+		t.log.WithFields(logrus.Fields{
+			"hostname":   t.hostname,
+			"connection": id,
+		}).Infof("tunnel connection colo changed from %s to %s", prev, colo)
+		tunnelConnectionInfo.DeleteLabelValues(t.hostname, id, prev)
+	}
+	t.colos[id] = colo
+	tunnelConnectionInfo.WithLabelValues(t.hostname, id, colo).Set(1)
+}
+
+// disconnected keeps the location of a failed connection, the
+// change is reported once it registers again.
+func (t *coloTracker) disconnected(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, p := range t.pending {
+		if p == id {
+			return
+		}
+	}
+	t.pending = append(t.pending, id)
+}
+
+// reset forgets the connections, used when the link restarts
+func (t *coloTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, colo := range t.colos {
+		tunnelConnectionInfo.DeleteLabelValues(t.hostname, id, colo)
+	}
+	t.colos = map[string]string{}
+	t.pending = nil
+	t.next = 0
+}
+
+// publish sets the connection metrics again, used when a retired link
+// sharing the hostname dropped them
+func (t *coloTracker) publish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, colo := range t.colos {
+		tunnelConnectionInfo.WithLabelValues(t.hostname, id, colo).Set(1)
+	}
+}
+
+func (t *coloTracker) connections() []tunnelConnection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := make([]tunnelConnection, 0, len(t.colos))
+	for id, colo := range t.colos {
+		conns = append(conns, tunnelConnection{ID: id, Colo: colo})
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ID < conns[j].ID
+	})
+	return conns
+}
+
+// newLinkLogger creates the cloudflared logger of a link. Entries reach
+// the hooks regardless of verbosity, and are then written through the
+// controller logger.
+func newLinkLogger(out *logrus.Logger, hooks ...logrus.Hook) *logrus.Logger {
+	log := logrus.New()
+	log.SetLevel(logrus.TraceLevel)
+	log.Out = io.Discard
+	for _, hook := range hooks {
+		log.AddHook(hook)
+	}
+	log.AddHook(forwardLoggerHook{out: out})
+	return log
+}
+
+// forwardLoggerHook writes entries through another logger
+type forwardLoggerHook struct {
+	out *logrus.Logger
+}
+
+func (h forwardLoggerHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h forwardLoggerHook) Fire(entry *logrus.Entry) error {
+	h.out.WithFields(entry.Data).Log(entry.Level, entry.Message)
+	return nil
+}
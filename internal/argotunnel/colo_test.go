@@ -0,0 +1,78 @@
+package argotunnel
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColoTracker(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		host    string
+		entries []*logrus.Entry
+		out     []tunnelConnection
+	}{
+		"no-connections": {
+			host:    "none.colo.unit.com",
+			entries: []*logrus.Entry{},
+			out:     []tunnelConnection{},
+		},
+		"connections-in-order": {
+			host: "order.colo.unit.com",
+			entries: []*logrus.Entry{
+				{Message: "Connected to AMS"},
+				{Message: "Route propagating, it may take up to 1 minute for your new route to become functional"},
+				{Message: "Connected to FRA"},
+			},
+			out: []tunnelConnection{
+				{ID: "0", Colo: "AMS"},
+				{ID: "1", Colo: "FRA"},
+			},
+		},
+		"connection-reconnected": {
+			host: "reconnect.colo.unit.com",
+			entries: []*logrus.Entry{
+				{Message: "Connected to AMS"},
+				{Message: "Connected to FRA"},
+				{Message: "Serve tunnel error", Data: logrus.Fields{coloConnectionField: "0"}},
+				{Message: "Muxer shutdown", Data: logrus.Fields{coloConnectionField: "0"}},
+				{Message: "Connected to SIN"},
+			},
+			out: []tunnelConnection{
+				{ID: "0", Colo: "SIN"},
+				{ID: "1", Colo: "FRA"},
+			},
+		},
+	} {
+		log, _ := logtest.NewNullLogger()
+		tracker := newColoTracker(test.host, log)
+		for _, entry := range test.entries {
+			assert.Nilf(t, tracker.Fire(entry), "test '%s' error mismatch", name)
+		}
+		assert.Equalf(t, test.out, tracker.connections(), "test '%s' connections mismatch", name)
+		for _, conn := range test.out {
+			value := testutil.ToFloat64(tunnelConnectionInfo.WithLabelValues(test.host, conn.ID, conn.Colo))
+			assert.Equalf(t, float64(1), value, "test '%s' metric mismatch", name)
+		}
+
+		tracker.reset()
+		assert.Equalf(t, []tunnelConnection{}, tracker.connections(), "test '%s' reset mismatch", name)
+	}
+}
+
+func TestColoTrackerChangeMetric(t *testing.T) {
+	t.Parallel()
+	host := "change.colo.unit.com"
+	log, _ := logtest.NewNullLogger()
+	tracker := newColoTracker(host, log)
+	tracker.Fire(&logrus.Entry{Message: "Connected to AMS"})
+	tracker.Fire(&logrus.Entry{Message: "Serve tunnel error", Data: logrus.Fields{coloConnectionField: "0"}})
+	tracker.Fire(&logrus.Entry{Message: "Connected to JNB"})
+
+	assert.Equal(t, 1, testutil.CollectAndCount(tunnelConnectionInfo.MustCurryWith(map[string]string{"hostname": host})))
+	assert.Equal(t, float64(1), testutil.ToFloat64(tunnelConnectionInfo.WithLabelValues(host, "0", "JNB")))
+}
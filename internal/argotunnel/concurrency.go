@@ -0,0 +1,93 @@
+package argotunnel
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// concurrencyQueueTimeout bounds the wait of a request for a slot below
+// the concurrency cap
+const concurrencyQueueTimeout = 10 * time.Second
+
+// concurrencyRoundTripper caps the requests in flight to the origin. A
+// request beyond the cap waits for a slot, up to the queue timeout, and
+// is then answered with 503. A request holds its slot until the response
+// body is closed.
+type concurrencyRoundTripper struct {
+	next    http.RoundTripper
+	slots   chan struct{}
+	timeout time.Duration
+	labels  []string
+}
+
+func newConcurrencyRoundTripper(next http.RoundTripper, rule tunnelRule, options tunnelOptions) *concurrencyRoundTripper {
+	return &concurrencyRoundTripper{
+		next:    next,
+		slots:   make(chan struct{}, options.maxConcurrentRequests),
+		timeout: concurrencyQueueTimeout,
+		labels:  metricsLabels(rule, options),
+	}
+}
+
+func (rt *concurrencyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !rt.acquire(req) {
+		closeRequestBody(req)
+		tunnelRejectedRequests.WithLabelValues(rt.labels...).Inc()
+		return newSyntheticPageResponse(req, syntheticPage{
+			status:     http.StatusServiceUnavailable,
+			reason:     syntheticReasonOriginBusy,
+			message:    fmt.Sprintf("The origin is busy, %d requests in flight.", cap(rt.slots)),
+			retryAfter: time.Second,
+		}), nil
+	}
+	tunnelConcurrency.WithLabelValues(rt.labels...).Inc()
+
+	res, err := rt.next.RoundTrip(req)
+	if err != nil || res.Body == nil {
+		rt.release()
+		return res, err
+	}
+	res.Body = &releaseBody{ReadCloser: res.Body, release: rt.release}
+	return res, nil
+}
+
+// acquire takes a slot, waiting up to the queue timeout, false when the
+// wait timed out or the request was canceled
+func (rt *concurrencyRoundTripper) acquire(req *http.Request) bool {
+	select {
+	case rt.slots <- struct{}{}:
+		return true
+	default:
+	}
+	timer := time.NewTimer(rt.timeout)
+	defer timer.Stop()
+	select {
+	case rt.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-req.Context().Done():
+	}
+	return false
+}
+
+func (rt *concurrencyRoundTripper) release() {
+	tunnelConcurrency.WithLabelValues(rt.labels...).Dec()
+	<-rt.slots
+}
+
+// releaseBody releases the slot of a request once its response body is
+// closed
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,97 @@
+package argotunnel
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyRoundTripper(t *testing.T) {
+	t.Parallel()
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return newSyntheticResponse(req, http.StatusOK, "text/plain", "ok"), nil
+	})
+	for name, test := range map[string]struct {
+		held    int
+		cancel  bool
+		release bool
+		status  int
+		retry   string
+	}{
+		"below-cap": {
+			held:   1,
+			status: http.StatusOK,
+		},
+		"at-cap-rejected": {
+			held:   2,
+			status: http.StatusServiceUnavailable,
+			retry:  "1",
+		},
+		"at-cap-canceled": {
+			held:   2,
+			cancel: true,
+			status: http.StatusServiceUnavailable,
+			retry:  "1",
+		},
+		"at-cap-released": {
+			held:    2,
+			release: true,
+			status:  http.StatusOK,
+		},
+	} {
+		rt := newConcurrencyRoundTripper(origin, tunnelRule{host: name}, tunnelOptions{maxConcurrentRequests: 2})
+		rt.timeout = 50 * time.Millisecond
+
+		var held []*http.Response
+		for i := 0; i < test.held; i++ {
+			req, _ := http.NewRequest(http.MethodGet, "http://unit.com", nil)
+			res, _ := rt.RoundTrip(req)
+			held = append(held, res)
+		}
+		if test.release {
+			rt.timeout = time.Second
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				held[0].Body.Close()
+			}()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if test.cancel {
+			rt.timeout = time.Minute
+			cancel()
+		}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://unit.com", nil)
+		res, err := rt.RoundTrip(req)
+		cancel()
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.status, res.StatusCode, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.retry, res.Header.Get("Retry-After"), "test '%s' retry mismatch", name)
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+
+		for _, r := range held {
+			r.Body.Close()
+			r.Body.Close()
+		}
+		assert.Equalf(t, 0, len(rt.slots), "test '%s' slots mismatch", name)
+	}
+}
+
+func TestNewConcurrencyRoundTripper(t *testing.T) {
+	t.Parallel()
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, nil
+	})
+	log, _ := logtest.NewNullLogger()
+	rt, ok := newLinkRoundTripper(transport, tunnelRule{}, tunnelOptions{maxConcurrentRequests: 4}, nil, nil, log).(*concurrencyRoundTripper)
+	assert.Truef(t, ok, "test concurrency mismatch")
+	assert.Equalf(t, 4, cap(rt.slots), "test concurrency slots mismatch")
+	_, ok = rt.next.(*metricsRoundTripper)
+	assert.Truef(t, ok, "test concurrency metrics mismatch")
+}
@@ -1,58 +1,236 @@
 package argotunnel
 
 import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 // Controller translates kubernetes events into tunnels.
 type Controller struct {
-	client  kubernetes.Interface
-	log     *logrus.Logger
-	options options
+	client    kubernetes.Interface
+	router    tunnelRouter
+	informers informerHealthSet
+	log       *logrus.Logger
+	options   options
+	reloads   chan []Option
+	mu        sync.Mutex
+	recorder  record.EventRecorder
+	webhook   *eventWebhook
 }
 
 // NewController create a new controller
 func NewController(client kubernetes.Interface, log *logrus.Logger, options ...Option) *Controller {
 	o := collectOptions(options)
+	log = newFieldLogger(log, o.logFields)
 	return &Controller{
-		client:  client,
-		log:     log,
-		options: o,
+		client:    client,
+		router:    newTunnelRouter(log, o),
+		informers: newInformerHealthSet(time.Now(), configMapKind, endpointKind, ingressKind, secretKind, serviceKind),
+		log:       log,
+		options:   o,
+		reloads:   make(chan []Option, 1),
+		webhook:   newEventWebhook(o.eventWebhookURL, log),
 	}
 }
 
-// Run starts processing
-func (c *Controller) Run(stopCh <-chan struct{}) (err error) {
+// Reload re-evaluates every ingress with the options, e.g. a new ingress
+// class or watch namespace, without restarting the tunnels still served.
+// It returns false while a previous reload is pending.
+func (c *Controller) Reload(opts ...Option) bool {
+	select {
+	case c.reloads <- opts:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run starts processing until stopCh is closed, see RunContext
+func (c *Controller) Run(stopCh <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return c.RunContext(ctx)
+}
+
+// RunContext starts processing until the context is done, the watches
+// and workers are restarted on reload. It returns nil once the context
+// is done, and the error of the controller otherwise. The tunnels are
+// stopped before it returns.
+func (c *Controller) RunContext(ctx context.Context) (err error) {
 	defer runtime.HandleCrash()
 
-	q := queue("queue")
+	// the webhook outlives the reloads, as the tunnels posting to it do
+	if c.webhook != nil {
+		go c.webhook.run(ctx.Done())
+	}
+
+	// the router outlives the reloads, its tunnels are stopped and
+	// drained on return
+	routerStopCh := make(chan struct{})
+	routed := make(chan error, 1)
+	go func() {
+		routed <- c.router.run(routerStopCh)
+	}()
+	defer func() {
+		close(routerStopCh)
+		<-routed
+	}()
+
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func(o options) {
+			done <- c.run(runCtx, o)
+		}(c.options)
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			return <-done
+		case err = <-done:
+			cancel()
+			return
+		case opts := <-c.reloads:
+			cancel()
+			if err = <-done; err != nil {
+				return
+			}
+			for _, opt := range opts {
+				opt(&c.options)
+			}
+			c.log.Infof("reloading argo-tunnel ingress, ingress class: %s, watch namespace: %q", c.options.ingressClass, c.options.watchNamespace)
+		}
+	}
+}
+
+func (c *Controller) run(ctx context.Context, o options) (err error) {
+	stopCh := ctx.Done()
+	q := queue("queue", o.reconcileBaseDelay, o.reconcileMaxDelay)
 	defer q.ShutDown()
 
-	eph := newEndpointEventHander(q)
-	ingh := newIngressEventHander(q, c.options.ingressClass)
-	sech := newSecretEventHander(q)
-	svch := newServiceEventHander(q)
+	if o.adoptUnclassed {
+		o.adoptUnclassed = verifyAdoptUnclassed(ctx, c.client, o.ingressClass, c.log)
+	}
+
+	if o.duplicatePolicy == DuplicateControllerWarn || o.duplicatePolicy == DuplicateControllerRefuse {
+		lease := newClassLease(c.client, o, c.log)
+		if err = lease.start(ctx); err != nil {
+			return
+		}
+		go wait.Until(func() {
+			lease.renew(ctx)
+		}, classLeaseRenew, stopCh)
+	}
+
+	iq := newInitialQueue(newBatchQueue(q, o.resyncBatchSize, o.resyncBatchInterval))
+	cmh := newConfigMapEventHander(iq)
+	eph := newEndpointEventHander(iq)
+	ingh := newIngressEventHander(iq, o.ingressClass, o.adoptUnclassed, o.shard, o.defaultHostname)
+	sech := newSecretEventHander(iq)
+	svch := newServiceEventHander(iq)
 
 	i := informerset{
-		endpoint: newEndpointInformer(c.client, c.options, eph),
-		ingress:  newIngressInformer(c.client, c.options, ingh),
-		secret:   newSecretInformer(c.client, c.options, sech),
-		service:  newServiceInformer(c.client, c.options, svch),
+		configMap: newConfigMapInformer(c.client, o, c.informers, cmh),
+		endpoint:  newEndpointInformer(c.client, o, c.informers, eph),
+		ingress:   newIngressInformer(c.client, o, c.informers, ingh),
+		secret:    newSecretInformer(c.client, o, c.informers, sech),
+		service:   newServiceInformer(c.client, o, c.informers, svch),
 	}
 
-	t := newTranslator(i, c.log, c.options)
+	// the routed ingresses are reconciled again, releasing those no longer
+	// matching after a reload
+	for _, key := range routedIngressKeys(c.router) {
+		q.Add(key)
+	}
+
+	b := record.NewBroadcaster()
+	defer b.Shutdown()
+	b.StartLogging(c.log.Debugf)
+	b.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: c.client.CoreV1().Events(o.watchNamespace),
+	})
+	r := b.NewRecorder(scheme.Scheme, v1.EventSource{
+		Component: o.eventComponent,
+	})
+
+	c.setRecorder(r)
+	defer c.setRecorder(nil)
+
+	t := newTranslator(i, c.router, r, c.webhook, c.log, o)
+
+	summary := newSummaryEvaluator(c.router, i.secret.GetStore(), q, func() bool {
+		return i.ingress.HasSynced() && i.secret.HasSynced()
+	}, time.Now())
+	go wait.Until(summary.run, summaryPeriod, stopCh)
+
+	go wait.Until(func() {
+		exportAdoptedIngresses(i.ingress.GetStore(), o.ingressClass, o.adoptUnclassed)
+	}, summaryPeriod, stopCh)
+
+	sweeper := newRouteSweeper(c.router, i.ingress.GetStore(), i.ingress.HasSynced)
+	go wait.Until(sweeper.run, sweepPeriod, stopCh)
 
 	w := worker{
 		queue:      q,
 		translator: t,
 		log:        c.log,
-		options:    c.options,
+		options:    o,
+		release: func() {
+			iq.release(ingressPriority(i.ingress.GetStore()))
+		},
 	}
 	c.log.Infof("starting argo-tunnel ingress...")
-	w.log.Debugf("argo-tunnel ingress options=%+v", c.options)
+	w.log.Debugf("argo-tunnel ingress options=%+v", o)
 	err = w.run(stopCh)
 	c.log.Infof("stopping argo-tunnel ingress...")
 	return
 }
+
+// setRecorder keeps the recorder of the running watches for the debug
+// handlers
+func (c *Controller) setRecorder(r record.EventRecorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recorder = r
+}
+
+// eventf records an event on the ingress while the watches run
+func (c *Controller) eventf(ing *networkingv1.Ingress, eventtype, reason, messageFmt string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.recorder != nil {
+		c.recorder.Eventf(ing, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// routedIngressKeys lists the queue keys of the ingresses with tunnels
+func routedIngressKeys(router tunnelRouter) (keys []string) {
+	seen := map[string]bool{}
+	for _, t := range router.tunnels() {
+		if !seen[t.Ingress] {
+			seen[t.Ingress] = true
+			keys = append(keys, ingressKind+"/"+t.Ingress)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
@@ -0,0 +1,277 @@
+package argotunnel
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	goruntime "runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestControllerRunContext(t *testing.T) {
+	holder := "other-namespace/argo-tunnel"
+	duration := int32(classLeaseDuration / time.Second)
+	renew := metav1.NewMicroTime(time.Now())
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test-namespace",
+			Name:      "argot-class-test-class",
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			AcquireTime:          &renew,
+			RenewTime:            &renew,
+		},
+	}
+	// the watches use the rest client missing from the fake clientset, the
+	// cases end before the watches start
+	for name, test := range map[string]struct {
+		in      []runtime.Object
+		policy  string
+		channel bool
+		err     string
+	}{
+		"duplicate-refused-context": {
+			in:     []runtime.Object{lease},
+			policy: DuplicateControllerRefuse,
+			err:    "ingress class test-class is served by another controller: other-namespace/argo-tunnel, see --duplicate-controller-policy",
+		},
+		"duplicate-refused-channel": {
+			in:      []runtime.Object{lease},
+			policy:  DuplicateControllerRefuse,
+			channel: true,
+			err:     "ingress class test-class is served by another controller: other-namespace/argo-tunnel, see --duplicate-controller-policy",
+		},
+	} {
+		log := logrus.New()
+		log.Out = ioutil.Discard
+		c := NewController(fake.NewSimpleClientset(test.in...), log,
+			ControllerID("test-namespace/argo-tunnel", "test-namespace"),
+			DuplicateControllerPolicy(test.policy),
+			IngressClass("test-class"),
+		)
+
+		var err error
+		if test.channel {
+			stopCh := make(chan struct{})
+			err = c.Run(stopCh)
+			close(stopCh)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err = c.RunContext(ctx)
+			cancel()
+		}
+		if len(test.err) == 0 {
+			assert.Nilf(t, err, "test '%s' error mismatch", name)
+		} else if assert.NotNilf(t, err, "test '%s' error mismatch", name) {
+			assert.Equalf(t, test.err, err.Error(), "test '%s' error mismatch", name)
+		}
+	}
+}
+
+func TestControllerRunContextStopsTunnels(t *testing.T) {
+	srv := newTestAPIServer()
+	defer srv.Close()
+
+	link := &mockTunnelLink{}
+	link.On("start").Return(nil)
+	link.On("stop").Return(nil)
+	rule := tunnelRule{host: "a.unit.com", port: 8080}
+
+	log, logs := logtest.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+	audit, hook := logtest.NewNullLogger()
+	c := NewController(kubernetes.NewForConfigOrDie(&rest.Config{Host: srv.URL}), log)
+	router := c.router.(*syncTunnelRouter)
+	router.audit = audit
+	router.history = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.RunContext(ctx)
+	}()
+	waitForTestWorkers(t, logs)
+	router.updateRoute(&tunnelRoute{
+		namespace: "unit",
+		name:      "a",
+		links:     tunnelRouteLinkMap{rule: link},
+	})
+	link.AssertNotCalled(t, "stop")
+
+	cancel()
+	assert.Nilf(t, <-done, "test 'cancel' error mismatch")
+	link.AssertExpectations(t)
+	if entry := hook.LastEntry(); assert.NotNilf(t, entry, "test 'cancel' audit mismatch") {
+		assert.Equalf(t, auditActionDelete, entry.Data["action"], "test 'cancel' audit action mismatch")
+		assert.Equalf(t, auditTriggerShutdown, entry.Data["trigger"], "test 'cancel' audit trigger mismatch")
+	}
+}
+
+func TestControllerRetriesTrippedTunnels(t *testing.T) {
+	srv := newTestAPIServer()
+	defer srv.Close()
+
+	started := make(chan struct{}, 8)
+	link := &mockTunnelLink{}
+	link.On("start").Return(nil).Run(func(mock.Arguments) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+	})
+	link.On("stop").Return(nil)
+	link.On("tripped").Return(true)
+
+	log, logs := logtest.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+	c := NewController(kubernetes.NewForConfigOrDie(&rest.Config{Host: srv.URL}), log, ResyncPeriod(10*time.Millisecond))
+	router := c.router.(*syncTunnelRouter)
+	router.audit = nil
+	router.history = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.RunContext(ctx)
+	}()
+	waitForTestWorkers(t, logs)
+	router.updateRoute(&tunnelRoute{
+		namespace: "unit",
+		name:      "a",
+		links:     tunnelRouteLinkMap{tunnelRule{host: "a.unit.com"}: link},
+	})
+
+	// the first start creates the link, the next one retries it
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("test 'retry' start %d mismatch", i)
+		}
+	}
+	cancel()
+	assert.Nilf(t, <-done, "test 'retry' error mismatch")
+	link.AssertCalled(t, "stop")
+}
+
+// TestControllerShutdownDrainsTunnels cancels the controller with
+// requests stuck on the tunnels, no goroutine may outlive the controller
+func TestControllerShutdownDrainsTunnels(t *testing.T) {
+	before := goruntime.NumGoroutine()
+	srv := newTestAPIServer()
+
+	log, logs := logtest.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+	c := NewController(kubernetes.NewForConfigOrDie(&rest.Config{Host: srv.URL}), log)
+	c.router.(*syncTunnelRouter).audit, _ = logtest.NewNullLogger()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.RunContext(ctx)
+	}()
+	waitForTestWorkers(t, logs)
+
+	host := "shutdown.unit.com"
+	l := newTunnelLink(tunnelRule{host: host}, nil, tunnelOptions{gracePeriod: 10 * time.Millisecond}, nil, nil, nil, nil, log).(*syncTunnelLink)
+	l.proxy.next = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	// a running link, its daemon is left out
+	l.stopCh, l.quitCh = make(chan struct{}), make(chan struct{})
+	c.router.updateRoute(&tunnelRoute{
+		namespace: "unit",
+		name:      "shutdown",
+		links:     tunnelRouteLinkMap{l.rule: l},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "http://shutdown.unit.com", nil)
+			if res, err := l.proxy.RoundTrip(req); err == nil {
+				res.Body.Close()
+			}
+		}()
+	}
+	for testutil.ToFloat64(proxyInflightRequests.WithLabelValues(host)) < 5 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	assert.Nilf(t, <-done, "test 'shutdown' error mismatch")
+	wg.Wait()
+	assert.Nilf(t, l.stopCh, "test 'shutdown' link mismatch")
+	assert.Equalf(t, float64(0), testutil.ToFloat64(proxyInflightRequests.WithLabelValues(host)), "test 'shutdown' inflight mismatch")
+	proxyInflightRequests.DeleteLabelValues(host)
+	srv.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	after := goruntime.NumGoroutine()
+	for after > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		after = goruntime.NumGoroutine()
+	}
+	assert.LessOrEqualf(t, after, before, "test 'shutdown' goroutine mismatch")
+}
+
+// newTestAPIServer serves empty lists and idle watches
+func newTestAPIServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method != http.MethodGet:
+			fmt.Fprint(w, `{}`)
+		case r.URL.Query().Get("watch") == "true":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			kind := testAPIListKinds[path.Base(r.URL.Path)]
+			fmt.Fprintf(w, `{"kind":%q,"apiVersion":%q,"metadata":{"resourceVersion":"1"},"items":[]}`, kind[1], kind[0])
+		}
+	}))
+}
+
+// testAPIListKinds are the list versions and kinds by resource
+var testAPIListKinds = map[string][2]string{
+	"configmaps": {"v1", "ConfigMapList"},
+	"endpoints":  {"v1", "EndpointsList"},
+	"ingresses":  {"networking.k8s.io/v1", "IngressList"},
+	"secrets":    {"v1", "SecretList"},
+	"services":   {"v1", "ServiceList"},
+}
+
+// waitForTestWorkers waits on the workers spawned once the caches synced
+func waitForTestWorkers(t *testing.T, logs *logtest.Hook) {
+	assert.Eventuallyf(t, func() bool {
+		for _, entry := range logs.AllEntries() {
+			if entry.Message == "spawning argo-tunnel workers..." {
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 10*time.Millisecond, "test workers mismatch")
+}
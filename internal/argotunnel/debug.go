@@ -0,0 +1,149 @@
+package argotunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// tunnelsReport is served by the tunnels debug handler
+type tunnelsReport struct {
+	Shard   *shard         `json:"shard"`
+	Tunnels []tunnelStatus `json:"tunnels"`
+}
+
+// TunnelsHandler reports the tunnels routed by the controller
+func (c *Controller) TunnelsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tunnelsReport{
+			Shard:   c.options.shard,
+			Tunnels: c.router.tunnels(),
+		})
+	})
+}
+
+// secretUsage lists the routes of the tunnels using an origin secret
+type secretUsage struct {
+	Secret     string        `json:"secret"`
+	References int           `json:"references"`
+	Routes     []secretRoute `json:"routes"`
+}
+
+// secretRoute is a host of an ingress routed with an origin secret
+type secretRoute struct {
+	Ingress  string `json:"ingress"`
+	Hostname string `json:"hostname"`
+}
+
+// getSecretUsages indexes the routed tunnels by the origin secret they
+// resolved, whichever of the tls section, the secret groups, or the
+// default secret it came from
+func getSecretUsages(tunnels []tunnelStatus) []secretUsage {
+	index := map[string]map[secretRoute]bool{}
+	for _, tunnel := range tunnels {
+		if index[tunnel.Secret] == nil {
+			index[tunnel.Secret] = map[secretRoute]bool{}
+		}
+		index[tunnel.Secret][secretRoute{Ingress: tunnel.Ingress, Hostname: tunnel.Hostname}] = true
+	}
+
+	usages := make([]secretUsage, 0, len(index))
+	for secret, set := range index {
+		routes := make([]secretRoute, 0, len(set))
+		for route := range set {
+			routes = append(routes, route)
+		}
+		sort.Slice(routes, func(i, j int) bool {
+			if routes[i].Ingress != routes[j].Ingress {
+				return routes[i].Ingress < routes[j].Ingress
+			}
+			return routes[i].Hostname < routes[j].Hostname
+		})
+		usages = append(usages, secretUsage{Secret: secret, References: len(routes), Routes: routes})
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Secret < usages[j].Secret
+	})
+	return usages
+}
+
+// SecretsHandler reports the routes using each origin secret
+func (c *Controller) SecretsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(getSecretUsages(c.router.tunnels()))
+	})
+}
+
+// TunnelHistoryHandler reports the last reconcile decisions of a host,
+// served under /debug/tunnels/<host>/history
+func (c *Controller) TunnelHistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/debug/tunnels/"), "/history")
+		if len(host) == 0 || strings.Contains(host, "/") || !strings.HasSuffix(r.URL.Path, "/history") {
+			http.NotFound(w, r)
+			return
+		}
+		decisions, ok := c.router.hostHistory(host)
+		if !ok {
+			http.Error(w, fmt.Sprintf("no history for host %s", host), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(decisions)
+	})
+}
+
+// TunnelPathHandler serves the paths under /debug/tunnels/, the history
+// of a host and the drain of a host of an ingress
+func (c *Controller) TunnelPathHandler() http.Handler {
+	history, drain := c.TunnelHistoryHandler(), c.TunnelDrainHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, drainPathSuffix) {
+			drain.ServeHTTP(w, r)
+			return
+		}
+		history.ServeHTTP(w, r)
+	})
+}
+
+// ReloadHandler reloads the controller with the ingress-class and
+// watch-namespace posted, an omitted value is kept
+func (c *Controller) ReloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "reload requires POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var opts []Option
+		if vals, ok := r.Form["ingress-class"]; ok {
+			class := strings.TrimSpace(vals[0])
+			if len(class) == 0 {
+				http.Error(w, "ingress-class is empty", http.StatusBadRequest)
+				return
+			}
+			opts = append(opts, IngressClass(class))
+		}
+		if vals, ok := r.Form["watch-namespace"]; ok {
+			opts = append(opts, WatchNamespace(strings.TrimSpace(vals[0])))
+		}
+		if len(opts) == 0 {
+			http.Error(w, "nothing to reload, post ingress-class or watch-namespace", http.StatusBadRequest)
+			return
+		}
+		if !c.Reload(opts...) {
+			http.Error(w, "a reload is already pending", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "reload accepted")
+	})
+}
@@ -0,0 +1,210 @@
+package argotunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTunnelsHandler(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		opts    options
+		tunnels []tunnelStatus
+		out     string
+	}{
+		"no-shard-no-tunnels": {
+			opts:    collectOptions(nil),
+			tunnels: []tunnelStatus{},
+			out:     `{"shard":null,"tunnels":[]}` + "\n",
+		},
+		"shard-tunnels": {
+			opts: collectOptions([]Option{
+				Shard(1, 3),
+			}),
+			tunnels: []tunnelStatus{
+				{
+					Hostname: "a.unit.com",
+					Ingress:  "unit/a",
+					Service:  "unit/svc",
+					Port:     8080,
+					Secret:   "unit/sec",
+					Upstream: UpstreamModeService,
+				},
+			},
+			out: `{"shard":{"index":1,"count":3},"tunnels":[{"hostname":"a.unit.com","ingress":"unit/a","service":"unit/svc","port":8080,"secret":"unit/sec","upstreamMode":"service"}]}` + "\n",
+		},
+	} {
+		router := &mockTunnelRouter{}
+		router.On("tunnels").Return(test.tunnels)
+		c := &Controller{
+			router:  router,
+			options: test.opts,
+		}
+		w := httptest.NewRecorder()
+		c.TunnelsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/tunnels", nil))
+		assert.Equalf(t, http.StatusOK, w.Code, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.out, w.Body.String(), "test '%s' body mismatch", name)
+	}
+}
+
+func TestTunnelHistoryHandler(t *testing.T) {
+	t.Parallel()
+	decisions := []reconcileDecision{
+		{
+			Time:    time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC),
+			Trigger: "endpoint/unit/svc",
+			Action:  historyActionReplace,
+			Service: "unit/svc:8080",
+			OldHash: "0123456789ab",
+			NewHash: "ba9876543210",
+			Changes: []string{"origin"},
+		},
+	}
+	for name, test := range map[string]struct {
+		path string
+		code int
+		out  string
+	}{
+		"history-host": {
+			path: "/debug/tunnels/a.unit.com/history",
+			code: http.StatusOK,
+			out:  `[{"time":"2024-01-01T14:32:00Z","trigger":"endpoint/unit/svc","action":"replace","service":"unit/svc:8080","oldHash":"0123456789ab","newHash":"ba9876543210","changes":["origin"]}]` + "\n",
+		},
+		"history-unknown-host": {
+			path: "/debug/tunnels/b.unit.com/history",
+			code: http.StatusNotFound,
+			out:  "no history for host b.unit.com\n",
+		},
+		"history-missing-suffix": {
+			path: "/debug/tunnels/a.unit.com",
+			code: http.StatusNotFound,
+			out:  "404 page not found\n",
+		},
+	} {
+		router := &mockTunnelRouter{}
+		router.On("hostHistory", "a.unit.com").Return(decisions, true)
+		router.On("hostHistory", "b.unit.com").Return([]reconcileDecision(nil), false)
+		c := &Controller{
+			router: router,
+		}
+		w := httptest.NewRecorder()
+		c.TunnelHistoryHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		assert.Equalf(t, test.code, w.Code, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.out, w.Body.String(), "test '%s' body mismatch", name)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		method  string
+		body    string
+		pending bool
+		code    int
+		out     options
+	}{
+		"reload-method-get": {
+			method: http.MethodGet,
+			code:   http.StatusMethodNotAllowed,
+		},
+		"reload-empty": {
+			method: http.MethodPost,
+			code:   http.StatusBadRequest,
+		},
+		"reload-empty-class": {
+			method: http.MethodPost,
+			body:   "ingress-class=+",
+			code:   http.StatusBadRequest,
+		},
+		"reload-class": {
+			method: http.MethodPost,
+			body:   "ingress-class=argo-b",
+			code:   http.StatusAccepted,
+			out:    options{ingressClass: "argo-b"},
+		},
+		"reload-class-namespace": {
+			method: http.MethodPost,
+			body:   "ingress-class=argo-b&watch-namespace=unit",
+			code:   http.StatusAccepted,
+			out:    options{ingressClass: "argo-b", watchNamespace: "unit"},
+		},
+		"reload-all-namespaces": {
+			method: http.MethodPost,
+			body:   "watch-namespace=",
+			code:   http.StatusAccepted,
+			out:    options{},
+		},
+		"reload-pending": {
+			method:  http.MethodPost,
+			body:    "ingress-class=argo-b",
+			pending: true,
+			code:    http.StatusConflict,
+		},
+	} {
+		c := &Controller{reloads: make(chan []Option, 1)}
+		if test.pending {
+			c.Reload(IngressClass("argo-a"))
+		}
+		req := httptest.NewRequest(test.method, "/debug/reload", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		c.ReloadHandler().ServeHTTP(rec, req)
+		assert.Equalf(t, test.code, rec.Code, "test '%s' code mismatch", name)
+		if test.code == http.StatusAccepted {
+			var out options
+			for _, opt := range <-c.reloads {
+				opt(&out)
+			}
+			assert.Equalf(t, test.out, out, "test '%s' options mismatch", name)
+		}
+	}
+}
+
+func TestRoutedIngressKeys(t *testing.T) {
+	t.Parallel()
+	router := &mockTunnelRouter{}
+	router.On("tunnels").Return([]tunnelStatus{
+		{Hostname: "b.unit.com", Ingress: "unit/ing-b"},
+		{Hostname: "a.unit.com", Ingress: "unit/ing-a"},
+		{Hostname: "c.unit.com", Ingress: "unit/ing-a"},
+	})
+	assert.Equalf(t, []string{"ingress/unit/ing-a", "ingress/unit/ing-b"}, routedIngressKeys(router), "test routed ingress keys mismatch")
+}
+
+func TestSecretsHandler(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		tunnels []tunnelStatus
+		out     string
+	}{
+		"no-tunnels": {
+			tunnels: []tunnelStatus{},
+			out:     `[]` + "\n",
+		},
+		"tunnels": {
+			tunnels: []tunnelStatus{
+				{Hostname: "b.unit.com", Ingress: "unit/b", Service: "unit/svc", Secret: "unit/sec"},
+				{Hostname: "a.unit.com", Ingress: "unit/a", Service: "unit/svc", Secret: "unit/sec"},
+				{Hostname: "a.unit.com", Ingress: "unit/a", Service: "unit/api", Secret: "unit/sec"},
+				{Hostname: "c.unit.com", Ingress: "unit/c", Service: "unit/svc", Secret: "argo/default"},
+			},
+			out: `[{"secret":"argo/default","references":1,"routes":[{"ingress":"unit/c","hostname":"c.unit.com"}]},` +
+				`{"secret":"unit/sec","references":2,"routes":[{"ingress":"unit/a","hostname":"a.unit.com"},{"ingress":"unit/b","hostname":"b.unit.com"}]}]` + "\n",
+		},
+	} {
+		router := &mockTunnelRouter{}
+		router.On("tunnels").Return(test.tunnels)
+		c := &Controller{
+			router: router,
+		}
+		w := httptest.NewRecorder()
+		c.SecretsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/secrets", nil))
+		assert.Equalf(t, http.StatusOK, w.Code, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.out, w.Body.String(), "test '%s' body mismatch", name)
+	}
+}
@@ -0,0 +1,103 @@
+package argotunnel
+
+import (
+	"strings"
+
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// defaultableAnnotations are the annotations a defaults ConfigMap may
+// set, the tuning of the tunnels rather than the routing of an ingress
+var defaultableAnnotations = map[string]bool{
+	annotationIngressCompressionQuality: true,
+	annotationIngressHAConnections:      true,
+	annotationIngressHeartbeatCount:     true,
+	annotationIngressHeartbeatInterval:  true,
+	annotationIngressIncludeUnready:     true,
+	annotationIngressInitialDelay:       true,
+	annotationIngressInjectOverride:     true,
+	annotationIngressInjectRobots:       true,
+	annotationIngressLoadBalancer:       true,
+	annotationIngressMaxBodyBytes:       true,
+	annotationIngressMaxConcurrent:      true,
+	annotationIngressNoChunkedEncoding:  true,
+	annotationIngressNoForwardedHeaders: true,
+	annotationIngressProto:              true,
+	annotationIngressRetries:            true,
+	annotationIngressStreamBufferBytes:  true,
+	annotationIngressTag:                true,
+	annotationIngressUpstreamMode:       true,
+}
+
+// isAnnotationDefaults reports whether the ConfigMap holds annotation
+// defaults, the defaults ConfigMap or its namesake in another namespace
+func (t *syncTranslator) isAnnotationDefaults(name string) bool {
+	d := t.options.annotationDefaults
+	return d != nil && d.name == name
+}
+
+// getAnnotationDefaults merges the defaults of an ingress namespace, the
+// ConfigMap named after the defaults ConfigMap in the namespace of the
+// ingress overrides the defaults ConfigMap
+func (t *syncTranslator) getAnnotationDefaults(namespace string) map[string]string {
+	d := t.options.annotationDefaults
+	if d == nil {
+		return nil
+	}
+	defaults := map[string]string{}
+	for _, key := range []string{itemKeyFunc(d.namespace, d.name), itemKeyFunc(namespace, d.name)} {
+		obj, exists, err := t.informers.configMap.GetIndexer().GetByKey(key)
+		if err != nil {
+			t.log.Errorf("translator annotation defaults configmap issue: %s, err: %v", key, err)
+			continue
+		} else if !exists {
+			continue
+		}
+		for name, val := range obj.(*v1.ConfigMap).Data {
+			if !defaultableAnnotations[name] {
+				t.log.Warnf("translator annotation defaults configmap: %s, key not defaultable: %q", key, name)
+				continue
+			}
+			defaults[name] = strings.TrimSpace(val)
+		}
+	}
+	return defaults
+}
+
+// withAnnotationDefaults returns the ingress with the defaults of the
+// annotations it does not set, the ingress itself when none applies
+func withAnnotationDefaults(ing *networkingv1.Ingress, defaults map[string]string) *networkingv1.Ingress {
+	var out *networkingv1.Ingress
+	for name, val := range defaults {
+		if _, ok := ing.Annotations[name]; ok {
+			continue
+		}
+		if out == nil {
+			out = ing.DeepCopy()
+			if out.Annotations == nil {
+				out.Annotations = map[string]string{}
+			}
+		}
+		out.Annotations[name] = val
+	}
+	if out == nil {
+		return ing
+	}
+	return out
+}
+
+// getControllerAnnotations lists the annotations of an ingress under the
+// controller prefix, nil without any
+func getControllerAnnotations(ing *networkingv1.Ingress) (annotations map[string]string) {
+	for name, val := range ing.Annotations {
+		if !strings.HasPrefix(name, annotationPrefix) {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[name] = val
+	}
+	return
+}
@@ -0,0 +1,225 @@
+package argotunnel
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestAnnotationDefaultsPrecedence(t *testing.T) {
+	t.Parallel()
+	configMap := func(namespace string, data map[string]string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "argo-defaults"},
+			Data:       data,
+		}
+	}
+	for name, test := range map[string]struct {
+		configMaps  []runtime.Object
+		annotations map[string]string
+		proto       string
+		ha          int
+	}{
+		"flags": {
+			proto: ProtoHTTPS,
+			ha:    haConnectionsDefault,
+		},
+		"configmap-over-flags": {
+			configMaps: []runtime.Object{
+				configMap("argo", map[string]string{
+					annotationIngressProto:         ProtoHTTP,
+					annotationIngressHAConnections: "2",
+				}),
+			},
+			proto: ProtoHTTP,
+			ha:    2,
+		},
+		"namespace-over-configmap": {
+			configMaps: []runtime.Object{
+				configMap("argo", map[string]string{
+					annotationIngressProto:         ProtoHTTP,
+					annotationIngressHAConnections: "2",
+				}),
+				configMap("unit", map[string]string{
+					annotationIngressHAConnections: "3",
+				}),
+			},
+			proto: ProtoHTTP,
+			ha:    3,
+		},
+		"ingress-over-namespace": {
+			configMaps: []runtime.Object{
+				configMap("argo", map[string]string{
+					annotationIngressProto:         ProtoHTTP,
+					annotationIngressHAConnections: "2",
+				}),
+				configMap("unit", map[string]string{
+					annotationIngressHAConnections: "3",
+				}),
+			},
+			annotations: map[string]string{
+				annotationIngressHAConnections: "1",
+			},
+			proto: ProtoHTTP,
+			ha:    1,
+		},
+		"other-namespace-ignored": {
+			configMaps: []runtime.Object{
+				configMap("other", map[string]string{
+					annotationIngressHAConnections: "3",
+				}),
+			},
+			proto: ProtoHTTPS,
+			ha:    haConnectionsDefault,
+		},
+		"not-defaultable-ignored": {
+			configMaps: []runtime.Object{
+				configMap("argo", map[string]string{
+					annotationIngressTargetService: "svc-b",
+					annotationIngressHAConnections: "2",
+				}),
+			},
+			proto: ProtoHTTPS,
+			ha:    2,
+		},
+	} {
+		logger, _ := logtest.NewNullLogger()
+		tr := &syncTranslator{
+			informers: informerset{
+				configMap: newStaticInformer(new(v1.ConfigMap), test.configMaps...),
+			},
+			log: logger,
+			options: collectOptions([]Option{
+				AnnotationDefaults("argo-defaults", "argo"),
+				DefaultProto(ProtoHTTPS),
+			}),
+		}
+		ing := withAnnotationDefaults(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "unit",
+				Name:        "ing-a",
+				Annotations: test.annotations,
+			},
+		}, tr.getAnnotationDefaults("unit"))
+		opts := collectTunnelOptions(append(defaultTunnelOptions(tr.options), parseIngressTunnelOptions(ing)...))
+		assert.Equalf(t, test.proto, opts.proto, "test '%s' proto mismatch", name)
+		assert.Equalf(t, test.ha, opts.haConnections, "test '%s' ha connections mismatch", name)
+		_, ok := ing.Annotations[annotationIngressTargetService]
+		assert.Falsef(t, ok, "test '%s' not defaultable mismatch", name)
+	}
+}
+
+func TestWithAnnotationDefaults(t *testing.T) {
+	t.Parallel()
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "unit",
+			Name:      "ing-a",
+			Annotations: map[string]string{
+				annotationIngressHAConnections: "4",
+			},
+		},
+	}
+	out := withAnnotationDefaults(ing, map[string]string{annotationIngressHAConnections: "2"})
+	assert.Truef(t, ing == out, "test 'set-by-ingress' copy mismatch")
+
+	out = withAnnotationDefaults(ing, map[string]string{annotationIngressProto: ProtoHTTP})
+	assert.Equalf(t, map[string]string{
+		annotationIngressHAConnections: "4",
+		annotationIngressProto:         ProtoHTTP,
+	}, out.Annotations, "test 'defaulted' annotations mismatch")
+	assert.Equalf(t, map[string]string{
+		annotationIngressHAConnections: "4",
+	}, ing.Annotations, "test 'defaulted' original mismatch")
+}
+
+func TestHandleConfigMapAnnotationDefaults(t *testing.T) {
+	ing := func(namespace, name string) runtime.Object {
+		return &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   namespace,
+				Name:        name,
+				Annotations: map[string]string{annotationIngressClass: IngressClassDefault},
+			},
+		}
+	}
+	configMaps := []runtime.Object{
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "argo", Name: "argo-defaults"},
+			Data:       map[string]string{annotationIngressHAConnections: "2"},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "argo-defaults"},
+			Data:       map[string]string{annotationIngressHAConnections: "3"},
+		},
+	}
+	for name, test := range map[string]struct {
+		key    string
+		routes map[string]string
+	}{
+		"configmap": {
+			key: "argo/argo-defaults",
+			routes: map[string]string{
+				"team-a/ing-a": "3",
+				"team-b/ing-b": "2",
+			},
+		},
+		"namespace": {
+			key: "team-a/argo-defaults",
+			routes: map[string]string{
+				"team-a/ing-a": "3",
+			},
+		},
+	} {
+		logger, _ := logtest.NewNullLogger()
+		router := &mockTunnelRouter{}
+		router.On("updateByKindRoutes", configMapKind, mock.Anything, "argo-defaults", mock.Anything).Return(nil)
+		tr := &syncTranslator{
+			informers: informerset{
+				configMap: newStaticInformer(new(v1.ConfigMap), configMaps...),
+				ingress:   newStaticInformer(new(networkingv1.Ingress), ing("team-a", "ing-a"), ing("team-b", "ing-b")),
+			},
+			router: router,
+			log:    logger,
+			options: collectOptions([]Option{
+				AnnotationDefaults("argo-defaults", "argo"),
+			}),
+		}
+		err := tr.handleConfigMap(context.Background(), configMapKind, test.key)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		router.AssertNumberOfCalls(t, "updateByKindRoutes", 1)
+
+		routes := map[string]string{}
+		for _, route := range router.Calls[0].Arguments.Get(3).([]*tunnelRoute) {
+			routes[itemKeyFunc(route.namespace, route.name)] = route.annotations[annotationIngressHAConnections]
+			ingressLastReconcile.DeleteLabelValues(route.namespace, route.name)
+		}
+		assert.Equalf(t, test.routes, routes, "test '%s' routes mismatch", name)
+	}
+}
+
+func TestGetControllerAnnotations(t *testing.T) {
+	t.Parallel()
+	out := getControllerAnnotations(&networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationIngressHAConnections: "2",
+				annotationIngressClass:         IngressClassDefault,
+			},
+		},
+	})
+	keys := make([]string, 0, len(out))
+	for key := range out {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	assert.Equalf(t, []string{annotationIngressHAConnections}, keys, "test annotations mismatch")
+}
@@ -0,0 +1,185 @@
+package argotunnel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// drainMaxDuration bounds a drain, a forgotten drain ends on its own
+	drainMaxDuration = 24 * time.Hour
+
+	drainPathSuffix = "/drain"
+)
+
+// parseDrains reads the hosts drained until a time from the drain
+// annotation, '<host>=<RFC3339 time>' entries separated by commas.
+// Malformed entries are skipped.
+func parseDrains(ing *networkingv1.Ingress) map[string]time.Time {
+	drains := map[string]time.Time{}
+	for _, entry := range strings.Split(ing.GetAnnotations()[annotationIngressDrain], ",") {
+		i := strings.IndexByte(entry, '=')
+		if i <= 0 {
+			continue
+		}
+		if until, err := time.Parse(time.RFC3339, strings.TrimSpace(entry[i+1:])); err == nil {
+			drains[strings.TrimSpace(entry[:i])] = until
+		}
+	}
+	return drains
+}
+
+// formatDrains renders the drains still running at now in host order,
+// ended drains are dropped
+func formatDrains(drains map[string]time.Time, now time.Time) string {
+	hosts := make([]string, 0, len(drains))
+	for host, until := range drains {
+		if until.After(now) {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	entries := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		entries = append(entries, host+"="+drains[host].UTC().Format(time.RFC3339))
+	}
+	return strings.Join(entries, ",")
+}
+
+// drainCaller names the client certificate of the request, verified by
+// the debug listener
+func drainCaller(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if len(subject.CommonName) > 0 {
+		return subject.CommonName, true
+	}
+	return subject.String(), true
+}
+
+// TunnelDrainHandler drains a host of an ingress for a duration, served
+// under /debug/tunnels/<namespace>/<ingress>/<host>/drain. The host
+// answers 503 until the drain ends, duration=0 ends it at once. The drain
+// is kept in an annotation of the ingress, and requires a client
+// certificate verified by the debug listener.
+func (c *Controller) TunnelDrainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/debug/tunnels/"), "/")
+		if len(parts) != 4 || "/"+parts[3] != drainPathSuffix || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		namespace, name, host := parts[0], parts[1], parts[2]
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "drain requires POST", http.StatusMethodNotAllowed)
+			return
+		}
+		caller, ok := drainCaller(r)
+		if !ok {
+			http.Error(w, "drain requires a client certificate, see --debug-client-ca-file", http.StatusForbidden)
+			return
+		}
+		duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || duration < 0 || duration > drainMaxDuration {
+			http.Error(w, fmt.Sprintf("duration must be between 0s and %s", drainMaxDuration), http.StatusBadRequest)
+			return
+		}
+		if !routesHost(c.router, namespace, name, host) {
+			http.Error(w, fmt.Sprintf("host %s is not routed by ingress %s", host, itemKeyFunc(namespace, name)), http.StatusNotFound)
+			return
+		}
+
+		until := time.Now().Add(duration).Truncate(time.Second)
+		ing, err := c.drain(r.Context(), namespace, name, host, until)
+		if err != nil {
+			c.log.Errorf("drain failed on ingress: %s, host: %s, caller: %s, err: %v", itemKeyFunc(namespace, name), host, caller, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entry := c.log.WithFields(logrus.Fields{
+			"caller":   caller,
+			"ingress":  itemKeyFunc(namespace, name),
+			"hostname": host,
+		})
+		if duration == 0 {
+			entry.Warnf("drain ended")
+			c.eventf(ing, v1.EventTypeNormal, eventReasonHostRestored, "host %q restored by %s", host, caller)
+			fmt.Fprintf(w, "host %s restored\n", host)
+			return
+		}
+		entry.Warnf("drain until %s", until.UTC().Format(time.RFC3339))
+		c.eventf(ing, v1.EventTypeWarning, eventReasonHostDrained, "host %q drained until %s by %s", host, until.UTC().Format(time.RFC3339), caller)
+		fmt.Fprintf(w, "host %s drained until %s\n", host, until.UTC().Format(time.RFC3339))
+	})
+}
+
+// routesHost verifies the ingress routes a tunnel of the host
+func routesHost(router tunnelRouter, namespace, name, host string) bool {
+	for _, l := range router.routeLinks(namespace, name) {
+		if l.host() == host {
+			return true
+		}
+	}
+	return false
+}
+
+// drain records the drain of the host in the annotation of the ingress,
+// an until in the past ends it
+func (c *Controller) drain(ctx context.Context, namespace, name, host string, until time.Time) (ing *networkingv1.Ingress, err error) {
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ing, err = c.client.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		drains := parseDrains(ing)
+		drains[host] = until
+		var value interface{}
+		if s := formatDrains(drains, time.Now()); len(s) > 0 {
+			value = s
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"resourceVersion": ing.ResourceVersion,
+				"annotations": map[string]interface{}{
+					annotationIngressDrain: value,
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		ing, err = c.client.NetworkingV1().Ingresses(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+	return
+}
+
+// drainedHosts lists the hosts of the ingress drained at now, and the
+// wait until the first drain ends
+func drainedHosts(ing *networkingv1.Ingress, now time.Time) (hosts map[string]bool, next time.Duration) {
+	hosts = map[string]bool{}
+	for host, until := range parseDrains(ing) {
+		if remaining := until.Sub(now); remaining > 0 {
+			hosts[host] = true
+			if next == 0 || remaining < next {
+				next = remaining
+			}
+		}
+	}
+	return
+}
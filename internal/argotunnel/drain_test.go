@@ -0,0 +1,200 @@
+package argotunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseDrains(t *testing.T) {
+	t.Parallel()
+	until := time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC)
+	for name, test := range map[string]struct {
+		in  string
+		out map[string]time.Time
+	}{
+		"empty": {
+			out: map[string]time.Time{},
+		},
+		"hosts": {
+			in: "a.unit.com=2024-01-01T14:32:00Z, b.unit.com=2024-01-01T14:32:00Z",
+			out: map[string]time.Time{
+				"a.unit.com": until,
+				"b.unit.com": until,
+			},
+		},
+		"malformed": {
+			in: "a.unit.com=2024-01-01T14:32:00Z,b.unit.com,=2024-01-01T14:32:00Z,c.unit.com=10m",
+			out: map[string]time.Time{
+				"a.unit.com": until,
+			},
+		},
+	} {
+		out := parseDrains(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{annotationIngressDrain: test.in},
+			},
+		})
+		assert.Equalf(t, test.out, out, "test '%s' drains mismatch", name)
+	}
+}
+
+func TestFormatDrains(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC)
+	for name, test := range map[string]struct {
+		in  map[string]time.Time
+		out string
+	}{
+		"empty": {
+			in: map[string]time.Time{},
+		},
+		"host-order": {
+			in: map[string]time.Time{
+				"b.unit.com": now.Add(time.Minute),
+				"a.unit.com": now.Add(time.Hour),
+			},
+			out: "a.unit.com=2024-01-01T15:32:00Z,b.unit.com=2024-01-01T14:33:00Z",
+		},
+		"ended-dropped": {
+			in: map[string]time.Time{
+				"a.unit.com": now,
+				"b.unit.com": now.Add(time.Minute),
+			},
+			out: "b.unit.com=2024-01-01T14:33:00Z",
+		},
+	} {
+		out := formatDrains(test.in, now)
+		assert.Equalf(t, test.out, out, "test '%s' annotation mismatch", name)
+	}
+}
+
+func TestDrainedHosts(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC)
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationIngressDrain: "a.unit.com=2024-01-01T14:42:00Z,b.unit.com=2024-01-01T14:35:00Z,c.unit.com=2024-01-01T14:00:00Z",
+			},
+		},
+	}
+	hosts, next := drainedHosts(ing, now)
+	assert.Equalf(t, map[string]bool{"a.unit.com": true, "b.unit.com": true}, hosts, "test drained hosts mismatch")
+	assert.Equalf(t, 3*time.Minute, next, "test drain requeue mismatch")
+}
+
+func TestTunnelDrainHandler(t *testing.T) {
+	t.Parallel()
+	verified := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "ops"}}}},
+	}
+	for name, test := range map[string]struct {
+		method  string
+		path    string
+		tls     *tls.ConnectionState
+		drains  string
+		code    int
+		drained bool
+		events  int
+	}{
+		"drain": {
+			method:  http.MethodPost,
+			path:    "/debug/tunnels/unit/ing/a.unit.com/drain?duration=10m",
+			tls:     verified,
+			code:    http.StatusOK,
+			drained: true,
+			events:  1,
+		},
+		"restore": {
+			method: http.MethodPost,
+			path:   "/debug/tunnels/unit/ing/a.unit.com/drain?duration=0s",
+			tls:    verified,
+			drains: "a.unit.com=2099-01-01T00:00:00Z",
+			code:   http.StatusOK,
+			events: 1,
+		},
+		"method-get": {
+			method: http.MethodGet,
+			path:   "/debug/tunnels/unit/ing/a.unit.com/drain?duration=10m",
+			tls:    verified,
+			code:   http.StatusMethodNotAllowed,
+		},
+		"no-client-certificate": {
+			method: http.MethodPost,
+			path:   "/debug/tunnels/unit/ing/a.unit.com/drain?duration=10m",
+			tls:    &tls.ConnectionState{},
+			code:   http.StatusForbidden,
+		},
+		"plain-http": {
+			method: http.MethodPost,
+			path:   "/debug/tunnels/unit/ing/a.unit.com/drain?duration=10m",
+			code:   http.StatusForbidden,
+		},
+		"duration-missing": {
+			method: http.MethodPost,
+			path:   "/debug/tunnels/unit/ing/a.unit.com/drain",
+			tls:    verified,
+			code:   http.StatusBadRequest,
+		},
+		"duration-too-long": {
+			method: http.MethodPost,
+			path:   "/debug/tunnels/unit/ing/a.unit.com/drain?duration=48h",
+			tls:    verified,
+			code:   http.StatusBadRequest,
+		},
+		"host-not-routed": {
+			method: http.MethodPost,
+			path:   "/debug/tunnels/unit/ing/b.unit.com/drain?duration=10m",
+			tls:    verified,
+			code:   http.StatusNotFound,
+		},
+		"path-malformed": {
+			method: http.MethodPost,
+			path:   "/debug/tunnels/unit/a.unit.com/drain?duration=10m",
+			tls:    verified,
+			code:   http.StatusNotFound,
+		},
+	} {
+		client := fake.NewSimpleClientset(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "unit",
+				Name:        "ing",
+				Annotations: map[string]string{annotationIngressDrain: test.drains},
+			},
+		})
+		link := &mockTunnelLink{}
+		link.On("host").Return("a.unit.com")
+		router := &mockTunnelRouter{}
+		router.On("routeLinks", "unit", "ing").Return([]tunnelLink{link})
+		recorder := record.NewFakeRecorder(4)
+		c := &Controller{
+			client:   client,
+			router:   router,
+			log:      logrus.New(),
+			recorder: recorder,
+		}
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.TLS = test.tls
+		w := httptest.NewRecorder()
+		c.TunnelPathHandler().ServeHTTP(w, req)
+		assert.Equalf(t, test.code, w.Code, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.events, len(recorder.Events), "test '%s' events mismatch", name)
+
+		ing, _ := client.NetworkingV1().Ingresses("unit").Get(context.Background(), "ing", metav1.GetOptions{})
+		hosts, _ := drainedHosts(ing, time.Now())
+		assert.Equalf(t, test.drained, hosts["a.unit.com"], "test '%s' drained mismatch", name)
+	}
+}
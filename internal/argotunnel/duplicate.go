@@ -0,0 +1,157 @@
+package argotunnel
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DuplicateControllerIgnore leaves the ingress class unclaimed
+	DuplicateControllerIgnore = "ignore"
+	// DuplicateControllerWarn claims the ingress class, and logs when
+	// another controller holds it
+	DuplicateControllerWarn = "warn"
+	// DuplicateControllerRefuse claims the ingress class, and stops when
+	// another controller holds it at startup
+	DuplicateControllerRefuse = "refuse"
+
+	// classLeaseDuration is the period a class lease is held without
+	// renewal, classLeaseRenew the period of its renewal
+	classLeaseDuration = 60 * time.Second
+	classLeaseRenew    = 20 * time.Second
+
+	classLeasePrefix = "argot-class-"
+)
+
+var (
+	// the generated suffixes of the pods of a Deployment or StatefulSet
+	deploymentPodSuffix  = regexp.MustCompile(`-[a-z0-9]{6,10}-[a-z0-9]{5}$`)
+	statefulSetPodSuffix = regexp.MustCompile(`-[0-9]+$`)
+)
+
+// DefaultControllerID derives the identity shared by the replicas of a
+// controller from the pod hostname, dropping the suffix generated for
+// each pod
+func DefaultControllerID(namespace, hostname string) string {
+	name := deploymentPodSuffix.ReplaceAllString(hostname, "")
+	if name == hostname {
+		name = statefulSetPodSuffix.ReplaceAllString(hostname, "")
+	}
+	if len(namespace) == 0 {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// classLease claims an ingress class for a controller through a Lease.
+// The replicas of a controller share its identity and renew the same
+// lease, another controller finds it held until it expires.
+type classLease struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	class     string
+	id        string
+	policy    string
+	log       *logrus.Logger
+	now       func() time.Time
+}
+
+func newClassLease(client kubernetes.Interface, o options, log *logrus.Logger) *classLease {
+	return &classLease{
+		client:    client,
+		namespace: o.leaseNamespace,
+		name:      classLeasePrefix + primaryIngressClass(o.ingressClass),
+		class:     primaryIngressClass(o.ingressClass),
+		id:        o.controllerID,
+		policy:    o.duplicatePolicy,
+		log:       log,
+		now:       time.Now,
+	}
+}
+
+// claim takes or renews the lease, and returns the identity of another
+// controller holding it
+func (l *classLease) claim(ctx context.Context) (holder string, err error) {
+	leases := l.client.CoordinationV1().Leases(l.namespace)
+	now := metav1.NewMicroTime(l.now())
+	duration := int32(classLeaseDuration / time.Second)
+
+	lease, err := leases.Get(ctx, l.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: l.namespace,
+				Name:      l.name,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &l.id,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return
+	} else if err != nil {
+		return
+	}
+
+	if h := lease.Spec.HolderIdentity; h != nil && *h != l.id && !leaseExpired(lease, now.Time) {
+		return *h, nil
+	}
+	if h := lease.Spec.HolderIdentity; h == nil || *h != l.id {
+		lease.Spec.HolderIdentity = &l.id
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return
+}
+
+// leaseExpired verifies the lease was not renewed within its duration
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(now)
+}
+
+// start claims the ingress class as the controller starts, a class held
+// by another controller is an error under the refuse policy
+func (l *classLease) start(ctx context.Context) error {
+	holder, err := l.claim(ctx)
+	if err != nil {
+		l.log.Warnf("unable to claim ingress class %s, lease: %s/%s: %v", l.class, l.namespace, l.name, err)
+		return nil
+	}
+	if len(holder) == 0 {
+		return nil
+	}
+	if l.policy == DuplicateControllerRefuse {
+		return fmt.Errorf("ingress class %s is served by another controller: %s, see --duplicate-controller-policy", l.class, holder)
+	}
+	l.log.WithField("holder", holder).Warnf("ingress class %s is also served by another controller: %s", l.class, holder)
+	return nil
+}
+
+// renew keeps the lease while the controller runs, and takes it over
+// once the other controller stops renewing
+func (l *classLease) renew(ctx context.Context) {
+	holder, err := l.claim(ctx)
+	if err != nil {
+		l.log.Debugf("unable to renew ingress class %s, lease: %s/%s: %v", l.class, l.namespace, l.name, err)
+		return
+	}
+	if len(holder) > 0 {
+		l.log.WithField("holder", holder).Warnf("ingress class %s is also served by another controller: %s", l.class, holder)
+	}
+}
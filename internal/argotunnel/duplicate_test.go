@@ -0,0 +1,122 @@
+package argotunnel
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDefaultControllerID(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		namespace string
+		hostname  string
+		out       string
+	}{
+		"deployment-pod": {
+			namespace: "test-namespace",
+			hostname:  "argo-tunnel-7d9f8b6c5d-x2k4p",
+			out:       "test-namespace/argo-tunnel",
+		},
+		"statefulset-pod": {
+			namespace: "test-namespace",
+			hostname:  "argo-tunnel-2",
+			out:       "test-namespace/argo-tunnel",
+		},
+		"plain-hostname": {
+			namespace: "test-namespace",
+			hostname:  "workstation",
+			out:       "test-namespace/workstation",
+		},
+		"no-namespace": {
+			hostname: "argo-tunnel-7d9f8b6c5d-x2k4p",
+			out:      "argo-tunnel",
+		},
+	} {
+		out := DefaultControllerID(test.namespace, test.hostname)
+		assert.Equalf(t, test.out, out, "test '%s' controller id mismatch", name)
+	}
+}
+
+func TestClassLeaseStart(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1600000000, 0)
+	lease := func(holder string, renewed time.Time) *coordinationv1.Lease {
+		duration := int32(classLeaseDuration / time.Second)
+		renew := metav1.NewMicroTime(renewed)
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "test-namespace",
+				Name:      "argot-class-test-class",
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &renew,
+				RenewTime:            &renew,
+			},
+		}
+	}
+	for name, test := range map[string]struct {
+		policy string
+		in     []runtime.Object
+		holder string
+		err    string
+	}{
+		"missing-lease": {
+			policy: DuplicateControllerRefuse,
+			holder: "test-namespace/argo-tunnel",
+		},
+		"own-lease": {
+			policy: DuplicateControllerRefuse,
+			in:     []runtime.Object{lease("test-namespace/argo-tunnel", now.Add(-10*time.Second))},
+			holder: "test-namespace/argo-tunnel",
+		},
+		"other-lease-active-refuse": {
+			policy: DuplicateControllerRefuse,
+			in:     []runtime.Object{lease("other-namespace/argo-tunnel", now.Add(-10*time.Second))},
+			holder: "other-namespace/argo-tunnel",
+			err:    "ingress class test-class is served by another controller: other-namespace/argo-tunnel, see --duplicate-controller-policy",
+		},
+		"other-lease-active-warn": {
+			policy: DuplicateControllerWarn,
+			in:     []runtime.Object{lease("other-namespace/argo-tunnel", now.Add(-10*time.Second))},
+			holder: "other-namespace/argo-tunnel",
+		},
+		"other-lease-expired": {
+			policy: DuplicateControllerRefuse,
+			in:     []runtime.Object{lease("other-namespace/argo-tunnel", now.Add(-2*classLeaseDuration))},
+			holder: "test-namespace/argo-tunnel",
+		},
+	} {
+		client := fake.NewSimpleClientset(test.in...)
+		log := logrus.New()
+		log.Out = ioutil.Discard
+		l := newClassLease(client, collectOptions([]Option{
+			ControllerID("test-namespace/argo-tunnel", "test-namespace"),
+			DuplicateControllerPolicy(test.policy),
+			IngressClass("test-class"),
+		}), log)
+		l.now = func() time.Time { return now }
+
+		err := l.start(context.TODO())
+		if len(test.err) == 0 {
+			assert.Nilf(t, err, "test '%s' error mismatch", name)
+		} else if assert.NotNilf(t, err, "test '%s' error mismatch", name) {
+			assert.Equalf(t, test.err, err.Error(), "test '%s' error mismatch", name)
+		}
+
+		out, err := client.CoordinationV1().Leases("test-namespace").Get(context.TODO(), "argot-class-test-class", metav1.GetOptions{})
+		if assert.Nilf(t, err, "test '%s' lease mismatch", name) {
+			assert.Equalf(t, test.holder, *out.Spec.HolderIdentity, "test '%s' holder mismatch", name)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package argotunnel
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// EdgeProtocolAuto lets the tunnels pick the edge protocol
+	EdgeProtocolAuto = "auto"
+	// EdgeProtocolH2mux connects the tunnels to the edge over h2mux on TCP
+	EdgeProtocolH2mux = "h2mux"
+	// EdgeProtocolHTTP2 connects the tunnels to the edge over HTTP/2 on TCP
+	EdgeProtocolHTTP2 = "http2"
+	// EdgeProtocolQUIC connects the tunnels to the edge over QUIC on UDP
+	EdgeProtocolQUIC = "quic"
+)
+
+// edgeProtocols lists the protocols known to cloudflared, in flag order
+var edgeProtocols = []string{EdgeProtocolAuto, EdgeProtocolH2mux, EdgeProtocolHTTP2, EdgeProtocolQUIC}
+
+// ValidateEdgeProtocol checks the protocol is served by the tunnels. The
+// vendored cloudflared origin only speaks h2mux, which auto resolves to;
+// http2 and quic need a later cloudflared release.
+func ValidateEdgeProtocol(s string) error {
+	switch s {
+	case EdgeProtocolAuto, EdgeProtocolH2mux:
+		return nil
+	case EdgeProtocolHTTP2, EdgeProtocolQUIC:
+		return fmt.Errorf("edge protocol %q is not supported, tunnels only reach the edge over %s on TCP", s, EdgeProtocolH2mux)
+	}
+	return fmt.Errorf("unknown edge protocol %q, expected one of: %s", s, strings.Join(edgeProtocols, ", "))
+}
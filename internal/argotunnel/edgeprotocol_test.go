@@ -0,0 +1,44 @@
+package argotunnel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEdgeProtocol(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  string
+		err string
+	}{
+		"auto": {
+			in: EdgeProtocolAuto,
+		},
+		"h2mux": {
+			in: EdgeProtocolH2mux,
+		},
+		"http2-unsupported": {
+			in:  EdgeProtocolHTTP2,
+			err: `edge protocol "http2" is not supported, tunnels only reach the edge over h2mux on TCP`,
+		},
+		"quic-unsupported": {
+			in:  EdgeProtocolQUIC,
+			err: `edge protocol "quic" is not supported, tunnels only reach the edge over h2mux on TCP`,
+		},
+		"unknown": {
+			in:  "udp",
+			err: `unknown edge protocol "udp", expected one of: auto, h2mux, http2, quic`,
+		},
+		"empty": {
+			err: `unknown edge protocol "", expected one of: auto, h2mux, http2, quic`,
+		},
+	} {
+		err := ValidateEdgeProtocol(test.in)
+		if len(test.err) == 0 {
+			assert.Nilf(t, err, "test '%s' error mismatch", name)
+		} else if assert.NotNilf(t, err, "test '%s' error mismatch", name) {
+			assert.Equalf(t, test.err, err.Error(), "test '%s' error mismatch", name)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package argotunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-ingress-controller/internal/cloudflare"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// edge discovery, see github.com/cloudflare/cloudflared/blob/master/origin/discovery.go
+	edgeSRVService = "warp"
+	edgeSRVProto   = "tcp"
+	edgeSRVName    = "cloudflarewarp.com"
+
+	edgeProxyDialTimeout = 15 * time.Second
+)
+
+// edgeProxy relays the edge connections of every tunnel through an HTTP
+// CONNECT proxy. cloudflared dials the edge itself, tunnels dial a
+// loopback relay of each edge address instead. Tunnels only reach the
+// edge over TCP, a proxy needs no UDP fallback.
+var edgeProxy = &edgeProxyRelay{
+	relays: map[string]string{},
+}
+
+type edgeProxyRelay struct {
+	mu      sync.Mutex
+	proxy   *url.URL
+	targets []string
+	relays  map[string]string
+}
+
+// SetEdgeProxy routes the edge connections through an HTTP CONNECT proxy,
+// an empty proxy url falls back to HTTPS_PROXY and NO_PROXY. The proxy
+// in use is returned, nil when the edge is dialed directly.
+func SetEdgeProxy(proxy string) (*url.URL, error) {
+	u, err := resolveEdgeProxy(proxy, http.ProxyFromEnvironment)
+	if err != nil {
+		return nil, err
+	}
+	edgeProxy.mu.Lock()
+	defer edgeProxy.mu.Unlock()
+	edgeProxy.proxy = u
+	return u, nil
+}
+
+// resolveEdgeProxy parses the explicit proxy url, otherwise asks the
+// environment for the proxy of the edge
+func resolveEdgeProxy(proxy string, env func(*http.Request) (*url.URL, error)) (*url.URL, error) {
+	if len(proxy) == 0 {
+		return env(&http.Request{URL: &url.URL{Scheme: "https", Host: serverName}})
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("edge proxy url %q is invalid: %v", proxy, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("edge proxy url %q is invalid: scheme must be http or https", proxy)
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("edge proxy url %q is invalid: missing host", proxy)
+	}
+	return u, nil
+}
+
+// addrs returns the edge addresses dialed by tunnels, the loopback relays
+// of the edge addresses when a proxy is in use
+func (e *edgeProxyRelay) addrs(edgeAddrs []string, log *logrus.Logger) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.proxy == nil {
+		return edgeAddrs
+	}
+	targets := edgeAddrs
+	if len(targets) == 0 {
+		var err error
+		if targets, err = e.discover(); err != nil {
+			log.Errorf("edge proxy discovery failed, set --edge-host-port: %v", err)
+			return edgeAddrs
+		}
+	}
+	relays := make([]string, 0, len(targets))
+	for _, target := range targets {
+		relay, ok := e.relays[target]
+		if !ok {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				log.Errorf("edge proxy relay for %s failed: %v", target, err)
+				continue
+			}
+			relay = l.Addr().String()
+			e.relays[target] = relay
+			go serveEdgeRelay(l, e.proxy, target, log)
+		}
+		relays = append(relays, relay)
+	}
+	return relays
+}
+
+// discover looks up the edge addresses once, the proxy resolves the
+// edge hostnames
+func (e *edgeProxyRelay) discover() ([]string, error) {
+	if len(e.targets) > 0 {
+		return e.targets, nil
+	}
+	_, srvs, err := net.LookupSRV(edgeSRVService, edgeSRVProto, edgeSRVName)
+	if err != nil {
+		return nil, err
+	}
+	for _, srv := range srvs {
+		e.targets = append(e.targets, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprint(srv.Port)))
+	}
+	if len(e.targets) == 0 {
+		return nil, fmt.Errorf("no edge addresses for %s", edgeSRVName)
+	}
+	return e.targets, nil
+}
+
+// serveEdgeRelay pipes each connection of the listener to the target
+// through the proxy
+func serveEdgeRelay(l net.Listener, proxy *url.URL, target string, log *logrus.Logger) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Errorf("edge proxy relay for %s stopped: %v", target, err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), edgeProxyDialTimeout)
+			edge, err := dialEdgeProxy(ctx, proxy, target)
+			cancel()
+			if err != nil {
+				log.Errorf("edge proxy connect to %s failed: %v", target, err)
+				return
+			}
+			defer edge.Close()
+			pipeConns(conn, edge)
+		}()
+	}
+}
+
+// pipeConns copies both ways until either side closes
+func pipeConns(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// dialEdgeProxy opens a CONNECT tunnel to the target through the proxy
+func dialEdgeProxy(ctx context.Context, proxy *url.URL, target string) (net.Conn, error) {
+	host := proxy.Host
+	if len(proxy.Port()) == 0 {
+		if proxy.Scheme == "https" {
+			host = net.JoinHostPort(proxy.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(proxy.Hostname(), "80")
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Scheme == "https" {
+		tconn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		if err = tconn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tconn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused CONNECT %s: %s", proxy.Redacted(), target, res.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads the bytes buffered past the CONNECT response first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CheckEdge dials an edge address, through the proxy when one is in
+// use, and completes the edge TLS handshake. The edge address checked
+// is returned.
+func CheckEdge(ctx context.Context, edgeAddrs []string) (string, error) {
+	edgeProxy.mu.Lock()
+	proxy, targets := edgeProxy.proxy, edgeAddrs
+	var err error
+	if len(targets) == 0 {
+		targets, err = edgeProxy.discover()
+	}
+	edgeProxy.mu.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("edge discovery failed, set --edge-host-port: %v", err)
+	}
+
+	target := targets[0]
+	var conn net.Conn
+	if proxy != nil {
+		conn, err = dialEdgeProxy(ctx, proxy, target)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", target)
+	}
+	if err != nil {
+		return target, err
+	}
+	defer conn.Close()
+
+	tconn := tls.Client(conn, &tls.Config{
+		RootCAs:    cloudflare.GetCloudflareRootCA(),
+		ServerName: serverName,
+	})
+	if err = tconn.HandshakeContext(ctx); err != nil {
+		return target, fmt.Errorf("edge handshake failed: %v", err)
+	}
+	return target, nil
+}
@@ -0,0 +1,27 @@
+package argotunnel
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// edgeRegionEU pins the tunnels to the edge of the European Union
+	edgeRegionEU = "eu"
+	// edgeRegionUS pins the tunnels to the edge of the United States
+	edgeRegionUS = "us"
+)
+
+// edgeRegions lists the regions known to cloudflared, in name order
+var edgeRegions = []string{edgeRegionEU, edgeRegionUS}
+
+// validateEdgeRegion checks the region an ingress pins its tunnels to.
+// The vendored cloudflared origin only discovers the global edge, so a
+// known region is refused rather than served outside of it.
+func validateEdgeRegion(s string) error {
+	switch s {
+	case edgeRegionEU, edgeRegionUS:
+		return fmt.Errorf("edge region %q is not supported, tunnels only discover the global edge", s)
+	}
+	return fmt.Errorf("unknown edge region %q, expected one of: %s", s, strings.Join(edgeRegions, ", "))
+}
@@ -0,0 +1,22 @@
+package argotunnel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEdgeRegion(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]string{
+		"eu":      `edge region "eu" is not supported, tunnels only discover the global edge`,
+		"us":      `edge region "us" is not supported, tunnels only discover the global edge`,
+		"unknown": `unknown edge region "unknown", expected one of: eu, us`,
+		"":        `unknown edge region "", expected one of: eu, us`,
+	} {
+		err := validateEdgeRegion(name)
+		if assert.NotNilf(t, err, "test '%s' error mismatch", name) {
+			assert.Equalf(t, test, err.Error(), "test '%s' error mismatch", name)
+		}
+	}
+}
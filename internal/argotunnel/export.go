@@ -0,0 +1,275 @@
+package argotunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// exportCertDir is where exported configurations expect the origin
+// certificate secrets to be mounted, one directory per secret
+const exportCertDir = "/etc/cloudflared"
+
+// cloudflaredConfig is the cloudflared 'config.yaml' of a single tunnel
+type cloudflaredConfig struct {
+	Hostname           string   `json:"hostname"`
+	URL                string   `json:"url"`
+	OriginCert         string   `json:"origincert"`
+	LBPool             string   `json:"lb-pool,omitempty"`
+	Tag                []string `json:"tag,omitempty"`
+	HAConnections      int      `json:"ha-connections,omitempty"`
+	HeartbeatInterval  string   `json:"heartbeat-interval,omitempty"`
+	HeartbeatCount     uint64   `json:"heartbeat-count,omitempty"`
+	Retries            uint     `json:"retries,omitempty"`
+	CompressionQuality uint64   `json:"compression-quality,omitempty"`
+	NoChunkedEncoding  bool     `json:"no-chunked-encoding,omitempty"`
+	Edge               []string `json:"edge,omitempty"`
+	GracePeriod        string   `json:"grace-period,omitempty"`
+}
+
+func newCloudflaredConfig(link tunnelLink) cloudflaredConfig {
+	rule, opts := link.routeRule(), link.options()
+	c := cloudflaredConfig{
+		Hostname:           rule.host,
+		URL:                link.originURL(),
+		OriginCert:         path.Join(exportCertDir, rule.secret.namespace, rule.secret.name, "cert.pem"),
+		LBPool:             opts.lbPool,
+		HAConnections:      opts.haConnections,
+		HeartbeatCount:     opts.heartbeatCount,
+		Retries:            opts.retries,
+		CompressionQuality: opts.compressionQuality,
+		NoChunkedEncoding:  opts.noChunkedEncoding,
+		Edge:               parseEdgeAddrs(opts.edgeAddrs),
+	}
+	for _, tag := range parseTags(opts.tags, tagConfig.limit) {
+		c.Tag = append(c.Tag, tag.Name+"="+tag.Value)
+	}
+	if opts.heartbeatInterval > 0 {
+		c.HeartbeatInterval = opts.heartbeatInterval.String()
+	}
+	if opts.gracePeriod > 0 {
+		c.GracePeriod = opts.gracePeriod.String()
+	}
+	return c
+}
+
+// controllerSettings lists the settings applied by the controller in the
+// request path, stock cloudflared has no equivalent
+func controllerSettings(opts tunnelOptions) (settings []string) {
+	add := func(key, val string) {
+		settings = append(settings, key+": "+val)
+	}
+	if len(opts.fallbackOrigin) > 0 {
+		add("fallback-origin", opts.fallbackOrigin)
+	}
+	if len(opts.unreadyEndpoints) > 0 {
+		add("include-unready-endpoints", strconv.FormatBool(opts.unreadyEndpoints == unreadyEndpointsInclude))
+	}
+	if opts.initialDelay > 0 {
+		add("initial-delay", opts.initialDelay.String())
+	}
+	if opts.injectOverride {
+		add("inject-override", "true")
+	}
+	if len(opts.injectRobots) > 0 {
+		add("inject-robots", opts.injectRobots)
+	}
+	if opts.maintenance {
+		add("maintenance", strconv.Itoa(opts.maintenanceStatus))
+	}
+	if opts.maxBodyBytes > 0 {
+		add("max-body-bytes", strconv.FormatUint(opts.maxBodyBytes, 10))
+	}
+	if opts.maxConcurrentRequests > 0 {
+		add("max-concurrent-requests", strconv.FormatUint(opts.maxConcurrentRequests, 10))
+	}
+	if opts.noForwardedHeaders {
+		add("no-forwarded-headers", "true")
+	}
+	if len(opts.pathPattern) > 0 {
+		add("path-pattern", opts.pathPattern)
+	}
+	if len(opts.pathPrefix) > 0 {
+		add("path-prefix", opts.pathPrefix)
+	}
+	if opts.proto == ProtoH2C {
+		add("proto", opts.proto)
+	}
+	if len(opts.responseHeaders) > 0 {
+		add("response-headers", strings.Join(headerNames(opts.responseHeaders), ","))
+	}
+	if len(opts.readinessPath) > 0 {
+		add("readiness-path", opts.readinessPath)
+	}
+	if opts.redirectCode != 0 {
+		add("redirect", strconv.Itoa(opts.redirectCode)+" "+opts.redirectURL)
+	}
+	if len(opts.rewriteTarget) > 0 {
+		add("rewrite-target", opts.rewriteTarget)
+	}
+	if len(opts.securityTxt) > 0 {
+		add("security-txt", "true")
+	}
+	if opts.streamBufferBytes > 0 {
+		add("stream-buffer-bytes", strconv.FormatUint(opts.streamBufferBytes, 10))
+	}
+	if opts.trailingSlashRedirect {
+		add("trailing-slash-redirect", "true")
+	}
+	if opts.upstreamMode == UpstreamModeEndpoint {
+		add("upstream-mode", opts.upstreamMode)
+	}
+	return
+}
+
+// exportLinks renders a cloudflared configuration per link. Secrets are
+// referenced by the 'origincert' path, their content is never exported.
+func exportLinks(ingress string, links []tunnelLink) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, link := range links {
+		b, err := yaml.Marshal(newCloudflaredConfig(link))
+		if err != nil {
+			return nil, err
+		}
+		rule := link.routeRule()
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		fmt.Fprintf(&buf, "# ingress: %s\n", ingress)
+		fmt.Fprintf(&buf, "# origincert: 'cert.pem' of secret %s\n", itemKeyFunc(rule.secret.namespace, rule.secret.name))
+		if settings := controllerSettings(link.options()); len(settings) > 0 {
+			buf.WriteString("# applied by the controller, not reproduced by cloudflared:\n")
+			for _, s := range settings {
+				fmt.Fprintf(&buf, "#   %s\n", s)
+			}
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// ExportHandler renders the cloudflared configuration of the tunnels
+// routed for the 'namespace' and 'ingress' query parameters.
+func (c *Controller) ExportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace, name := r.URL.Query().Get("namespace"), r.URL.Query().Get("ingress")
+		links := c.router.routeLinks(namespace, name)
+		if len(links) == 0 {
+			http.Error(w, fmt.Sprintf("no tunnels routed for ingress %s", itemKeyFunc(namespace, name)), http.StatusNotFound)
+			return
+		}
+		b, err := exportLinks(itemKeyFunc(namespace, name), links)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(b)
+	})
+}
+
+// ExportIngress renders the cloudflared configuration of the tunnels the
+// controller derives from an ingress. The ingress, and the services,
+// endpoints, secrets, and config maps it may reference, are read once
+// and translated as the controller would, without starting tunnels.
+func ExportIngress(client kubernetes.Interface, log *logrus.Logger, namespace, name string, options ...Option) ([]byte, error) {
+	o := collectOptions(options)
+	ctx := context.TODO()
+
+	ing, err := client.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	list := metav1.ListOptions{}
+	configMaps, err := client.CoreV1().ConfigMaps(namespace).List(ctx, list)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := client.CoreV1().Endpoints(namespace).List(ctx, list)
+	if err != nil {
+		return nil, err
+	}
+	services, err := client.CoreV1().Services(namespace).List(ctx, list)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := client.CoreV1().Secrets(namespace).List(ctx, list)
+	if err != nil {
+		return nil, err
+	}
+
+	secretObjs := make([]runtime.Object, 0, len(secrets.Items))
+	for i := range secrets.Items {
+		secretObjs = append(secretObjs, &secrets.Items[i])
+	}
+	// default secrets may live outside the ingress namespace
+	defaults := []*resource{o.secret}
+	for _, r := range o.originSecrets {
+		defaults = append(defaults, r)
+	}
+	for _, r := range o.domainSecrets {
+		defaults = append(defaults, r)
+	}
+	for _, r := range defaults {
+		if r == nil || r.namespace == namespace {
+			continue
+		}
+		secret, err := client.CoreV1().Secrets(r.namespace).Get(ctx, r.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		secretObjs = append(secretObjs, secret)
+	}
+
+	i := informerset{
+		configMap: newStaticInformer(new(v1.ConfigMap), listObjects(configMaps)...),
+		endpoint:  newStaticInformer(new(v1.Endpoints), listObjects(endpoints)...),
+		ingress:   newStaticInformer(new(networkingv1.Ingress), ing),
+		secret:    newStaticInformer(new(v1.Secret), secretObjs...),
+		service:   newStaticInformer(new(v1.Service), listObjects(services)...),
+	}
+	t := &syncTranslator{
+		informers: i,
+		log:       log,
+		options:   o,
+	}
+	route := t.getRouteFromIngress(ctx, ing)
+	links := route.sortedLinks()
+	if len(links) == 0 {
+		return nil, fmt.Errorf("no tunnels derived from ingress %s", itemKeyFunc(namespace, name))
+	}
+	return exportLinks(itemKeyFunc(namespace, name), links)
+}
+
+// newStaticInformer holds the objects in an informer cache, the
+// informer is never run
+func newStaticInformer(objType runtime.Object, objs ...runtime.Object) cache.SharedIndexInformer {
+	sw := cache.NewSharedIndexInformer(&cache.ListWatch{}, objType, time.Duration(0), cache.Indexers{})
+	for _, obj := range objs {
+		sw.GetIndexer().Add(obj)
+	}
+	return sw
+}
+
+func listObjects(list runtime.Object) []runtime.Object {
+	objs, _ := meta.ExtractList(list)
+	return objs
+}
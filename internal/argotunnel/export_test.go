@@ -0,0 +1,228 @@
+package argotunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExportLinks(t *testing.T) {
+	t.Parallel()
+	logger := logrus.New()
+	rule := tunnelRule{
+		host:    "a.unit.com",
+		port:    8080,
+		service: resource{namespace: "unit", name: "svc-a"},
+		secret:  resource{namespace: "unit", name: "sec-a"},
+	}
+	for name, test := range map[string]struct {
+		links []tunnelLink
+		out   string
+	}{
+		"export-defaults": {
+			links: []tunnelLink{
+				newTunnelLink(rule, nil, tunnelOptions{}, nil, nil, nil, nil, logger),
+			},
+			out: "# ingress: unit/ing\n" +
+				"# origincert: 'cert.pem' of secret unit/sec-a\n" +
+				"hostname: a.unit.com\n" +
+				"origincert: /etc/cloudflared/unit/sec-a/cert.pem\n" +
+				"url: svc-a.unit:8080\n",
+		},
+		"export-options": {
+			links: []tunnelLink{
+				newTunnelLink(rule, nil, tunnelOptions{
+					compressionQuality: 2,
+					edgeAddrs:          "edge.unit.com:7844",
+					gracePeriod:        10 * time.Second,
+					haConnections:      2,
+					heartbeatCount:     4,
+					heartbeatInterval:  5 * time.Second,
+					lbPool:             "unit-pool",
+					maxBodyBytes:       1024,
+					proto:              "https",
+					retries:            3,
+					rewriteTarget:      "/",
+					tags:               "key1=val1",
+				}, nil, nil, nil, nil, logger),
+			},
+			out: "# ingress: unit/ing\n" +
+				"# origincert: 'cert.pem' of secret unit/sec-a\n" +
+				"# applied by the controller, not reproduced by cloudflared:\n" +
+				"#   max-body-bytes: 1024\n" +
+				"#   rewrite-target: /\n" +
+				"compression-quality: 2\n" +
+				"edge:\n" +
+				"- edge.unit.com:7844\n" +
+				"grace-period: 10s\n" +
+				"ha-connections: 2\n" +
+				"heartbeat-count: 4\n" +
+				"heartbeat-interval: 5s\n" +
+				"hostname: a.unit.com\n" +
+				"lb-pool: unit-pool\n" +
+				"origincert: /etc/cloudflared/unit/sec-a/cert.pem\n" +
+				"retries: 3\n" +
+				"tag:\n" +
+				"- key1=val1\n" +
+				"url: https://svc-a.unit:8080\n",
+		},
+		"export-many": {
+			links: []tunnelLink{
+				newTunnelLink(rule, nil, tunnelOptions{}, nil, nil, nil, nil, logger),
+				newTunnelLink(tunnelRule{
+					host:    "b.unit.com",
+					port:    80,
+					service: resource{namespace: "unit", name: "svc-b"},
+					secret:  resource{namespace: "unit", name: "sec-a"},
+				}, nil, tunnelOptions{}, nil, nil, nil, nil, logger),
+			},
+			out: "# ingress: unit/ing\n" +
+				"# origincert: 'cert.pem' of secret unit/sec-a\n" +
+				"hostname: a.unit.com\n" +
+				"origincert: /etc/cloudflared/unit/sec-a/cert.pem\n" +
+				"url: svc-a.unit:8080\n" +
+				"---\n" +
+				"# ingress: unit/ing\n" +
+				"# origincert: 'cert.pem' of secret unit/sec-a\n" +
+				"hostname: b.unit.com\n" +
+				"origincert: /etc/cloudflared/unit/sec-a/cert.pem\n" +
+				"url: svc-b.unit:80\n",
+		},
+	} {
+		out, err := exportLinks("unit/ing", test.links)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.out, string(out), "test '%s' config mismatch", name)
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	t.Parallel()
+	link := newTunnelLink(tunnelRule{
+		host:    "a.unit.com",
+		port:    8080,
+		service: resource{namespace: "unit", name: "svc-a"},
+		secret:  resource{namespace: "unit", name: "sec-a"},
+	}, nil, tunnelOptions{}, nil, nil, nil, nil, logrus.New())
+	for name, test := range map[string]struct {
+		links []tunnelLink
+		code  int
+	}{
+		"export-routed": {
+			links: []tunnelLink{link},
+			code:  http.StatusOK,
+		},
+		"export-not-routed": {
+			links: []tunnelLink{},
+			code:  http.StatusNotFound,
+		},
+	} {
+		router := &mockTunnelRouter{}
+		router.On("routeLinks", "unit", "ing").Return(test.links)
+		c := &Controller{
+			router:  router,
+			options: collectOptions(nil),
+		}
+		w := httptest.NewRecorder()
+		c.ExportHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/export?namespace=unit&ingress=ing", nil))
+		assert.Equalf(t, test.code, w.Code, "test '%s' status mismatch", name)
+	}
+}
+
+func TestExportIngress(t *testing.T) {
+	t.Parallel()
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ing",
+			Namespace: "unit",
+			Annotations: map[string]string{
+				annotationIngressClass:         IngressClassDefault,
+				annotationIngressHAConnections: "2",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{
+				{Hosts: []string{"a.unit.com"}, SecretName: "sec-a"},
+			},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "a.unit.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path: "/",
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "svc-a",
+											Port: networkingv1.ServiceBackendPort{Number: 8080},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-a", Namespace: "unit"},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Name: "http", Port: 8080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	eps := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-a", Namespace: "unit"},
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{{IP: "1.1.1.1"}},
+				Ports:     []v1.EndpointPort{{Name: "http", Port: 9090, Protocol: v1.ProtocolTCP}},
+			},
+		},
+	}
+	sec := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "sec-a", Namespace: "unit"},
+		Data: map[string][]byte{
+			"cert.pem": genCertforHost("a.unit.com"),
+		},
+	}
+	for name, test := range map[string]struct {
+		objs []runtime.Object
+		out  string
+		err  bool
+	}{
+		"export-ingress": {
+			objs: []runtime.Object{ing, svc, eps, sec},
+			out: "# ingress: unit/ing\n" +
+				"# origincert: 'cert.pem' of secret unit/sec-a\n" +
+				"ha-connections: 2\n" +
+				"heartbeat-count: 5\n" +
+				"heartbeat-interval: 5s\n" +
+				"hostname: a.unit.com\n" +
+				"origincert: /etc/cloudflared/unit/sec-a/cert.pem\n" +
+				"retries: 3\n" +
+				"url: svc-a.unit:8080\n",
+		},
+		"export-secret-missing": {
+			objs: []runtime.Object{ing, svc, eps},
+			err:  true,
+		},
+		"export-ingress-missing": {
+			objs: []runtime.Object{svc, eps, sec},
+			err:  true,
+		},
+	} {
+		out, err := ExportIngress(fake.NewSimpleClientset(test.objs...), logrus.New(), "unit", "ing")
+		assert.Equalf(t, test.err, err != nil, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.out, string(out), "test '%s' config mismatch", name)
+	}
+}
@@ -0,0 +1,44 @@
+package argotunnel
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// newFieldLogger derives a logger adding the fields to every entry. The
+// logger is copied rather than hooked, so controllers sharing a logger
+// keep their own fields; later level changes are not mirrored.
+func newFieldLogger(log *logrus.Logger, fields logrus.Fields) *logrus.Logger {
+	if len(fields) == 0 {
+		return log
+	}
+	hooks := make(logrus.LevelHooks, len(log.Hooks))
+	for level, hs := range log.Hooks {
+		hooks[level] = append([]logrus.Hook{}, hs...)
+	}
+	out := &logrus.Logger{
+		Out:          log.Out,
+		Hooks:        hooks,
+		Formatter:    log.Formatter,
+		ReportCaller: log.ReportCaller,
+		Level:        log.GetLevel(),
+		ExitFunc:     log.ExitFunc,
+	}
+	out.AddHook(fieldsHook(fields))
+	return out
+}
+
+// fieldsHook sets the fields missing from an entry
+type fieldsHook logrus.Fields
+
+func (h fieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h fieldsHook) Fire(entry *logrus.Entry) error {
+	for k, v := range h {
+		if _, ok := entry.Data[k]; !ok {
+			entry.Data[k] = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package argotunnel
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFieldLogger(t *testing.T) {
+	t.Parallel()
+	base, hook := logtest.NewNullLogger()
+	assert.Equalf(t, base, newFieldLogger(base, nil), "test no fields logger mismatch")
+
+	a := newFieldLogger(base, logrus.Fields{"deployment": "a"})
+	b := newFieldLogger(base, logrus.Fields{"deployment": "b"})
+	for name, test := range map[string]struct {
+		log    func()
+		fields logrus.Fields
+	}{
+		"fields-a": {
+			log:    func() { a.Info("unit") },
+			fields: logrus.Fields{"deployment": "a"},
+		},
+		"fields-b": {
+			log:    func() { b.WithField("host", "b.unit.com").Info("unit") },
+			fields: logrus.Fields{"deployment": "b", "host": "b.unit.com"},
+		},
+		"fields-entry-wins": {
+			log:    func() { a.WithField("deployment", "entry").Info("unit") },
+			fields: logrus.Fields{"deployment": "entry"},
+		},
+		"fields-base-unchanged": {
+			log:    func() { base.Info("unit") },
+			fields: logrus.Fields{},
+		},
+	} {
+		test.log()
+		assert.Equalf(t, test.fields, hook.LastEntry().Data, "test '%s' fields mismatch", name)
+	}
+}
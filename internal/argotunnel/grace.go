@@ -0,0 +1,121 @@
+package argotunnel
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// missingBackends tracks the backend services found deleted, the tunnels
+// of a backend stay registered through the grace and answer with 503
+var missingBackends = newBackendTracker()
+
+// missingBackend records when a backend went missing, and whether its
+// tunnels were removed once the grace elapsed
+type missingBackend struct {
+	since   time.Time
+	removed bool
+}
+
+// backendTracker keeps the missing backends by service key, a nil
+// tracker never reports a backend missing
+type backendTracker struct {
+	mu    sync.RWMutex
+	now   func() time.Time
+	items map[string]missingBackend
+}
+
+func newBackendTracker() *backendTracker {
+	return &backendTracker{
+		now:   time.Now,
+		items: map[string]missingBackend{},
+	}
+}
+
+// mark records the backend missing, the first mark starts the grace
+func (b *backendTracker) mark(namespace, name string) (m missingBackend, first bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := itemKeyFunc(namespace, name)
+	m, ok := b.items[key]
+	if !ok {
+		m = missingBackend{since: b.now()}
+		b.items[key] = m
+	}
+	return m, !ok
+}
+
+// remaining returns the grace left to a missing backend
+func (b *backendTracker) remaining(m missingBackend, grace time.Duration) time.Duration {
+	return m.since.Add(grace).Sub(b.now())
+}
+
+// removed records the tunnels of the backend removed past the grace
+func (b *backendTracker) removed(namespace, name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := itemKeyFunc(namespace, name)
+	if m, ok := b.items[key]; ok {
+		m.removed = true
+		b.items[key] = m
+	}
+}
+
+// clear forgets a backend found again
+func (b *backendTracker) clear(namespace, name string) {
+	if !b.missing(namespace, name) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.items, itemKeyFunc(namespace, name))
+}
+
+// inGrace reports a missing backend whose tunnels are kept
+func (b *backendTracker) inGrace(namespace, name string, grace time.Duration) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	m, ok := b.items[itemKeyFunc(namespace, name)]
+	b.mu.RUnlock()
+	return ok && !m.removed && b.remaining(m, grace) > 0
+}
+
+// missing reports a backend tracked as missing
+func (b *backendTracker) missing(namespace, name string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.items[itemKeyFunc(namespace, name)]
+	return ok
+}
+
+// missingBackendRoundTripper answers for a missing backend without
+// dialing it, with the fallback origin when set or a 503
+type missingBackendRoundTripper struct {
+	next     http.RoundTripper
+	rule     tunnelRule
+	backends *backendTracker
+	fallback *fallbackRoundTripper
+}
+
+func (rt *missingBackendRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !rt.backends.missing(rt.rule.service.namespace, rt.rule.service.name) {
+		return rt.next.RoundTrip(req)
+	}
+	if rt.fallback != nil {
+		return rt.fallback.RoundTrip(req)
+	}
+	closeRequestBody(req)
+	return newSyntheticPageResponse(req, syntheticPage{
+		status:     http.StatusServiceUnavailable,
+		reason:     syntheticReasonMissing,
+		message:    "The backend service is missing.",
+		retryAfter: syntheticRetryAfter,
+	}), nil
+}
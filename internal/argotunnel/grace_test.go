@@ -0,0 +1,200 @@
+package argotunnel
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDeferBackendRemoval(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC)
+	backends := newBackendTracker()
+	backends.now = func() time.Time { return now }
+
+	ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "ing-a"}}
+	router := &mockTunnelRouter{}
+	router.On("deleteByKindKeys", serviceKind, "unit", "svc-a", []string{"unit/ing-a"}).Return(nil)
+	recorder := record.NewFakeRecorder(10)
+	logger, _ := logtest.NewNullLogger()
+	tr := &syncTranslator{
+		informers: informerset{
+			ingress: func() cache.SharedIndexInformer {
+				i := &mockSharedIndexInformer{}
+				i.On("GetIndexer").Return(func() cache.Indexer {
+					idx := &mockIndexer{}
+					idx.On("IndexKeys", serviceKind, "unit/svc-a").Return([]string{"unit/ing-a"}, nil)
+					idx.On("ByIndex", serviceKind, "unit/svc-a").Return([]interface{}{ing}, nil)
+					return idx
+				}())
+				return i
+			}(),
+		},
+		router:   router,
+		recorder: recorder,
+		log:      logger,
+		options:  options{missingBackendGrace: 2 * time.Minute},
+		backends: backends,
+	}
+
+	// steps run in order, the grace runs from the first
+	for _, test := range []struct {
+		name    string
+		at      time.Duration
+		out     error
+		deletes int
+		event   string
+	}{
+		{
+			name:  "grace-start",
+			at:    0,
+			out:   &requeueError{after: 2 * time.Minute},
+			event: "Warning BackendMissing backend service \"unit/svc-a\" missing, tunnels kept for 2m0s before removal",
+		},
+		{
+			name: "grace-running",
+			at:   time.Minute,
+			out:  &requeueError{after: time.Minute},
+		},
+		{
+			name:    "grace-elapsed",
+			at:      2 * time.Minute,
+			deletes: 1,
+			event:   "Normal RouteRemoved backend service \"unit/svc-a\" missing past the 2m0s grace, tunnels removed",
+		},
+		{
+			name:    "removed",
+			at:      3 * time.Minute,
+			deletes: 2,
+		},
+	} {
+		name := test.name
+		now = time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC).Add(test.at)
+		assert.Equalf(t, test.out, tr.deleteByKind(serviceKind, "unit/svc-a"), "test '%s' error mismatch", name)
+		router.AssertNumberOfCalls(t, "deleteByKindKeys", test.deletes)
+		select {
+		case event := <-recorder.Events:
+			assert.Equalf(t, test.event, event, "test '%s' event mismatch", name)
+		default:
+			assert.Equalf(t, test.event, "", "test '%s' event mismatch", name)
+		}
+	}
+}
+
+func TestGetGraceRule(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC)
+	backends := newBackendTracker()
+	backends.now = func() time.Time { return now }
+	backends.mark("unit", "svc-a")
+
+	rule := tunnelRule{
+		host:         "a.unit.com",
+		port:         443,
+		service:      resource{namespace: "unit", name: "svc-a"},
+		externalName: "api.saas.com",
+	}
+	link := &mockTunnelLink{}
+	link.On("routeRule").Return(rule)
+	router := &mockTunnelRouter{}
+	router.On("routeLinks", "unit", "ing-a").Return([]tunnelLink{link})
+	tr := &syncTranslator{
+		router:   router,
+		options:  options{missingBackendGrace: 2 * time.Minute},
+		backends: backends,
+	}
+	ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "ing-a"}}
+
+	for name, test := range map[string]struct {
+		at   time.Duration
+		host string
+		svc  string
+		out  tunnelRule
+		ok   bool
+	}{
+		"in-grace": {
+			at:   time.Minute,
+			host: "a.unit.com",
+			svc:  "svc-a",
+			out:  rule,
+			ok:   true,
+		},
+		"other-host": {
+			at:   time.Minute,
+			host: "b.unit.com",
+			svc:  "svc-a",
+		},
+		"not-missing": {
+			at:   time.Minute,
+			host: "a.unit.com",
+			svc:  "svc-b",
+		},
+		"grace-elapsed": {
+			at:   2 * time.Minute,
+			host: "a.unit.com",
+			svc:  "svc-a",
+		},
+	} {
+		now = time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC).Add(test.at)
+		out, ok := tr.getGraceRule(ing, test.host, test.svc)
+		assert.Equalf(t, test.out, out, "test '%s' rule mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' ok mismatch", name)
+	}
+}
+
+func TestMissingBackendRoundTripper(t *testing.T) {
+	t.Parallel()
+	backends := newBackendTracker()
+	backends.mark("unit", "svc-missing")
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return newSyntheticResponse(req, http.StatusOK, "text/plain", req.URL.Host), nil
+	})
+	for name, test := range map[string]struct {
+		svc      string
+		fallback *fallbackRoundTripper
+		status   int
+		body     string
+	}{
+		"backend-present": {
+			svc:    "svc-a",
+			status: http.StatusOK,
+			body:   "svc-a.unit:80",
+		},
+		"backend-missing": {
+			svc:    "svc-missing",
+			status: http.StatusServiceUnavailable,
+			body:   `{"status":503,"error":"Service Unavailable","reason":"backend-missing","message":"The backend service is missing.","hostname":"svc-missing.unit:80","retryAfter":5}` + "\n",
+		},
+		"backend-missing-fallback": {
+			svc:      "svc-missing",
+			fallback: &fallbackRoundTripper{next: origin, origin: "fallback.unit:80", labels: metricsLabels(tunnelRule{}, tunnelOptions{})},
+			status:   http.StatusOK,
+			body:     "fallback.unit:80",
+		},
+	} {
+		rt := &missingBackendRoundTripper{
+			next:     origin,
+			rule:     tunnelRule{service: resource{namespace: "unit", name: test.svc}},
+			backends: backends,
+			fallback: test.fallback,
+		}
+		req, _ := http.NewRequest(http.MethodGet, "http://"+test.svc+".unit:80/", nil)
+		req.Header.Set("Accept", "application/json")
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		body, _ := io.ReadAll(res.Body)
+		assert.Equalf(t, test.status, res.StatusCode, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.body, string(body), "test '%s' body mismatch", name)
+	}
+
+	backends.clear("unit", "svc-missing")
+	assert.Falsef(t, backends.missing("unit", "svc-missing"), "test cleared backend mismatch")
+}
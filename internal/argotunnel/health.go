@@ -0,0 +1,168 @@
+package argotunnel
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	watchOpList  = "list"
+	watchOpWatch = "watch"
+)
+
+// informerHealth tracks the list and watch activity of an informer
+type informerHealth struct {
+	kind string
+	last int64
+}
+
+func newInformerHealth(kind string, now time.Time) *informerHealth {
+	return &informerHealth{
+		kind: kind,
+		last: now.UnixNano(),
+	}
+}
+
+func (h *informerHealth) observe(now time.Time) {
+	atomic.StoreInt64(&h.last, now.UnixNano())
+	informerLastEvent.WithLabelValues(h.kind).Set(float64(now.Unix()))
+}
+
+func (h *informerHealth) failed(op string) {
+	informerWatchErrors.WithLabelValues(h.kind, op).Inc()
+}
+
+func (h *informerHealth) lastEvent() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&h.last))
+}
+
+// informerHealthSet tracks the informers of a controller
+type informerHealthSet map[string]*informerHealth
+
+func newInformerHealthSet(now time.Time, kinds ...string) informerHealthSet {
+	s := make(informerHealthSet, len(kinds))
+	for _, kind := range kinds {
+		s[kind] = newInformerHealth(kind, now)
+	}
+	return s
+}
+
+// stale lists the kinds without a list or watch event within the threshold
+func (s informerHealthSet) stale(now time.Time, threshold time.Duration) (kinds []string) {
+	for kind, h := range s {
+		if now.Sub(h.lastEvent()) > threshold {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return
+}
+
+// instrumentedListerWatcher records the activity of an informer: the
+// successful lists, the watch events, the errors, and the cached objects.
+type instrumentedListerWatcher struct {
+	lw     cache.ListerWatcher
+	health *informerHealth
+}
+
+func (lw *instrumentedListerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	obj, err := lw.lw.List(options)
+	if err != nil {
+		lw.health.failed(watchOpList)
+		return obj, err
+	}
+	lw.health.observe(time.Now())
+	if n := meta.LenList(obj); len(options.Continue) > 0 {
+		informerObjects.WithLabelValues(lw.health.kind).Add(float64(n))
+	} else {
+		informerObjects.WithLabelValues(lw.health.kind).Set(float64(n))
+	}
+	return obj, err
+}
+
+func (lw *instrumentedListerWatcher) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := lw.lw.Watch(options)
+	if err != nil {
+		lw.health.failed(watchOpWatch)
+		return w, err
+	}
+	lw.health.observe(time.Now())
+	return newInstrumentedWatch(w, lw.health), nil
+}
+
+// instrumentedWatch records the events passing through a watch
+type instrumentedWatch struct {
+	watch.Interface
+	result chan watch.Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newInstrumentedWatch(w watch.Interface, health *informerHealth) *instrumentedWatch {
+	iw := &instrumentedWatch{
+		Interface: w,
+		result:    make(chan watch.Event),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(iw.result)
+		for ev := range w.ResultChan() {
+			switch ev.Type {
+			case watch.Error:
+				health.failed(watchOpWatch)
+			case watch.Added:
+				informerObjects.WithLabelValues(health.kind).Inc()
+				health.observe(time.Now())
+			case watch.Deleted:
+				informerObjects.WithLabelValues(health.kind).Dec()
+				health.observe(time.Now())
+			default:
+				health.observe(time.Now())
+			}
+			select {
+			case iw.result <- ev:
+			case <-iw.done:
+				return
+			}
+		}
+	}()
+	return iw
+}
+
+func (w *instrumentedWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *instrumentedWatch) Stop() {
+	w.once.Do(func() {
+		close(w.done)
+	})
+	w.Interface.Stop()
+}
+
+// HealthHandler fails once an informer has seen no list or watch event
+// within the stale watch threshold, a zero threshold always succeeds.
+func (c *Controller) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if c.options.staleWatchThreshold > 0 {
+			if kinds := c.informers.stale(time.Now(), c.options.staleWatchThreshold); len(kinds) > 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, "stale watch: %s\n", strings.Join(kinds, ","))
+				return
+			}
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
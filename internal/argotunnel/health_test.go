@@ -0,0 +1,145 @@
+package argotunnel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestInformerHealthSetStale(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	for name, test := range map[string]struct {
+		events    map[string]time.Time
+		threshold time.Duration
+		out       []string
+	}{
+		"none-stale": {
+			events: map[string]time.Time{
+				"unit-a": now.Add(-time.Minute),
+				"unit-b": now,
+			},
+			threshold: 2 * time.Minute,
+			out:       nil,
+		},
+		"some-stale": {
+			events: map[string]time.Time{
+				"unit-a": now.Add(-3 * time.Minute),
+				"unit-b": now,
+				"unit-c": now.Add(-5 * time.Minute),
+			},
+			threshold: 2 * time.Minute,
+			out:       []string{"unit-a", "unit-c"},
+		},
+	} {
+		s := informerHealthSet{}
+		for kind, last := range test.events {
+			s[kind] = newInformerHealth(kind, last)
+		}
+		assert.Equalf(t, test.out, s.stale(now, test.threshold), "test '%s' stale mismatch", name)
+	}
+}
+
+func TestInstrumentedListerWatcher(t *testing.T) {
+	t.Parallel()
+	start := time.Now().Add(-time.Hour)
+	health := newInformerHealth("unit-lw", start)
+	fw := watch.NewFake()
+	fail := true
+	lw := &instrumentedListerWatcher{
+		lw: &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				if fail {
+					return nil, fmt.Errorf("unit list error")
+				}
+				return &v1.SecretList{Items: []v1.Secret{{}, {}}}, nil
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return fw, nil
+			},
+		},
+		health: health,
+	}
+
+	_, err := lw.List(metav1.ListOptions{})
+	assert.NotNilf(t, err, "test list error mismatch")
+	assert.Equalf(t, start.UnixNano(), health.lastEvent().UnixNano(), "test failed list event mismatch")
+	assert.Equalf(t, float64(1), testutil.ToFloat64(informerWatchErrors.WithLabelValues("unit-lw", watchOpList)), "test list errors mismatch")
+
+	fail = false
+	_, err = lw.List(metav1.ListOptions{})
+	assert.Nilf(t, err, "test list error mismatch")
+	assert.Truef(t, health.lastEvent().After(start), "test list event mismatch")
+	assert.Equalf(t, float64(2), testutil.ToFloat64(informerObjects.WithLabelValues("unit-lw")), "test list objects mismatch")
+
+	w, err := lw.Watch(metav1.ListOptions{})
+	assert.Nilf(t, err, "test watch error mismatch")
+	go func() {
+		fw.Add(&v1.Secret{})
+		fw.Delete(&v1.Secret{})
+		fw.Delete(&v1.Secret{})
+		fw.Error(&metav1.Status{})
+	}()
+	for _, want := range []watch.EventType{watch.Added, watch.Deleted, watch.Deleted, watch.Error} {
+		ev := <-w.ResultChan()
+		assert.Equalf(t, want, ev.Type, "test watch event mismatch")
+	}
+	w.Stop()
+	_, open := <-w.ResultChan()
+	assert.Falsef(t, open, "test watch stop mismatch")
+	assert.Equalf(t, float64(1), testutil.ToFloat64(informerObjects.WithLabelValues("unit-lw")), "test watch objects mismatch")
+	assert.Equalf(t, float64(1), testutil.ToFloat64(informerWatchErrors.WithLabelValues("unit-lw", watchOpWatch)), "test watch errors mismatch")
+}
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	for name, test := range map[string]struct {
+		last      time.Time
+		threshold time.Duration
+		code      int
+		body      string
+	}{
+		"healthy": {
+			last:      now,
+			threshold: time.Minute,
+			code:      http.StatusOK,
+			body:      "ok\n",
+		},
+		"stale": {
+			last:      now.Add(-time.Hour),
+			threshold: time.Minute,
+			code:      http.StatusServiceUnavailable,
+			body:      "stale watch: endpoint\n",
+		},
+		"disabled": {
+			last:      now.Add(-time.Hour),
+			threshold: 0,
+			code:      http.StatusOK,
+			body:      "ok\n",
+		},
+	} {
+		c := &Controller{
+			informers: informerHealthSet{
+				endpointKind: newInformerHealth(endpointKind, test.last),
+			},
+			options: options{
+				staleWatchThreshold: test.threshold,
+			},
+		}
+		rec := httptest.NewRecorder()
+		c.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equalf(t, test.code, rec.Code, "test '%s' code mismatch", name)
+		assert.Equalf(t, test.body, rec.Body.String(), "test '%s' body mismatch", name)
+	}
+}
@@ -0,0 +1,169 @@
+package argotunnel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	historyActionCreate  = "create"
+	historyActionReplace = "replace"
+	historyActionDelete  = "delete"
+	historyActionRetry   = "retry"
+
+	historyTriggerResync = "resync"
+	historyTriggerRepair = "repair"
+
+	// historyHostsMax bounds the hosts holding a history, the host with
+	// the oldest decision is dropped first
+	historyHostsMax = 1024
+)
+
+// reconcileDecision records what a reconcile did to a tunnel of a host.
+// Configurations are only referenced by hash, and changes by name, the
+// recorded values never include a certificate or option value.
+type reconcileDecision struct {
+	Time    time.Time `json:"time"`
+	Trigger string    `json:"trigger"`
+	Action  string    `json:"action"`
+	Service string    `json:"service"`
+	OldHash string    `json:"oldHash,omitempty"`
+	NewHash string    `json:"newHash,omitempty"`
+	Changes []string  `json:"changes,omitempty"`
+}
+
+// reconcileHistory keeps the last decisions of each host, a nil history
+// records nothing
+type reconcileHistory struct {
+	mu    sync.Mutex
+	size  int
+	now   func() time.Time
+	hosts map[string][]reconcileDecision
+}
+
+func newReconcileHistory(size int) *reconcileHistory {
+	if size <= 0 {
+		return nil
+	}
+	return &reconcileHistory{
+		size:  size,
+		now:   time.Now,
+		hosts: map[string][]reconcileDecision{},
+	}
+}
+
+// record appends a decision on the links of a host, dropping the oldest
+// decision past the size
+func (h *reconcileHistory) record(trigger, action string, rule tunnelRule, oldLink, newLink tunnelLink) {
+	if h == nil {
+		return
+	}
+	d := reconcileDecision{
+		Trigger: trigger,
+		Action:  action,
+		Service: fmt.Sprintf("%s:%d", itemKeyFunc(rule.service.namespace, rule.service.name), rule.port),
+	}
+	if oldLink != nil {
+		d.OldHash = linkHash(oldLink)
+	}
+	if newLink != nil {
+		d.NewHash = linkHash(newLink)
+	}
+	if oldLink != nil && newLink != nil {
+		d.Changes = linkChanges(oldLink, newLink)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d.Time = h.now()
+	host := strings.ToLower(rule.host)
+	decisions, ok := h.hosts[host]
+	if !ok && len(h.hosts) >= historyHostsMax {
+		h.dropOldest()
+	}
+	if len(decisions) >= h.size {
+		decisions = append(decisions[:0:0], decisions[len(decisions)-h.size+1:]...)
+	}
+	h.hosts[host] = append(decisions, d)
+}
+
+// dropOldest removes the host whose last decision is the oldest
+func (h *reconcileHistory) dropOldest() {
+	var oldest string
+	var at time.Time
+	for host, decisions := range h.hosts {
+		if last := decisions[len(decisions)-1].Time; len(oldest) == 0 || last.Before(at) {
+			oldest, at = host, last
+		}
+	}
+	delete(h.hosts, oldest)
+}
+
+// sweep drops the hosts not kept whose last decision is before the time,
+// and returns the hosts left
+func (h *reconcileHistory) sweep(keep func(host string) bool, before time.Time) int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for host, decisions := range h.hosts {
+		if !keep(host) && decisions[len(decisions)-1].Time.Before(before) {
+			delete(h.hosts, host)
+		}
+	}
+	return len(h.hosts)
+}
+
+// list returns the decisions of a host, oldest first
+func (h *reconcileHistory) list(host string) ([]reconcileDecision, bool) {
+	if h == nil {
+		return nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	decisions, ok := h.hosts[strings.ToLower(host)]
+	return append([]reconcileDecision(nil), decisions...), ok
+}
+
+// linkHash identifies the configuration of a link
+func linkHash(l tunnelLink) string {
+	s := sha256.New()
+	fmt.Fprintf(s, "%+v|%s|%+v|", l.routeRule(), l.originURL(), l.options())
+	s.Write(l.originCert())
+	return hex.EncodeToString(s.Sum(nil))[:12]
+}
+
+// linkChanges names the parts of the configuration changed between links
+func linkChanges(oldLink, newLink tunnelLink) (changes []string) {
+	o, n := oldLink.routeRule(), newLink.routeRule()
+	if o.service != n.service {
+		changes = append(changes, "service")
+	}
+	if o.port != n.port {
+		changes = append(changes, "port")
+	}
+	if o.secret != n.secret {
+		changes = append(changes, "secret")
+	}
+	if oldLink.originURL() != newLink.originURL() {
+		changes = append(changes, "origin")
+	}
+	if oldLink.options() != newLink.options() {
+		changes = append(changes, "options")
+	}
+	if !bytes.Equal(oldLink.originCert(), newLink.originCert()) {
+		changes = append(changes, "certificate")
+	}
+	if len(changes) == 0 && oldLink.tripped() {
+		changes = append(changes, "breaker")
+	}
+	return
+}
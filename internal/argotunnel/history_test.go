@@ -0,0 +1,183 @@
+package argotunnel
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcileHistory(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC)
+	h := newReconcileHistory(2)
+	h.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	rule := tunnelRule{
+		host:    "A.unit.com",
+		port:    8080,
+		service: resource{namespace: "unit", name: "svc"},
+	}
+	for _, trigger := range []string{"ingress/unit/a", "endpoint/unit/svc", historyTriggerResync} {
+		h.record(trigger, historyActionCreate, rule, nil, nil)
+	}
+	decisions, ok := h.list("a.unit.com")
+	assert.Truef(t, ok, "test history exists mismatch")
+	assert.Equalf(t, []reconcileDecision{
+		{
+			Time:    time.Date(2024, 1, 1, 14, 32, 2, 0, time.UTC),
+			Trigger: "endpoint/unit/svc",
+			Action:  historyActionCreate,
+			Service: "unit/svc:8080",
+		},
+		{
+			Time:    time.Date(2024, 1, 1, 14, 32, 3, 0, time.UTC),
+			Trigger: historyTriggerResync,
+			Action:  historyActionCreate,
+			Service: "unit/svc:8080",
+		},
+	}, decisions, "test history decisions mismatch")
+
+	_, ok = h.list("b.unit.com")
+	assert.Falsef(t, ok, "test history missing host mismatch")
+
+	var disabled *reconcileHistory
+	disabled.record("ingress/unit/a", historyActionCreate, rule, nil, nil)
+	_, ok = disabled.list("a.unit.com")
+	assert.Falsef(t, ok, "test disabled history mismatch")
+	assert.Nilf(t, newReconcileHistory(0), "test zero size history mismatch")
+}
+
+func TestReconcileHistoryHostsMax(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	h := newReconcileHistory(1)
+	h.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	for i := 0; i <= historyHostsMax; i++ {
+		h.record("ingress/unit/a", historyActionCreate, tunnelRule{host: fmt.Sprintf("%d.unit.com", i)}, nil, nil)
+	}
+	assert.Equalf(t, historyHostsMax, len(h.hosts), "test history hosts mismatch")
+	_, ok := h.list("0.unit.com")
+	assert.Falsef(t, ok, "test oldest host dropped mismatch")
+	_, ok = h.list(fmt.Sprintf("%d.unit.com", historyHostsMax))
+	assert.Truef(t, ok, "test newest host kept mismatch")
+}
+
+func TestLinkChanges(t *testing.T) {
+	t.Parallel()
+	rule := tunnelRule{
+		host:    "a.unit.com",
+		port:    8080,
+		service: resource{namespace: "unit", name: "svc"},
+		secret:  resource{namespace: "unit", name: "sec"},
+	}
+	newLink := func(rule tunnelRule, origin string, opts tunnelOptions, cert string, tripped bool) tunnelLink {
+		l := &mockTunnelLink{}
+		l.On("routeRule").Return(rule)
+		l.On("originURL").Return(origin)
+		l.On("options").Return(opts)
+		l.On("originCert").Return([]byte(cert))
+		l.On("tripped").Return(tripped)
+		return l
+	}
+	base := newLink(rule, "svc.unit:8080", tunnelOptions{}, "cert-a", false)
+	for name, test := range map[string]struct {
+		old tunnelLink
+		new tunnelLink
+		out []string
+	}{
+		"unchanged": {
+			old: base,
+			new: newLink(rule, "svc.unit:8080", tunnelOptions{}, "cert-a", false),
+			out: nil,
+		},
+		"tripped": {
+			old: newLink(rule, "svc.unit:8080", tunnelOptions{}, "cert-a", true),
+			new: base,
+			out: []string{"breaker"},
+		},
+		"certificate-options": {
+			old: base,
+			new: newLink(rule, "svc.unit:8080", tunnelOptions{retries: 3}, "cert-b", false),
+			out: []string{"options", "certificate"},
+		},
+		"port-origin": {
+			old: base,
+			new: newLink(tunnelRule{host: rule.host, port: 8443, service: rule.service, secret: rule.secret}, "svc.unit:8443", tunnelOptions{}, "cert-a", false),
+			out: []string{"port", "origin"},
+		},
+	} {
+		assert.Equalf(t, test.out, linkChanges(test.old, test.new), "test '%s' changes mismatch", name)
+		assert.Equalf(t, len(test.out) == 0 || test.out[0] == "breaker", linkHash(test.old) == linkHash(test.new), "test '%s' hash mismatch", name)
+	}
+}
+
+func TestRouterHistory(t *testing.T) {
+	t.Parallel()
+	rule := tunnelRule{
+		host:    "a.unit.com",
+		port:    8080,
+		service: resource{namespace: "unit", name: "svc"},
+		secret:  resource{namespace: "unit", name: "sec"},
+	}
+	newLink := func(cert string) *mockTunnelLink {
+		l := &mockTunnelLink{}
+		l.On("routeRule").Return(rule)
+		l.On("originURL").Return("svc.unit:8080")
+		l.On("options").Return(tunnelOptions{})
+		l.On("originCert").Return([]byte(cert))
+		l.On("tripped").Return(false)
+		l.On("start").Return(nil)
+		l.On("stop").Return(nil)
+		return l
+	}
+	linkA, linkB := newLink("cert-a"), newLink("cert-b")
+	linkB.On("equal", linkA).Return(false)
+	linkA.On("equal", linkB).Return(false)
+
+	logger, _ := logtest.NewNullLogger()
+	router := &syncTunnelRouter{
+		items:   map[string]*tunnelRoute{},
+		log:     logger,
+		history: newReconcileHistory(HistorySizeDefault),
+	}
+	router.updateRoute(&tunnelRoute{namespace: "unit", name: "a", resourceVersion: "100", links: tunnelRouteLinkMap{rule: linkA}})
+	router.updateRoute(&tunnelRoute{namespace: "unit", name: "a", resourceVersion: "100", links: tunnelRouteLinkMap{rule: linkB}})
+	router.deleteByKindKeys(secretKind, "unit", "sec", []string{"unit/a"})
+
+	decisions, ok := router.hostHistory("a.unit.com")
+	assert.Truef(t, ok, "test history exists mismatch")
+	assert.Equalf(t, 3, len(decisions), "test history decisions mismatch")
+	for i, want := range []struct {
+		trigger, action string
+		old, new        tunnelLink
+		changes         []string
+	}{
+		{"ingress/unit/a", historyActionCreate, nil, linkA, nil},
+		{historyTriggerResync, historyActionReplace, linkA, linkB, []string{"certificate"}},
+		{"secret/unit/sec", historyActionDelete, linkB, nil, nil},
+	} {
+		if i >= len(decisions) {
+			break
+		}
+		d := decisions[i]
+		assert.Equalf(t, want.trigger, d.Trigger, "test decision %d trigger mismatch", i)
+		assert.Equalf(t, want.action, d.Action, "test decision %d action mismatch", i)
+		assert.Equalf(t, "unit/svc:8080", d.Service, "test decision %d service mismatch", i)
+		assert.Equalf(t, want.changes, d.Changes, "test decision %d changes mismatch", i)
+		if want.old != nil {
+			assert.Equalf(t, linkHash(want.old), d.OldHash, "test decision %d old hash mismatch", i)
+		}
+		if want.new != nil {
+			assert.Equalf(t, linkHash(want.new), d.NewHash, "test decision %d new hash mismatch", i)
+		}
+		assert.NotContainsf(t, fmt.Sprintf("%+v", d), "cert-", "test decision %d leaks the certificate", i)
+	}
+}
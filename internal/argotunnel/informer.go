@@ -7,21 +7,39 @@ import (
 	"time"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 )
 
+// acmeSolverIndex indexes the cert-manager http-01 solver ingresses by host
+const acmeSolverIndex = "acme-solver"
+
+// informerResources are the resources watched by the informers, by kind
+var informerResources = map[string]schema.GroupResource{
+	configMapKind: {Group: v1.GroupName, Resource: "configmaps"},
+	endpointKind:  {Group: v1.GroupName, Resource: "endpoints"},
+	ingressKind:   {Group: networkingv1.GroupName, Resource: "ingresses"},
+	secretKind:    {Group: v1.GroupName, Resource: "secrets"},
+	serviceKind:   {Group: v1.GroupName, Resource: "services"},
+}
+
 // TODO: consider registering indexers by kind in a map
 type informerset struct {
-	endpoint cache.SharedIndexInformer
-	ingress  cache.SharedIndexInformer
-	secret   cache.SharedIndexInformer
-	service  cache.SharedIndexInformer
+	configMap cache.SharedIndexInformer
+	endpoint  cache.SharedIndexInformer
+	ingress   cache.SharedIndexInformer
+	secret    cache.SharedIndexInformer
+	service   cache.SharedIndexInformer
 }
 
 func (i *informerset) run(stopCh <-chan struct{}) {
+	go i.configMap.Run(stopCh)
 	go i.endpoint.Run(stopCh)
 	go i.ingress.Run(stopCh)
 	go i.secret.Run(stopCh)
@@ -29,14 +47,15 @@ func (i *informerset) run(stopCh <-chan struct{}) {
 }
 
 func (i *informerset) getKindIndexer(kind string) (idx cache.Indexer, err error) {
-	indexerFuncs := map[string]func() cache.Indexer{
-		endpointKind: i.endpoint.GetIndexer,
-		ingressKind:  i.ingress.GetIndexer,
-		secretKind:   i.secret.GetIndexer,
-		serviceKind:  i.service.GetIndexer,
+	informers := map[string]cache.SharedIndexInformer{
+		configMapKind: i.configMap,
+		endpointKind:  i.endpoint,
+		ingressKind:   i.ingress,
+		secretKind:    i.secret,
+		serviceKind:   i.service,
 	}
-	if indexerFunc, ok := indexerFuncs[kind]; ok {
-		idx = indexerFunc()
+	if informer, ok := informers[kind]; ok && informer != nil {
+		idx = informer.GetIndexer()
 	} else {
 		err = fmt.Errorf("unexpected kind (%q)", kind)
 	}
@@ -45,6 +64,7 @@ func (i *informerset) getKindIndexer(kind string) (idx cache.Indexer, err error)
 
 func (i *informerset) waitForCacheSync(stopCh <-chan struct{}) bool {
 	return cache.WaitForCacheSync(stopCh,
+		i.configMap.HasSynced,
 		i.endpoint.HasSynced,
 		i.ingress.HasSynced,
 		i.secret.HasSynced,
@@ -52,29 +72,57 @@ func (i *informerset) waitForCacheSync(stopCh <-chan struct{}) bool {
 	)
 }
 
-func newEndpointInformer(client kubernetes.Interface, opts options, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
-	return newInformer(client.CoreV1().RESTClient(), opts.watchNamespace, "endpoints", new(v1.Endpoints), opts.resyncPeriod, rs...)
+func newConfigMapInformer(client kubernetes.Interface, opts options, health informerHealthSet, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
+	return newInformer(health[configMapKind], client.CoreV1().RESTClient(), opts.watchNamespace, informerResources[configMapKind].Resource, new(v1.ConfigMap), opts.resyncPeriod, rs...)
 }
 
-func newIngressInformer(client kubernetes.Interface, opts options, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
-	i := newInformer(client.NetworkingV1().RESTClient(), opts.watchNamespace, "ingresses", new(networkingv1.Ingress), opts.resyncPeriod, rs...)
+func newEndpointInformer(client kubernetes.Interface, opts options, health informerHealthSet, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
+	return newInformer(health[endpointKind], client.CoreV1().RESTClient(), opts.watchNamespace, informerResources[endpointKind].Resource, new(v1.Endpoints), opts.resyncPeriod, rs...)
+}
+
+func newIngressInformer(client kubernetes.Interface, opts options, health informerHealthSet, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
+	namespace, selector := ingressListScope(opts)
+	i := newTransformingInformer(health[ingressKind], client.NetworkingV1().RESTClient(), namespace, selector, informerResources[ingressKind].Resource, new(networkingv1.Ingress), opts.resyncPeriod, stripUnknownAnnotations, rs...)
 	i.AddIndexers(cache.Indexers{
-		secretKind:  ingressSecretIndexFunc(opts.ingressClass, opts.originSecrets, opts.domainSecrets, opts.secret),
-		serviceKind: ingressServiceIndexFunc(opts.ingressClass),
+		acmeSolverIndex: ingressACMESolverIndexFunc(opts.ingressClass, opts.adoptUnclassed),
+		configMapKind:   ingressConfigMapIndexFunc(opts.ingressClass, opts.adoptUnclassed),
+		secretKind:      ingressSecretIndexFunc(opts.ingressClass, opts.adoptUnclassed, opts.originSecrets, opts.domainSecrets, opts.secret, opts.defaultHostname),
+		serviceKind:     ingressServiceIndexFunc(opts.ingressClass, opts.adoptUnclassed),
 	})
 	return i
 }
 
-func newSecretInformer(client kubernetes.Interface, opts options, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
-	return newInformer(client.CoreV1().RESTClient(), opts.watchNamespace, "secrets", new(v1.Secret), opts.resyncPeriod, rs...)
+func newSecretInformer(client kubernetes.Interface, opts options, health informerHealthSet, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
+	return newInformer(health[secretKind], client.CoreV1().RESTClient(), opts.watchNamespace, informerResources[secretKind].Resource, new(v1.Secret), opts.resyncPeriod, rs...)
+}
+
+func newServiceInformer(client kubernetes.Interface, opts options, health informerHealthSet, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
+	return newInformer(health[serviceKind], client.CoreV1().RESTClient(), opts.watchNamespace, informerResources[serviceKind].Resource, new(v1.Service), opts.resyncPeriod, rs...)
 }
 
-func newServiceInformer(client kubernetes.Interface, opts options, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
-	return newInformer(client.CoreV1().RESTClient(), opts.watchNamespace, "services", new(v1.Service), opts.resyncPeriod, rs...)
+func newInformer(health *informerHealth, c cache.Getter, namespace string, resource string, objType runtime.Object, resyncPeriod time.Duration, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
+	return newTransformingInformer(health, c, namespace, fields.Everything(), resource, objType, resyncPeriod, nil, rs...)
 }
 
-func newInformer(c cache.Getter, namespace string, resource string, objType runtime.Object, resyncPeriod time.Duration, rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
-	lw := cache.NewListWatchFromClient(c, resource, namespace, fields.Everything())
+// ingressListScope restricts the ingress watch to the watched namespace,
+// or to the single ingress being debugged
+func ingressListScope(opts options) (string, fields.Selector) {
+	if opts.onlyIngress != nil {
+		return opts.onlyIngress.namespace, fields.OneTermEqualSelector("metadata.name", opts.onlyIngress.name)
+	}
+	return opts.watchNamespace, fields.Everything()
+}
+
+// newTransformingInformer applies the transform to the listed and watched
+// objects before they are cached
+func newTransformingInformer(health *informerHealth, c cache.Getter, namespace string, selector fields.Selector, resource string, objType runtime.Object, resyncPeriod time.Duration, transform func(metav1.Object), rs ...cache.ResourceEventHandler) cache.SharedIndexInformer {
+	var lw cache.ListerWatcher = cache.NewListWatchFromClient(c, resource, namespace, selector)
+	if transform != nil {
+		lw = &transformingListerWatcher{lw: lw, transform: transform}
+	}
+	if health != nil {
+		lw = &instrumentedListerWatcher{lw: lw, health: health}
+	}
 	sw := cache.NewSharedIndexInformer(lw, objType, resyncPeriod, cache.Indexers{
 		//cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
 	})
@@ -84,11 +132,43 @@ func newInformer(c cache.Getter, namespace string, resource string, objType runt
 	return sw
 }
 
-func ingressSecretIndexFunc(ingressClass string, originSecrets map[string]*resource, domainSecrets map[string]*resource, secret *resource) func(obj interface{}) ([]string, error) {
+func ingressConfigMapIndexFunc(ingressClass string, adoptUnclassed bool) func(obj interface{}) ([]string, error) {
+	return func(obj interface{}) ([]string, error) {
+		if ing, ok := obj.(*networkingv1.Ingress); ok {
+			var idx []string
+			if matchIngressClass(ing, ingressClass, adoptUnclassed) {
+				if name, ok := parseMaintenanceConfigMap(ing); ok {
+					idx = append(idx, itemKeyFunc(ing.Namespace, name))
+				}
+			}
+			return idx, nil
+		}
+		return []string{}, fmt.Errorf("index unexpected obj type: %T", obj)
+	}
+}
+
+func ingressACMESolverIndexFunc(ingressClass string, adoptUnclassed bool) func(obj interface{}) ([]string, error) {
+	return func(obj interface{}) ([]string, error) {
+		if ing, ok := obj.(*networkingv1.Ingress); ok {
+			var idx []string
+			if isACMESolver(ing) && matchIngressClass(ing, ingressClass, adoptUnclassed) {
+				for _, rule := range ing.Spec.Rules {
+					if rule.HTTP != nil && len(rule.Host) > 0 {
+						idx = append(idx, strings.ToLower(rule.Host))
+					}
+				}
+			}
+			return idx, nil
+		}
+		return []string{}, fmt.Errorf("index unexpected obj type: %T", obj)
+	}
+}
+
+func ingressSecretIndexFunc(ingressClass string, adoptUnclassed bool, originSecrets map[string]*resource, domainSecrets map[string]*resource, secret *resource, defaultHostname string) func(obj interface{}) ([]string, error) {
 	return func(obj interface{}) ([]string, error) {
 		if ing, ok := obj.(*networkingv1.Ingress); ok {
 			var idx []string
-			if objIngClass, ok := parseIngressClass(ing); ok && ingressClass == objIngClass {
+			if matchIngressClass(ing, ingressClass, adoptUnclassed) {
 				hostsecret := make(map[string]*resource)
 				for _, tls := range ing.Spec.TLS {
 					for _, host := range tls.Hosts {
@@ -101,12 +181,16 @@ func ingressSecretIndexFunc(ingressClass string, originSecrets map[string]*resou
 					}
 				}
 				for _, rule := range ing.Spec.Rules {
-					if rule.HTTP != nil && len(rule.Host) > 0 {
-						if r, ok := hostsecret[rule.Host]; ok {
+					host := rule.Host
+					if len(host) == 0 {
+						host = getDefaultHost(ing, defaultHostname)
+					}
+					if rule.HTTP != nil && len(host) > 0 {
+						if r, ok := hostsecret[host]; ok {
 							idx = append(idx, itemKeyFunc(r.namespace, r.name))
-						} else if r, ok := originSecrets[rule.Host]; ok {
+						} else if r, ok := originSecrets[host]; ok {
 							idx = append(idx, itemKeyFunc(r.namespace, r.name))
-						} else if r, ok := getDomainSecret(rule.Host, domainSecrets); ok {
+						} else if r, ok := getDomainSecret(host, domainSecrets); ok {
 							idx = append(idx, itemKeyFunc(r.namespace, r.name))
 						} else if secret != nil {
 							idx = append(idx, itemKeyFunc(secret.namespace, secret.name))
@@ -120,13 +204,13 @@ func ingressSecretIndexFunc(ingressClass string, originSecrets map[string]*resou
 	}
 }
 
-func ingressServiceIndexFunc(ingressClass string) func(obj interface{}) ([]string, error) {
+func ingressServiceIndexFunc(ingressClass string, adoptUnclassed bool) func(obj interface{}) ([]string, error) {
 	return func(obj interface{}) ([]string, error) {
 		if ing, ok := obj.(*networkingv1.Ingress); ok {
 			var idx []string
-			if objIngClass, ok := parseIngressClass(ing); ok && ingressClass == objIngClass {
+			if matchIngressClass(ing, ingressClass, adoptUnclassed) {
 				for _, rule := range ing.Spec.Rules {
-					if rule.HTTP != nil && len(rule.Host) > 0 {
+					if rule.HTTP != nil {
 						for _, path := range rule.HTTP.Paths {
 							if len(path.Backend.Service.Name) > 0 {
 								idx = append(idx, itemKeyFunc(ing.Namespace, path.Backend.Service.Name))
@@ -134,6 +218,9 @@ func ingressServiceIndexFunc(ingressClass string) func(obj interface{}) ([]strin
 						}
 					}
 				}
+				if name, _, ok := parseFallbackService(ing); ok {
+					idx = append(idx, itemKeyFunc(ing.Namespace, name))
+				}
 			}
 			return idx, nil
 		}
@@ -164,3 +251,41 @@ func itemKeyFunc(namespace, name string) (key string) {
 	key = namespace + "/" + name
 	return
 }
+
+// transformingListerWatcher transforms the objects of the lists and watch
+// events, the client-go informers of this version take no transform
+type transformingListerWatcher struct {
+	lw        cache.ListerWatcher
+	transform func(metav1.Object)
+}
+
+func (lw *transformingListerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	obj, err := lw.lw.List(options)
+	if err != nil {
+		return obj, err
+	}
+	err = meta.EachListItem(obj, func(item runtime.Object) error {
+		lw.apply(item)
+		return nil
+	})
+	return obj, err
+}
+
+func (lw *transformingListerWatcher) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := lw.lw.Watch(options)
+	if err != nil {
+		return w, err
+	}
+	return watch.Filter(w, func(ev watch.Event) (watch.Event, bool) {
+		if ev.Type != watch.Error && ev.Type != watch.Bookmark {
+			lw.apply(ev.Object)
+		}
+		return ev, true
+	}), nil
+}
+
+func (lw *transformingListerWatcher) apply(obj runtime.Object) {
+	if m, err := meta.Accessor(obj); err == nil {
+		lw.transform(m)
+	}
+}
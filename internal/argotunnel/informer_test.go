@@ -9,6 +9,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -138,7 +140,189 @@ func TestIngressSecretIndexFunc(t *testing.T) {
 			err: nil,
 		},
 	} {
-		indexFunc := ingressSecretIndexFunc("unit", nil, nil, nil)
+		indexFunc := ingressSecretIndexFunc("unit", false, nil, nil, nil, "")
+		out, err := indexFunc(test.obj)
+		assert.Equalf(t, test.out, out, "test '%s' index mismatch", name)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+	}
+}
+
+func TestIngressSecretIndexFuncHostless(t *testing.T) {
+	t.Parallel()
+	hostless := networkingv1.IngressRule{
+		IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{},
+		},
+	}
+	for name, test := range map[string]struct {
+		tls             []networkingv1.IngressTLS
+		defaultHostname string
+		out             []string
+	}{
+		"hostless-unserved": {
+			out: nil,
+		},
+		"hostless-tls-host": {
+			tls: []networkingv1.IngressTLS{
+				{Hosts: []string{"a.unit.com"}, SecretName: "sec-a"},
+			},
+			defaultHostname: "b.unit.com",
+			out:             []string{"unit/sec-a"},
+		},
+		"hostless-default-hostname": {
+			defaultHostname: "b.unit.com",
+			out:             []string{"unit/sec-b"},
+		},
+	} {
+		indexFunc := ingressSecretIndexFunc("unit", false, map[string]*resource{
+			"b.unit.com": {namespace: "unit", name: "sec-b"},
+		}, nil, nil, test.defaultHostname)
+		out, err := indexFunc(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "unit",
+				Annotations: map[string]string{annotationIngressClass: "unit"},
+			},
+			Spec: networkingv1.IngressSpec{
+				TLS:   test.tls,
+				Rules: []networkingv1.IngressRule{hostless},
+			},
+		})
+		assert.Equalf(t, test.out, out, "test '%s' index mismatch", name)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+	}
+}
+
+func TestIngressACMESolverIndexFunc(t *testing.T) {
+	t.Parallel()
+	rules := []networkingv1.IngressRule{
+		{
+			Host: "A.unit.com",
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{},
+			},
+		},
+	}
+	for name, test := range map[string]struct {
+		obj interface{}
+		out []string
+		err error
+	}{
+		"obj-nil": {
+			obj: nil,
+			out: []string{},
+			err: fmt.Errorf("index unexpected obj type: %T", nil),
+		},
+		"obj-ing-no-solver": {
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+					Annotations: map[string]string{
+						annotationIngressClass: "unit",
+					},
+				},
+				Spec: networkingv1.IngressSpec{Rules: rules},
+			},
+			out: nil,
+			err: nil,
+		},
+		"obj-ing-solver-no-class": {
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+					Labels: map[string]string{
+						labelACMESolver: "true",
+					},
+				},
+				Spec: networkingv1.IngressSpec{Rules: rules},
+			},
+			out: nil,
+			err: nil,
+		},
+		"obj-ing-solver": {
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+					Annotations: map[string]string{
+						annotationIngressClass: "unit",
+					},
+					Labels: map[string]string{
+						labelACMESolver: "true",
+					},
+				},
+				Spec: networkingv1.IngressSpec{Rules: rules},
+			},
+			out: []string{
+				"a.unit.com",
+			},
+			err: nil,
+		},
+	} {
+		indexFunc := ingressACMESolverIndexFunc("unit", false)
+		out, err := indexFunc(test.obj)
+		assert.Equalf(t, test.out, out, "test '%s' index mismatch", name)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+	}
+}
+
+func TestIngressConfigMapIndexFunc(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		obj interface{}
+		out []string
+		err error
+	}{
+		"obj-nil": {
+			obj: nil,
+			out: []string{},
+			err: fmt.Errorf("index unexpected obj type: %T", nil),
+		},
+		"obj-ing-no-class": {
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+					Annotations: map[string]string{
+						annotationIngressMaintenanceConfig: "cm-a",
+					},
+				},
+			},
+			out: nil,
+			err: nil,
+		},
+		"obj-ing-no-configmap": {
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+					Annotations: map[string]string{
+						annotationIngressClass: "unit",
+					},
+				},
+			},
+			out: nil,
+			err: nil,
+		},
+		"obj-ing-configmap": {
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+					Annotations: map[string]string{
+						annotationIngressClass:             "unit",
+						annotationIngressMaintenanceConfig: "cm-a",
+					},
+				},
+			},
+			out: []string{
+				"unit/cm-a",
+			},
+			err: nil,
+		},
+	} {
+		indexFunc := ingressConfigMapIndexFunc("unit", false)
 		out, err := indexFunc(test.obj)
 		assert.Equalf(t, test.out, out, "test '%s' index mismatch", name)
 		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
@@ -167,6 +351,22 @@ func TestIngressServiceIndexFunc(t *testing.T) {
 			out: []string{},
 			err: fmt.Errorf("index unexpected obj type: %T", &unit{}),
 		},
+		"ing-fallback-service": {
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+					Annotations: map[string]string{
+						annotationIngressClass:           "unit",
+						annotationIngressFallbackService: "svc-fallback:http",
+					},
+				},
+			},
+			out: []string{
+				"unit/svc-fallback",
+			},
+			err: nil,
+		},
 		"obj-ing-class-mismatch": {
 			obj: &networkingv1.Ingress{
 				ObjectMeta: metav1.ObjectMeta{
@@ -312,7 +512,7 @@ func TestIngressServiceIndexFunc(t *testing.T) {
 			err: nil,
 		},
 	} {
-		indexFunc := ingressServiceIndexFunc("unit")
+		indexFunc := ingressServiceIndexFunc("unit", false)
 		out, err := indexFunc(test.obj)
 		assert.Equalf(t, test.out, out, "test '%s' index mismatch", name)
 		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
@@ -554,3 +754,73 @@ func (i *mockIndexer) AddIndexers(newIndexers cache.Indexers) error {
 	args := i.Called(newIndexers)
 	return args.Error(0)
 }
+
+func TestTransformingListerWatcher(t *testing.T) {
+	t.Parallel()
+	ing := func() networkingv1.Ingress {
+		return networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			annotationIngressTag:                  "a",
+			"argo.cloudflare.com/request-headers": "junk",
+		}}}
+	}
+	out := map[string]string{annotationIngressTag: "a"}
+	fw := watch.NewFake()
+	lw := &transformingListerWatcher{
+		lw: &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return &networkingv1.IngressList{Items: []networkingv1.Ingress{ing(), ing()}}, nil
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return fw, nil
+			},
+		},
+		transform: stripUnknownAnnotations,
+	}
+
+	obj, err := lw.List(metav1.ListOptions{})
+	assert.Nilf(t, err, "test list error mismatch")
+	for _, item := range obj.(*networkingv1.IngressList).Items {
+		assert.Equalf(t, out, item.Annotations, "test list annotations mismatch")
+	}
+
+	w, err := lw.Watch(metav1.ListOptions{})
+	assert.Nilf(t, err, "test watch error mismatch")
+	go func() {
+		added, modified := ing(), ing()
+		fw.Add(&added)
+		fw.Modify(&modified)
+		fw.Error(&metav1.Status{})
+	}()
+	for _, want := range []watch.EventType{watch.Added, watch.Modified} {
+		ev := <-w.ResultChan()
+		assert.Equalf(t, want, ev.Type, "test watch event mismatch")
+		assert.Equalf(t, out, ev.Object.(*networkingv1.Ingress).Annotations, "test watch annotations mismatch")
+	}
+	ev := <-w.ResultChan()
+	assert.Equalf(t, watch.Error, ev.Type, "test watch error event mismatch")
+	w.Stop()
+}
+
+func TestIngressListScope(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		opts      []Option
+		namespace string
+		selector  string
+	}{
+		"all-namespaces": {},
+		"watch-namespace": {
+			opts:      []Option{WatchNamespace("unit")},
+			namespace: "unit",
+		},
+		"only-ingress": {
+			opts:      []Option{WatchNamespace("unit"), OnlyIngress("ing", "debug")},
+			namespace: "debug",
+			selector:  "metadata.name=ing",
+		},
+	} {
+		namespace, selector := ingressListScope(collectOptions(test.opts))
+		assert.Equalf(t, test.namespace, namespace, "test '%s' namespace mismatch", name)
+		assert.Equalf(t, test.selector, selector.String(), "test '%s' selector mismatch", name)
+	}
+}
@@ -0,0 +1,73 @@
+package argotunnel
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// verifyAdoptUnclassed confirms unclassed ingresses may be adopted.
+// A default IngressClass other than the classes of the controller claims
+// the unclassed ingresses, so adoption is disabled rather than serving
+// them twice.
+func verifyAdoptUnclassed(ctx context.Context, client kubernetes.Interface, ingressClass string, log *logrus.Logger) (ok bool) {
+	classes, err := client.NetworkingV1().IngressClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Warnf("unclassed ingress adoption disabled, unable to list ingress classes: %v", err)
+		return false
+	}
+	for _, class := range classes.Items {
+		if class.Annotations[annotationIngressClassIsDefault] != "true" {
+			continue
+		}
+		if !containsIngressClass(ingressClass, class.Name) {
+			log.Warnf("unclassed ingress adoption disabled, default ingress class: %s", class.Name)
+			return false
+		}
+	}
+	return true
+}
+
+// containsIngressClass verifies the class is one of the controller
+func containsIngressClass(ingressClass, class string) bool {
+	for _, c := range parseIngressClasses(ingressClass) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// ingressClassUnclassed labels the adopted ingresses claiming no class
+const ingressClassUnclassed = "unclassed"
+
+// exportAdoptedIngresses counts the ingresses adopted per class of the
+// controller, following the migration from a legacy class
+func exportAdoptedIngresses(ingresses cache.Store, ingressClass string, adoptUnclassed bool) {
+	counts := map[string]int{}
+	for _, class := range parseIngressClasses(ingressClass) {
+		counts[class] = 0
+	}
+	if adoptUnclassed {
+		counts[ingressClassUnclassed] = 0
+	}
+	for _, obj := range ingresses.List() {
+		ing, ok := obj.(*networkingv1.Ingress)
+		if !ok || !matchIngressClass(ing, ingressClass, adoptUnclassed) {
+			continue
+		}
+		if class, ok := parseIngressClass(ing); ok {
+			counts[class]++
+		} else {
+			counts[ingressClassUnclassed]++
+		}
+	}
+	ingressesAdopted.Reset()
+	for class, n := range counts {
+		ingressesAdopted.WithLabelValues(class).Set(float64(n))
+	}
+}
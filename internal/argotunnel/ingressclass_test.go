@@ -0,0 +1,206 @@
+package argotunnel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestVerifyAdoptUnclassed(t *testing.T) {
+	t.Parallel()
+	ingressClass := func(name string, isDefault bool) *networkingv1.IngressClass {
+		c := &networkingv1.IngressClass{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+		}
+		if isDefault {
+			c.Annotations = map[string]string{
+				annotationIngressClassIsDefault: "true",
+			}
+		}
+		return c
+	}
+	for name, test := range map[string]struct {
+		objs  []runtime.Object
+		class string
+		out   bool
+		level logrus.Level
+	}{
+		"no-classes": {
+			class: "argo-tunnel",
+			out:   true,
+		},
+		"no-default-class": {
+			objs: []runtime.Object{
+				ingressClass("argo-tunnel", false),
+				ingressClass("nginx", false),
+			},
+			class: "argo-tunnel",
+			out:   true,
+		},
+		"own-default-class": {
+			objs: []runtime.Object{
+				ingressClass("argo-tunnel", true),
+				ingressClass("nginx", false),
+			},
+			class: "argo-tunnel",
+			out:   true,
+		},
+		"legacy-default-class": {
+			objs: []runtime.Object{
+				ingressClass("argo-tunnel", true),
+				ingressClass("cloudflare", false),
+			},
+			class: "cloudflare,argo-tunnel",
+			out:   true,
+		},
+		"other-default-class": {
+			objs: []runtime.Object{
+				ingressClass("argo-tunnel", false),
+				ingressClass("nginx", true),
+			},
+			class: "argo-tunnel",
+			out:   false,
+			level: logrus.WarnLevel,
+		},
+	} {
+		logger, hook := logtest.NewNullLogger()
+		out := verifyAdoptUnclassed(context.TODO(), fake.NewSimpleClientset(test.objs...), test.class, logger)
+		assert.Equalf(t, test.out, out, "test '%s' condition mismatch", name)
+		if test.out {
+			assert.Nilf(t, hook.LastEntry(), "test '%s' log mismatch", name)
+		} else {
+			assert.Equalf(t, test.level, hook.LastEntry().Level, "test '%s' log level mismatch", name)
+		}
+	}
+}
+
+func TestParseIngressClasses(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in      string
+		out     []string
+		primary string
+	}{
+		"single-class": {
+			in:      "argo-tunnel",
+			out:     []string{"argo-tunnel"},
+			primary: "argo-tunnel",
+		},
+		"legacy-classes": {
+			in:      "cloudflare, argo-tunnel,argo",
+			out:     []string{"cloudflare", "argo-tunnel", "argo"},
+			primary: "cloudflare",
+		},
+		"empty-classes": {
+			in:      ",cloudflare,",
+			out:     []string{"cloudflare"},
+			primary: "cloudflare",
+		},
+		"no-class": {},
+	} {
+		assert.Equalf(t, test.out, parseIngressClasses(test.in), "test '%s' classes mismatch", name)
+		assert.Equalf(t, test.primary, primaryIngressClass(test.in), "test '%s' primary class mismatch", name)
+	}
+}
+
+func TestMatchIngressClasses(t *testing.T) {
+	t.Parallel()
+	ing := func(class string) *networkingv1.Ingress {
+		i := &networkingv1.Ingress{}
+		if len(class) > 0 {
+			i.Annotations = map[string]string{annotationIngressClass: class}
+		}
+		return i
+	}
+	for name, test := range map[string]struct {
+		ing            *networkingv1.Ingress
+		class          string
+		adoptUnclassed bool
+		out            bool
+	}{
+		"current-class": {
+			ing:   ing("cloudflare"),
+			class: "cloudflare,argo-tunnel",
+			out:   true,
+		},
+		"legacy-class": {
+			ing:   ing("argo-tunnel"),
+			class: "cloudflare,argo-tunnel",
+			out:   true,
+		},
+		"other-class": {
+			ing:   ing("nginx"),
+			class: "cloudflare,argo-tunnel",
+		},
+		"class-prefix": {
+			ing:   ing("cloudflare"),
+			class: "cloudflare-internal",
+		},
+		"unclassed": {
+			ing:   ing(""),
+			class: "cloudflare,argo-tunnel",
+		},
+		"unclassed-adopted": {
+			ing:            ing(""),
+			class:          "cloudflare,argo-tunnel",
+			adoptUnclassed: true,
+			out:            true,
+		},
+	} {
+		out := matchIngressClass(test.ing, test.class, test.adoptUnclassed)
+		assert.Equalf(t, test.out, out, "test '%s' match mismatch", name)
+	}
+}
+
+func TestExportAdoptedIngresses(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for name, class := range map[string]string{
+		"ing-a": "cloudflare",
+		"ing-b": "argo-tunnel",
+		"ing-c": "argo-tunnel",
+		"ing-d": "nginx",
+		"ing-e": "",
+	} {
+		ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: name}}
+		if len(class) > 0 {
+			ing.Annotations = map[string]string{annotationIngressClass: class}
+		}
+		store.Add(ing)
+	}
+	for name, test := range map[string]struct {
+		class          string
+		adoptUnclassed bool
+		out            map[string]float64
+	}{
+		"legacy-classes": {
+			class: "cloudflare,argo-tunnel",
+			out:   map[string]float64{"cloudflare": 1, "argo-tunnel": 2},
+		},
+		"migrated-class": {
+			class: "cloudflare,argo",
+			out:   map[string]float64{"cloudflare": 1, "argo": 0},
+		},
+		"unclassed-adopted": {
+			class:          "cloudflare",
+			adoptUnclassed: true,
+			out:            map[string]float64{"cloudflare": 1, ingressClassUnclassed: 1},
+		},
+	} {
+		exportAdoptedIngresses(store, test.class, test.adoptUnclassed)
+		assert.Equalf(t, len(test.out), testutil.CollectAndCount(ingressesAdopted), "test '%s' series mismatch", name)
+		for class, n := range test.out {
+			assert.Equalf(t, n, testutil.ToFloat64(ingressesAdopted.WithLabelValues(class)), "test '%s' class %s mismatch", name, class)
+		}
+	}
+}
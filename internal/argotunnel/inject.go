@@ -0,0 +1,64 @@
+package argotunnel
+
+import (
+	"io"
+	"net/http"
+)
+
+const (
+	// robotsDisallowAll denies every crawler the whole host
+	robotsDisallowAll = "disallow-all"
+
+	robotsPath      = "/robots.txt"
+	securityTxtPath = "/.well-known/security.txt"
+
+	robotsDisallowAllBody = "User-agent: *\nDisallow: /\n"
+)
+
+// injectRoundTripper answers /robots.txt and /.well-known/security.txt
+// with static content, other paths are passed through. The origin is
+// asked first and wins unless it answers 404, or the injection overrides
+// it.
+type injectRoundTripper struct {
+	next     http.RoundTripper
+	files    map[string]string
+	override bool
+}
+
+// newInjectRoundTripper wraps the tunnel with the injected files, nil
+// when the tunnel injects none
+func newInjectRoundTripper(next http.RoundTripper, options tunnelOptions) *injectRoundTripper {
+	files := map[string]string{}
+	if options.injectRobots == robotsDisallowAll {
+		files[robotsPath] = robotsDisallowAllBody
+	}
+	if len(options.securityTxt) > 0 {
+		files[securityTxtPath] = options.securityTxt
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return &injectRoundTripper{
+		next:     next,
+		files:    files,
+		override: options.injectOverride,
+	}
+}
+
+func (rt *injectRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, ok := rt.files[req.URL.Path]
+	if !ok || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return rt.next.RoundTrip(req)
+	}
+	if !rt.override {
+		res, err := rt.next.RoundTrip(req)
+		if err != nil || res.StatusCode != http.StatusNotFound {
+			return res, err
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	} else {
+		closeRequestBody(req)
+	}
+	return newSyntheticResponse(req, http.StatusOK, "text/plain; charset=utf-8", body), nil
+}
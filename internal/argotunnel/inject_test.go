@@ -0,0 +1,128 @@
+package argotunnel
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewInjectRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  tunnelOptions
+		out map[string]string
+	}{
+		"none": {
+			in: tunnelOptions{},
+		},
+		"unknown-robots": {
+			in: tunnelOptions{injectRobots: "allow-all"},
+		},
+		"robots": {
+			in:  tunnelOptions{injectRobots: robotsDisallowAll},
+			out: map[string]string{robotsPath: robotsDisallowAllBody},
+		},
+		"robots-and-security-txt": {
+			in: tunnelOptions{injectRobots: robotsDisallowAll, securityTxt: "Contact: mailto:security@unit.com\n"},
+			out: map[string]string{
+				robotsPath:      robotsDisallowAllBody,
+				securityTxtPath: "Contact: mailto:security@unit.com\n",
+			},
+		},
+	} {
+		rt := newInjectRoundTripper(http.DefaultTransport, test.in)
+		if test.out == nil {
+			assert.Nilf(t, rt, "test '%s' round tripper mismatch", name)
+			continue
+		}
+		assert.Equalf(t, test.out, rt.files, "test '%s' files mismatch", name)
+	}
+}
+
+func TestInjectRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		method   string
+		path     string
+		origin   int
+		override bool
+		status   int
+		body     string
+		reached  bool
+	}{
+		"origin-serves": {
+			method:  http.MethodGet,
+			path:    robotsPath,
+			origin:  http.StatusOK,
+			status:  http.StatusOK,
+			body:    "origin",
+			reached: true,
+		},
+		"origin-not-found": {
+			method:  http.MethodGet,
+			path:    robotsPath,
+			origin:  http.StatusNotFound,
+			status:  http.StatusOK,
+			body:    robotsDisallowAllBody,
+			reached: true,
+		},
+		"origin-error-status": {
+			method:  http.MethodGet,
+			path:    robotsPath,
+			origin:  http.StatusBadGateway,
+			status:  http.StatusBadGateway,
+			body:    "origin",
+			reached: true,
+		},
+		"override": {
+			method:   http.MethodGet,
+			path:     robotsPath,
+			origin:   http.StatusOK,
+			override: true,
+			status:   http.StatusOK,
+			body:     robotsDisallowAllBody,
+		},
+		"head": {
+			method:  http.MethodHead,
+			path:    robotsPath,
+			origin:  http.StatusNotFound,
+			status:  http.StatusOK,
+			body:    robotsDisallowAllBody,
+			reached: true,
+		},
+		"post": {
+			method:   http.MethodPost,
+			path:     robotsPath,
+			origin:   http.StatusNotFound,
+			override: true,
+			status:   http.StatusNotFound,
+			body:     "origin",
+			reached:  true,
+		},
+		"other-path": {
+			method:   http.MethodGet,
+			path:     "/index.html",
+			origin:   http.StatusNotFound,
+			override: true,
+			status:   http.StatusNotFound,
+			body:     "origin",
+			reached:  true,
+		},
+	} {
+		reached := false
+		origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			reached = true
+			return newSyntheticResponse(req, test.origin, "text/plain", "origin"), nil
+		})
+		rt := newInjectRoundTripper(origin, tunnelOptions{injectOverride: test.override, injectRobots: robotsDisallowAll})
+		req, _ := http.NewRequest(test.method, "http://unit.com"+test.path, nil)
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		body, _ := ioutil.ReadAll(res.Body)
+		assert.Equalf(t, test.status, res.StatusCode, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.body, string(body), "test '%s' body mismatch", name)
+		assert.Equalf(t, test.reached, reached, "test '%s' origin reached mismatch", name)
+	}
+}
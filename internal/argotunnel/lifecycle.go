@@ -0,0 +1,129 @@
+package argotunnel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// linkDrainTimeout bounds the drain of the requests in flight to the
+// origin of a stopped link, the requests left are then canceled
+const linkDrainTimeout = 10 * time.Second
+
+// lifecycleRoundTripper ties the requests proxied by a link to the
+// lifecycle of the link. Once the link is stopped, the requests in flight
+// drain up to a bound and are then canceled along with their dials, new
+// requests are refused.
+type lifecycleRoundTripper struct {
+	next  http.RoundTripper
+	host  string
+	mu    sync.Mutex
+	cycle *linkCycle
+}
+
+// linkCycle tracks the requests in flight between a start and a stop of
+// a link
+type linkCycle struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inflight sync.WaitGroup
+	closed   bool
+}
+
+func newLinkCycle() *linkCycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &linkCycle{ctx: ctx, cancel: cancel}
+}
+
+func newLifecycleRoundTripper(next http.RoundTripper, host string) *lifecycleRoundTripper {
+	return &lifecycleRoundTripper{
+		next:  next,
+		host:  host,
+		cycle: newLinkCycle(),
+	}
+}
+
+func (rt *lifecycleRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	c := rt.cycle
+	if c.closed {
+		rt.mu.Unlock()
+		closeRequestBody(req)
+		return nil, fmt.Errorf("link stopped for host %s", rt.host)
+	}
+	c.inflight.Add(1)
+	rt.mu.Unlock()
+
+	proxyInflightRequests.WithLabelValues(rt.host).Inc()
+	ctx, cancel := context.WithCancel(req.Context())
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	release := func() {
+		cancel()
+		proxyInflightRequests.WithLabelValues(rt.host).Dec()
+		c.inflight.Done()
+	}
+
+	res, err := rt.next.RoundTrip(req.WithContext(ctx))
+	if err != nil || res.Body == nil {
+		release()
+		return res, err
+	}
+	res.Body = &releaseBody{ReadCloser: res.Body, release: release}
+	return res, nil
+}
+
+// open starts a new cycle once the previous one was closed
+func (rt *lifecycleRoundTripper) open() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.cycle.closed {
+		rt.cycle = newLinkCycle()
+	}
+}
+
+// close refuses new requests and waits up to timeout for the requests
+// in flight, the requests left are canceled
+func (rt *lifecycleRoundTripper) close(timeout time.Duration) {
+	if c := rt.seal(); c != nil {
+		c.drain(timeout)
+	}
+}
+
+// seal refuses new requests on the current cycle, nil when it was already
+// closed. A link restarted right after is open again at once, the drain
+// of the sealed cycle runs on its own.
+func (rt *lifecycleRoundTripper) seal() *linkCycle {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	c := rt.cycle
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c
+}
+
+// drain waits up to timeout for the requests in flight, the requests left
+// are canceled
+func (c *linkCycle) drain(timeout time.Duration) {
+	defer c.cancel()
+	drained := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(drained)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,143 @@
+package argotunnel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycleRoundTripper(t *testing.T) {
+	for name, test := range map[string]struct {
+		delay    time.Duration
+		timeout  time.Duration
+		canceled bool
+	}{
+		"drained": {
+			delay:   10 * time.Millisecond,
+			timeout: time.Second,
+		},
+		"canceled": {
+			delay:    time.Minute,
+			timeout:  10 * time.Millisecond,
+			canceled: true,
+		},
+	} {
+		origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			select {
+			case <-time.After(test.delay):
+				return newSyntheticResponse(req, http.StatusOK, "text/plain", "ok"), nil
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		})
+		rt := newLifecycleRoundTripper(origin, name)
+
+		resCh := make(chan error, 1)
+		go func() {
+			req, _ := http.NewRequest(http.MethodGet, "http://unit.com", nil)
+			res, err := rt.RoundTrip(req)
+			if err == nil {
+				res.Body.Close()
+			}
+			resCh <- err
+		}()
+		for testutil.ToFloat64(proxyInflightRequests.WithLabelValues(name)) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		rt.close(test.timeout)
+
+		err := <-resCh
+		assert.Equalf(t, test.canceled, err != nil, "test '%s' canceled mismatch", name)
+		assert.Equalf(t, float64(0), testutil.ToFloat64(proxyInflightRequests.WithLabelValues(name)), "test '%s' inflight mismatch", name)
+
+		req, _ := http.NewRequest(http.MethodGet, "http://unit.com", nil)
+		_, err = rt.RoundTrip(req)
+		assert.NotNilf(t, err, "test '%s' stopped error mismatch", name)
+
+		rt.open()
+		test.delay = 0
+		req, _ = http.NewRequest(http.MethodGet, "http://unit.com", nil)
+		res, err := rt.RoundTrip(req)
+		if assert.Nilf(t, err, "test '%s' reopened error mismatch", name) {
+			res.Body.Close()
+		}
+		proxyInflightRequests.DeleteLabelValues(name)
+	}
+}
+
+// TestLifecycleRoundTripperChurn churns links with requests stuck on a
+// slow origin, no goroutine may outlive the links
+func TestLifecycleRoundTripperChurn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Minute):
+		}
+	}))
+	defer srv.Close()
+	transport := newLinkHTTPTransport()
+	defer transport.CloseIdleConnections()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		host := fmt.Sprintf("churn-%d.unit.com", i)
+		rt := newLifecycleRoundTripper(transport, host)
+		var wg sync.WaitGroup
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+				if res, err := rt.RoundTrip(req); err == nil {
+					res.Body.Close()
+				}
+			}()
+		}
+		for testutil.ToFloat64(proxyInflightRequests.WithLabelValues(host)) < 5 {
+			time.Sleep(time.Millisecond)
+		}
+		rt.close(10 * time.Millisecond)
+		wg.Wait()
+		assert.Equalf(t, float64(0), testutil.ToFloat64(proxyInflightRequests.WithLabelValues(host)), "test '%s' inflight mismatch", host)
+		proxyInflightRequests.DeleteLabelValues(host)
+	}
+	transport.CloseIdleConnections()
+
+	deadline := time.Now().Add(5 * time.Second)
+	after := runtime.NumGoroutine()
+	for after > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+	assert.LessOrEqualf(t, after, before, "test 'churn' goroutine mismatch")
+}
+
+func TestLinkRestartServes(t *testing.T) {
+	l := newTunnelLink(tunnelRule{host: "restart.unit.com"}, nil, tunnelOptions{}, nil, nil, nil, nil, logrus.StandardLogger()).(*syncTunnelLink)
+	l.proxy.next = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return newSyntheticResponse(req, http.StatusOK, "text/plain", "ok"), nil
+	})
+	// a running link, stopped then started at once as a tripped link retry
+	l.stopCh, l.quitCh = make(chan struct{}), make(chan struct{})
+	l.halt("stop")
+	l.proxy.open()
+	// the drain of the stopped cycle must not close the restarted one
+	time.Sleep(20 * time.Millisecond)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://restart.unit.com", nil)
+	res, err := l.proxy.RoundTrip(req)
+	assert.Nilf(t, err, "test 'restart' error mismatch")
+	if err == nil {
+		assert.Equalf(t, http.StatusOK, res.StatusCode, "test 'restart' status mismatch")
+		res.Body.Close()
+	}
+	proxyInflightRequests.DeleteLabelValues(l.rule.host)
+}
@@ -1,27 +1,329 @@
 package argotunnel
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/cloudflare/cloudflared/origin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 )
 
 // TODO: Review the metrics pattern used by cloudflared and
 // migrate towards go-kit metrics with configurable providers.
 var metricsConfig = struct {
+	mu              sync.Mutex
+	enabled         bool
 	metrics         *origin.TunnelMetrics
+	registerer      prometheus.Registerer
+	hosts           map[string]*hostTunnelMetrics
 	updateFrequency time.Duration
 	setMetrics      sync.Once
 }{
 	metrics:         &origin.TunnelMetrics{},
+	registerer:      prometheus.NewRegistry(),
+	hosts:           map[string]*hostTunnelMetrics{},
 	updateFrequency: 10000 * time.Hour,
 }
 
 // EnableMetrics configures the metrics used by all tunnels
 func EnableMetrics(updateFrequency time.Duration) {
 	metricsConfig.setMetrics.Do(func() {
-		metricsConfig.metrics = origin.NewTunnelMetrics()
+		metricsConfig.mu.Lock()
+		defer metricsConfig.mu.Unlock()
+		metricsConfig.enabled = true
 		metricsConfig.updateFrequency = updateFrequency
 	})
 }
+
+// hostTunnelMetrics are the cloudflared metrics of the links serving a
+// host, a retiring link and its successor share them
+type hostTunnelMetrics struct {
+	metrics  *origin.TunnelMetrics
+	recorder *collectorRecorder
+	refs     int
+}
+
+// acquireTunnelMetrics returns the cloudflared metrics of the host.
+// cloudflared registers its metrics in the global registry and assumes a
+// daemon per tunnel, the metrics of each host are prefixed with
+// cloudflared_ and labeled by hostname in the controller registry. A host
+// clashing with the registry falls back to unregistered metrics.
+func acquireTunnelMetrics(host string, log *logrus.Logger) *origin.TunnelMetrics {
+	metricsConfig.mu.Lock()
+	defer metricsConfig.mu.Unlock()
+
+	if !metricsConfig.enabled {
+		return metricsConfig.metrics
+	}
+	if h, ok := metricsConfig.hosts[host]; ok {
+		h.refs++
+		return h.metrics
+	}
+	r := prometheus.WrapRegistererWith(prometheus.Labels{"hostname": host}, metricsConfig.registerer)
+	m, rec, err := newTunnelMetrics(r)
+	if err != nil {
+		log.Warnf("cloudflared metrics of host %s clash with the registry, left unexported: %v", host, err)
+		m, rec, _ = newTunnelMetrics(prometheus.NewRegistry())
+	}
+	metricsConfig.hosts[host] = &hostTunnelMetrics{
+		metrics:  m,
+		recorder: rec,
+		refs:     1,
+	}
+	return m
+}
+
+// releaseTunnelMetrics unregisters the cloudflared metrics of the host
+// once no link uses them
+func releaseTunnelMetrics(host string) {
+	metricsConfig.mu.Lock()
+	defer metricsConfig.mu.Unlock()
+
+	h, ok := metricsConfig.hosts[host]
+	if !ok {
+		return
+	}
+	if h.refs--; h.refs > 0 {
+		return
+	}
+	h.recorder.unregister()
+	delete(metricsConfig.hosts, host)
+}
+
+// newTunnelMetrics creates cloudflared metrics registered with r, the
+// global registerer is swapped while cloudflared registers them
+func newTunnelMetrics(r prometheus.Registerer) (m *origin.TunnelMetrics, rec *collectorRecorder, err error) {
+	rec = &collectorRecorder{Registerer: r}
+	global := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = rec
+	defer func() {
+		prometheus.DefaultRegisterer = global
+		if p := recover(); p != nil {
+			rec.unregister()
+			m, err = nil, fmt.Errorf("%v", p)
+		}
+	}()
+	m = origin.NewTunnelMetrics()
+	return
+}
+
+// collectorRecorder keeps the collectors registered through it, to
+// unregister them together
+type collectorRecorder struct {
+	prometheus.Registerer
+	collectors []prometheus.Collector
+}
+
+func (r *collectorRecorder) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+func (r *collectorRecorder) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r *collectorRecorder) unregister() {
+	for _, c := range r.collectors {
+		r.Registerer.Unregister(c)
+	}
+	r.collectors = nil
+}
+
+var (
+	originRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argo_origin_requests_total",
+		Help: "Number of requests proxied to the origin.",
+	}, []string{"hostname", "namespace", "service", "code"})
+	originRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "argo_origin_request_duration_seconds",
+		Help:    "Duration of requests proxied to the origin.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"hostname", "namespace", "service"})
+	maintenanceResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argo_maintenance_responses_total",
+		Help: "Number of requests answered by the maintenance response.",
+	}, []string{"hostname", "namespace", "service"})
+	redirectResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argo_redirect_responses_total",
+		Help: "Number of requests answered by the redirect of the tunnel.",
+	}, []string{"hostname", "namespace", "service"})
+	fallbackRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argo_fallback_requests_total",
+		Help: "Number of requests sent to the fallback service, the origin could not be reached.",
+	}, []string{"hostname", "namespace", "service"})
+	tunnelConcurrency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argot_tunnel_concurrency",
+		Help: "Number of requests in flight to the origin of a tunnel capped by max-concurrent-requests.",
+	}, []string{"hostname", "namespace", "service"})
+	tunnelRejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argot_tunnel_rejected_requests_total",
+		Help: "Number of requests rejected past the max-concurrent-requests cap of a tunnel.",
+	}, []string{"hostname", "namespace", "service"})
+	reconcileTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argot_reconcile_timeouts_total",
+		Help: "Number of reconciles canceled after running past the reconcile timeout.",
+	}, []string{"kind"})
+	registrationsDeferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argo_tunnel_registrations_deferred_total",
+		Help: "Number of tunnel repairs delayed past their backoff by the retry-after of a throttling edge.",
+	}, []string{"hostname"})
+	webhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argot_webhook_events_total",
+		Help: "Number of tunnel events of the event webhook by result, sent, failed after the retries, or dropped on a full queue.",
+	}, []string{"event", "result"})
+	proxyInflightRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argo_proxy_inflight_requests",
+		Help: "Number of requests in flight to the origin of a tunnel, including the requests draining from a stopped tunnel.",
+	}, []string{"hostname"})
+	tunnelConnectionInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argo_tunnel_connection_info",
+		Help: "Edge colo serving each tunnel connection, always 1.",
+	}, []string{"hostname", "connection_id", "colo"})
+	tunnelOriginReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argo_tunnel_origin_ready",
+		Help: "Whether the tunnel origin passed its readiness probe, 1 without a probe.",
+	}, []string{"hostname"})
+	tunnelBreakerOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argo_tunnel_breaker_open",
+		Help: "Whether the tunnel stopped repairing after exhausting its repair cycles.",
+	}, []string{"hostname"})
+	streamStalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argo_stream_stalled_total",
+		Help: "Number of times a proxied stream was held back by a slow reader.",
+	}, []string{"hostname", "direction"})
+	informerLastEvent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argo_informer_last_event_timestamp_seconds",
+		Help: "Timestamp of the last successful list or watch event of an informer.",
+	}, []string{"kind"})
+	informerWatchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argo_informer_watch_errors_total",
+		Help: "Number of failed lists and watches of an informer.",
+	}, []string{"kind", "op"})
+	informerObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argo_informer_objects",
+		Help: "Number of objects listed and watched by an informer.",
+	}, []string{"kind"})
+	clockSkewSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argo_clock_skew_seconds",
+		Help: "System clock ahead of Cloudflare, measured after registrations fail for time.",
+	})
+	ingressLastReconcile = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argot_ingress_last_reconcile_timestamp_seconds",
+		Help: "Timestamp of the last successful reconcile of an ingress.",
+	}, []string{"namespace", "ingress"})
+	queueBatchedItems = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argo_queue_batched_items_total",
+		Help: "Number of queue items held back to a later batch of a resync burst.",
+	})
+	policyRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argo_policy_rejections_total",
+		Help: "Number of ingress hosts rejected by the hostname policy.",
+	}, []string{"reason"})
+	chaosFaults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argot_chaos_faults_total",
+		Help: "Number of faults injected for resilience testing.",
+	}, []string{"fault"})
+	ingressesSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argot_ingresses_skipped_total",
+		Help: "Number of ingress events skipped by the ingress filter, by reason.",
+	}, []string{"reason"})
+	ingressesAdopted = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argot_ingresses_adopted",
+		Help: "Number of ingresses adopted by the controller, by ingress class.",
+	}, []string{"class"})
+	credentialRefreshFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "argot_credential_refresh_failures_total",
+		Help: "Number of invalid origin certificate rotations kept out of running tunnels.",
+	})
+	routesTracked = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argo_internal_routes_tracked",
+		Help: "Number of entries held per route by each internal structure, after the last sweep.",
+	}, []string{"structure"})
+	summaryIngresses = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argo_summary_ingresses",
+		Help: "Number of ingresses adopted by the controller.",
+	})
+	summaryRoutes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argo_summary_routes",
+		Help: "Number of routed hosts.",
+	})
+	summaryRouteStates = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argo_summary_routes_by_state",
+		Help: "Number of routed hosts by tunnel state.",
+	}, []string{"state"})
+	summarySecrets = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argo_summary_secrets_referenced",
+		Help: "Number of origin certificate secrets referenced by the routes.",
+	})
+	summarySecretsMissing = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argo_summary_secrets_missing",
+		Help: "Number of referenced origin certificate secrets missing from the cache.",
+	})
+	summaryDNSRecords = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argo_summary_dns_records",
+		Help: "Number of DNS records managed by the controller.",
+	})
+	summaryResyncAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "argo_summary_last_full_resync_age_seconds",
+		Help: "Seconds since every ingress last converged.",
+	})
+)
+
+// RegisterMetrics registers the controller metrics, the cloudflared
+// metrics of each tunnel are registered with r as the tunnel starts
+func RegisterMetrics(r prometheus.Registerer) (err error) {
+	metricsConfig.mu.Lock()
+	metricsConfig.registerer = prometheus.WrapRegistererWithPrefix("cloudflared_", r)
+	metricsConfig.mu.Unlock()
+	for _, c := range []prometheus.Collector{
+		chaosFaults,
+		clockSkewSeconds,
+		credentialRefreshFailures,
+		fallbackRequests,
+		ingressLastReconcile,
+		ingressesAdopted,
+		ingressesSkipped,
+		maintenanceResponses,
+		originRequests,
+		originRequestDuration,
+		policyRejections,
+		proxyInflightRequests,
+		queueBatchedItems,
+		reconcileTimeouts,
+		redirectResponses,
+		registrationsDeferred,
+		routesTracked,
+		streamStalls,
+		summaryDNSRecords,
+		summaryIngresses,
+		summaryResyncAge,
+		summaryRouteStates,
+		summaryRoutes,
+		summarySecrets,
+		summarySecretsMissing,
+		tunnelBreakerOpen,
+		tunnelConcurrency,
+		tunnelConnectionInfo,
+		tunnelOriginReady,
+		tunnelRejectedRequests,
+		informerLastEvent,
+		informerObjects,
+		informerWatchErrors,
+		webhookEvents,
+	} {
+		if err = r.Register(c); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package argotunnel
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTunnelMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	wrap := func(host string) prometheus.Registerer {
+		return prometheus.WrapRegistererWith(prometheus.Labels{"hostname": host}, prometheus.WrapRegistererWithPrefix("cloudflared_", reg))
+	}
+	global := prometheus.DefaultRegisterer
+
+	a, reca, err := newTunnelMetrics(wrap("a.unit.com"))
+	assert.NotNilf(t, a, "test host a metrics mismatch")
+	assert.Nilf(t, err, "test host a error mismatch")
+	b, _, err := newTunnelMetrics(wrap("b.unit.com"))
+	assert.NotNilf(t, b, "test host b metrics mismatch")
+	assert.Nilf(t, err, "test host b error mismatch")
+	assert.Equalf(t, global, prometheus.DefaultRegisterer, "test global registerer mismatch")
+
+	families, err := reg.Gather()
+	assert.Nilf(t, err, "test gather error mismatch")
+	hosts := map[string]bool{}
+	for _, f := range families {
+		if f.GetName() != "cloudflared_ha_connections" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "hostname" {
+					hosts[l.GetValue()] = true
+				}
+			}
+		}
+	}
+	assert.Equalf(t, map[string]bool{"a.unit.com": true, "b.unit.com": true}, hosts, "test hostname labels mismatch")
+
+	clash, _, err := newTunnelMetrics(wrap("a.unit.com"))
+	assert.Nilf(t, clash, "test clash metrics mismatch")
+	assert.NotNilf(t, err, "test clash error mismatch")
+	assert.Equalf(t, global, prometheus.DefaultRegisterer, "test clash global registerer mismatch")
+
+	reca.unregister()
+	again, _, err := newTunnelMetrics(wrap("a.unit.com"))
+	assert.NotNilf(t, again, "test re-register metrics mismatch")
+	assert.Nilf(t, err, "test re-register error mismatch")
+}
@@ -1,18 +1,67 @@
 package argotunnel
 
 import (
+	"strings"
 	"time"
 
 	"github.com/cloudflare/cloudflare-ingress-controller/internal/cloudflare"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
 )
 
 const (
+	// DuplicateControllerPolicyDefault defines the default handling of
+	// another controller serving the ingress class
+	DuplicateControllerPolicyDefault = DuplicateControllerIgnore
+
+	// EdgeProtocolDefault defines the default protocol of the edge connections
+	EdgeProtocolDefault = EdgeProtocolAuto
+
+	// EventComponentDefault defines the default source component of the
+	// events recorded by the controller
+	EventComponentDefault = "argot"
+
+	// HistorySizeDefault defines the default reconcile decisions kept per host
+	HistorySizeDefault = 20
+
 	// IngressClassDefault defines the default class of ingresses managed by the controller
 	IngressClassDefault = "argo-tunnel"
 
+	// ReconcileBaseDelayDefault defines the default delay before the first
+	// retry of a failed reconcile, doubled on each failure of the item
+	ReconcileBaseDelayDefault = 5 * time.Millisecond
+
+	// ReconcileMaxDelayDefault defines the default bound of the delay between
+	// the retries of a failed reconcile
+	ReconcileMaxDelayDefault = 1000 * time.Second
+
+	// ReconcileTimeoutDefault defines the default period a reconcile runs
+	// before it is canceled
+	ReconcileTimeoutDefault = 5 * time.Minute
+
 	// ResyncPeriodDefault defines the default duration prior to synchronization
 	ResyncPeriodDefault = 5 * time.Minute
 
+	// ResyncBatchIntervalDefault defines the default period between the
+	// batches of a resync burst
+	ResyncBatchIntervalDefault = time.Second
+
+	// StaleWatchThresholdDefault defines the default duration an informer may
+	// go without a list or watch event before the controller is unhealthy
+	StaleWatchThresholdDefault = 15 * time.Minute
+
+	// MissingBackendGraceDefault defines the default duration the tunnels of
+	// a deleted backend service stay registered before they are removed
+	MissingBackendGraceDefault = 2 * time.Minute
+
+	// KeepCredentialOnRefreshFailureDefault defines whether tunnels keep
+	// their origin certificate when a rotation of its secret is invalid
+	KeepCredentialOnRefreshFailureDefault = true
+
+	// MakeBeforeBreakDefault defines whether replaced tunnels serve until
+	// their replacement is connected
+	MakeBeforeBreakDefault = true
+
 	// RequeueLimitDefault defines the default processing attempts before dropping the item
 	RequeueLimitDefault = 2
 
@@ -21,19 +70,161 @@ const (
 )
 
 type options struct {
-	ingressClass   string
-	originSecrets  map[string]*resource
-	domainSecrets  map[string]*resource
-	resyncPeriod   time.Duration
-	requeueLimit   int
-	secret         *resource
-	watchNamespace string
-	workers        int
+	adoptUnclassed      bool
+	allowMissing        bool
+	annotationDefaults  *resource
+	compressionQuality  uint64
+	controllerID        string
+	defaultHostname     string
+	defaultProto        string
+	duplicatePolicy     string
+	edgeAddrs           []string
+	edgeProtocol        string
+	eventComponent      string
+	eventWebhookURL     string
+	historySize         int
+	hostPolicy          *HostnamePolicy
+	ingressClass        string
+	keepCredential      bool
+	leaseNamespace      string
+	logFields           logrus.Fields
+	makeBeforeBreak     bool
+	missingBackendGrace time.Duration
+	onlyIngress         *resource
+	originSecrets       map[string]*resource
+	reconcileBaseDelay  time.Duration
+	reconcileMaxDelay   time.Duration
+	reconcileTimeout    time.Duration
+	domainSecrets       map[string]*resource
+	resyncBatchInterval time.Duration
+	resyncBatchSize     int
+	resyncPeriod        time.Duration
+	requeueLimit        int
+	requireTLS          bool
+	secret              *resource
+	securityTxt         string
+	shard               *shard
+	staleWatchThreshold time.Duration
+	statusClient        dynamic.Interface
+	upstreamMode        string
+	watchNamespace      string
+	workers             int
 }
 
 // Option provides behavior overrides
 type Option func(*options)
 
+// AdoptUnclassedIngresses manages ingresses that do not claim any class
+func AdoptUnclassedIngresses(b bool) Option {
+	return func(o *options) {
+		o.adoptUnclassed = b
+	}
+}
+
+// AllowMissingBackend creates tunnels for services without ready endpoints
+func AllowMissingBackend(b bool) Option {
+	return func(o *options) {
+		o.allowMissing = b
+	}
+}
+
+// AnnotationDefaults reads the defaults of the annotations an ingress
+// does not set from a ConfigMap, a ConfigMap of the same name in the
+// namespace of the ingress overrides them
+func AnnotationDefaults(name, namespace string) Option {
+	return func(o *options) {
+		if len(name) > 0 && len(namespace) > 0 {
+			o.annotationDefaults = &resource{
+				name:      name,
+				namespace: namespace,
+			}
+		}
+	}
+}
+
+// CompressionQuality defines the cross-stream compression used when an ingress omits it
+func CompressionQuality(i uint64) Option {
+	return func(o *options) {
+		o.compressionQuality = i
+	}
+}
+
+// ControllerID identifies the controller, its replicas share the identity
+// claiming the ingress class in the lease namespace
+func ControllerID(id, leaseNamespace string) Option {
+	return func(o *options) {
+		o.controllerID = id
+		o.leaseNamespace = leaseNamespace
+	}
+}
+
+// DefaultHostname defines the host serving ingress rules without a host
+func DefaultHostname(s string) Option {
+	return func(o *options) {
+		o.defaultHostname = s
+	}
+}
+
+// DefaultProto defines the origin protocol used when an ingress omits it
+func DefaultProto(s string) Option {
+	return func(o *options) {
+		o.defaultProto = s
+	}
+}
+
+// DuplicateControllerPolicy defines the handling of another controller
+// serving the ingress class
+func DuplicateControllerPolicy(s string) Option {
+	return func(o *options) {
+		o.duplicatePolicy = s
+	}
+}
+
+// EdgeAddrs overrides the edge addresses dialed by tunnels
+func EdgeAddrs(s []string) Option {
+	return func(o *options) {
+		o.edgeAddrs = s
+	}
+}
+
+// EdgeProtocol defines the protocol of the edge connections, see
+// ValidateEdgeProtocol
+func EdgeProtocol(s string) Option {
+	return func(o *options) {
+		o.edgeProtocol = s
+	}
+}
+
+// EventComponent defines the source component of the recorded events
+func EventComponent(s string) Option {
+	return func(o *options) {
+		o.eventComponent = s
+	}
+}
+
+// EventWebhookURL defines the url the tunnel connection events are posted
+// to, empty disables the webhook
+func EventWebhookURL(s string) Option {
+	return func(o *options) {
+		o.eventWebhookURL = s
+	}
+}
+
+// HistorySize defines the reconcile decisions kept per host, 0 disables
+// the history
+func HistorySize(i int) Option {
+	return func(o *options) {
+		o.historySize = i
+	}
+}
+
+// HostPolicy restricts the hosts exposed by the controller
+func HostPolicy(p *HostnamePolicy) Option {
+	return func(o *options) {
+		o.hostPolicy = p
+	}
+}
+
 // IngressClass defines the ingress class for the controller
 func IngressClass(s string) Option {
 	return func(o *options) {
@@ -41,6 +232,77 @@ func IngressClass(s string) Option {
 	}
 }
 
+// KeepCredentialOnRefreshFailure keeps the origin certificate of the
+// running tunnels when a rotation of its secret fails validation
+func KeepCredentialOnRefreshFailure(b bool) Option {
+	return func(o *options) {
+		o.keepCredential = b
+	}
+}
+
+// LogFields defines the fields added to every controller log entry
+func LogFields(fields logrus.Fields) Option {
+	return func(o *options) {
+		o.logFields = fields
+	}
+}
+
+// MakeBeforeBreak keeps a replaced tunnel serving until its replacement
+// is connected
+func MakeBeforeBreak(b bool) Option {
+	return func(o *options) {
+		o.makeBeforeBreak = b
+	}
+}
+
+// MissingBackendGrace keeps the tunnels of a deleted backend service
+// registered for the duration before removing them, answering with 503
+// or the fallback origin meanwhile. A duration of 0 removes them at once.
+func MissingBackendGrace(d time.Duration) Option {
+	return func(o *options) {
+		o.missingBackendGrace = d
+	}
+}
+
+// OnlyIngress restricts the controller to a single ingress, a debugging
+// aid not meant for production
+func OnlyIngress(name, namespace string) Option {
+	return func(o *options) {
+		if len(name) > 0 && len(namespace) > 0 {
+			o.onlyIngress = &resource{
+				name:      name,
+				namespace: namespace,
+			}
+		}
+	}
+}
+
+// ReconcileDelay bounds the delay before a failed reconcile is retried,
+// starting at base and doubling on each failure of the item up to max
+func ReconcileDelay(base, max time.Duration) Option {
+	return func(o *options) {
+		o.reconcileBaseDelay = base
+		o.reconcileMaxDelay = max
+	}
+}
+
+// ReconcileTimeout bounds the period a reconcile runs before it is
+// canceled, the item is requeued as a failure, 0 waits forever
+func ReconcileTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.reconcileTimeout = d
+	}
+}
+
+// ResyncBatch spreads the items enqueued in a burst, as by a resync, into
+// batches of size released every interval, a size of 0 disables batching
+func ResyncBatch(size int, interval time.Duration) Option {
+	return func(o *options) {
+		o.resyncBatchSize = size
+		o.resyncBatchInterval = interval
+	}
+}
+
 // ResyncPeriod defines the duration prior to synchronization
 func ResyncPeriod(d time.Duration) Option {
 	return func(o *options) {
@@ -55,6 +317,13 @@ func RequeueLimit(i int) Option {
 	}
 }
 
+// RequireTLSBlock restricts tunnels to the hosts listed in the ingress tls section
+func RequireTLSBlock(b bool) Option {
+	return func(o *options) {
+		o.requireTLS = b
+	}
+}
+
 // Secret defines the default secret used by tunnels
 func Secret(name, namespace string) Option {
 	return func(o *options) {
@@ -106,6 +375,48 @@ func SecretGroups(v cloudflare.OriginSecrets) Option {
 	}
 }
 
+// SecurityTxt defines the /.well-known/security.txt served on every host
+// whose origin does not serve its own
+func SecurityTxt(s string) Option {
+	return func(o *options) {
+		o.securityTxt = s
+	}
+}
+
+// Shard restricts the controller to the hosts owned by the shard index
+func Shard(index, count int) Option {
+	return func(o *options) {
+		if count > 0 {
+			o.shard = &shard{
+				Index: index,
+				Count: count,
+			}
+		}
+	}
+}
+
+// StaleWatchThreshold defines the duration an informer may go without a
+// list or watch event before the controller is unhealthy, 0 disables the check
+func StaleWatchThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.staleWatchThreshold = d
+	}
+}
+
+// StatusClient records the ingress reconcile results as IngressStatus resources
+func StatusClient(client dynamic.Interface) Option {
+	return func(o *options) {
+		o.statusClient = client
+	}
+}
+
+// UpstreamMode defines how requests reach the origin when an ingress omits it
+func UpstreamMode(s string) Option {
+	return func(o *options) {
+		o.upstreamMode = s
+	}
+}
+
 // WatchNamespace restricts Ingress, Secret, and Service monitoring
 func WatchNamespace(s string) Option {
 	return func(o *options) {
@@ -120,13 +431,45 @@ func Workers(i int) Option {
 	}
 }
 
+// defaultTunnelOptions derives the tunnel defaults from the controller options
+func defaultTunnelOptions(o options) (opts []tunnelOption) {
+	if o.compressionQuality > 0 && o.compressionQuality <= CompressionQualityMax {
+		opts = append(opts, compressionQuality(o.compressionQuality))
+	}
+	if len(o.defaultProto) > 0 {
+		opts = append(opts, proto(o.defaultProto))
+	}
+	if len(o.edgeAddrs) > 0 {
+		opts = append(opts, edgeAddrs(strings.Join(o.edgeAddrs, ",")))
+	}
+	if len(o.securityTxt) > 0 {
+		opts = append(opts, securityTxt(o.securityTxt))
+	}
+	if len(o.upstreamMode) > 0 {
+		opts = append(opts, upstreamMode(o.upstreamMode))
+	}
+	return
+}
+
 func collectOptions(opts []Option) options {
 	// set defaults
 	o := options{
-		ingressClass: IngressClassDefault,
-		resyncPeriod: ResyncPeriodDefault,
-		requeueLimit: RequeueLimitDefault,
-		workers:      WorkersDefault,
+		duplicatePolicy:     DuplicateControllerPolicyDefault,
+		edgeProtocol:        EdgeProtocolDefault,
+		eventComponent:      EventComponentDefault,
+		historySize:         HistorySizeDefault,
+		ingressClass:        IngressClassDefault,
+		keepCredential:      KeepCredentialOnRefreshFailureDefault,
+		makeBeforeBreak:     MakeBeforeBreakDefault,
+		missingBackendGrace: MissingBackendGraceDefault,
+		reconcileBaseDelay:  ReconcileBaseDelayDefault,
+		reconcileMaxDelay:   ReconcileMaxDelayDefault,
+		reconcileTimeout:    ReconcileTimeoutDefault,
+		resyncBatchInterval: ResyncBatchIntervalDefault,
+		resyncPeriod:        ResyncPeriodDefault,
+		requeueLimit:        RequeueLimitDefault,
+		staleWatchThreshold: StaleWatchThresholdDefault,
+		workers:             WorkersDefault,
 	}
 	// overlay values
 	for _, opt := range opts {
@@ -136,6 +479,9 @@ func collectOptions(opts []Option) options {
 }
 
 const (
+	// CompressionQualityMax defines the highest cross-stream compression quality
+	CompressionQualityMax = uint64(3)
+
 	// haConnectionsDefault defines the default high-availability connections
 	haConnectionsDefault = 4
 	// heartbeatCountDefault defines the default heartbeat count
@@ -150,15 +496,41 @@ const (
 )
 
 type tunnelOptions struct {
-	compressionQuality uint64
-	gracePeriod        time.Duration
-	haConnections      int
-	heartbeatCount     uint64
-	heartbeatInterval  time.Duration
-	lbPool             string
-	noChunkedEncoding  bool
-	retries            uint
-	tags               string
+	compressionQuality    uint64
+	edgeAddrs             string
+	gracePeriod           time.Duration
+	haConnections         int
+	heartbeatCount        uint64
+	heartbeatInterval     time.Duration
+	fallbackOrigin        string
+	initialDelay          time.Duration
+	injectOverride        bool
+	injectRobots          string
+	unreadyEndpoints      string
+	lbPool                string
+	maintenance           bool
+	maintenanceBody       string
+	maintenanceStatus     int
+	maxBodyBytes          uint64
+	maxConcurrentRequests uint64
+	noChunkedEncoding     bool
+	noForwardedHeaders    bool
+	originScheme          string
+	pathPattern           string
+	pathPrefix            string
+	proto                 string
+	readinessPath         string
+	redirectCode          int
+	redirectURL           string
+	responseHeaders       string
+	retries               uint
+	rewriteTarget         string
+	securityTxt           string
+	streamBufferBytes     uint64
+	tags                  string
+	targetService         string
+	trailingSlashRedirect bool
+	upstreamMode          string
 }
 
 type tunnelOption func(*tunnelOptions)
@@ -169,12 +541,40 @@ func compressionQuality(i uint64) tunnelOption {
 	}
 }
 
+// fallbackOrigin defines the '<host>:<port>' serving requests the origin
+// cannot be reached for
+func fallbackOrigin(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.fallbackOrigin = s
+	}
+}
+
+// initialDelay holds the first registration of a new host for the
+// duration since the ingress creation
+func initialDelay(d time.Duration) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.initialDelay = d
+	}
+}
+
 func disableChunkedEncoding(b bool) tunnelOption {
 	return func(o *tunnelOptions) {
 		o.noChunkedEncoding = b
 	}
 }
 
+func disableForwardedHeaders(b bool) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.noForwardedHeaders = b
+	}
+}
+
+func edgeAddrs(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.edgeAddrs = s
+	}
+}
+
 func gracePeriod(d time.Duration) tunnelOption {
 	return func(o *tunnelOptions) {
 		o.gracePeriod = d
@@ -193,6 +593,15 @@ func heartbeatCount(i uint64) tunnelOption {
 	}
 }
 
+func includeUnreadyEndpoints(b bool) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.unreadyEndpoints = unreadyEndpointsExclude
+		if b {
+			o.unreadyEndpoints = unreadyEndpointsInclude
+		}
+	}
+}
+
 func heartbeatInterval(d time.Duration) tunnelOption {
 	return func(o *tunnelOptions) {
 		o.heartbeatInterval = d
@@ -205,18 +614,144 @@ func lbPool(s string) tunnelOption {
 	}
 }
 
+// injectOverride serves the injected files without asking the origin
+func injectOverride(b bool) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.injectOverride = b
+	}
+}
+
+// injectRobots serves the robots.txt of the policy
+func injectRobots(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.injectRobots = s
+	}
+}
+
+func maintenance(b bool) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.maintenance = b
+	}
+}
+
+func maintenanceResponse(status int, body string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.maintenanceStatus = status
+		o.maintenanceBody = body
+	}
+}
+
+// maxConcurrentRequests caps the requests in flight to the origin
+func maxConcurrentRequests(i uint64) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.maxConcurrentRequests = i
+	}
+}
+
+func maxBodyBytes(i uint64) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.maxBodyBytes = i
+	}
+}
+
+func streamBufferBytes(i uint64) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.streamBufferBytes = i
+	}
+}
+
+// pathPattern serves the paths matching the regular expression, its
+// capture groups are referenced by the rewrite target
+// originScheme sets the origin scheme over the proto annotation and the
+// appProtocol of the service port
+func originScheme(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.originScheme = s
+	}
+}
+
+func pathPattern(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.pathPattern = s
+	}
+}
+
+func pathPrefix(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.pathPrefix = s
+	}
+}
+
+func proto(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.proto = s
+	}
+}
+
+// redirect answers every request with a redirect to the url, the origin
+// is not dialed
+func redirect(code int, url string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.redirectCode = code
+		o.redirectURL = url
+	}
+}
+
+func readinessPath(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.readinessPath = s
+	}
+}
+
+// responseHeaders sets the 'Name: value' header lines on origin responses
+func responseHeaders(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.responseHeaders = s
+	}
+}
+
+func securityTxt(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.securityTxt = s
+	}
+}
+
 func retries(i uint) tunnelOption {
 	return func(o *tunnelOptions) {
 		o.retries = i
 	}
 }
 
+func rewriteTarget(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.rewriteTarget = s
+	}
+}
+
 func tags(s string) tunnelOption {
 	return func(o *tunnelOptions) {
 		o.tags = s
 	}
 }
 
+func targetService(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.targetService = s
+	}
+}
+
+func trailingSlashRedirect(b bool) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.trailingSlashRedirect = b
+	}
+}
+
+func upstreamMode(s string) tunnelOption {
+	return func(o *tunnelOptions) {
+		o.upstreamMode = s
+	}
+}
+
 func collectTunnelOptions(opts []tunnelOption) tunnelOptions {
 	// set defaults
 	o := tunnelOptions{
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/cloudflare/cloudflare-ingress-controller/internal/cloudflare"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,10 +18,22 @@ func TestOptions(t *testing.T) {
 		"default-options": {
 			in: []Option{},
 			out: options{
-				ingressClass: IngressClassDefault,
-				resyncPeriod: ResyncPeriodDefault,
-				requeueLimit: RequeueLimitDefault,
-				workers:      WorkersDefault,
+				duplicatePolicy:     DuplicateControllerPolicyDefault,
+				edgeProtocol:        EdgeProtocolDefault,
+				eventComponent:      EventComponentDefault,
+				historySize:         HistorySizeDefault,
+				ingressClass:        IngressClassDefault,
+				keepCredential:      KeepCredentialOnRefreshFailureDefault,
+				makeBeforeBreak:     MakeBeforeBreakDefault,
+				missingBackendGrace: MissingBackendGraceDefault,
+				reconcileBaseDelay:  ReconcileBaseDelayDefault,
+				reconcileMaxDelay:   ReconcileMaxDelayDefault,
+				reconcileTimeout:    ReconcileTimeoutDefault,
+				resyncBatchInterval: ResyncBatchIntervalDefault,
+				resyncPeriod:        ResyncPeriodDefault,
+				requeueLimit:        RequeueLimitDefault,
+				staleWatchThreshold: StaleWatchThresholdDefault,
+				workers:             WorkersDefault,
 			},
 		},
 		"set-one-option": {
@@ -28,10 +41,22 @@ func TestOptions(t *testing.T) {
 				IngressClass("test-class"),
 			},
 			out: options{
-				ingressClass: "test-class",
-				resyncPeriod: ResyncPeriodDefault,
-				requeueLimit: RequeueLimitDefault,
-				workers:      WorkersDefault,
+				duplicatePolicy:     DuplicateControllerPolicyDefault,
+				edgeProtocol:        EdgeProtocolDefault,
+				eventComponent:      EventComponentDefault,
+				historySize:         HistorySizeDefault,
+				ingressClass:        "test-class",
+				keepCredential:      KeepCredentialOnRefreshFailureDefault,
+				makeBeforeBreak:     MakeBeforeBreakDefault,
+				missingBackendGrace: MissingBackendGraceDefault,
+				reconcileBaseDelay:  ReconcileBaseDelayDefault,
+				reconcileMaxDelay:   ReconcileMaxDelayDefault,
+				reconcileTimeout:    ReconcileTimeoutDefault,
+				resyncBatchInterval: ResyncBatchIntervalDefault,
+				resyncPeriod:        ResyncPeriodDefault,
+				requeueLimit:        RequeueLimitDefault,
+				staleWatchThreshold: StaleWatchThresholdDefault,
+				workers:             WorkersDefault,
 			},
 		},
 		"set-secret-default-from-groups": {
@@ -52,19 +77,55 @@ func TestOptions(t *testing.T) {
 				}),
 			},
 			out: options{
-				ingressClass: IngressClassDefault,
-				resyncPeriod: ResyncPeriodDefault,
-				requeueLimit: RequeueLimitDefault,
-				secret:       &resource{"test-secret-name-b", "test-secret-namespace-b"},
-				workers:      WorkersDefault,
+				duplicatePolicy:     DuplicateControllerPolicyDefault,
+				edgeProtocol:        EdgeProtocolDefault,
+				eventComponent:      EventComponentDefault,
+				historySize:         HistorySizeDefault,
+				ingressClass:        IngressClassDefault,
+				keepCredential:      KeepCredentialOnRefreshFailureDefault,
+				makeBeforeBreak:     MakeBeforeBreakDefault,
+				missingBackendGrace: MissingBackendGraceDefault,
+				reconcileBaseDelay:  ReconcileBaseDelayDefault,
+				reconcileMaxDelay:   ReconcileMaxDelayDefault,
+				reconcileTimeout:    ReconcileTimeoutDefault,
+				resyncBatchInterval: ResyncBatchIntervalDefault,
+				resyncPeriod:        ResyncPeriodDefault,
+				requeueLimit:        RequeueLimitDefault,
+				secret:              &resource{"test-secret-name-b", "test-secret-namespace-b"},
+				staleWatchThreshold: StaleWatchThresholdDefault,
+				workers:             WorkersDefault,
 			},
 		},
 		"set-all-options": {
 			in: []Option{
+				AdoptUnclassedIngresses(true),
+				AllowMissingBackend(true),
+				RequireTLSBlock(true),
+				CompressionQuality(2),
+				ControllerID("test-namespace/argo-tunnel", "test-lease-namespace"),
+				DefaultHostname("default.test.com"),
+				DefaultProto("https"),
+				DuplicateControllerPolicy("refuse"),
+				EdgeAddrs([]string{"edge-a.test.com:7844", "edge-b.test.com:7844"}),
+				EdgeProtocol("h2mux"),
+				EventComponent("argot-test"),
+				HistorySize(5),
 				IngressClass("test-class"),
+				KeepCredentialOnRefreshFailure(false),
+				LogFields(logrus.Fields{"deployment": "test"}),
+				MakeBeforeBreak(false),
+				MissingBackendGrace(30 * time.Second),
+				OnlyIngress("test-ingress-name", "test-ingress-namespace"),
+				ReconcileDelay(time.Second, 5*time.Minute),
+				ReconcileTimeout(time.Minute),
+				ResyncBatch(100, 2*time.Second),
 				ResyncPeriod(1 * time.Minute),
 				RequeueLimit(-1),
 				Secret("test-secret-name", "test-secret-namespace"),
+				SecurityTxt("Contact: mailto:security@test.com\n"),
+				Shard(1, 3),
+				StaleWatchThreshold(1 * time.Minute),
+				UpstreamMode("endpoint"),
 				SecretGroups(cloudflare.OriginSecrets{
 					Groups: []cloudflare.OriginSecretGroup{
 						{
@@ -84,10 +145,35 @@ func TestOptions(t *testing.T) {
 				Workers(2),
 			},
 			out: options{
-				ingressClass: "test-class",
-				resyncPeriod: 1 * time.Minute,
-				requeueLimit: -1,
-				secret:       &resource{"test-secret-name", "test-secret-namespace"},
+				adoptUnclassed:      true,
+				allowMissing:        true,
+				requireTLS:          true,
+				compressionQuality:  2,
+				controllerID:        "test-namespace/argo-tunnel",
+				defaultHostname:     "default.test.com",
+				defaultProto:        "https",
+				duplicatePolicy:     "refuse",
+				edgeAddrs:           []string{"edge-a.test.com:7844", "edge-b.test.com:7844"},
+				edgeProtocol:        "h2mux",
+				eventComponent:      "argot-test",
+				historySize:         5,
+				ingressClass:        "test-class",
+				leaseNamespace:      "test-lease-namespace",
+				logFields:           logrus.Fields{"deployment": "test"},
+				missingBackendGrace: 30 * time.Second,
+				onlyIngress:         &resource{"test-ingress-name", "test-ingress-namespace"},
+				reconcileBaseDelay:  time.Second,
+				reconcileMaxDelay:   5 * time.Minute,
+				reconcileTimeout:    time.Minute,
+				resyncBatchInterval: 2 * time.Second,
+				resyncBatchSize:     100,
+				resyncPeriod:        1 * time.Minute,
+				requeueLimit:        -1,
+				secret:              &resource{"test-secret-name", "test-secret-namespace"},
+				securityTxt:         "Contact: mailto:security@test.com\n",
+				shard:               &shard{Index: 1, Count: 3},
+				staleWatchThreshold: 1 * time.Minute,
+				upstreamMode:        "endpoint",
 				originSecrets: map[string]*resource{
 					"abc.test.com": {"test-secret-name", "test-secret-namespace"},
 					"xyz.test.com": {"test-secret-name", "test-secret-namespace"},
@@ -135,24 +221,59 @@ func TestTunnelOptions(t *testing.T) {
 			in: []tunnelOption{
 				compressionQuality(8),
 				disableChunkedEncoding(true),
+				disableForwardedHeaders(true),
+				edgeAddrs("edge.test.com:7844"),
 				gracePeriod(100 * time.Millisecond),
 				haConnections(8),
 				heartbeatCount(100),
 				heartbeatInterval(100 * time.Millisecond),
+				includeUnreadyEndpoints(false),
+				injectOverride(true),
+				injectRobots(robotsDisallowAll),
 				lbPool("test-lb"),
+				maintenance(true),
+				maintenanceResponse(200, "<h1>unit</h1>"),
+				maxBodyBytes(1024),
+				pathPrefix("/app/"),
+				proto("https"),
+				readinessPath("/healthz"),
 				retries(100),
+				rewriteTarget("/"),
+				securityTxt("Contact: mailto:security@test.com\n"),
+				streamBufferBytes(4096),
 				tags("key1=val1"),
+				targetService("test-deploy"),
+				trailingSlashRedirect(true),
+				upstreamMode("endpoint"),
 			},
 			out: tunnelOptions{
-				compressionQuality: 8,
-				noChunkedEncoding:  true,
-				gracePeriod:        100 * time.Millisecond,
-				haConnections:      8,
-				heartbeatCount:     100,
-				heartbeatInterval:  100 * time.Millisecond,
-				lbPool:             "test-lb",
-				retries:            100,
-				tags:               "key1=val1",
+				compressionQuality:    8,
+				noChunkedEncoding:     true,
+				noForwardedHeaders:    true,
+				edgeAddrs:             "edge.test.com:7844",
+				gracePeriod:           100 * time.Millisecond,
+				haConnections:         8,
+				heartbeatCount:        100,
+				heartbeatInterval:     100 * time.Millisecond,
+				injectOverride:        true,
+				injectRobots:          robotsDisallowAll,
+				lbPool:                "test-lb",
+				maintenance:           true,
+				maintenanceBody:       "<h1>unit</h1>",
+				maintenanceStatus:     200,
+				maxBodyBytes:          1024,
+				pathPrefix:            "/app/",
+				proto:                 "https",
+				readinessPath:         "/healthz",
+				retries:               100,
+				rewriteTarget:         "/",
+				securityTxt:           "Contact: mailto:security@test.com\n",
+				streamBufferBytes:     4096,
+				tags:                  "key1=val1",
+				targetService:         "test-deploy",
+				trailingSlashRedirect: true,
+				unreadyEndpoints:      unreadyEndpointsExclude,
+				upstreamMode:          "endpoint",
 			},
 		},
 	} {
@@ -160,3 +281,65 @@ func TestTunnelOptions(t *testing.T) {
 		assert.Equalf(t, test.out, out, "test '%s' options mismatch", name)
 	}
 }
+
+func TestDefaultTunnelOptions(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  options
+		out tunnelOptions
+	}{
+		"default-options": {
+			in:  collectOptions(nil),
+			out: collectTunnelOptions(nil),
+		},
+		"default-proto": {
+			in: collectOptions([]Option{
+				DefaultProto("https"),
+			}),
+			out: collectTunnelOptions([]tunnelOption{
+				proto("https"),
+			}),
+		},
+		"default-edge-addrs": {
+			in: collectOptions([]Option{
+				EdgeAddrs([]string{"edge-a.test.com:7844", "edge-b.test.com:7844"}),
+			}),
+			out: collectTunnelOptions([]tunnelOption{
+				edgeAddrs("edge-a.test.com:7844,edge-b.test.com:7844"),
+			}),
+		},
+		"default-upstream-mode": {
+			in: collectOptions([]Option{
+				UpstreamMode("endpoint"),
+			}),
+			out: collectTunnelOptions([]tunnelOption{
+				upstreamMode("endpoint"),
+			}),
+		},
+		"default-compression-quality": {
+			in: collectOptions([]Option{
+				CompressionQuality(3),
+			}),
+			out: collectTunnelOptions([]tunnelOption{
+				compressionQuality(3),
+			}),
+		},
+		"default-security-txt": {
+			in: collectOptions([]Option{
+				SecurityTxt("Contact: mailto:security@test.com\n"),
+			}),
+			out: collectTunnelOptions([]tunnelOption{
+				securityTxt("Contact: mailto:security@test.com\n"),
+			}),
+		},
+		"default-compression-quality-out-of-range": {
+			in: collectOptions([]Option{
+				CompressionQuality(4),
+			}),
+			out: collectTunnelOptions(nil),
+		},
+	} {
+		out := collectTunnelOptions(defaultTunnelOptions(test.in))
+		assert.Equalf(t, test.out, out, "test '%s' options mismatch", name)
+	}
+}
@@ -0,0 +1,134 @@
+package argotunnel
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	policyReasonDenied     = "denied"
+	policyReasonNotAllowed = "not-allowed"
+)
+
+// HostnamePolicy restricts the hosts exposed through tunnels
+type HostnamePolicy struct {
+	allowed []*regexp.Regexp
+	denied  []*regexp.Regexp
+}
+
+// NewHostnamePolicy compiles the allowed and denied hostname patterns.
+// A pattern starting with '.' matches the domain and its subdomains,
+// any other pattern is an anchored regular expression.
+func NewHostnamePolicy(allowed, denied []string) (p *HostnamePolicy, err error) {
+	p = &HostnamePolicy{}
+	if p.allowed, err = compileHostnamePatterns(allowed); err != nil {
+		return nil, err
+	}
+	if p.denied, err = compileHostnamePatterns(denied); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Allows verifies the host satisfies the policy, returning the
+// reason on rejection.
+func (p *HostnamePolicy) Allows(host string) (ok bool, reason string) {
+	if p == nil {
+		return true, ""
+	}
+	for _, re := range p.denied {
+		if re.MatchString(host) {
+			return false, policyReasonDenied
+		}
+	}
+	if len(p.allowed) == 0 {
+		return true, ""
+	}
+	for _, re := range p.allowed {
+		if re.MatchString(host) {
+			return true, ""
+		}
+	}
+	return false, policyReasonNotAllowed
+}
+
+func compileHostnamePatterns(patterns []string) (res []*regexp.Regexp, err error) {
+	for _, pattern := range patterns {
+		expr := pattern
+		if strings.HasPrefix(pattern, ".") {
+			expr = `(.+\.)?` + regexp.QuoteMeta(pattern[1:])
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("hostname pattern %q is invalid: %v", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return
+}
+
+// policyRejectionTracker records the hosts of an ingress rejected by the
+// policy, so a rejection is reported once per generation of the ingress
+// rather than on every resync
+type policyRejectionTracker struct {
+	mu    sync.Mutex
+	items map[string]rejectedHosts
+}
+
+type rejectedHosts struct {
+	generation int64
+	hosts      map[string]bool
+}
+
+func newPolicyRejectionTracker() *policyRejectionTracker {
+	return &policyRejectionTracker{
+		items: map[string]rejectedHosts{},
+	}
+}
+
+// reject records the host rejected, true when it was not yet rejected in
+// this generation of the ingress. A nil tracker reports every rejection.
+func (r *policyRejectionTracker) reject(key string, generation int64, host string) bool {
+	if r == nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, ok := r.items[key]
+	if !ok || item.generation != generation {
+		item = rejectedHosts{generation: generation, hosts: map[string]bool{}}
+		r.items[key] = item
+	}
+	if item.hosts[host] {
+		return false
+	}
+	item.hosts[host] = true
+	return true
+}
+
+// allow clears the rejection of a host the policy now allows
+func (r *policyRejectionTracker) allow(key, host string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if item, ok := r.items[key]; ok {
+		delete(item.hosts, host)
+	}
+}
+
+// forget drops the rejections of a deleted ingress
+func (r *policyRejectionTracker) forget(key string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.items, key)
+}
@@ -0,0 +1,127 @@
+package argotunnel
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHostnamePolicy(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		allowed []string
+		denied  []string
+		err     error
+	}{
+		"policy-empty": {
+			allowed: nil,
+			denied:  nil,
+			err:     nil,
+		},
+		"policy-okay": {
+			allowed: []string{".unit.com", `[a-z]+\.test\.com`},
+			denied:  []string{"admin.unit.com"},
+			err:     nil,
+		},
+		"policy-invalid-allowed": {
+			allowed: []string{"(unit"},
+			err:     fmt.Errorf("hostname pattern %q is invalid: %v", "(unit", "error parsing regexp: missing closing ): `^(?:(unit)$`"),
+		},
+		"policy-invalid-denied": {
+			denied: []string{"[unit"},
+			err:    fmt.Errorf("hostname pattern %q is invalid: %v", "[unit", "error parsing regexp: missing closing ]: `[unit)$`"),
+		},
+	} {
+		_, err := NewHostnamePolicy(test.allowed, test.denied)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+	}
+}
+
+func TestHostnamePolicyAllows(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		allowed []string
+		denied  []string
+		host    string
+		ok      bool
+		reason  string
+	}{
+		"policy-empty": {
+			host: "a.unit.com",
+			ok:   true,
+		},
+		"policy-suffix-allowed": {
+			allowed: []string{".unit.com"},
+			host:    "a.b.unit.com",
+			ok:      true,
+		},
+		"policy-suffix-apex-allowed": {
+			allowed: []string{".unit.com"},
+			host:    "unit.com",
+			ok:      true,
+		},
+		"policy-suffix-not-allowed": {
+			allowed: []string{".unit.com"},
+			host:    "a.notunit.com",
+			ok:      false,
+			reason:  policyReasonNotAllowed,
+		},
+		"policy-regex-allowed": {
+			allowed: []string{`[a-z]+\.unit\.com`},
+			host:    "abc.unit.com",
+			ok:      true,
+		},
+		"policy-regex-anchored": {
+			allowed: []string{`[a-z]+\.unit\.com`},
+			host:    "abc.unit.com.evil.com",
+			ok:      false,
+			reason:  policyReasonNotAllowed,
+		},
+		"policy-denied": {
+			denied: []string{".internal.unit.com"},
+			host:   "a.internal.unit.com",
+			ok:     false,
+			reason: policyReasonDenied,
+		},
+		"policy-denied-wins": {
+			allowed: []string{".unit.com"},
+			denied:  []string{"admin.unit.com"},
+			host:    "admin.unit.com",
+			ok:      false,
+			reason:  policyReasonDenied,
+		},
+	} {
+		p, err := NewHostnamePolicy(test.allowed, test.denied)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		ok, reason := p.Allows(test.host)
+		assert.Equalf(t, test.ok, ok, "test '%s' condition mismatch", name)
+		assert.Equalf(t, test.reason, reason, "test '%s' reason mismatch", name)
+	}
+}
+
+func TestNilHostnamePolicyAllows(t *testing.T) {
+	t.Parallel()
+	var p *HostnamePolicy
+	ok, reason := p.Allows("a.unit.com")
+	assert.True(t, ok)
+	assert.Empty(t, reason)
+}
+
+func TestPolicyRejectionTracker(t *testing.T) {
+	t.Parallel()
+	r := newPolicyRejectionTracker()
+	assert.Truef(t, r.reject("unit/ing", 1, "a.unit.com"), "test 'first' reject mismatch")
+	assert.Falsef(t, r.reject("unit/ing", 1, "a.unit.com"), "test 'resync' reject mismatch")
+	assert.Truef(t, r.reject("unit/ing", 1, "b.unit.com"), "test 'other-host' reject mismatch")
+	assert.Truef(t, r.reject("unit/ing", 2, "a.unit.com"), "test 'generation' reject mismatch")
+
+	r.allow("unit/ing", "a.unit.com")
+	assert.Truef(t, r.reject("unit/ing", 2, "a.unit.com"), "test 'allowed' reject mismatch")
+
+	r.forget("unit/ing")
+	assert.Truef(t, r.reject("unit/ing", 2, "a.unit.com"), "test 'forgotten' reject mismatch")
+
+	var none *policyRejectionTracker
+	assert.Truef(t, none.reject("unit/ing", 1, "a.unit.com"), "test 'nil' reject mismatch")
+}
@@ -0,0 +1,90 @@
+package argotunnel
+
+import (
+	"sort"
+	"sync"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// initialQueue holds the items added during the initial sync, released
+// once the caches synced with the ingresses in priority order. Items
+// added after the release pass through.
+type initialQueue struct {
+	workqueue.RateLimitingInterface
+	mu       sync.Mutex
+	held     []interface{}
+	released bool
+}
+
+func newInitialQueue(q workqueue.RateLimitingInterface) *initialQueue {
+	return &initialQueue{
+		RateLimitingInterface: q,
+	}
+}
+
+func (q *initialQueue) Add(item interface{}) {
+	q.mu.Lock()
+	if !q.released {
+		q.held = append(q.held, item)
+		q.mu.Unlock()
+		return
+	}
+	q.mu.Unlock()
+	q.RateLimitingInterface.Add(item)
+}
+
+// release adds the held items, the ingress keys first from the highest
+// priority, ties and the other kinds in the order they were added
+func (q *initialQueue) release(priority func(key string) int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.released {
+		return
+	}
+	q.released = true
+
+	type held struct {
+		item     interface{}
+		ingress  bool
+		priority int
+	}
+	items := make([]held, len(q.held))
+	for i, item := range q.held {
+		items[i].item = item
+		if key, ok := item.(string); ok {
+			if kind, metakey, err := splitKindMetaKey(key); err == nil && kind == ingressKind {
+				items[i].ingress, items[i].priority = true, priority(metakey)
+			}
+		}
+	}
+	sort.SliceStable(items, func(a, b int) bool {
+		if items[a].ingress != items[b].ingress {
+			return items[a].ingress
+		}
+		return items[a].priority > items[b].priority
+	})
+	for _, h := range items {
+		q.RateLimitingInterface.Add(h.item)
+	}
+	q.held = nil
+}
+
+// ingressPriority reads the priority annotation of the cached ingress,
+// ingresses without one have priority 0
+func ingressPriority(store cache.Store) func(key string) int {
+	return func(key string) int {
+		obj, exists, err := store.GetByKey(key)
+		if err != nil || !exists {
+			return 0
+		}
+		ing, ok := obj.(*networkingv1.Ingress)
+		if !ok {
+			return 0
+		}
+		val, _ := parseMetaInt(ing, annotationIngressPriority)
+		return val
+	}
+}
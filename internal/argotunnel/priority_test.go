@@ -0,0 +1,90 @@
+package argotunnel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestInitialQueue(t *testing.T) {
+	t.Parallel()
+	priorities := map[string]int{
+		"unit/critical": 100,
+		"unit/low":      -1,
+		"unit/high":     10,
+	}
+	for name, test := range map[string]struct {
+		in  []string
+		out []string
+	}{
+		"empty": {
+			in:  []string{},
+			out: []string{},
+		},
+		"priority-order": {
+			in:  []string{"ingress/unit/low", "ingress/unit/plain", "ingress/unit/high", "ingress/unit/critical"},
+			out: []string{"ingress/unit/critical", "ingress/unit/high", "ingress/unit/plain", "ingress/unit/low"},
+		},
+		"ties-keep-order": {
+			in:  []string{"ingress/unit/b", "ingress/unit/a", "ingress/unit/high", "ingress/unit/c"},
+			out: []string{"ingress/unit/high", "ingress/unit/b", "ingress/unit/a", "ingress/unit/c"},
+		},
+		"other-kinds-last": {
+			in:  []string{"service/unit/svc", "ingress/unit/low", "secret/unit/sec", "ingress/unit/high"},
+			out: []string{"ingress/unit/high", "ingress/unit/low", "service/unit/svc", "secret/unit/sec"},
+		},
+	} {
+		q := newInitialQueue(workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()))
+		for _, key := range test.in {
+			q.Add(key)
+		}
+		assert.Equalf(t, 0, q.Len(), "test '%s' held mismatch", name)
+		q.release(func(key string) int { return priorities[key] })
+		q.Add("ingress/unit/after")
+
+		out := []string{}
+		for q.Len() > 0 {
+			item, _ := q.Get()
+			q.Done(item)
+			out = append(out, item.(string))
+		}
+		assert.Equalf(t, append(test.out, "ingress/unit/after"), out, "test '%s' order mismatch", name)
+		q.ShutDown()
+	}
+}
+
+func TestIngressPriority(t *testing.T) {
+	t.Parallel()
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for name, val := range map[string]string{
+		"high":    "10",
+		"low":     "-5",
+		"invalid": "urgent",
+	} {
+		store.Add(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "unit",
+				Name:        name,
+				Annotations: map[string]string{annotationIngressPriority: val},
+			},
+		})
+	}
+	store.Add(&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "plain"}})
+	for name, test := range map[string]struct {
+		in  string
+		out int
+	}{
+		"high":    {in: "unit/high", out: 10},
+		"low":     {in: "unit/low", out: -5},
+		"invalid": {in: "unit/invalid", out: 0},
+		"plain":   {in: "unit/plain", out: 0},
+		"missing": {in: "unit/missing", out: 0},
+	} {
+		out := ingressPriority(store)(test.in)
+		assert.Equalf(t, test.out, out, "test '%s' priority mismatch", name)
+	}
+}
@@ -0,0 +1,73 @@
+package argotunnel
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// ReadinessProbeTCP probes the origin by opening a tcp connection
+	ReadinessProbeTCP = "tcp"
+
+	readinessProbeInterval = 2 * time.Second
+	readinessProbeTimeout  = time.Second
+)
+
+// probeOrigin checks the origin is serving. A path probes the origin
+// with an http GET expecting a 2xx or 3xx response, 'tcp' probes the
+// origin with a tcp connection.
+func probeOrigin(originURL, path string, timeout time.Duration) (err error) {
+	if !strings.Contains(originURL, "://") {
+		originURL = ProtoHTTP + "://" + originURL
+	}
+	u, err := url.Parse(originURL)
+	if err != nil {
+		return
+	}
+
+	if path == ReadinessProbeTCP {
+		conn, err := net.DialTimeout("tcp", u.Host, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	transport := newLinkHTTPTransport()
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(u.Scheme + "://" + u.Host + path)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+		err = fmt.Errorf("readiness probe returned status %d", res.StatusCode)
+	}
+	return
+}
+
+// waitForOrigin probes the origin until it is serving, false when
+// stopped first
+func waitForOrigin(originURL, path string, interval time.Duration, stopCh <-chan struct{}) bool {
+	for {
+		if probeOrigin(originURL, path, readinessProbeTimeout) == nil {
+			return true
+		}
+		select {
+		case <-stopCh:
+			return false
+		case <-time.After(interval):
+		}
+	}
+}
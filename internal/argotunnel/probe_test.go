@@ -0,0 +1,81 @@
+package argotunnel
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeOrigin(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ready":
+			w.WriteHeader(http.StatusOK)
+		case "/moved":
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	for name, test := range map[string]struct {
+		origin string
+		path   string
+		ok     bool
+	}{
+		"http-ready": {
+			origin: srv.URL,
+			path:   "/ready",
+			ok:     true,
+		},
+		"http-redirect": {
+			origin: srv.URL,
+			path:   "/moved",
+			ok:     true,
+		},
+		"http-unavailable": {
+			origin: srv.URL,
+			path:   "/booting",
+			ok:     false,
+		},
+		"http-no-scheme": {
+			origin: strings.TrimPrefix(srv.URL, "http://"),
+			path:   "/ready",
+			ok:     true,
+		},
+		"tcp-open": {
+			origin: srv.URL,
+			path:   ReadinessProbeTCP,
+			ok:     true,
+		},
+		"tcp-closed": {
+			origin: "http://" + closedAddr,
+			path:   ReadinessProbeTCP,
+			ok:     false,
+		},
+	} {
+		err := probeOrigin(test.origin, test.path, time.Second)
+		assert.Equalf(t, test.ok, err == nil, "test '%s' probe mismatch: %v", name, err)
+	}
+}
+
+func TestWaitForOrigin(t *testing.T) {
+	t.Parallel()
+	stopCh := make(chan struct{})
+	close(stopCh)
+	assert.Falsef(t, waitForOrigin("http://127.0.0.1:1", ReadinessProbeTCP, time.Millisecond, stopCh), "test stopped wait mismatch")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	assert.Truef(t, waitForOrigin(srv.URL, "/", time.Millisecond, make(chan struct{})), "test ready wait mismatch")
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	networkingv1 "k8s.io/api/networking/v1"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloudflare/cloudflare-ingress-controller/internal/k8s"
+	"golang.org/x/time/rate"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -13,9 +16,74 @@ import (
 	"k8s.io/client-go/util/workqueue"
 )
 
-func queue(name string) workqueue.RateLimitingInterface {
-	l := workqueue.DefaultControllerRateLimiter()
-	return workqueue.NewNamedRateLimitingQueue(l, name)
+func queue(name string, base, max time.Duration) workqueue.RateLimitingInterface {
+	return workqueue.NewNamedRateLimitingQueue(rateLimiter(base, max), name)
+}
+
+// rateLimiter retries a failed item after an exponential delay from base
+// to max, all items share the overall bucket of the client-go default
+func rateLimiter(base, max time.Duration) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(base, max),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// batchQueue spreads the items added in a burst, as by the list of a
+// resync, into batches of size released every interval. Items added
+// outside a burst are released at once.
+type batchQueue struct {
+	workqueue.RateLimitingInterface
+	mu       sync.Mutex
+	size     int
+	interval time.Duration
+	now      func() time.Time
+	start    time.Time
+	count    int
+}
+
+// newBatchQueue batches the items added to the queue, a size of 0
+// returns the queue unchanged
+func newBatchQueue(q workqueue.RateLimitingInterface, size int, interval time.Duration) workqueue.RateLimitingInterface {
+	if size <= 0 || interval <= 0 {
+		return q
+	}
+	return &batchQueue{
+		RateLimitingInterface: q,
+		size:                  size,
+		interval:              interval,
+		now:                   time.Now,
+	}
+}
+
+func (q *batchQueue) Add(item interface{}) {
+	if d := q.delay(); d > 0 {
+		q.RateLimitingInterface.AddAfter(item, d)
+	} else {
+		q.RateLimitingInterface.Add(item)
+	}
+}
+
+// delay returns the wait before the batch of the next item, a burst
+// ends once its last batch is released
+func (q *batchQueue) delay() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	if now.Sub(q.start) >= time.Duration(q.count/q.size+1)*q.interval {
+		q.start, q.count = now, 0
+	}
+	batch := q.count / q.size
+	q.count++
+	if batch > 0 {
+		queueBatchedItems.Inc()
+	}
+	return q.start.Add(time.Duration(batch) * q.interval).Sub(now)
+}
+
+func newConfigMapEventHander(q workqueue.RateLimitingInterface) cache.ResourceEventHandler {
+	return newKindQueueEventHander(configMapKind, q)
 }
 
 func newEndpointEventHander(q workqueue.RateLimitingInterface) cache.ResourceEventHandler {
@@ -25,10 +93,60 @@ func newEndpointEventHander(q workqueue.RateLimitingInterface) cache.ResourceEve
 	}
 }
 
-func newIngressEventHander(q workqueue.RateLimitingInterface, ingclass string) cache.ResourceEventHandler {
-	return cache.FilteringResourceEventHandler{
-		FilterFunc: ingressFilterFunc(ingclass),
-		Handler:    newKindQueueEventHander(ingressKind, q),
+const (
+	// ingressSkipClassMismatch labels the ingresses skipped for claiming
+	// another class, or no class while unclassed ingresses are not adopted
+	ingressSkipClassMismatch = "class-mismatch"
+	// ingressSkipOtherShard labels the ingresses skipped for routing only
+	// hosts owned by other shards
+	ingressSkipOtherShard = "other-shard"
+)
+
+// newIngressEventHander queues the ingresses of the class routing a host
+// owned by the shard. An update moving every host of an ingress to other
+// shards is queued as a delete, so its routes are removed.
+func newIngressEventHander(q workqueue.RateLimitingInterface, ingclass string, adoptUnclassed bool, s *shard, defaultHostname string) cache.ResourceEventHandler {
+	classFilter := ingressFilterFunc(ingclass, adoptUnclassed)
+	shardFilter := ingressShardFilterFunc(s, defaultHostname)
+	return &skipCountingHandler{
+		ResourceEventHandler: cache.FilteringResourceEventHandler{
+			FilterFunc: classFilter,
+			Handler: &skipCountingHandler{
+				ResourceEventHandler: cache.FilteringResourceEventHandler{
+					FilterFunc: shardFilter,
+					Handler:    newKindQueueEventHander(ingressKind, q),
+				},
+				filter: shardFilter,
+				reason: ingressSkipOtherShard,
+			},
+		},
+		filter: classFilter,
+		reason: ingressSkipClassMismatch,
+	}
+}
+
+// skipCountingHandler counts the added and updated objects excluded by
+// the filter, once per event. A resync updates every object, so an object
+// excluded for good is counted once per resync period.
+type skipCountingHandler struct {
+	cache.ResourceEventHandler
+	filter func(obj interface{}) bool
+	reason string
+}
+
+func (h *skipCountingHandler) OnAdd(obj interface{}) {
+	h.count(obj)
+	h.ResourceEventHandler.OnAdd(obj)
+}
+
+func (h *skipCountingHandler) OnUpdate(oldObj, newObj interface{}) {
+	h.count(newObj)
+	h.ResourceEventHandler.OnUpdate(oldObj, newObj)
+}
+
+func (h *skipCountingHandler) count(obj interface{}) {
+	if !h.filter(obj) {
+		ingressesSkipped.WithLabelValues(h.reason).Inc()
 	}
 }
 
@@ -43,6 +161,11 @@ func newServiceEventHander(q workqueue.RateLimitingInterface) cache.ResourceEven
 	return newKindQueueEventHander(serviceKind, q)
 }
 
+// resyncKinds are reconciled on every resync, other kinds only on change
+var resyncKinds = map[string]bool{
+	ingressKind: true,
+}
+
 func newKindQueueEventHander(kind string, q workqueue.RateLimitingInterface) cache.ResourceEventHandler {
 	return cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -54,7 +177,7 @@ func newKindQueueEventHander(kind string, q workqueue.RateLimitingInterface) cac
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			key, err := resourceKeyFunc(kind, newObj)
 			if err == nil {
-				if !equality.Semantic.DeepEqual(newObj, oldObj) {
+				if resyncKinds[kind] || !equality.Semantic.DeepEqual(newObj, oldObj) {
 					q.Add(key)
 				}
 			}
@@ -77,11 +200,31 @@ func endpointFilterFunc() func(obj interface{}) bool {
 	}
 }
 
-func ingressFilterFunc(ingressClass string) func(obj interface{}) bool {
+func ingressFilterFunc(ingressClass string, adoptUnclassed bool) func(obj interface{}) bool {
 	return func(obj interface{}) bool {
 		if ing, ok := obj.(*networkingv1.Ingress); ok {
-			if objIngressClass, ok := parseIngressClass(ing); ok {
-				return ingressClass == objIngressClass
+			return matchIngressClass(ing, ingressClass, adoptUnclassed)
+		}
+		return false
+	}
+}
+
+// ingressShardFilterFunc passes the ingresses with a rule host owned by
+// the shard, a nil shard passes every ingress
+func ingressShardFilterFunc(s *shard, defaultHostname string) func(obj interface{}) bool {
+	return func(obj interface{}) bool {
+		if s == nil {
+			return true
+		}
+		if ing, ok := obj.(*networkingv1.Ingress); ok {
+			for _, rule := range ing.Spec.Rules {
+				host := rule.Host
+				if len(host) == 0 {
+					host = getDefaultHost(ing, defaultHostname)
+				}
+				if len(host) > 0 && s.owns(host) {
+					return true
+				}
 			}
 		}
 		return false
@@ -5,11 +5,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 type unit struct {
@@ -86,11 +88,14 @@ func TestEndpointFilterFunc(t *testing.T) {
 	}
 }
 
+var unitIngressClass = "unit"
+
 func TestIngressFilterFunc(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
 		obj      interface{}
 		ingclass string
+		adopt    bool
 		out      bool
 	}{
 		"obj-nil": {
@@ -156,8 +161,73 @@ func TestIngressFilterFunc(t *testing.T) {
 			},
 			out: true,
 		},
+		"obj-ing-match-class-name": {
+			ingclass: "unit",
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+				},
+				Spec: networkingv1.IngressSpec{
+					IngressClassName: &unitIngressClass,
+				},
+			},
+			out: true,
+		},
+		"obj-ing-mismatch-class-name": {
+			ingclass: "argo",
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+				},
+				Spec: networkingv1.IngressSpec{
+					IngressClassName: &unitIngressClass,
+				},
+			},
+			out: false,
+		},
+		"obj-ing-adopt-no-class": {
+			ingclass: "unit",
+			adopt:    true,
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+				},
+			},
+			out: true,
+		},
+		"obj-ing-adopt-mismatch-class": {
+			ingclass: "unit",
+			adopt:    true,
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+					Annotations: map[string]string{
+						annotationIngressClass: "other",
+					},
+				},
+			},
+			out: false,
+		},
+		"obj-ing-adopt-mismatch-class-name": {
+			ingclass: "argo",
+			adopt:    true,
+			obj: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit",
+					Namespace: "unit",
+				},
+				Spec: networkingv1.IngressSpec{
+					IngressClassName: &unitIngressClass,
+				},
+			},
+			out: false,
+		},
 	} {
-		filterFunc := ingressFilterFunc(test.ingclass)
+		filterFunc := ingressFilterFunc(test.ingclass, test.adopt)
 		out := filterFunc(test.obj)
 		assert.Equalf(t, test.out, out, "test '%s' condition mismatch", name)
 	}
@@ -399,6 +469,268 @@ func TestSplitKindMetaKey(t *testing.T) {
 	}
 }
 
+func TestKindQueueEventHanderResync(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		kind    string
+		obj     interface{}
+		enqueue bool
+	}{
+		"resync-ingress": {
+			kind:    ingressKind,
+			obj:     &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "ing"}},
+			enqueue: true,
+		},
+		"resync-service": {
+			kind:    serviceKind,
+			obj:     &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "svc"}},
+			enqueue: false,
+		},
+	} {
+		q := &mockQueue{}
+		q.On("Add", mock.Anything).Return()
+		newKindQueueEventHander(test.kind, q).OnUpdate(test.obj, test.obj)
+		assert.Equalf(t, test.enqueue, len(q.Calls) > 0, "test '%s' enqueue mismatch", name)
+	}
+}
+
+func TestIngressEventHanderSkipped(t *testing.T) {
+	classed := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "unit",
+			Name:        "ing",
+			Annotations: map[string]string{annotationIngressClass: "unit"},
+		},
+	}
+	other := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "unit",
+			Name:        "ing",
+			Annotations: map[string]string{annotationIngressClass: "other"},
+		},
+	}
+	hosted := func(host string) *networkingv1.Ingress {
+		ing := classed.DeepCopy()
+		ing.Spec.Rules = []networkingv1.IngressRule{{Host: host}}
+		return ing
+	}
+	for name, test := range map[string]struct {
+		shard   *shard
+		event   func(h cache.ResourceEventHandler)
+		skipped float64
+		sharded float64
+		enqueue bool
+	}{
+		"add-matched": {
+			event:   func(h cache.ResourceEventHandler) { h.OnAdd(classed) },
+			enqueue: true,
+		},
+		"add-mismatched": {
+			event:   func(h cache.ResourceEventHandler) { h.OnAdd(other) },
+			skipped: 1,
+		},
+		"update-mismatched": {
+			event:   func(h cache.ResourceEventHandler) { h.OnUpdate(other, other) },
+			skipped: 1,
+		},
+		"update-class-removed": {
+			event:   func(h cache.ResourceEventHandler) { h.OnUpdate(classed, other) },
+			skipped: 1,
+			enqueue: true,
+		},
+		"delete-mismatched": {
+			event: func(h cache.ResourceEventHandler) { h.OnDelete(other) },
+		},
+		"add-shard-owned": {
+			shard:   &shard{Index: 0, Count: 2},
+			event:   func(h cache.ResourceEventHandler) { h.OnAdd(hosted("a.test.com")) },
+			enqueue: true,
+		},
+		"add-other-shard": {
+			shard:   &shard{Index: 0, Count: 2},
+			event:   func(h cache.ResourceEventHandler) { h.OnAdd(hosted("b.test.com")) },
+			sharded: 1,
+		},
+		"add-mismatched-other-shard": {
+			shard:   &shard{Index: 0, Count: 2},
+			event:   func(h cache.ResourceEventHandler) { h.OnAdd(other) },
+			skipped: 1,
+		},
+		"update-moved-to-other-shard": {
+			shard:   &shard{Index: 0, Count: 2},
+			event:   func(h cache.ResourceEventHandler) { h.OnUpdate(hosted("a.test.com"), hosted("b.test.com")) },
+			sharded: 1,
+			enqueue: true,
+		},
+	} {
+		q := &mockQueue{}
+		q.On("Add", mock.Anything).Return()
+		before := testutil.ToFloat64(ingressesSkipped.WithLabelValues(ingressSkipClassMismatch))
+		beforeSharded := testutil.ToFloat64(ingressesSkipped.WithLabelValues(ingressSkipOtherShard))
+		test.event(newIngressEventHander(q, "unit", false, test.shard, ""))
+		skipped := testutil.ToFloat64(ingressesSkipped.WithLabelValues(ingressSkipClassMismatch)) - before
+		sharded := testutil.ToFloat64(ingressesSkipped.WithLabelValues(ingressSkipOtherShard)) - beforeSharded
+		assert.Equalf(t, test.skipped, skipped, "test '%s' skipped mismatch", name)
+		assert.Equalf(t, test.sharded, sharded, "test '%s' sharded mismatch", name)
+		assert.Equalf(t, test.enqueue, len(q.Calls) > 0, "test '%s' enqueue mismatch", name)
+	}
+}
+
+func TestIngressShardFilterFunc(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		shard           *shard
+		defaultHostname string
+		in              interface{}
+		out             bool
+	}{
+		"no-shard": {
+			in: &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "b.test.com"}},
+				},
+			},
+			out: true,
+		},
+		"host-owned": {
+			shard: &shard{Index: 0, Count: 2},
+			in: &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "a.test.com"}},
+				},
+			},
+			out: true,
+		},
+		"host-other-shard": {
+			shard: &shard{Index: 0, Count: 2},
+			in: &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "b.test.com"}},
+				},
+			},
+		},
+		"one-host-owned": {
+			shard: &shard{Index: 0, Count: 2},
+			in: &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "b.test.com"}, {Host: "c.test.com"}},
+				},
+			},
+			out: true,
+		},
+		"default-hostname-owned": {
+			shard:           &shard{Index: 0, Count: 2},
+			defaultHostname: "default.test.com",
+			in: &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{}},
+				},
+			},
+			out: true,
+		},
+		"tls-host-other-shard": {
+			shard:           &shard{Index: 0, Count: 2},
+			defaultHostname: "default.test.com",
+			in: &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					TLS:   []networkingv1.IngressTLS{{Hosts: []string{"d.test.com"}}},
+					Rules: []networkingv1.IngressRule{{}},
+				},
+			},
+		},
+		"no-host": {
+			shard: &shard{Index: 0, Count: 2},
+			in: &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{}},
+				},
+			},
+		},
+		"not-ingress": {
+			shard: &shard{Index: 0, Count: 2},
+			in:    &v1.Service{},
+		},
+	} {
+		out := ingressShardFilterFunc(test.shard, test.defaultHostname)(test.in)
+		assert.Equalf(t, test.out, out, "test '%s' filter mismatch", name)
+	}
+}
+
+func TestBatchQueue(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, test := range map[string]struct {
+		size  int
+		adds  []time.Duration
+		delay []time.Duration
+	}{
+		"batch-disabled": {
+			size:  0,
+			adds:  []time.Duration{0, 0, 0},
+			delay: []time.Duration{0, 0, 0},
+		},
+		"batch-burst": {
+			size:  2,
+			adds:  []time.Duration{0, 0, 0, 0, 0},
+			delay: []time.Duration{0, 0, time.Second, time.Second, 2 * time.Second},
+		},
+		"batch-burst-elapsed": {
+			size:  2,
+			adds:  []time.Duration{0, 0, 0, 500 * time.Millisecond},
+			delay: []time.Duration{0, 0, time.Second, 500 * time.Millisecond},
+		},
+		"batch-burst-ended": {
+			size:  2,
+			adds:  []time.Duration{0, 0, 0, 2 * time.Second, 2 * time.Second},
+			delay: []time.Duration{0, 0, time.Second, 0, 0},
+		},
+	} {
+		q := &mockQueue{}
+		bq := newBatchQueue(q, test.size, time.Second)
+		now := start
+		if b, ok := bq.(*batchQueue); ok {
+			b.now = func() time.Time { return now }
+		}
+		for i, d := range test.adds {
+			now = start.Add(d)
+			if test.delay[i] > 0 {
+				q.On("AddAfter", i, test.delay[i]).Return().Once()
+			} else {
+				q.On("Add", i).Return().Once()
+			}
+			bq.Add(i)
+		}
+		assert.Truef(t, q.AssertExpectations(t), "test '%s' queue mismatch", name)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		base  time.Duration
+		max   time.Duration
+		delay []time.Duration
+	}{
+		"limiter-default": {
+			base:  ReconcileBaseDelayDefault,
+			max:   ReconcileMaxDelayDefault,
+			delay: []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond},
+		},
+		"limiter-bounded": {
+			base:  time.Second,
+			max:   3 * time.Second,
+			delay: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+	} {
+		l := rateLimiter(test.base, test.max)
+		for i, d := range test.delay {
+			assert.Equalf(t, d, l.When(name), "test '%s' delay %d mismatch", name, i)
+		}
+		l.Forget(name)
+		assert.Equalf(t, test.base, l.When(name), "test '%s' forget mismatch", name)
+	}
+}
+
 type mockQueue struct {
 	mock.Mock
 }
@@ -0,0 +1,71 @@
+package argotunnel
+
+import (
+	"sort"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// PolicyRules lists the api access needed by the controller: the
+// resources watched by its informers, the events it records, the
+// ingress classes listed to adopt unclassed ingresses, the
+// IngressStatus resources it writes, the ingresses it patches to drain a
+// host, and the leases claiming its ingress class.
+func PolicyRules(adoptUnclassed, ingressStatus, drain, classLease bool) (rules []rbacv1.PolicyRule) {
+	groups := map[string][]string{}
+	for _, gr := range informerResources {
+		groups[gr.Group] = append(groups[gr.Group], gr.Resource)
+	}
+	names := make([]string, 0, len(groups))
+	for group := range groups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+	for _, group := range names {
+		resources := groups[group]
+		sort.Strings(resources)
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{group},
+			Resources: resources,
+			Verbs:     []string{"list", "watch"},
+		})
+	}
+
+	rules = append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{v1.GroupName},
+		Resources: []string{"events"},
+		Verbs:     []string{"create", "patch"},
+	})
+	if adoptUnclassed {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{networkingv1.GroupName},
+			Resources: []string{"ingressclasses"},
+			Verbs:     []string{"list"},
+		})
+	}
+	if ingressStatus {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{ingressStatusResource.Group},
+			Resources: []string{ingressStatusResource.Resource},
+			Verbs:     []string{"get", "create", "update"},
+		})
+	}
+	if drain {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{networkingv1.GroupName},
+			Resources: []string{"ingresses"},
+			Verbs:     []string{"get", "patch"},
+		})
+	}
+	if classLease {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{coordinationv1.GroupName},
+			Resources: []string{"leases"},
+			Verbs:     []string{"get", "create", "update"},
+		})
+	}
+	return
+}
@@ -0,0 +1,109 @@
+package argotunnel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestPolicyRules(t *testing.T) {
+	t.Parallel()
+	watched := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps", "endpoints", "secrets", "services"},
+			Verbs:     []string{"list", "watch"},
+		},
+		{
+			APIGroups: []string{"networking.k8s.io"},
+			Resources: []string{"ingresses"},
+			Verbs:     []string{"list", "watch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"create", "patch"},
+		},
+	}
+	classes := rbacv1.PolicyRule{
+		APIGroups: []string{"networking.k8s.io"},
+		Resources: []string{"ingressclasses"},
+		Verbs:     []string{"list"},
+	}
+	statuses := rbacv1.PolicyRule{
+		APIGroups: []string{"argo.cloudflare.com"},
+		Resources: []string{"ingressstatuses"},
+		Verbs:     []string{"get", "create", "update"},
+	}
+	drains := rbacv1.PolicyRule{
+		APIGroups: []string{"networking.k8s.io"},
+		Resources: []string{"ingresses"},
+		Verbs:     []string{"get", "patch"},
+	}
+	leases := rbacv1.PolicyRule{
+		APIGroups: []string{"coordination.k8s.io"},
+		Resources: []string{"leases"},
+		Verbs:     []string{"get", "create", "update"},
+	}
+	for name, test := range map[string]struct {
+		adoptUnclassed bool
+		ingressStatus  bool
+		drain          bool
+		classLease     bool
+		out            []rbacv1.PolicyRule
+	}{
+		"rules-default": {
+			out: watched,
+		},
+		"rules-adopt-unclassed": {
+			adoptUnclassed: true,
+			out:            append(append([]rbacv1.PolicyRule{}, watched...), classes),
+		},
+		"rules-ingress-status": {
+			ingressStatus: true,
+			out:           append(append([]rbacv1.PolicyRule{}, watched...), statuses),
+		},
+		"rules-drain": {
+			drain: true,
+			out:   append(append([]rbacv1.PolicyRule{}, watched...), drains),
+		},
+		"rules-class-lease": {
+			classLease: true,
+			out:        append(append([]rbacv1.PolicyRule{}, watched...), leases),
+		},
+		"rules-all": {
+			adoptUnclassed: true,
+			ingressStatus:  true,
+			drain:          true,
+			classLease:     true,
+			out:            append(append([]rbacv1.PolicyRule{}, watched...), classes, statuses, drains, leases),
+		},
+	} {
+		out := PolicyRules(test.adoptUnclassed, test.ingressStatus, test.drain, test.classLease)
+		assert.Equalf(t, test.out, out, "test '%s' rules mismatch", name)
+	}
+}
+
+func TestPolicyRulesInformers(t *testing.T) {
+	t.Parallel()
+	rules := PolicyRules(false, false, false, false)
+	for kind, gr := range informerResources {
+		granted := false
+		for _, rule := range rules {
+			if containsString(rule.APIGroups, gr.Group) && containsString(rule.Resources, gr.Resource) && containsString(rule.Verbs, "list") && containsString(rule.Verbs, "watch") {
+				granted = true
+			}
+		}
+		assert.Truef(t, granted, "test '%s' watch access mismatch", kind)
+	}
+}
+
+func containsString(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
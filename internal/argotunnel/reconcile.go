@@ -0,0 +1,103 @@
+package argotunnel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// ingressState is the desired state of an ingress, derived once per
+// reconcile and applied by each sub-reconciler on its own
+type ingressState struct {
+	ing    *networkingv1.Ingress
+	route  *tunnelRoute
+	cond   ingressCondition
+	events []ingressEvent
+	// requeue is the wait before the state changes on its own, e.g. the
+	// end of a drain
+	requeue time.Duration
+}
+
+// ingressEvent is an event raised while deriving the state of an ingress
+type ingressEvent struct {
+	eventtype string
+	reason    string
+	message   string
+}
+
+func newIngressState(ing *networkingv1.Ingress) *ingressState {
+	return &ingressState{
+		ing:  ing,
+		cond: ingressCondition{reason: statusReasonReady},
+	}
+}
+
+// fail records the first failure of the reconcile as the condition
+func (s *ingressState) fail(reason, format string, args ...interface{}) {
+	if s.cond.reason == statusReasonReady {
+		s.cond = ingressCondition{reason: reason, message: fmt.Sprintf(format, args...)}
+	}
+}
+
+func (s *ingressState) eventf(eventtype, reason, messageFmt string, args ...interface{}) {
+	s.events = append(s.events, ingressEvent{
+		eventtype: eventtype,
+		reason:    reason,
+		message:   fmt.Sprintf(messageFmt, args...),
+	})
+}
+
+// reconcileStates applies the desired states of the ingresses affected by
+// a resource change. Each sub-reconciler runs whatever the others return,
+// with its own error policy: a tunnel failure requeues the key, a status
+// write retries on its own without requeueing, events are best effort.
+// A stuck status update never re-runs the tunnel reconcile. Nothing is
+// applied once ctx is done.
+func (t *syncTranslator) reconcileStates(ctx context.Context, kind, namespace, name string, states []*ingressState) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	err = t.reconcileTunnels(kind, namespace, name, states)
+	t.reconcileStatus(states)
+	t.reconcileEvents(states)
+	return
+}
+
+// reconcileTunnels brings the tunnels of the states up to date, an
+// error is returned to requeue the key
+func (t *syncTranslator) reconcileTunnels(kind, namespace, name string, states []*ingressState) (err error) {
+	routes := make([]*tunnelRoute, 0, len(states))
+	for _, s := range states {
+		routes = append(routes, s.route)
+	}
+	if kind == ingressKind && len(routes) == 1 {
+		err = t.router.updateRoute(routes[0])
+	} else {
+		err = t.router.updateByKindRoutes(kind, namespace, name, routes)
+	}
+	if err == nil {
+		for _, route := range routes {
+			reconciled(route)
+		}
+	}
+	return
+}
+
+// reconcileStatus records the conditions of the states, failed writes
+// are retried by the status writer
+func (t *syncTranslator) reconcileStatus(states []*ingressState) {
+	for _, s := range states {
+		t.status.write(s.ing.Namespace, s.ing.Name, s.ing.UID, s.cond)
+	}
+}
+
+// reconcileEvents records the events raised by the states
+func (t *syncTranslator) reconcileEvents(states []*ingressState) {
+	for _, s := range states {
+		for _, e := range s.events {
+			t.eventf(s.ing, e.eventtype, e.reason, "%s", e.message)
+		}
+	}
+}
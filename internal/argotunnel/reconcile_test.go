@@ -0,0 +1,139 @@
+package argotunnel
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// reconcileRouteEvents derives the route of an ingress and records the
+// events raised on the way
+func reconcileRouteEvents(tr *syncTranslator, ing *networkingv1.Ingress) *tunnelRoute {
+	state := tr.getIngressState(context.Background(), ing)
+	if state == nil {
+		return nil
+	}
+	tr.reconcileEvents([]*ingressState{state})
+	return state.route
+}
+
+func TestReconcileStates(t *testing.T) {
+	t.Parallel()
+	state := func(name string) *ingressState {
+		s := newIngressState(&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: name}})
+		s.route = &tunnelRoute{namespace: "unit", name: name, links: tunnelRouteLinkMap{}}
+		s.eventf(v1.EventTypeWarning, eventReasonRuleSkipped, "rule %d skipped", 0)
+		return s
+	}
+	for name, test := range map[string]struct {
+		kind   string
+		states []*ingressState
+		err    error
+		call   string
+	}{
+		"ingress-kind": {
+			kind:   ingressKind,
+			states: []*ingressState{state("ing-a")},
+			call:   "updateRoute",
+		},
+		"service-kind": {
+			kind:   serviceKind,
+			states: []*ingressState{state("ing-a"), state("ing-b")},
+			call:   "updateByKindRoutes",
+		},
+		"tunnel-failure": {
+			kind:   ingressKind,
+			states: []*ingressState{state("ing-a")},
+			err:    fmt.Errorf("short-circuit"),
+			call:   "updateRoute",
+		},
+	} {
+		logger, _ := logtest.NewNullLogger()
+		router := &mockTunnelRouter{}
+		router.On("updateRoute", mock.Anything).Return(test.err)
+		router.On("updateByKindRoutes", test.kind, "unit", "svc", mock.Anything).Return(test.err)
+		recorder := record.NewFakeRecorder(10)
+		tr := &syncTranslator{
+			router:   router,
+			recorder: recorder,
+			log:      logger,
+		}
+		err := tr.reconcileStates(context.Background(), test.kind, "unit", "svc", test.states)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+		router.AssertNumberOfCalls(t, test.call, 1)
+		// events are recorded whatever the tunnels return
+		assert.Equalf(t, len(test.states), len(recorder.Events), "test '%s' events mismatch", name)
+	}
+}
+
+func TestReconcileStatesCanceled(t *testing.T) {
+	t.Parallel()
+	logger, _ := logtest.NewNullLogger()
+	router := &mockTunnelRouter{}
+	tr := &syncTranslator{
+		router:   router,
+		recorder: record.NewFakeRecorder(10),
+		log:      logger,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	state := newIngressState(&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "ing-a"}})
+	state.route = &tunnelRoute{namespace: "unit", name: "ing-a", links: tunnelRouteLinkMap{}}
+	err := tr.reconcileStates(ctx, ingressKind, "unit", "ing-a", []*ingressState{state})
+	assert.Equalf(t, context.Canceled, err, "test canceled error mismatch")
+	router.AssertNotCalled(t, "updateRoute", mock.Anything)
+}
+
+func TestReconcileStatusFault(t *testing.T) {
+	t.Parallel()
+	var stuck int32 = 1
+	var attempts int32
+	client := newFakeStatusClient()
+	client.PrependReactor("create", ingressStatusResource.Resource, func(action clienttesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&attempts, 1)
+		if atomic.LoadInt32(&stuck) == 1 {
+			return true, nil, apierrors.NewConflict(ingressStatusResource.GroupResource(), "unit", fmt.Errorf("stuck"))
+		}
+		return false, nil, nil
+	})
+
+	router := &mockTunnelRouter{}
+	router.On("updateRoute", mock.Anything).Return(nil)
+	tr := newMockedSyncTranslator()
+	tr.router = router
+	tr.log, _ = logtest.NewNullLogger()
+	tr.status = newIngressStatusWriter(client, tr.log)
+	tr.status.retryBase = 5 * time.Millisecond
+	tr.status.retryMax = 20 * time.Millisecond
+
+	ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "unit", UID: "uid"}}
+	assert.Nilf(t, tr.updateIngress(context.Background(), "unit/unit", ing), "test stuck status error mismatch")
+	assert.Eventuallyf(t, func() bool {
+		return atomic.LoadInt32(&attempts) >= 3
+	}, time.Second, 5*time.Millisecond, "test stuck status retry mismatch")
+	router.AssertNumberOfCalls(t, "updateRoute", 1)
+
+	atomic.StoreInt32(&stuck, 0)
+	assert.Eventuallyf(t, func() bool {
+		_, err := client.Resource(ingressStatusResource).Namespace("unit").Get(context.TODO(), "unit", metav1.GetOptions{})
+		return err == nil
+	}, time.Second, 5*time.Millisecond, "test recovered status mismatch")
+	router.AssertNumberOfCalls(t, "updateRoute", 1)
+
+	tr.status.mu.Lock()
+	assert.Equalf(t, 0, len(tr.status.retries), "test recovered status retries mismatch")
+	tr.status.mu.Unlock()
+}
@@ -1,10 +1,29 @@
 package argotunnel
 
 const (
-	endpointKind = "endpoint"
-	ingressKind  = "ingress"
-	secretKind   = "secret"
-	serviceKind  = "service"
+	configMapKind = "configmap"
+	endpointKind  = "endpoint"
+	ingressKind   = "ingress"
+	secretKind    = "secret"
+	serviceKind   = "service"
+)
+
+const (
+	eventReasonHostRejected       = "HostRejected"
+	eventReasonRuleSkipped        = "RuleSkipped"
+	eventReasonClockSkewed        = "ClockSkewed"
+	eventReasonRedirectLoop       = "RedirectLoop"
+	eventReasonTunnelFailed       = "TunnelFailed"
+	eventReasonBackendMissing     = "BackendMissing"
+	eventReasonRouteRemoved       = "RouteRemoved"
+	eventReasonReadinessTimeout   = "ReadinessTimeout"
+	eventReasonAnnotationTooLarge = "AnnotationTooLarge"
+	eventReasonCredentialInvalid  = "CredentialInvalid"
+	eventReasonHostDrained        = "HostDrained"
+	eventReasonHostRestored       = "HostRestored"
+	eventReasonHostSuperseded     = "HostSuperseded"
+	eventReasonEdgeRegionRefused  = "EdgeRegionRefused"
+	eventReasonSecretDeleted      = "SecretDeleted"
 )
 
 type resource struct {
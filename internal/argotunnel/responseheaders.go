@@ -0,0 +1,86 @@
+package argotunnel
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// parseHeaderLines reads 'Name: value' lines, lines without a valid
+// header name or value are skipped
+func parseHeaderLines(s string) http.Header {
+	h := http.Header{}
+	for _, line := range strings.Split(s, "\n") {
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			continue
+		}
+		addHeader(h, strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]))
+	}
+	return h
+}
+
+// addHeader sets the header when its name and value are valid
+func addHeader(h http.Header, name, value string) bool {
+	if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+		return false
+	}
+	h.Set(name, value)
+	return true
+}
+
+// formatHeaderLines renders the headers as 'Name: value' lines in name
+// order, the comparable form kept in the tunnel options
+func formatHeaderLines(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		for _, value := range h[name] {
+			b.WriteString(name + ": " + value + "\n")
+		}
+	}
+	return b.String()
+}
+
+// headerNames lists the header names of 'Name: value' lines
+func headerNames(s string) (names []string) {
+	for name := range parseHeaderLines(s) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// responseHeadersRoundTripper sets headers on the origin responses,
+// replacing the values sent by the origin
+type responseHeadersRoundTripper struct {
+	next    http.RoundTripper
+	headers http.Header
+}
+
+func newResponseHeadersRoundTripper(next http.RoundTripper, options tunnelOptions) *responseHeadersRoundTripper {
+	return &responseHeadersRoundTripper{
+		next:    next,
+		headers: parseHeaderLines(options.responseHeaders),
+	}
+}
+
+func (rt *responseHeadersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := rt.next.RoundTrip(req)
+	if err != nil || res == nil {
+		return res, err
+	}
+	if res.Header == nil {
+		res.Header = http.Header{}
+	}
+	for name, values := range rt.headers {
+		res.Header[name] = append([]string(nil), values...)
+	}
+	return res, nil
+}
@@ -0,0 +1,172 @@
+package argotunnel
+
+import (
+	"net/http"
+	"testing"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseHeaderLines(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  string
+		out http.Header
+	}{
+		"empty": {
+			in:  "",
+			out: http.Header{},
+		},
+		"lines": {
+			in: "strict-transport-security: max-age=63072000; includeSubDomains\nX-Trace-Zone:eu \n",
+			out: http.Header{
+				"Strict-Transport-Security": {"max-age=63072000; includeSubDomains"},
+				"X-Trace-Zone":              {"eu"},
+			},
+		},
+		"invalid-lines": {
+			in: "no-colon\n: no-name\nbad name: x\nX-Ok: yes",
+			out: http.Header{
+				"X-Ok": {"yes"},
+			},
+		},
+	} {
+		out := parseHeaderLines(test.in)
+		assert.Equalf(t, test.out, out, "test '%s' headers mismatch", name)
+		assert.Equalf(t, test.out, parseHeaderLines(formatHeaderLines(out)), "test '%s' format mismatch", name)
+	}
+}
+
+func TestParseResponseHeaders(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in     string
+		inline http.Header
+		secret string
+		ok     bool
+	}{
+		"empty": {
+			in: " ",
+			ok: false,
+		},
+		"inline": {
+			in:     "X-Frame-Options: DENY",
+			inline: http.Header{"X-Frame-Options": {"DENY"}},
+			ok:     true,
+		},
+		"inline-invalid": {
+			in:     "bad name: x",
+			inline: http.Header{},
+			ok:     false,
+		},
+		"secret": {
+			in:     "security-headers",
+			secret: "security-headers",
+			ok:     true,
+		},
+	} {
+		inline, secret, ok := parseResponseHeaders(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: "test",
+				Annotations: map[string]string{
+					annotationIngressResponseHeaders: test.in,
+				},
+			},
+		})
+		assert.Equalf(t, test.inline, inline, "test '%s' inline mismatch", name)
+		assert.Equalf(t, test.secret, secret, "test '%s' secret mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' ok mismatch", name)
+	}
+}
+
+func TestGetResponseHeaders(t *testing.T) {
+	t.Parallel()
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "security-headers"},
+		Data: map[string][]byte{
+			"Strict-Transport-Security": []byte("max-age=63072000\n"),
+			"bad name":                  []byte("x"),
+		},
+	}
+	for name, test := range map[string]struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		"none": {
+			ok: false,
+		},
+		"inline": {
+			in:  "x-frame-options: DENY\nX-Trace-Zone: eu",
+			out: "X-Frame-Options: DENY\nX-Trace-Zone: eu\n",
+			ok:  true,
+		},
+		"secret": {
+			in:  "security-headers",
+			out: "Strict-Transport-Security: max-age=63072000\n",
+			ok:  true,
+		},
+		"secret-missing": {
+			in: "other-headers",
+			ok: false,
+		},
+	} {
+		logger, _ := logtest.NewNullLogger()
+		tr := &syncTranslator{
+			informers: informerset{
+				secret: newStaticInformer(new(v1.Secret), secret),
+			},
+			log: logger,
+		}
+		out, ok := tr.getResponseHeaders(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "unit",
+				Name:        "unit",
+				Annotations: map[string]string{annotationIngressResponseHeaders: test.in},
+			},
+		})
+		assert.Equalf(t, test.out, out, "test '%s' headers mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' ok mismatch", name)
+	}
+}
+
+func TestResponseHeadersRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		origin http.Header
+		out    http.Header
+	}{
+		"added": {
+			origin: http.Header{"Content-Type": {"text/plain"}},
+			out: http.Header{
+				"Content-Type":              {"text/plain"},
+				"Strict-Transport-Security": {"max-age=63072000"},
+			},
+		},
+		"replaced": {
+			origin: http.Header{"Strict-Transport-Security": {"max-age=0"}},
+			out: http.Header{
+				"Strict-Transport-Security": {"max-age=63072000"},
+			},
+		},
+		"no-origin-headers": {
+			out: http.Header{
+				"Strict-Transport-Security": {"max-age=63072000"},
+			},
+		},
+	} {
+		origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: test.origin.Clone()}, nil
+		})
+		rt := newResponseHeadersRoundTripper(origin, tunnelOptions{responseHeaders: "Strict-Transport-Security: max-age=63072000\n"})
+		req, _ := http.NewRequest(http.MethodGet, "http://unit.com", nil)
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.out, res.Header, "test '%s' headers mismatch", name)
+	}
+}
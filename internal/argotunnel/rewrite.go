@@ -0,0 +1,172 @@
+package argotunnel
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// rewriteCapture matches the capture group references of a rewrite target
+var rewriteCapture = regexp.MustCompile(`\$([0-9])`)
+
+// rewriteRoundTripper serves a host from a path prefix. Request paths are
+// normalized, paths outside the prefix are answered with 404, the prefix
+// is replaced by the rewrite target, and the bare prefix is optionally
+// redirected to its trailing-slash form. With a path pattern, the path is
+// replaced by the target expanded with the capture groups of the match.
+type rewriteRoundTripper struct {
+	next          http.RoundTripper
+	prefix        string
+	pattern       *regexp.Regexp
+	target        string
+	slashRedirect bool
+}
+
+func newRewriteRoundTripper(next http.RoundTripper, options tunnelOptions) *rewriteRoundTripper {
+	rt := &rewriteRoundTripper{
+		next:          next,
+		prefix:        strings.TrimSuffix(options.pathPrefix, "/"),
+		target:        options.rewriteTarget,
+		slashRedirect: options.trailingSlashRedirect,
+	}
+	if len(options.pathPattern) > 0 {
+		// the translator verified the pattern
+		rt.pattern = regexp.MustCompile(options.pathPattern)
+		rt.target = rewriteCapture.ReplaceAllString(options.rewriteTarget, "$${${1}}")
+	}
+	return rt
+}
+
+func (rt *rewriteRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := normalizePath(req.URL.Path)
+	if rt.pattern != nil {
+		m := rt.pattern.FindStringSubmatchIndex(p)
+		var expanded string
+		if m != nil {
+			expanded = string(rt.pattern.ExpandString(nil, rt.target, p, m))
+		}
+		// a capture must not climb out of the target, e.g. '/app..' as '/v2/..'
+		if m == nil || hasParentSegment(expanded) {
+			closeRequestBody(req)
+			return newSyntheticPageResponse(req, syntheticPage{
+				status:  http.StatusNotFound,
+				reason:  syntheticReasonNotFound,
+				message: "The requested path was not found.",
+			}), nil
+		}
+		r := req.Clone(req.Context())
+		r.URL.Path = normalizePath(expanded)
+		r.URL.RawPath = ""
+		return rt.next.RoundTrip(r)
+	}
+	rest, ok := matchPathPrefix(p, rt.prefix)
+	if !ok {
+		closeRequestBody(req)
+		return newSyntheticPageResponse(req, syntheticPage{
+			status:  http.StatusNotFound,
+			reason:  syntheticReasonNotFound,
+			message: "The requested path was not found.",
+		}), nil
+	}
+	if rt.slashRedirect && len(rt.prefix) > 0 && len(rest) == 0 {
+		closeRequestBody(req)
+		location := p + "/"
+		if len(req.URL.RawQuery) > 0 {
+			location += "?" + req.URL.RawQuery
+		}
+		return newSyntheticPageResponse(req, syntheticPage{
+			status:   http.StatusMovedPermanently,
+			reason:   syntheticReasonSlashRedirect,
+			location: location,
+		}), nil
+	}
+	if len(rt.target) > 0 {
+		p = joinRewritePath(rt.target, rest)
+	}
+
+	r := req.Clone(req.Context())
+	r.URL.Path = p
+	r.URL.RawPath = ""
+	return rt.next.RoundTrip(r)
+}
+
+// normalizePath resolves '.' and '..' elements and duplicate slashes,
+// keeping a trailing slash
+func normalizePath(p string) string {
+	if len(p) == 0 {
+		return "/"
+	}
+	n := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && n != "/" {
+		n += "/"
+	}
+	return n
+}
+
+// matchPathPrefix matches the prefix element-wise, '/app' matches '/app'
+// and '/app/x' but not '/apps', and returns the remaining path
+func matchPathPrefix(p, prefix string) (rest string, ok bool) {
+	if len(prefix) == 0 {
+		return p, true
+	}
+	if !strings.HasPrefix(p, prefix) {
+		return "", false
+	}
+	rest = p[len(prefix):]
+	if len(rest) > 0 && rest[0] != '/' {
+		return "", false
+	}
+	return rest, true
+}
+
+func hasParentSegment(p string) bool {
+	for _, s := range strings.Split(p, "/") {
+		if s == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// validRewriteCaptures verifies every '$' of a rewrite target references
+// a capture group, '$1' to '$9'
+func validRewriteCaptures(target string) bool {
+	return strings.Count(target, "$") == len(rewriteCapture.FindAllStringIndex(target, -1))
+}
+
+// hasRewriteCaptures reports whether a rewrite target references capture
+// groups of its path
+func hasRewriteCaptures(target string) bool {
+	return strings.Contains(target, "$")
+}
+
+// getPathPattern returns the regular expression matching the paths of a
+// rewrite target with capture groups, anchored to the start of the path.
+// Only the single implementation specific path of a host is a pattern.
+func getPathPattern(path networkingv1.HTTPIngressPath, paths int) (pattern string, ok bool) {
+	if paths > 1 {
+		return "", false
+	}
+	if path.PathType != nil && *path.PathType != networkingv1.PathTypeImplementationSpecific {
+		return "", false
+	}
+	p := path.Path
+	if len(p) == 0 {
+		p = "/"
+	}
+	pattern = "^(?:" + p + ")"
+	if _, err := regexp.Compile(pattern); err != nil {
+		return "", false
+	}
+	return pattern, true
+}
+
+func joinRewritePath(target, rest string) string {
+	if len(rest) == 0 {
+		return target
+	}
+	return strings.TrimSuffix(target, "/") + rest
+}
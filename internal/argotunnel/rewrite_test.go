@@ -0,0 +1,189 @@
+package argotunnel
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+func TestRewriteRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		opts     tunnelOptions
+		url      string
+		code     int
+		path     string
+		location string
+	}{
+		"strip-prefix": {
+			opts: tunnelOptions{pathPrefix: "/app/", rewriteTarget: "/"},
+			url:  "http://unit.com/app/static/main.js?v=1",
+			code: http.StatusOK,
+			path: "/static/main.js",
+		},
+		"strip-prefix-bare": {
+			opts: tunnelOptions{pathPrefix: "/app", rewriteTarget: "/"},
+			url:  "http://unit.com/app",
+			code: http.StatusOK,
+			path: "/",
+		},
+		"replace-prefix": {
+			opts: tunnelOptions{pathPrefix: "/app", rewriteTarget: "/v2/"},
+			url:  "http://unit.com/app/users/",
+			code: http.StatusOK,
+			path: "/v2/users/",
+		},
+		"add-prefix": {
+			opts: tunnelOptions{rewriteTarget: "/v2"},
+			url:  "http://unit.com/users",
+			code: http.StatusOK,
+			path: "/v2/users",
+		},
+		"prefix-element-mismatch": {
+			opts: tunnelOptions{pathPrefix: "/app", rewriteTarget: "/"},
+			url:  "http://unit.com/apps",
+			code: http.StatusNotFound,
+		},
+		"outside-prefix": {
+			opts: tunnelOptions{pathPrefix: "/app", rewriteTarget: "/"},
+			url:  "http://unit.com/admin",
+			code: http.StatusNotFound,
+		},
+		"traversal-outside-prefix": {
+			opts: tunnelOptions{pathPrefix: "/app", rewriteTarget: "/"},
+			url:  "http://unit.com/app/../admin",
+			code: http.StatusNotFound,
+		},
+		"encoded-traversal-outside-prefix": {
+			opts: tunnelOptions{pathPrefix: "/app", rewriteTarget: "/"},
+			url:  "http://unit.com/app/%2e%2e/admin",
+			code: http.StatusNotFound,
+		},
+		"traversal-inside-prefix": {
+			opts: tunnelOptions{pathPrefix: "/app", rewriteTarget: "/"},
+			url:  "http://unit.com/app/a/../b//c",
+			code: http.StatusOK,
+			path: "/b/c",
+		},
+		"slash-redirect": {
+			opts:     tunnelOptions{pathPrefix: "/app", rewriteTarget: "/", trailingSlashRedirect: true},
+			url:      "http://unit.com/app?q=1",
+			code:     http.StatusMovedPermanently,
+			location: "/app/?q=1",
+		},
+		"slash-redirect-with-slash": {
+			opts: tunnelOptions{pathPrefix: "/app", rewriteTarget: "/", trailingSlashRedirect: true},
+			url:  "http://unit.com/app/",
+			code: http.StatusOK,
+			path: "/",
+		},
+		"pattern-capture": {
+			opts: tunnelOptions{pathPattern: "^(?:/app(/|$)(.*))", rewriteTarget: "/$2"},
+			url:  "http://unit.com/app/static/main.js?v=1",
+			code: http.StatusOK,
+			path: "/static/main.js",
+		},
+		"pattern-capture-bare": {
+			opts: tunnelOptions{pathPattern: "^(?:/app(/|$)(.*))", rewriteTarget: "/$2"},
+			url:  "http://unit.com/app",
+			code: http.StatusOK,
+			path: "/",
+		},
+		"pattern-capture-adjacent": {
+			opts: tunnelOptions{pathPattern: "^(?:/api/(v[0-9])/(.*))", rewriteTarget: "/$1api/$2"},
+			url:  "http://unit.com/api/v2/users",
+			code: http.StatusOK,
+			path: "/v2api/users",
+		},
+		"pattern-capture-traversal": {
+			opts: tunnelOptions{pathPattern: "^(?:/app(.*))", rewriteTarget: "/v2/$1"},
+			url:  "http://unit.com/app../admin",
+			code: http.StatusNotFound,
+		},
+		"pattern-mismatch": {
+			opts: tunnelOptions{pathPattern: "^(?:/app(/|$)(.*))", rewriteTarget: "/$2"},
+			url:  "http://unit.com/admin",
+			code: http.StatusNotFound,
+		},
+	} {
+		var path string
+		rt := newRewriteRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			path = req.URL.Path
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, nil
+		}), test.opts)
+		req, _ := http.NewRequest(http.MethodGet, test.url, nil)
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.code, res.StatusCode, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.path, path, "test '%s' path mismatch", name)
+		assert.Equalf(t, test.location, res.Header.Get("Location"), "test '%s' location mismatch", name)
+	}
+}
+
+func TestGetPathPattern(t *testing.T) {
+	t.Parallel()
+	prefix, specific := networkingv1.PathTypePrefix, networkingv1.PathTypeImplementationSpecific
+	for name, test := range map[string]struct {
+		path    networkingv1.HTTPIngressPath
+		paths   int
+		pattern string
+		ok      bool
+	}{
+		"pattern-specific": {
+			path:    networkingv1.HTTPIngressPath{Path: "/app(/|$)(.*)", PathType: &specific},
+			paths:   1,
+			pattern: "^(?:/app(/|$)(.*))",
+			ok:      true,
+		},
+		"pattern-untyped": {
+			path:    networkingv1.HTTPIngressPath{Path: "/app/(.*)"},
+			paths:   1,
+			pattern: "^(?:/app/(.*))",
+			ok:      true,
+		},
+		"pattern-empty": {
+			path:    networkingv1.HTTPIngressPath{},
+			paths:   1,
+			pattern: "^(?:/)",
+			ok:      true,
+		},
+		"pattern-prefix": {
+			path:  networkingv1.HTTPIngressPath{Path: "/app", PathType: &prefix},
+			paths: 1,
+			ok:    false,
+		},
+		"pattern-many-paths": {
+			path:  networkingv1.HTTPIngressPath{Path: "/app/(.*)", PathType: &specific},
+			paths: 2,
+			ok:    false,
+		},
+		"pattern-invalid": {
+			path:  networkingv1.HTTPIngressPath{Path: "/app/(.*", PathType: &specific},
+			paths: 1,
+			ok:    false,
+		},
+	} {
+		pattern, ok := getPathPattern(test.path, test.paths)
+		assert.Equalf(t, test.pattern, pattern, "test '%s' pattern mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' ok mismatch", name)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	t.Parallel()
+	for in, out := range map[string]string{
+		"":             "/",
+		"/":            "/",
+		"/app":         "/app",
+		"/app/":        "/app/",
+		"//app//x/":    "/app/x/",
+		"/app/../x":    "/x",
+		"/../../etc":   "/etc",
+		"/app/./x/../": "/app/",
+		"app/x":        "/app/x",
+	} {
+		assert.Equalf(t, out, normalizePath(in), "test '%s' path mismatch", in)
+	}
+}
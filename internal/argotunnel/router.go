@@ -1,7 +1,10 @@
 package argotunnel
 
 import (
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -12,20 +15,61 @@ type tunnelRouter interface {
 	updateByKindRoutes(kind, namespace, name string, routes []*tunnelRoute) (err error)
 	deleteByRoute(namespace, name string) (err error)
 	deleteByKindKeys(kind, namespace, name string, keys []string) (err error)
+	tunnels() []tunnelStatus
+	routeLinks(namespace, name string) []tunnelLink
+	hostHistory(host string) ([]reconcileDecision, bool)
+	sweepHistory(before time.Time) int
 	run(stopCh <-chan struct{}) (err error)
 }
 
+// tunnelStatus describes a routed tunnel
+type tunnelStatus struct {
+	Hostname string `json:"hostname"`
+	Ingress  string `json:"ingress"`
+	Service  string `json:"service"`
+	Port     int32  `json:"port"`
+	Secret   string `json:"secret"`
+	Upstream string `json:"upstreamMode"`
+	LBPool   string `json:"lbPool,omitempty"`
+
+	Annotations map[string]string  `json:"annotations,omitempty"`
+	Connections []tunnelConnection `json:"connections,omitempty"`
+	Breaker     string             `json:"breaker,omitempty"`
+}
+
+// linkUpstreamMode names how the requests of a link reach the origin
+func linkUpstreamMode(opts tunnelOptions) string {
+	if opts.upstreamMode == UpstreamModeEndpoint {
+		return UpstreamModeEndpoint
+	}
+	return UpstreamModeService
+}
+
+// tunnelBreakerStateOpen marks a tunnel that stopped repairing
+const tunnelBreakerStateOpen = "open"
+
+const (
+	makeBeforeBreakInterval = 500 * time.Millisecond
+	makeBeforeBreakTimeout  = 30 * time.Second
+)
+
 type syncTunnelRouter struct {
-	mu      sync.RWMutex
-	items   map[string]*tunnelRoute
-	log     *logrus.Logger
-	options options
+	mu       sync.RWMutex
+	items    map[string]*tunnelRoute
+	log      *logrus.Logger
+	audit    *logrus.Logger
+	history  *reconcileHistory
+	options  options
+	draining sync.WaitGroup
+	quitCh   chan struct{}
+	quitOnce sync.Once
+	haltOnce sync.Once
 }
 
 func (r *syncTunnelRouter) updateRoute(newRoute *tunnelRoute) (err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.unsafeUpdateRoute(newRoute)
+	r.unsafeUpdateRoute(auditTrigger(ingressKind, newRoute.namespace, newRoute.name), newRoute)
 	return
 }
 
@@ -34,35 +78,63 @@ func (r *syncTunnelRouter) updateByKindRoutes(kind, namespace, name string, rout
 	// TODO: consider locking per-route (avoid long locks, but lock more often)
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	trigger := auditTrigger(kind, namespace, name)
 	for _, newRoute := range routes {
-		r.unsafeUpdateRoute(newRoute)
+		r.unsafeUpdateRoute(trigger, newRoute)
 	}
 	return
 }
 
 // unsafeUpdateRoute requires the lock to be handled prior to call
-func (r *syncTunnelRouter) unsafeUpdateRoute(newRoute *tunnelRoute) (err error) {
+func (r *syncTunnelRouter) unsafeUpdateRoute(trigger string, newRoute *tunnelRoute) (err error) {
 	r.log.Debugf("router update route: %s/%s", newRoute.namespace, newRoute.name)
 	key := itemKeyFunc(newRoute.namespace, newRoute.name)
 
 	oldRoute, exists := r.items[key]
 	r.items[key] = newRoute
 
+	cause := trigger
+	if exists && trigger == auditTrigger(ingressKind, newRoute.namespace, newRoute.name) && oldRoute.resourceVersion == newRoute.resourceVersion {
+		cause = historyTriggerResync
+	}
 	if !exists {
-		for _, newLink := range newRoute.links {
+		for newRule, newLink := range newRoute.links {
+			auditLink(r.audit, auditActionCreate, trigger, newRoute, newRule)
+			r.history.record(cause, historyActionCreate, newRule, nil, newLink)
+			gateFirstStart(newLink, newRoute.created)
 			newLink.start()
 		}
 	} else {
 		swapLinks := tunnelRouteLinkMap{}
+		hostLinks := map[string]tunnelLink{}
+		oldHosts := map[string]bool{}
+		for oldRule := range oldRoute.links {
+			oldHosts[oldRule.host] = true
+		}
 		for newRule, newLink := range newRoute.links {
 			oldLink, ok := oldRoute.links[newRule]
 			if !ok {
+				auditLink(r.audit, auditActionCreate, trigger, newRoute, newRule)
+				r.history.record(cause, historyActionCreate, newRule, nil, newLink)
+				if !oldHosts[newRule.host] {
+					gateFirstStart(newLink, newRoute.created)
+				}
 				newLink.start()
+				hostLinks[newRule.host] = newLink
 			} else {
 				delete(oldRoute.links, newRule)
-				if !oldLink.equal(newLink) {
-					oldLink.stop()
-					newLink.start()
+				if !oldLink.equal(newLink) || oldLink.tripped() {
+					r.history.record(cause, historyActionReplace, newRule, oldLink, newLink)
+					auditLink(r.audit, auditActionDelete, trigger, oldRoute, newRule)
+					if r.options.makeBeforeBreak && !oldLink.tripped() {
+						auditLink(r.audit, auditActionCreate, trigger, newRoute, newRule)
+						newLink.start()
+						r.breakAfter(oldLink, newLink)
+					} else {
+						oldLink.stop()
+						auditLink(r.audit, auditActionCreate, trigger, newRoute, newRule)
+						newLink.start()
+					}
 				} else {
 					swapLinks[newRule] = oldLink
 				}
@@ -71,13 +143,54 @@ func (r *syncTunnelRouter) unsafeUpdateRoute(newRoute *tunnelRoute) (err error)
 		for oldRule, oldLink := range swapLinks {
 			newRoute.links[oldRule] = oldLink
 		}
-		for _, oldLink := range oldRoute.links {
-			oldLink.stop()
+		for oldRule, oldLink := range oldRoute.links {
+			auditLink(r.audit, auditActionDelete, trigger, oldRoute, oldRule)
+			r.history.record(cause, historyActionDelete, oldRule, oldLink, nil)
+			if newLink, ok := hostLinks[oldRule.host]; ok && r.options.makeBeforeBreak && !oldLink.tripped() {
+				r.breakAfter(oldLink, newLink)
+			} else {
+				oldLink.stop()
+			}
 		}
 	}
 	return
 }
 
+// breakAfter retires a replaced link once the link replacing it is
+// connected, or after the make-before-break timeout. Both links serve
+// the host meanwhile.
+func (r *syncTunnelRouter) breakAfter(oldLink, newLink tunnelLink) {
+	r.draining.Add(1)
+	go func() {
+		defer r.draining.Done()
+		timeout := time.NewTimer(makeBeforeBreakTimeout)
+		defer timeout.Stop()
+		ticker := time.NewTicker(makeBeforeBreakInterval)
+		defer ticker.Stop()
+		for len(newLink.connections()) == 0 {
+			select {
+			case <-ticker.C:
+				continue
+			case <-timeout.C:
+				r.log.Warnf("router replacement link not connected after %s, host: %s", makeBeforeBreakTimeout, oldLink.host())
+			case <-r.quit():
+			}
+			break
+		}
+		oldLink.retire(newLink)
+	}()
+}
+
+// quit is closed when the router halts
+func (r *syncTunnelRouter) quit() chan struct{} {
+	r.quitOnce.Do(func() {
+		if r.quitCh == nil {
+			r.quitCh = make(chan struct{})
+		}
+	})
+	return r.quitCh
+}
+
 func (r *syncTunnelRouter) deleteByRoute(namespace, name string) (err error) {
 	r.log.Debugf("router delete route: %s/%s", namespace, name)
 	var wg wait.Group
@@ -93,7 +206,10 @@ func (r *syncTunnelRouter) deleteByRoute(namespace, name string) (err error) {
 		}
 
 		delete(r.items, key)
-		for _, oldLink := range oldRoute.links {
+		trigger := auditTrigger(ingressKind, namespace, name)
+		for oldRule, oldLink := range oldRoute.links {
+			auditLink(r.audit, auditActionDelete, trigger, oldRoute, oldRule)
+			r.history.record(trigger, historyActionDelete, oldRule, oldLink, nil)
 			wg.Start(stopLinkFunc(oldLink))
 		}
 	}()
@@ -110,6 +226,7 @@ func (r *syncTunnelRouter) deleteByKindKeys(kind, namespace, name string, keys [
 		r.mu.Lock()
 		defer r.mu.Unlock()
 
+		trigger := auditTrigger(kind, namespace, name)
 		for _, key := range keys {
 			r.log.Debugf("router delete route: %s", key)
 			if oldRoute, exists := r.items[key]; exists {
@@ -118,6 +235,8 @@ func (r *syncTunnelRouter) deleteByKindKeys(kind, namespace, name string, keys [
 				for oldRule, oldLink := range oldLinks {
 					rc := getKindRuleResource(kind, oldRule)
 					if rc.namespace == namespace && rc.name == name {
+						auditLink(r.audit, auditActionDelete, trigger, oldRoute, oldRule)
+						r.history.record(trigger, historyActionDelete, oldRule, oldLink, nil)
 						wg.Start(stopLinkFunc(oldLink))
 					} else {
 						newLinks[oldRule] = oldLink
@@ -132,22 +251,113 @@ func (r *syncTunnelRouter) deleteByKindKeys(kind, namespace, name string, keys [
 	return
 }
 
+func (r *syncTunnelRouter) tunnels() []tunnelStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tunnels := []tunnelStatus{}
+	for _, route := range r.items {
+		for rule, link := range route.links {
+			var breaker string
+			if link.tripped() {
+				breaker = tunnelBreakerStateOpen
+			}
+			opts := link.options()
+			tunnels = append(tunnels, tunnelStatus{
+				Hostname: rule.host,
+				Ingress:  itemKeyFunc(route.namespace, route.name),
+				Service:  itemKeyFunc(rule.service.namespace, rule.service.name),
+				Port:     rule.port,
+				Secret:   itemKeyFunc(rule.secret.namespace, rule.secret.name),
+				Upstream: linkUpstreamMode(opts),
+				LBPool:   opts.lbPool,
+
+				Annotations: route.annotations,
+				Connections: link.connections(),
+				Breaker:     breaker,
+			})
+		}
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		if tunnels[i].Hostname != tunnels[j].Hostname {
+			return tunnels[i].Hostname < tunnels[j].Hostname
+		}
+		return tunnels[i].Ingress < tunnels[j].Ingress
+	})
+	return tunnels
+}
+
+// hostHistory lists the last reconcile decisions of a host
+func (r *syncTunnelRouter) hostHistory(host string) ([]reconcileDecision, bool) {
+	return r.history.list(host)
+}
+
+// sweepHistory drops the history of the hosts no longer routed, last
+// decided before the time, and returns the hosts holding a history
+func (r *syncTunnelRouter) sweepHistory(before time.Time) int {
+	r.mu.RLock()
+	routed := map[string]bool{}
+	for _, route := range r.items {
+		for rule := range route.links {
+			routed[strings.ToLower(rule.host)] = true
+		}
+	}
+	r.mu.RUnlock()
+	return r.history.sweep(func(host string) bool { return routed[host] }, before)
+}
+
+// routeLinks lists the links of a route by hostname
+func (r *syncTunnelRouter) routeLinks(namespace, name string) []tunnelLink {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	route, ok := r.items[itemKeyFunc(namespace, name)]
+	if !ok {
+		return nil
+	}
+	return route.sortedLinks()
+}
+
 func (r *syncTunnelRouter) run(stopCh <-chan struct{}) (err error) {
 	r.log.Debugf("starting argo-tunnel ingress tunnel router...")
+	if r.options.resyncPeriod > 0 {
+		go wait.Until(r.retryTripped, r.options.resyncPeriod, stopCh)
+	}
 	<-stopCh
 	r.log.Debugf("stopping argo-tunnel ingress tunnel router...")
 	r.halt()
 	return
 }
 
+// retryTripped restarts the links that stopped repairing
+func (r *syncTunnelRouter) retryTripped() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, route := range r.items {
+		for rule, link := range route.links {
+			if link.tripped() {
+				r.log.Infof("router retry tripped link host: %s", rule.host)
+				r.history.record(historyTriggerRepair, historyActionRetry, rule, link, link)
+				link.stop()
+				link.start()
+			}
+		}
+	}
+}
+
 func (r *syncTunnelRouter) halt() (err error) {
+	r.haltOnce.Do(func() { close(r.quit()) })
+	defer r.draining.Wait()
+
 	var wg wait.Group
 	func() {
 		r.mu.RLock()
 		defer r.mu.RUnlock()
 
 		for _, c := range r.items {
-			for _, l := range c.links {
+			for rule, l := range c.links {
+				auditLink(r.audit, auditActionDelete, auditTriggerShutdown, c, rule)
 				wg.Start(stopLinkFunc(l))
 			}
 		}
@@ -160,6 +370,8 @@ func newTunnelRouter(log *logrus.Logger, opts options) tunnelRouter {
 	return &syncTunnelRouter{
 		items:   map[string]*tunnelRoute{},
 		log:     log,
+		audit:   AuditLogger(),
+		history: newReconcileHistory(opts.historySize),
 		options: opts,
 	}
 }
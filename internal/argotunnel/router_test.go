@@ -2,7 +2,9 @@ package argotunnel
 
 import (
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -52,6 +54,7 @@ func TestUpdateRoute(t *testing.T) {
 							tunnelRule{port: 8080}: func() tunnelLink {
 								l := &mockTunnelLink{}
 								l.On("equal", mock.Anything).Return(true)
+								l.On("tripped").Return(false)
 								return l
 							}(),
 						},
@@ -92,6 +95,7 @@ func TestUpdateRoute(t *testing.T) {
 							tunnelRule{port: 8080}: func() tunnelLink {
 								l := &mockTunnelLink{}
 								l.On("equal", mock.Anything).Return(true)
+								l.On("tripped").Return(false)
 								return l
 							}(),
 							tunnelRule{port: 8081}: func() tunnelLink {
@@ -420,6 +424,206 @@ func TestDeleteByKindKeys(t *testing.T) {
 	}
 }
 
+func TestRouterAudit(t *testing.T) {
+	t.Parallel()
+	link := &mockTunnelLink{}
+	link.On("start").Return(nil)
+	link.On("stop").Return(nil)
+	rule := tunnelRule{
+		host:    "a.unit.com",
+		port:    8080,
+		service: resource{namespace: "unit", name: "svc"},
+		secret:  resource{namespace: "unit", name: "sec"},
+	}
+
+	logger, _ := logtest.NewNullLogger()
+	audit, hook := logtest.NewNullLogger()
+	router := &syncTunnelRouter{
+		items: map[string]*tunnelRoute{},
+		log:   logger,
+		audit: audit,
+	}
+
+	router.updateRoute(&tunnelRoute{
+		namespace:       "unit",
+		name:            "a",
+		generation:      2,
+		resourceVersion: "100",
+		links: tunnelRouteLinkMap{
+			rule: link,
+		},
+	})
+	router.deleteByKindKeys(secretKind, "unit", "sec", []string{"unit/a"})
+
+	entries := hook.AllEntries()
+	assert.Equalf(t, 2, len(entries), "test audit entries mismatch")
+	for i, action := range []string{auditActionCreate, auditActionDelete} {
+		assert.Equalf(t, logrus.InfoLevel, entries[i].Level, "test audit %d level mismatch", i)
+		assert.Equalf(t, action, entries[i].Data["action"], "test audit %d action mismatch", i)
+		assert.Equalf(t, "a.unit.com", entries[i].Data["hostname"], "test audit %d hostname mismatch", i)
+		assert.Equalf(t, "unit/a", entries[i].Data["ingress"], "test audit %d ingress mismatch", i)
+		assert.Equalf(t, int64(2), entries[i].Data["generation"], "test audit %d generation mismatch", i)
+		assert.Equalf(t, "100", entries[i].Data["resourceVersion"], "test audit %d resourceVersion mismatch", i)
+	}
+	assert.Equalf(t, "ingress/unit/a", entries[0].Data["trigger"], "test audit create trigger mismatch")
+	assert.Equalf(t, "secret/unit/sec", entries[1].Data["trigger"], "test audit delete trigger mismatch")
+}
+
+func TestRouterTunnels(t *testing.T) {
+	t.Parallel()
+	linkA := &mockTunnelLink{}
+	linkA.On("tripped").Return(false)
+	linkA.On("options").Return(tunnelOptions{upstreamMode: UpstreamModeEndpoint, lbPool: "unit-pool"})
+	linkA.On("connections").Return([]tunnelConnection{
+		{ID: "0", Colo: "AMS"},
+		{ID: "1", Colo: "FRA"},
+	})
+	linkB := &mockTunnelLink{}
+	linkB.On("tripped").Return(true)
+	linkB.On("options").Return(tunnelOptions{})
+	linkB.On("connections").Return([]tunnelConnection{})
+	router := &syncTunnelRouter{
+		items: map[string]*tunnelRoute{
+			"unit/b": {
+				namespace: "unit",
+				name:      "b",
+				links: tunnelRouteLinkMap{
+					tunnelRule{
+						host:    "b.unit.com",
+						port:    8080,
+						service: resource{namespace: "unit", name: "svc-b"},
+						secret:  resource{namespace: "unit", name: "sec"},
+					}: linkB,
+				},
+			},
+			"unit/a": {
+				namespace: "unit",
+				name:      "a",
+				links: tunnelRouteLinkMap{
+					tunnelRule{
+						host:    "a.unit.com",
+						port:    8443,
+						service: resource{namespace: "unit", name: "svc-a"},
+						secret:  resource{namespace: "unit", name: "sec"},
+					}: linkA,
+				},
+			},
+		},
+	}
+	assert.Equalf(t, []tunnelStatus{
+		{
+			Hostname: "a.unit.com",
+			Ingress:  "unit/a",
+			Service:  "unit/svc-a",
+			Port:     8443,
+			Secret:   "unit/sec",
+			Upstream: UpstreamModeEndpoint,
+			LBPool:   "unit-pool",
+			Connections: []tunnelConnection{
+				{ID: "0", Colo: "AMS"},
+				{ID: "1", Colo: "FRA"},
+			},
+		},
+		{
+			Hostname:    "b.unit.com",
+			Ingress:     "unit/b",
+			Service:     "unit/svc-b",
+			Port:        8080,
+			Secret:      "unit/sec",
+			Upstream:    UpstreamModeService,
+			Connections: []tunnelConnection{},
+			Breaker:     tunnelBreakerStateOpen,
+		},
+	}, router.tunnels(), "test router tunnels mismatch")
+}
+
+func TestRouterRetryTripped(t *testing.T) {
+	t.Parallel()
+	tripped := &mockTunnelLink{}
+	tripped.On("tripped").Return(true)
+	tripped.On("stop").Return(nil)
+	tripped.On("start").Return(nil)
+	healthy := &mockTunnelLink{}
+	healthy.On("tripped").Return(false)
+	logger, _ := logtest.NewNullLogger()
+	router := &syncTunnelRouter{
+		items: map[string]*tunnelRoute{
+			"unit/a": {
+				namespace: "unit",
+				name:      "a",
+				links: tunnelRouteLinkMap{
+					tunnelRule{host: "a.unit.com"}: tripped,
+					tunnelRule{host: "b.unit.com"}: healthy,
+				},
+			},
+		},
+		log: logger,
+	}
+	router.retryTripped()
+	tripped.AssertExpectations(t)
+	healthy.AssertExpectations(t)
+}
+
+func TestRouterMakeBeforeBreak(t *testing.T) {
+	t.Parallel()
+	oldRule := tunnelRule{host: "a.unit.com", port: 8080, service: resource{namespace: "unit", name: "old"}}
+	newRule := tunnelRule{host: "a.unit.com", port: 8080, service: resource{namespace: "unit", name: "new"}}
+	for name, test := range map[string]struct {
+		makeBeforeBreak bool
+		tripped         bool
+		retired         bool
+	}{
+		"make-before-break": {
+			makeBeforeBreak: true,
+			retired:         true,
+		},
+		"make-before-break-tripped": {
+			makeBeforeBreak: true,
+			tripped:         true,
+		},
+		"break-before-make": {},
+	} {
+		newLink := &mockTunnelLink{}
+		newLink.On("start").Return(nil)
+		newLink.On("connections").Return([]tunnelConnection{{ID: "0"}})
+		oldLink := &mockTunnelLink{}
+		oldLink.On("tripped").Return(test.tripped)
+		oldLink.On("host").Return(oldRule.host)
+		oldLink.On("stop").Return(nil)
+		oldLink.On("retire", newLink).Return(nil)
+
+		logger, _ := logtest.NewNullLogger()
+		router := &syncTunnelRouter{
+			items: map[string]*tunnelRoute{
+				"unit/a": {
+					namespace: "unit",
+					name:      "a",
+					links:     tunnelRouteLinkMap{oldRule: oldLink},
+				},
+			},
+			log:     logger,
+			audit:   logger,
+			options: options{makeBeforeBreak: test.makeBeforeBreak},
+		}
+		router.updateRoute(&tunnelRoute{
+			namespace: "unit",
+			name:      "a",
+			links:     tunnelRouteLinkMap{newRule: newLink},
+		})
+		router.draining.Wait()
+
+		newLink.AssertCalled(t, "start")
+		if test.retired {
+			oldLink.AssertCalled(t, "retire", newLink)
+			oldLink.AssertNotCalled(t, "stop")
+		} else {
+			oldLink.AssertCalled(t, "stop")
+			oldLink.AssertNotCalled(t, "retire", newLink)
+		}
+		assert.Equalf(t, newLink, router.items["unit/a"].links[newRule], "test '%s' link mismatch", name)
+	}
+}
+
 func TestGetKindRuleResource(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
@@ -536,6 +740,22 @@ func (r *mockTunnelRouter) deleteByKindKeys(kind, namespace, name string, keys [
 	args := r.Called(kind, namespace, name, keys)
 	return args.Error(0)
 }
+func (r *mockTunnelRouter) tunnels() []tunnelStatus {
+	args := r.Called()
+	return args.Get(0).([]tunnelStatus)
+}
+func (r *mockTunnelRouter) routeLinks(namespace, name string) []tunnelLink {
+	args := r.Called(namespace, name)
+	return args.Get(0).([]tunnelLink)
+}
+func (r *mockTunnelRouter) hostHistory(host string) ([]reconcileDecision, bool) {
+	args := r.Called(host)
+	return args.Get(0).([]reconcileDecision), args.Bool(1)
+}
+func (r *mockTunnelRouter) sweepHistory(before time.Time) int {
+	args := r.Called(before)
+	return args.Int(0)
+}
 func (r *mockTunnelRouter) run(stopCh <-chan struct{}) (err error) {
 	args := r.Called(stopCh)
 	return args.Error(0)
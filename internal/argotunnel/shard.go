@@ -0,0 +1,52 @@
+package argotunnel
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// shard identifies the hosts owned by a controller replica
+type shard struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+}
+
+// owns verifies the host belongs to the shard, a nil shard owns every host
+func (s *shard) owns(host string) bool {
+	if s == nil {
+		return true
+	}
+	return shardOwner(host, s.Count) == s.Index
+}
+
+// shardOwner selects the shard of a host by rendezvous hashing, a
+// change in the shard count only moves the hosts of the shards added
+// or removed.
+func shardOwner(host string, count int) (owner int) {
+	var top uint64
+	for i := 0; i < count; i++ {
+		h := fnv.New64a()
+		h.Write([]byte(host))
+		h.Write([]byte{0})
+		h.Write([]byte(strconv.Itoa(i)))
+		if score := h.Sum64(); i == 0 || score > top {
+			owner, top = i, score
+		}
+	}
+	return
+}
+
+// ParseShardOrdinal reads the ordinal suffix of a StatefulSet pod name
+func ParseShardOrdinal(name string) (ordinal int, err error) {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("name %q has no ordinal suffix", name)
+	}
+	ordinal, err = strconv.Atoi(name[i+1:])
+	if err != nil || ordinal < 0 {
+		return 0, fmt.Errorf("name %q has no ordinal suffix", name)
+	}
+	return
+}
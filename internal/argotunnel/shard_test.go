@@ -0,0 +1,84 @@
+package argotunnel
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardOwns(t *testing.T) {
+	t.Parallel()
+	var none *shard
+	assert.True(t, none.owns("a.unit.com"))
+
+	owners := 0
+	for i := 0; i < 3; i++ {
+		if (&shard{Index: i, Count: 3}).owns("a.unit.com") {
+			owners++
+		}
+	}
+	assert.Equalf(t, 1, owners, "test shard owners mismatch")
+}
+
+func TestShardOwnerStable(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		from  int
+		to    int
+		shard int
+	}{
+		"shard-grow": {
+			from:  3,
+			to:    4,
+			shard: 3,
+		},
+		"shard-shrink": {
+			from:  4,
+			to:    3,
+			shard: 3,
+		},
+	} {
+		moved := 0
+		for i := 0; i < 1000; i++ {
+			host := fmt.Sprintf("host-%d.unit.com", i)
+			from, to := shardOwner(host, test.from), shardOwner(host, test.to)
+			if from != to {
+				moved++
+				// only hosts of the added or removed shard move
+				assert.Truef(t, from == test.shard || to == test.shard, "test '%s' host '%s' move mismatch", name, host)
+			}
+		}
+		assert.Truef(t, moved > 0 && moved < 500, "test '%s' moved hosts mismatch: %d", name, moved)
+	}
+}
+
+func TestParseShardOrdinal(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  string
+		out int
+		err error
+	}{
+		"ordinal-zero": {
+			in:  "argo-tunnel-0",
+			out: 0,
+		},
+		"ordinal-many": {
+			in:  "argo-tunnel-12",
+			out: 12,
+		},
+		"no-suffix": {
+			in:  "argotunnel",
+			err: fmt.Errorf("name %q has no ordinal suffix", "argotunnel"),
+		},
+		"deployment-suffix": {
+			in:  "argo-tunnel-7d9f8b6c4-x2x9z",
+			err: fmt.Errorf("name %q has no ordinal suffix", "argo-tunnel-7d9f8b6c4-x2x9z"),
+		},
+	} {
+		out, err := ParseShardOrdinal(test.in)
+		assert.Equalf(t, test.out, out, "test '%s' ordinal mismatch", name)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+	}
+}
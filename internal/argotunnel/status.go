@@ -0,0 +1,267 @@
+package argotunnel
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	statusConditionReady = "Ready"
+
+	statusReasonReady            = "Ready"
+	statusReasonSecretMissing    = "SecretMissing"
+	statusReasonBackendMissing   = "BackendMissing"
+	statusReasonTunnelFailed     = "TunnelFailed"
+	statusReasonOriginNotReady   = "OriginNotReady"
+	statusReasonRepairsExhausted = "RepairsExhausted"
+	statusReasonClockSkewed      = "ClockSkewed"
+	statusReasonReadinessTimeout = "ReadinessTimeout"
+
+	// statusRetryBaseDelay is the delay before retrying a failed status
+	// write, doubled on each failure up to statusRetryMaxDelay
+	statusRetryBaseDelay = time.Second
+	statusRetryMaxDelay  = 5 * time.Minute
+)
+
+var (
+	// ingressStatusResource holds the reconcile result of an ingress,
+	// the Ingress status has no conditions.
+	ingressStatusResource = schema.GroupVersionResource{
+		Group:    "argo.cloudflare.com",
+		Version:  "v1alpha1",
+		Resource: "ingressstatuses",
+	}
+	ingressStatusKind = "IngressStatus"
+)
+
+// ingressCondition is the reconcile result of an ingress
+type ingressCondition struct {
+	reason  string
+	message string
+}
+
+// ingressStatusWriter records the reconcile result of ingresses in an
+// IngressStatus resource named after, and owned by, the ingress. The
+// reconcile result is combined with the conditions reported by the
+// tunnels of each host. A failed write is retried by the writer, it
+// never requeues the ingress.
+type ingressStatusWriter struct {
+	mu         sync.Mutex
+	client     dynamic.Interface
+	reconciled map[string]ingressCondition
+	hosts      map[string]map[string]ingressCondition
+	last       map[string]ingressCondition
+	retries    map[string]*statusRetry
+	retryBase  time.Duration
+	retryMax   time.Duration
+	log        *logrus.Logger
+}
+
+// statusRetry is the pending retry of a failed status write
+type statusRetry struct {
+	timer    *time.Timer
+	failures uint
+}
+
+func newIngressStatusWriter(client dynamic.Interface, log *logrus.Logger) *ingressStatusWriter {
+	if client == nil {
+		return nil
+	}
+	return &ingressStatusWriter{
+		client:     client,
+		reconciled: map[string]ingressCondition{},
+		hosts:      map[string]map[string]ingressCondition{},
+		last:       map[string]ingressCondition{},
+		retries:    map[string]*statusRetry{},
+		retryBase:  statusRetryBaseDelay,
+		retryMax:   statusRetryMaxDelay,
+		log:        log,
+	}
+}
+
+// write records the reconcile result of the ingress
+func (w *ingressStatusWriter) write(namespace, name string, uid types.UID, cond ingressCondition) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.reconciled[itemKeyFunc(namespace, name)] = cond
+	w.unsafeFlush(namespace, name, uid)
+}
+
+// writeHost records the condition reported by the tunnel of a host,
+// an empty condition clears it. Reports on forgotten ingresses are
+// dropped.
+func (w *ingressStatusWriter) writeHost(namespace, name string, uid types.UID, host string, cond ingressCondition) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := itemKeyFunc(namespace, name)
+	if _, ok := w.reconciled[key]; !ok {
+		return
+	}
+	if len(cond.reason) == 0 {
+		delete(w.hosts[key], host)
+	} else {
+		if w.hosts[key] == nil {
+			w.hosts[key] = map[string]ingressCondition{}
+		}
+		w.hosts[key][host] = cond
+	}
+	w.unsafeFlush(namespace, name, uid)
+}
+
+// unsafeFlush writes the condition of the ingress, a failed reconcile
+// takes precedence over the host conditions. Unchanged conditions are
+// not written again.
+func (w *ingressStatusWriter) unsafeFlush(namespace, name string, uid types.UID) {
+	key := itemKeyFunc(namespace, name)
+	cond := w.reconciled[key]
+	if cond.reason == statusReasonReady {
+		hosts := make([]string, 0, len(w.hosts[key]))
+		for host := range w.hosts[key] {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		if len(hosts) > 0 {
+			cond = w.hosts[key][hosts[0]]
+		}
+	}
+	if last, ok := w.last[key]; ok && last == cond {
+		return
+	}
+
+	res := w.client.Resource(ingressStatusResource).Namespace(namespace)
+	cur, err := res.Get(context.TODO(), name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = res.Create(context.TODO(), newIngressStatus(namespace, name, uid, cond, time.Now()), metav1.CreateOptions{})
+	case err == nil && getIngressStatusCondition(cur) != cond:
+		obj := newIngressStatus(namespace, name, uid, cond, time.Now())
+		obj.SetResourceVersion(cur.GetResourceVersion())
+		_, err = res.Update(context.TODO(), obj, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		delay := w.unsafeRetry(namespace, name, uid)
+		w.log.Errorf("status write failed on ingress: %s, reason: %s, err: %v, retry in %s", key, cond.reason, err, delay)
+		return
+	}
+	w.last[key] = cond
+	if r, ok := w.retries[key]; ok {
+		r.timer.Stop()
+		delete(w.retries, key)
+	}
+}
+
+// unsafeRetry schedules another flush of the ingress, the delay doubles
+// with each failure in a row
+func (w *ingressStatusWriter) unsafeRetry(namespace, name string, uid types.UID) time.Duration {
+	key := itemKeyFunc(namespace, name)
+	r, ok := w.retries[key]
+	if !ok {
+		r = &statusRetry{}
+		w.retries[key] = r
+	} else {
+		r.timer.Stop()
+	}
+	delay := w.retryBase << r.failures
+	if delay <= 0 || delay > w.retryMax {
+		delay = w.retryMax
+	} else {
+		r.failures++
+	}
+	r.timer = time.AfterFunc(delay, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.retries[key] != r {
+			return
+		}
+		if _, ok := w.reconciled[key]; ok {
+			w.unsafeFlush(namespace, name, uid)
+		}
+	})
+	return delay
+}
+
+// forget drops the conditions of a deleted ingress, the IngressStatus
+// is garbage collected with its owner.
+func (w *ingressStatusWriter) forget(namespace, name string) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := itemKeyFunc(namespace, name)
+	delete(w.reconciled, key)
+	delete(w.hosts, key)
+	delete(w.last, key)
+	if r, ok := w.retries[key]; ok {
+		r.timer.Stop()
+		delete(w.retries, key)
+	}
+}
+
+func newIngressStatus(namespace, name string, uid types.UID, cond ingressCondition, now time.Time) *unstructured.Unstructured {
+	status := metav1.ConditionTrue
+	if cond.reason != statusReasonReady {
+		status = metav1.ConditionFalse
+	}
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"status": map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{
+						"type":               statusConditionReady,
+						"status":             string(status),
+						"reason":             cond.reason,
+						"message":            cond.message,
+						"lastTransitionTime": now.UTC().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	}
+	obj.SetAPIVersion(ingressStatusResource.GroupVersion().String())
+	obj.SetKind(ingressStatusKind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "Ingress",
+			Name:       name,
+			UID:        uid,
+		},
+	})
+	return obj
+}
+
+func getIngressStatusCondition(obj *unstructured.Unstructured) (cond ingressCondition) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		if m, ok := c.(map[string]interface{}); ok && m["type"] == statusConditionReady {
+			cond.reason, _ = m["reason"].(string)
+			cond.message, _ = m["message"].(string)
+		}
+	}
+	return
+}
@@ -0,0 +1,200 @@
+package argotunnel
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func newFakeStatusClient() *fake.FakeDynamicClient {
+	return fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		ingressStatusResource: ingressStatusKind + "List",
+	})
+}
+
+func TestIngressStatusWriter(t *testing.T) {
+	t.Parallel()
+	client := newFakeStatusClient()
+	log, _ := logtest.NewNullLogger()
+	w := newIngressStatusWriter(client, log)
+	for _, step := range []struct {
+		cond    ingressCondition
+		actions []string
+	}{
+		{
+			cond:    ingressCondition{reason: statusReasonSecretMissing, message: "host a.unit.com: secret 'unit/sec' does not exist"},
+			actions: []string{"get", "create"},
+		},
+		{
+			cond:    ingressCondition{reason: statusReasonSecretMissing, message: "host a.unit.com: secret 'unit/sec' does not exist"},
+			actions: []string{},
+		},
+		{
+			cond:    ingressCondition{reason: statusReasonReady},
+			actions: []string{"get", "update"},
+		},
+	} {
+		client.ClearActions()
+		w.write("unit", "ing", types.UID("uid"), step.cond)
+		actions := []string{}
+		for _, action := range client.Actions() {
+			actions = append(actions, action.GetVerb())
+		}
+		assert.Equalf(t, step.actions, actions, "test '%s' actions mismatch", step.cond.reason)
+
+		obj, err := client.Resource(ingressStatusResource).Namespace("unit").Get(context.TODO(), "ing", metav1.GetOptions{})
+		assert.Nilf(t, err, "test '%s' error mismatch", step.cond.reason)
+		assert.Equalf(t, step.cond, getIngressStatusCondition(obj), "test '%s' condition mismatch", step.cond.reason)
+		assert.Equalf(t, types.UID("uid"), obj.GetOwnerReferences()[0].UID, "test '%s' owner mismatch", step.cond.reason)
+	}
+}
+
+func TestIngressStatusWriterHosts(t *testing.T) {
+	t.Parallel()
+	client := newFakeStatusClient()
+	log, _ := logtest.NewNullLogger()
+	w := newIngressStatusWriter(client, log)
+	notReady := ingressCondition{reason: statusReasonOriginNotReady, message: "host b.unit.com: waiting on readiness probe /healthz"}
+	failed := ingressCondition{reason: statusReasonTunnelFailed, message: "host a.unit.com: edge unreachable"}
+	secret := ingressCondition{reason: statusReasonSecretMissing, message: "host c.unit.com has no origin certificate secret"}
+	ready := ingressCondition{reason: statusReasonReady}
+	for name, step := range []struct {
+		write func()
+		cond  ingressCondition
+	}{
+		{
+			write: func() { w.write("unit", "ing", types.UID("uid"), ready) },
+			cond:  ready,
+		},
+		{
+			write: func() { w.writeHost("unit", "ing", types.UID("uid"), "b.unit.com", notReady) },
+			cond:  notReady,
+		},
+		{
+			write: func() { w.writeHost("unit", "ing", types.UID("uid"), "a.unit.com", failed) },
+			cond:  failed,
+		},
+		{
+			write: func() { w.write("unit", "ing", types.UID("uid"), secret) },
+			cond:  secret,
+		},
+		{
+			write: func() { w.write("unit", "ing", types.UID("uid"), ready) },
+			cond:  failed,
+		},
+		{
+			write: func() { w.writeHost("unit", "ing", types.UID("uid"), "a.unit.com", ingressCondition{}) },
+			cond:  notReady,
+		},
+		{
+			write: func() { w.writeHost("unit", "ing", types.UID("uid"), "b.unit.com", ingressCondition{}) },
+			cond:  ready,
+		},
+	} {
+		step.write()
+		obj, err := client.Resource(ingressStatusResource).Namespace("unit").Get(context.TODO(), "ing", metav1.GetOptions{})
+		assert.Nilf(t, err, "test step %d error mismatch", name)
+		assert.Equalf(t, step.cond, getIngressStatusCondition(obj), "test step %d condition mismatch", name)
+	}
+
+	w.forget("unit", "ing")
+	client.ClearActions()
+	w.writeHost("unit", "ing", types.UID("uid"), "a.unit.com", failed)
+	assert.Emptyf(t, client.Actions(), "test forgotten ingress actions mismatch")
+}
+
+func TestNewIngressStatus(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for name, test := range map[string]struct {
+		cond   ingressCondition
+		status string
+	}{
+		"status-ready": {
+			cond:   ingressCondition{reason: statusReasonReady},
+			status: "True",
+		},
+		"status-tunnel-failed": {
+			cond:   ingressCondition{reason: statusReasonTunnelFailed, message: "host a.unit.com: edge unreachable"},
+			status: "False",
+		},
+	} {
+		obj := newIngressStatus("unit", "ing", types.UID("uid"), test.cond, now)
+		assert.Equalf(t, map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{
+					"type":               statusConditionReady,
+					"status":             test.status,
+					"reason":             test.cond.reason,
+					"message":            test.cond.message,
+					"lastTransitionTime": "2020-01-02T03:04:05Z",
+				},
+			},
+		}, obj.Object["status"], "test '%s' status mismatch", name)
+		assert.Equalf(t, "argo.cloudflare.com/v1alpha1", obj.GetAPIVersion(), "test '%s' api version mismatch", name)
+	}
+}
+
+func TestNilIngressStatusWriter(t *testing.T) {
+	t.Parallel()
+	var w *ingressStatusWriter
+	w.write("unit", "ing", types.UID("uid"), ingressCondition{reason: statusReasonReady})
+	w.writeHost("unit", "ing", types.UID("uid"), "a.unit.com", ingressCondition{})
+	w.forget("unit", "ing")
+	assert.Nil(t, newIngressStatusWriter(nil, nil))
+}
+
+func TestReconcileStatus(t *testing.T) {
+	t.Parallel()
+	client := newFakeStatusClient()
+	tr := newMockedSyncTranslator()
+	tr.log, _ = logtest.NewNullLogger()
+	tr.status = newIngressStatusWriter(client, tr.log)
+	state := tr.getIngressState(context.Background(), &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unit",
+			Namespace: "unit",
+			UID:       types.UID("uid"),
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "a.unit.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "svc-a",
+											Port: networkingv1.ServiceBackendPort{
+												Number: 8080,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	tr.reconcileStatus([]*ingressState{state})
+	obj, err := client.Resource(ingressStatusResource).Namespace("unit").Get(context.TODO(), "unit", metav1.GetOptions{})
+	assert.Nilf(t, err, "test ingress status error mismatch")
+	assert.Equalf(t, ingressCondition{
+		reason:  statusReasonSecretMissing,
+		message: fmt.Sprintf("host %s has no origin certificate secret", "a.unit.com"),
+	}, getIngressStatusCondition(obj), "test ingress status condition mismatch")
+}
@@ -0,0 +1,92 @@
+package argotunnel
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// StreamBufferBytesDefault caps the bytes in flight on each read
+	// of a proxied stream
+	StreamBufferBytesDefault = 32 * 1024
+
+	streamDirectionRequest  = "request"
+	streamDirectionResponse = "response"
+
+	streamStallThreshold = time.Second
+)
+
+// streamRoundTripper caps the bytes moved by each read of the request
+// and response bodies, so a slow reader holds back the writer instead
+// of the proxy buffering for it. Reads held back past the stall
+// threshold are counted as back-pressure.
+type streamRoundTripper struct {
+	next     http.RoundTripper
+	hostname string
+	limit    int
+}
+
+func newStreamRoundTripper(next http.RoundTripper, rule tunnelRule, options tunnelOptions) *streamRoundTripper {
+	limit := int(options.streamBufferBytes)
+	if limit <= 0 {
+		limit = StreamBufferBytesDefault
+	}
+	return &streamRoundTripper{
+		next:     next,
+		hostname: rule.host,
+		limit:    limit,
+	}
+}
+
+func (rt *streamRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		r := req.Clone(req.Context())
+		r.Body = newStreamBody(req.Body, rt.limit, rt.hostname, streamDirectionRequest)
+		req = r
+	}
+	res, err := rt.next.RoundTrip(req)
+	if err == nil && res.Body != nil && res.Body != http.NoBody {
+		res.Body = newStreamBody(res.Body, rt.limit, rt.hostname, streamDirectionResponse)
+	}
+	return res, err
+}
+
+// streamBody caps each read to the limit and counts the reads arriving
+// after the reader was held back longer than the stall threshold. The
+// reader is held back while it writes the previous read downstream.
+type streamBody struct {
+	body      io.ReadCloser
+	limit     int
+	hostname  string
+	direction string
+	threshold time.Duration
+	last      int64
+}
+
+func newStreamBody(body io.ReadCloser, limit int, hostname, direction string) *streamBody {
+	return &streamBody{
+		body:      body,
+		limit:     limit,
+		hostname:  hostname,
+		direction: direction,
+		threshold: streamStallThreshold,
+	}
+}
+
+func (b *streamBody) Read(p []byte) (n int, err error) {
+	if last := atomic.LoadInt64(&b.last); last > 0 && time.Since(time.Unix(0, last)) > b.threshold {
+		streamStalls.WithLabelValues(b.hostname, b.direction).Inc()
+	}
+	if len(p) > b.limit {
+		p = p[:b.limit]
+	}
+	n, err = b.body.Read(p)
+	atomic.StoreInt64(&b.last, time.Now().UnixNano())
+	return
+}
+
+func (b *streamBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,113 @@
+package argotunnel
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// summaryPeriod is the period of the cluster summary evaluation
+const summaryPeriod = 15 * time.Second
+
+const (
+	routeStateHealthy  = "healthy"
+	routeStateDegraded = "degraded"
+	routeStateFailed   = "failed"
+)
+
+// clusterSummary is the cluster-wide state of the controller, aggregated
+// without per-host labels
+type clusterSummary struct {
+	ingresses      int
+	routes         int
+	healthy        int
+	degraded       int
+	failed         int
+	secrets        int
+	missingSecrets int
+}
+
+// summarize aggregates the routed tunnels. A route is healthy with a
+// registered connection, degraded while it has none and repairs, and
+// failed once it stopped repairing. A referenced secret is missing when
+// absent from the secret cache.
+func summarize(tunnels []tunnelStatus, secrets cache.Store) (s clusterSummary) {
+	ingresses := map[string]bool{}
+	referenced := map[string]bool{}
+	for _, t := range tunnels {
+		s.routes++
+		ingresses[t.Ingress] = true
+		switch {
+		case t.Breaker == tunnelBreakerStateOpen:
+			s.failed++
+		case len(t.Connections) > 0:
+			s.healthy++
+		default:
+			s.degraded++
+		}
+		if referenced[t.Secret] {
+			continue
+		}
+		referenced[t.Secret] = true
+		if _, ok, err := secrets.GetByKey(t.Secret); err != nil || !ok {
+			s.missingSecrets++
+		}
+	}
+	s.ingresses = len(ingresses)
+	s.secrets = len(referenced)
+	return
+}
+
+func (s clusterSummary) export() {
+	summaryIngresses.Set(float64(s.ingresses))
+	summaryRoutes.Set(float64(s.routes))
+	summaryRouteStates.WithLabelValues(routeStateHealthy).Set(float64(s.healthy))
+	summaryRouteStates.WithLabelValues(routeStateDegraded).Set(float64(s.degraded))
+	summaryRouteStates.WithLabelValues(routeStateFailed).Set(float64(s.failed))
+	summarySecrets.Set(float64(s.secrets))
+	summarySecretsMissing.Set(float64(s.missingSecrets))
+	// DNS records are managed by cloudflared on registration, not by the
+	// controller
+	summaryDNSRecords.Set(0)
+}
+
+// summaryEvaluator periodically exports the cluster summary. A full
+// resync succeeds when the caches are synced, the queue is drained and
+// no route failed, i.e. every ingress converged.
+type summaryEvaluator struct {
+	router  tunnelRouter
+	secrets cache.Store
+	queue   interface{ Len() int }
+	synced  func() bool
+
+	mu         sync.Mutex
+	lastResync time.Time
+}
+
+func newSummaryEvaluator(router tunnelRouter, secrets cache.Store, queue interface{ Len() int }, synced func() bool, now time.Time) *summaryEvaluator {
+	return &summaryEvaluator{
+		router:     router,
+		secrets:    secrets,
+		queue:      queue,
+		synced:     synced,
+		lastResync: now,
+	}
+}
+
+func (e *summaryEvaluator) evaluate(now time.Time) clusterSummary {
+	s := summarize(e.router.tunnels(), e.secrets)
+	s.export()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.synced() && e.queue.Len() == 0 && s.failed == 0 {
+		e.lastResync = now
+	}
+	summaryResyncAge.Set(now.Sub(e.lastResync).Seconds())
+	return s
+}
+
+func (e *summaryEvaluator) run() {
+	e.evaluate(time.Now())
+}
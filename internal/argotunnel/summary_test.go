@@ -0,0 +1,115 @@
+package argotunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+type fixedLenQueue int
+
+func (q fixedLenQueue) Len() int {
+	return int(q)
+}
+
+func newSummarySecretStore(keys ...string) cache.Store {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, key := range keys {
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		store.Add(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}})
+	}
+	return store
+}
+
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+	connected := []tunnelConnection{{ID: "0", Colo: "LAX"}}
+	for name, test := range map[string]struct {
+		tunnels []tunnelStatus
+		secrets []string
+		out     clusterSummary
+	}{
+		"empty": {
+			tunnels: []tunnelStatus{},
+			out:     clusterSummary{},
+		},
+		"route-states": {
+			tunnels: []tunnelStatus{
+				{Hostname: "a.unit.com", Ingress: "unit/ing-a", Secret: "unit/cert", Connections: connected},
+				{Hostname: "b.unit.com", Ingress: "unit/ing-a", Secret: "unit/cert"},
+				{Hostname: "c.unit.com", Ingress: "unit/ing-b", Secret: "unit/cert", Connections: connected, Breaker: tunnelBreakerStateOpen},
+			},
+			secrets: []string{"unit/cert"},
+			out: clusterSummary{
+				ingresses: 2,
+				routes:    3,
+				healthy:   1,
+				degraded:  1,
+				failed:    1,
+				secrets:   1,
+			},
+		},
+		"missing-secret": {
+			tunnels: []tunnelStatus{
+				{Hostname: "a.unit.com", Ingress: "unit/ing-a", Secret: "unit/cert-a", Connections: connected},
+				{Hostname: "b.unit.com", Ingress: "unit/ing-b", Secret: "unit/cert-b", Connections: connected},
+				{Hostname: "c.unit.com", Ingress: "unit/ing-c", Secret: "unit/cert-b", Connections: connected},
+			},
+			secrets: []string{"unit/cert-a"},
+			out: clusterSummary{
+				ingresses:      3,
+				routes:         3,
+				healthy:        3,
+				secrets:        2,
+				missingSecrets: 1,
+			},
+		},
+	} {
+		out := summarize(test.tunnels, newSummarySecretStore(test.secrets...))
+		assert.Equalf(t, test.out, out, "test '%s' summary mismatch", name)
+	}
+}
+
+func TestSummaryEvaluatorResync(t *testing.T) {
+	t.Parallel()
+	start := time.Unix(0, 0)
+	failed := []tunnelStatus{{Hostname: "a.unit.com", Ingress: "unit/ing-a", Secret: "unit/cert", Breaker: tunnelBreakerStateOpen}}
+	for name, test := range map[string]struct {
+		tunnels []tunnelStatus
+		synced  bool
+		queued  int
+		out     time.Time
+	}{
+		"converged": {
+			tunnels: []tunnelStatus{},
+			synced:  true,
+			out:     start.Add(time.Minute),
+		},
+		"unsynced": {
+			tunnels: []tunnelStatus{},
+			out:     start,
+		},
+		"queued": {
+			tunnels: []tunnelStatus{},
+			synced:  true,
+			queued:  1,
+			out:     start,
+		},
+		"failed-route": {
+			tunnels: failed,
+			synced:  true,
+			out:     start,
+		},
+	} {
+		router := &mockTunnelRouter{}
+		router.On("tunnels").Return(test.tunnels)
+		synced := test.synced
+		e := newSummaryEvaluator(router, newSummarySecretStore(), fixedLenQueue(test.queued), func() bool { return synced }, start)
+		e.evaluate(start.Add(time.Minute))
+		assert.Equalf(t, test.out, e.lastResync, "test '%s' last resync mismatch", name)
+	}
+}
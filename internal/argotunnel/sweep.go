@@ -0,0 +1,145 @@
+package argotunnel
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/client-go/tools/cache"
+)
+
+// sweepPeriod is the period of the route sweep, the history of a host
+// no longer routed is kept for at least a period
+const sweepPeriod = 5 * time.Minute
+
+const (
+	trackedRoutes        = "routes"
+	trackedHistory       = "history"
+	trackedTunnelMetrics = "tunnel-metrics"
+	trackedHostSeries    = "host-series"
+	trackedIngressSeries = "ingress-series"
+)
+
+// labeledCollector is a metric vector whose series can be deleted
+type labeledCollector interface {
+	prometheus.Collector
+	Delete(labels prometheus.Labels) bool
+}
+
+// hostSeries are the metric vectors labeled by the hostname of a route,
+// their series outlive the tunnel until swept
+var hostSeries = []labeledCollector{
+	fallbackRequests,
+	maintenanceResponses,
+	originRequestDuration,
+	originRequests,
+	proxyInflightRequests,
+	redirectResponses,
+	registrationsDeferred,
+	streamStalls,
+	tunnelBreakerOpen,
+	tunnelConcurrency,
+	tunnelConnectionInfo,
+	tunnelOriginReady,
+	tunnelRejectedRequests,
+}
+
+// sweepSeries deletes the series not kept, and returns the labels of the
+// series kept
+func sweepSeries(c labeledCollector, keep func(labels prometheus.Labels) bool) (kept []prometheus.Labels) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var series []prometheus.Labels
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			continue
+		}
+		labels := prometheus.Labels{}
+		for _, pair := range pb.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		series = append(series, labels)
+	}
+	// a vector is locked while collected, series are deleted after
+	for _, labels := range series {
+		if keep(labels) {
+			kept = append(kept, labels)
+		} else {
+			c.Delete(labels)
+		}
+	}
+	return
+}
+
+// routeSweeper periodically drops the per-route state left behind by
+// routes that are gone: the metric series of hosts no longer routed, the
+// history of those hosts, and the series of ingresses absent from the
+// ingress cache.
+type routeSweeper struct {
+	router    tunnelRouter
+	ingresses cache.Store
+	synced    func() bool
+}
+
+func newRouteSweeper(router tunnelRouter, ingresses cache.Store, synced func() bool) *routeSweeper {
+	return &routeSweeper{
+		router:    router,
+		ingresses: ingresses,
+		synced:    synced,
+	}
+}
+
+// sweep returns the number of entries tracked by each structure
+func (s *routeSweeper) sweep(now time.Time) map[string]int {
+	tunnels := s.router.tunnels()
+	routed := map[string]bool{}
+	for _, t := range tunnels {
+		routed[strings.ToLower(t.Hostname)] = true
+	}
+
+	hosts := map[string]bool{}
+	for _, c := range hostSeries {
+		for _, labels := range sweepSeries(c, func(labels prometheus.Labels) bool {
+			return routed[strings.ToLower(labels["hostname"])]
+		}) {
+			hosts[labels["hostname"]] = true
+		}
+	}
+
+	// the ingress series are only swept once the cache is complete
+	ingresses := sweepSeries(ingressLastReconcile, func(labels prometheus.Labels) bool {
+		if !s.synced() {
+			return true
+		}
+		_, exists, err := s.ingresses.GetByKey(itemKeyFunc(labels["namespace"], labels["ingress"]))
+		return err != nil || exists
+	})
+
+	tracked := map[string]int{
+		trackedRoutes:        len(tunnels),
+		trackedHistory:       s.router.sweepHistory(now.Add(-sweepPeriod)),
+		trackedTunnelMetrics: trackedTunnelMetricsLen(),
+		trackedHostSeries:    len(hosts),
+		trackedIngressSeries: len(ingresses),
+	}
+	for structure, n := range tracked {
+		routesTracked.WithLabelValues(structure).Set(float64(n))
+	}
+	return tracked
+}
+
+func (s *routeSweeper) run() {
+	s.sweep(time.Now())
+}
+
+// trackedTunnelMetricsLen counts the hosts holding cloudflared metrics
+func trackedTunnelMetricsLen() int {
+	metricsConfig.mu.Lock()
+	defer metricsConfig.mu.Unlock()
+	return len(metricsConfig.hosts)
+}
@@ -0,0 +1,106 @@
+package argotunnel
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSweepSeries(t *testing.T) {
+	t.Parallel()
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unit_sweep_total"}, []string{"hostname", "code"})
+	vec.WithLabelValues("a.unit.com", "200").Inc()
+	vec.WithLabelValues("a.unit.com", "502").Inc()
+	vec.WithLabelValues("b.unit.com", "200").Inc()
+	kept := sweepSeries(vec, func(labels prometheus.Labels) bool {
+		return labels["hostname"] == "a.unit.com"
+	})
+	assert.Equalf(t, 2, len(kept), "test kept series mismatch")
+	assert.Equalf(t, 2, testutil.CollectAndCount(vec), "test swept series mismatch")
+}
+
+// TestRouteSweeperSoak churns routes through the router and verifies the
+// per-route state returns to the baseline once they are deleted. It is
+// not parallel, the sweep drops the host series of every other test.
+func TestRouteSweeperSoak(t *testing.T) {
+	const churn = 1000
+	start := time.Now()
+	logger, _ := logtest.NewNullLogger()
+	router := &syncTunnelRouter{
+		items:   map[string]*tunnelRoute{},
+		log:     logger,
+		history: newReconcileHistory(HistorySizeDefault),
+	}
+	router.history.now = func() time.Time { return start }
+	ingresses := cache.NewStore(cache.MetaNamespaceKeyFunc)
+
+	route := func(name string) *tunnelRoute {
+		rule := tunnelRule{
+			host:    name + ".unit.com",
+			port:    8080,
+			service: resource{namespace: "soak", name: "svc"},
+			secret:  resource{namespace: "soak", name: "sec"},
+		}
+		link := &mockTunnelLink{}
+		link.On("routeRule").Return(rule)
+		link.On("originURL").Return("svc.soak:8080")
+		link.On("options").Return(tunnelOptions{})
+		link.On("originCert").Return([]byte{})
+		link.On("tripped").Return(false)
+		link.On("connections").Return([]tunnelConnection{})
+		link.On("start").Return(nil)
+		link.On("stop").Return(nil)
+		// traffic and reconciles leave series behind
+		originRequests.WithLabelValues(rule.host, "soak", "svc", "200").Inc()
+		originRequestDuration.WithLabelValues(rule.host, "soak", "svc").Observe(0.1)
+		streamStalls.WithLabelValues(rule.host, "response").Inc()
+		ingressLastReconcile.WithLabelValues("soak", name).Set(1)
+		return &tunnelRoute{namespace: "soak", name: name, links: tunnelRouteLinkMap{rule: link}}
+	}
+
+	router.updateRoute(route("baseline"))
+	ingresses.Add(&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "soak", Name: "baseline"}})
+	for i := 0; i < churn; i++ {
+		name := "soak-" + strconv.Itoa(i)
+		router.updateRoute(route(name))
+		router.deleteByRoute("soak", name)
+	}
+
+	sweeper := newRouteSweeper(router, ingresses, func() bool { return true })
+	tracked := sweeper.sweep(start)
+	assert.Equalf(t, 1, tracked[trackedRoutes], "test routes mismatch")
+	assert.Equalf(t, churn+1, tracked[trackedHistory], "test recent history mismatch")
+	assert.Equalf(t, 1, tracked[trackedHostSeries], "test host series mismatch")
+	assert.Equalf(t, 1, tracked[trackedIngressSeries], "test ingress series mismatch")
+
+	tracked = sweeper.sweep(start.Add(2 * sweepPeriod))
+	assert.Equalf(t, 1, tracked[trackedHistory], "test history mismatch")
+	_, ok := router.hostHistory("baseline.unit.com")
+	assert.Truef(t, ok, "test routed history kept mismatch")
+	assert.Equalf(t, float64(1), testutil.ToFloat64(routesTracked.WithLabelValues(trackedHistory)), "test tracked metric mismatch")
+	for _, c := range hostSeries {
+		for _, labels := range sweepSeries(c, func(prometheus.Labels) bool { return true }) {
+			assert.Equalf(t, "baseline.unit.com", labels["hostname"], "test series %v mismatch", labels)
+		}
+	}
+}
+
+func TestRouteSweeperUnsynced(t *testing.T) {
+	router := &mockTunnelRouter{}
+	router.On("tunnels").Return([]tunnelStatus{})
+	router.On("sweepHistory", time.Unix(0, 0).Add(-sweepPeriod)).Return(0)
+	ingressLastReconcile.WithLabelValues("unsynced", "ing").Set(1)
+	defer ingressLastReconcile.DeleteLabelValues("unsynced", "ing")
+
+	sweeper := newRouteSweeper(router, cache.NewStore(cache.MetaNamespaceKeyFunc), func() bool { return false })
+	sweeper.sweep(time.Unix(0, 0))
+	assert.Equalf(t, float64(1), testutil.ToFloat64(ingressLastReconcile.WithLabelValues("unsynced", "ing")), "test unsynced ingress series mismatch")
+}
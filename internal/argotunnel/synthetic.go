@@ -0,0 +1,248 @@
+package argotunnel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syntheticReasonBodyTooLarge  = "body-too-large"
+	syntheticReasonNoEndpoints   = "no-endpoints"
+	syntheticReasonMaintenance   = "maintenance"
+	syntheticReasonMissing       = "backend-missing"
+	syntheticReasonNotFound      = "not-found"
+	syntheticReasonOriginBusy    = "origin-busy"
+	syntheticReasonRedirect      = "redirect"
+	syntheticReasonSlashRedirect = "trailing-slash"
+
+	mediaTypeHTML = "text/html"
+	mediaTypeJSON = "application/json"
+
+	encodingBrotli   = "br"
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+
+	// syntheticRetryAfter is the retry hint of an origin missing for a
+	// while, as endpoints come back
+	syntheticRetryAfter = 5 * time.Second
+)
+
+// syntheticPage describes a response the controller answers itself,
+// without reaching the origin
+type syntheticPage struct {
+	status     int
+	reason     string
+	message    string
+	retryAfter time.Duration
+	location   string
+	// html replaces the rendered page for html clients, e.g. the body
+	// configured for maintenance
+	html string
+}
+
+// syntheticData is the variables of the html page and the json body
+type syntheticData struct {
+	Status     int    `json:"status"`
+	Error      string `json:"error"`
+	Reason     string `json:"reason"`
+	Message    string `json:"message,omitempty"`
+	Hostname   string `json:"hostname"`
+	RetryAfter int    `json:"retryAfter,omitempty"`
+	Location   string `json:"location,omitempty"`
+}
+
+var syntheticTemplate = template.Must(template.New("synthetic").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Status}} {{.Error}}</title>
+</head>
+<body>
+<h1>{{.Status}} {{.Error}}</h1>
+{{if .Message}}<p>{{.Message}}</p>
+{{end}}{{if .Location}}<p><a href="{{.Location}}">{{.Location}}</a></p>
+{{end}}{{if .RetryAfter}}<p>Please retry in {{.RetryAfter}} seconds.</p>
+{{end}}<hr>
+<p><small>{{.Hostname}} &middot; {{.Reason}}</small></p>
+</body>
+</html>
+`))
+
+// syntheticEncodings are the compressions offered, best first; brotli is
+// only offered when built in
+var syntheticEncodings = func() []string {
+	if brotliEnabled {
+		return []string{encodingBrotli, encodingGzip, encodingIdentity}
+	}
+	return []string{encodingGzip, encodingIdentity}
+}()
+
+// newSyntheticPageResponse renders the page as html or json, as accepted
+// by the request, compressed when the request accepts it. Synthetic
+// responses are never cached, they describe a transient state.
+func newSyntheticPageResponse(req *http.Request, page syntheticPage) *http.Response {
+	data := syntheticData{
+		Status:     page.status,
+		Error:      http.StatusText(page.status),
+		Reason:     page.reason,
+		Message:    page.message,
+		Hostname:   requestHostname(req),
+		RetryAfter: int(page.retryAfter / time.Second),
+		Location:   page.location,
+	}
+
+	var body []byte
+	contentType := "text/html; charset=utf-8"
+	// a client accepting neither gets html rather than a 406
+	if negotiate(req.Header.Get("Accept"), []string{mediaTypeHTML, mediaTypeJSON}) == mediaTypeJSON {
+		contentType = "application/json"
+		body, _ = json.Marshal(data)
+		body = append(body, '\n')
+	} else if len(page.html) > 0 {
+		body = []byte(page.html)
+	} else {
+		var buf bytes.Buffer
+		syntheticTemplate.Execute(&buf, data)
+		body = buf.Bytes()
+	}
+
+	encoding := negotiate(req.Header.Get("Accept-Encoding"), syntheticEncodings)
+	if encoded, err := encodeSynthetic(encoding, body); err == nil {
+		body = encoded
+	} else {
+		encoding = encodingIdentity
+	}
+
+	res := newSyntheticResponse(req, page.status, contentType, string(body))
+	res.Header.Set("Cache-Control", "no-store")
+	res.Header.Set("Vary", "Accept, Accept-Encoding")
+	if len(encoding) > 0 && encoding != encodingIdentity {
+		res.Header.Set("Content-Encoding", encoding)
+	}
+	if data.RetryAfter > 0 {
+		res.Header.Set("Retry-After", strconv.Itoa(data.RetryAfter))
+	}
+	if len(page.location) > 0 {
+		res.Header.Set("Location", page.location)
+	}
+	return res
+}
+
+// requestHostname is the public hostname of the request
+func requestHostname(req *http.Request) string {
+	if len(req.Host) > 0 {
+		return req.Host
+	}
+	if req.URL != nil {
+		return req.URL.Host
+	}
+	return ""
+}
+
+func encodeSynthetic(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case encodingBrotli:
+		return encodeBrotli(body)
+	case encodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case encodingIdentity, "":
+		return body, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// acceptRange is a media range or coding of an accept header
+type acceptRange struct {
+	value string
+	q     float64
+}
+
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(value) == 0 {
+			continue
+		}
+		r := acceptRange{value: value, q: 1}
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// acceptQuality is the quality the ranges give an offer, the most
+// specific matching range wins; -1 when no range matches
+func acceptQuality(ranges []acceptRange, offer string) float64 {
+	q, specificity := -1.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.value == offer:
+			s = 2
+		case strings.HasSuffix(r.value, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(r.value, "*")):
+			s = 1
+		case r.value == "*" || r.value == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+// negotiate picks the offer the header prefers, ties going to the earlier
+// offer, empty without a header or an acceptable offer. For codings,
+// identity is acceptable unless excluded, as by RFC 7231.
+func negotiate(header string, offers []string) string {
+	if len(strings.TrimSpace(header)) == 0 {
+		return ""
+	}
+	ranges := parseAccept(header)
+	type scored struct {
+		offer string
+		q     float64
+	}
+	var candidates []scored
+	for _, offer := range offers {
+		q := acceptQuality(ranges, offer)
+		if q < 0 && offer == encodingIdentity {
+			q = 0.001
+		}
+		if q > 0 {
+			candidates = append(candidates, scored{offer: offer, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].q > candidates[b].q
+	})
+	return candidates[0].offer
+}
@@ -0,0 +1,15 @@
+//go:build cgo
+// +build cgo
+
+package argotunnel
+
+import (
+	"github.com/cloudflare/brotli-go"
+)
+
+// brotliEnabled reports brotli encoding built in, it needs cgo
+const brotliEnabled = true
+
+func encodeBrotli(body []byte) ([]byte, error) {
+	return brotli.Encode(body, brotli.WriterOptions{Quality: 5, LGWin: 0})
+}
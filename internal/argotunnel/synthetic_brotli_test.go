@@ -0,0 +1,31 @@
+//go:build cgo
+// +build cgo
+
+package argotunnel
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/brotli-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyntheticBrotli(t *testing.T) {
+	t.Parallel()
+	req, _ := http.NewRequest(http.MethodGet, "http://svc.unit:80/app", nil)
+	req.Host = "www.unit.com"
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	res := newSyntheticPageResponse(req, syntheticPages["no-endpoints"])
+	assert.Equalf(t, encodingBrotli, res.Header.Get("Content-Encoding"), "test brotli encoding mismatch")
+
+	body, _ := io.ReadAll(res.Body)
+	assert.Equalf(t, int64(len(body)), res.ContentLength, "test brotli content length mismatch")
+	decoded, err := brotli.Decode(body)
+	assert.Nilf(t, err, "test brotli decode error mismatch")
+	want, _ := os.ReadFile(filepath.Join("testdata", "synthetic", "no-endpoints.html"))
+	assert.Equalf(t, string(want), string(decoded), "test brotli body mismatch")
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+// +build !cgo
+
+package argotunnel
+
+import (
+	"fmt"
+)
+
+const brotliEnabled = false
+
+func encodeBrotli(body []byte) ([]byte, error) {
+	return nil, fmt.Errorf("brotli encoding needs cgo")
+}
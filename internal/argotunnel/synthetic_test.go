@@ -0,0 +1,232 @@
+package argotunnel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of testdata")
+
+// syntheticPages are the responses of every synthetic path
+var syntheticPages = map[string]syntheticPage{
+	"body-too-large": {
+		status:  http.StatusRequestEntityTooLarge,
+		reason:  syntheticReasonBodyTooLarge,
+		message: "The request body exceeds 1024 bytes.",
+	},
+	"no-endpoints": {
+		status:     http.StatusServiceUnavailable,
+		reason:     syntheticReasonNoEndpoints,
+		message:    "No endpoints are available for this service.",
+		retryAfter: syntheticRetryAfter,
+	},
+	"maintenance": {
+		status:  maintenanceStatusDefault,
+		reason:  syntheticReasonMaintenance,
+		message: "Down for maintenance.",
+		html:    maintenanceBodyDefault,
+	},
+	"backend-missing": {
+		status:     http.StatusServiceUnavailable,
+		reason:     syntheticReasonMissing,
+		message:    "The backend service is missing.",
+		retryAfter: syntheticRetryAfter,
+	},
+	"not-found": {
+		status:  http.StatusNotFound,
+		reason:  syntheticReasonNotFound,
+		message: "The requested path was not found.",
+	},
+	"origin-busy": {
+		status:     http.StatusServiceUnavailable,
+		reason:     syntheticReasonOriginBusy,
+		message:    "The origin is busy, 2 requests in flight.",
+		retryAfter: time.Second,
+	},
+	"redirect": {
+		status:   http.StatusFound,
+		reason:   syntheticReasonRedirect,
+		location: "https://example.com/a?b=<c>",
+	},
+	"trailing-slash": {
+		status:   http.StatusMovedPermanently,
+		reason:   syntheticReasonSlashRedirect,
+		location: "/app/",
+	},
+}
+
+func TestSyntheticGolden(t *testing.T) {
+	t.Parallel()
+	for name, page := range syntheticPages {
+		for _, test := range []struct {
+			accept      string
+			contentType string
+			ext         string
+		}{
+			{accept: "text/html,application/xhtml+xml,*/*;q=0.8", contentType: "text/html; charset=utf-8", ext: ".html"},
+			{accept: "application/json", contentType: "application/json", ext: ".json"},
+		} {
+			req, _ := http.NewRequest(http.MethodGet, "http://svc.unit:80/app", nil)
+			req.Host = "www.unit.com"
+			req.Header.Set("Accept", test.accept)
+			res := newSyntheticPageResponse(req, page)
+			body, _ := io.ReadAll(res.Body)
+
+			golden := filepath.Join("testdata", "synthetic", name+test.ext)
+			if *updateGolden {
+				os.MkdirAll(filepath.Dir(golden), 0755)
+				os.WriteFile(golden, body, 0644)
+			}
+			want, err := os.ReadFile(golden)
+			assert.Nilf(t, err, "test '%s' golden '%s' error mismatch", name, golden)
+			assert.Equalf(t, string(want), string(body), "test '%s' golden '%s' mismatch", name, golden)
+			assert.Equalf(t, page.status, res.StatusCode, "test '%s' status mismatch", name)
+			assert.Equalf(t, test.contentType, res.Header.Get("Content-Type"), "test '%s' content type mismatch", name)
+			assert.Equalf(t, "no-store", res.Header.Get("Cache-Control"), "test '%s' cache control mismatch", name)
+			assert.Equalf(t, "Accept, Accept-Encoding", res.Header.Get("Vary"), "test '%s' vary mismatch", name)
+			assert.Equalf(t, page.location, res.Header.Get("Location"), "test '%s' location mismatch", name)
+			assert.Equalf(t, int64(len(body)), res.ContentLength, "test '%s' content length mismatch", name)
+		}
+	}
+}
+
+func TestSyntheticRetryAfter(t *testing.T) {
+	t.Parallel()
+	req, _ := http.NewRequest(http.MethodGet, "http://unit.com", nil)
+	res := newSyntheticPageResponse(req, syntheticPages["no-endpoints"])
+	assert.Equalf(t, "5", res.Header.Get("Retry-After"), "test retry after mismatch")
+	res = newSyntheticPageResponse(req, syntheticPages["not-found"])
+	assert.Equalf(t, "", res.Header.Get("Retry-After"), "test no retry after mismatch")
+}
+
+func TestSyntheticGzip(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		encoding string
+		out      string
+	}{
+		"gzip": {
+			encoding: "gzip, deflate",
+			out:      "gzip",
+		},
+		"gzip-refused": {
+			encoding: "gzip;q=0",
+		},
+		"identity": {
+			encoding: "",
+		},
+		"unsupported": {
+			encoding: "compress",
+		},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://svc.unit:80/app", nil)
+		req.Host = "www.unit.com"
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Accept-Encoding", test.encoding)
+		res := newSyntheticPageResponse(req, syntheticPages["not-found"])
+		assert.Equalf(t, test.out, res.Header.Get("Content-Encoding"), "test '%s' encoding mismatch", name)
+
+		body, _ := io.ReadAll(res.Body)
+		assert.Equalf(t, int64(len(body)), res.ContentLength, "test '%s' content length mismatch", name)
+		if test.out == encodingGzip {
+			r, err := gzip.NewReader(bytes.NewReader(body))
+			assert.Nilf(t, err, "test '%s' gzip error mismatch", name)
+			body, _ = io.ReadAll(r)
+		}
+		want, _ := os.ReadFile(filepath.Join("testdata", "synthetic", "not-found.json"))
+		assert.Equalf(t, string(want), string(body), "test '%s' body mismatch", name)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+	media := []string{mediaTypeHTML, mediaTypeJSON}
+	codings := []string{encodingBrotli, encodingGzip, encodingIdentity}
+	for name, test := range map[string]struct {
+		header string
+		offers []string
+		out    string
+	}{
+		"empty": {
+			header: "",
+			offers: media,
+			out:    "",
+		},
+		"browser": {
+			header: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			offers: media,
+			out:    mediaTypeHTML,
+		},
+		"json": {
+			header: "application/json",
+			offers: media,
+			out:    mediaTypeJSON,
+		},
+		"json-preferred": {
+			header: "text/html;q=0.5, application/json",
+			offers: media,
+			out:    mediaTypeJSON,
+		},
+		"wildcard-tie": {
+			header: "*/*",
+			offers: media,
+			out:    mediaTypeHTML,
+		},
+		"subtype-wildcard": {
+			header: "application/*",
+			offers: media,
+			out:    mediaTypeJSON,
+		},
+		"specific-excluded": {
+			header: "*/*, text/html;q=0",
+			offers: media,
+			out:    mediaTypeJSON,
+		},
+		"unacceptable": {
+			header: "image/png",
+			offers: media,
+			out:    "",
+		},
+		"case-insensitive": {
+			header: "Application/JSON",
+			offers: media,
+			out:    mediaTypeJSON,
+		},
+		"coding-order": {
+			header: "gzip, deflate, br",
+			offers: codings,
+			out:    encodingBrotli,
+		},
+		"coding-quality": {
+			header: "br;q=0.5, gzip",
+			offers: codings,
+			out:    encodingGzip,
+		},
+		"coding-identity-implied": {
+			header: "deflate",
+			offers: codings,
+			out:    encodingIdentity,
+		},
+		"coding-identity-excluded": {
+			header: "identity;q=0",
+			offers: codings,
+			out:    "",
+		},
+		"coding-wildcard": {
+			header: "*",
+			offers: codings,
+			out:    encodingBrotli,
+		},
+	} {
+		assert.Equalf(t, test.out, negotiate(test.header, test.offers), "test '%s' negotiate mismatch", name)
+	}
+}
@@ -0,0 +1,75 @@
+package argotunnel
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registrationThrottleMax bounds the delay asked by the edge, a larger
+// delay is cut to it
+const registrationThrottleMax = time.Hour
+
+// registrationThrottleErrors are the error fragments of registrations
+// refused by the edge for their rate. The registration rpc of the edge
+// only carries a message, the delay is read from its text.
+var registrationThrottleErrors = []string{
+	"429",
+	"rate limit",
+	"rate-limit",
+	"ratelimit",
+	"throttl",
+	"too many",
+}
+
+var registrationRetryAfter = regexp.MustCompile(`retry[- _]?after[:= ]*([0-9]+(?:\.[0-9]+)?)\s*(ms|s|m|h|sec|secs|seconds?|minutes?)?\b`)
+
+// registrationThrottle reads the delay asked by the edge from a refused
+// registration, false when the error is not a throttle. A throttle
+// without a delay asks for 0.
+func registrationThrottle(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	msg := strings.ToLower(err.Error())
+	m := registrationRetryAfter.FindStringSubmatch(msg)
+	if m == nil {
+		for _, s := range registrationThrottleErrors {
+			if strings.Contains(msg, s) {
+				return 0, true
+			}
+		}
+		return 0, false
+	}
+
+	n, perr := strconv.ParseFloat(m[1], 64)
+	if perr != nil {
+		return 0, true
+	}
+	unit := time.Second
+	switch m[2] {
+	case "ms":
+		unit = time.Millisecond
+	case "m", "minute", "minutes":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	}
+	d := time.Duration(n * float64(unit))
+	if d > registrationThrottleMax {
+		d = registrationThrottleMax
+	}
+	return d, true
+}
+
+// repairWait is the delay before the repair of a link failing with err,
+// the backoff delay raised to the delay asked by the edge. It returns the
+// source of the delay.
+func repairWait(host string, err error, backoff time.Duration) (time.Duration, string) {
+	if wait, ok := registrationThrottle(err); ok && wait > backoff {
+		registrationsDeferred.WithLabelValues(host).Inc()
+		return wait, "edge retry-after"
+	}
+	return backoff, "backoff"
+}
@@ -0,0 +1,104 @@
+package argotunnel
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationThrottle(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		err       error
+		wait      time.Duration
+		throttled bool
+	}{
+		"nil": {},
+		"other-error": {
+			err: errors.New("Server error: hostname already registered"),
+		},
+		"rate-limited": {
+			err:       errors.New("Server error: rate limited"),
+			throttled: true,
+		},
+		"too-many-requests": {
+			err:       errors.New("Server error: 429 Too Many Requests"),
+			throttled: true,
+		},
+		"retry-after-seconds": {
+			err:       errors.New("Server error: too many registrations, retry after 30s"),
+			wait:      30 * time.Second,
+			throttled: true,
+		},
+		"retry-after-header": {
+			err:       errors.New("Server error: Retry-After: 120"),
+			wait:      2 * time.Minute,
+			throttled: true,
+		},
+		"retry-after-minutes": {
+			err:       errors.New("Server error: throttled, retry_after=5 minutes"),
+			wait:      5 * time.Minute,
+			throttled: true,
+		},
+		"retry-after-milliseconds": {
+			err:       errors.New("Server error: retry after 1500ms"),
+			wait:      1500 * time.Millisecond,
+			throttled: true,
+		},
+		"retry-after-capped": {
+			err:       errors.New("Server error: retry after 86400"),
+			wait:      registrationThrottleMax,
+			throttled: true,
+		},
+	} {
+		wait, throttled := registrationThrottle(test.err)
+		assert.Equalf(t, test.throttled, throttled, "test '%s' throttled mismatch", name)
+		assert.Equalf(t, test.wait, wait, "test '%s' wait mismatch", name)
+	}
+}
+
+func TestRepairWait(t *testing.T) {
+	for name, test := range map[string]struct {
+		err      error
+		backoff  time.Duration
+		wait     time.Duration
+		source   string
+		deferred float64
+	}{
+		"backoff": {
+			err:     errors.New("Server error: hostname already registered"),
+			backoff: time.Second,
+			wait:    time.Second,
+			source:  "backoff",
+		},
+		"throttle-below-backoff": {
+			err:     errors.New("Server error: retry after 1s"),
+			backoff: 4 * time.Second,
+			wait:    4 * time.Second,
+			source:  "backoff",
+		},
+		"throttle-without-delay": {
+			err:     errors.New("Server error: rate limited"),
+			backoff: time.Second,
+			wait:    time.Second,
+			source:  "backoff",
+		},
+		"throttle-above-backoff": {
+			err:      errors.New("Server error: rate limited, retry after 30s"),
+			backoff:  time.Second,
+			wait:     30 * time.Second,
+			source:   "edge retry-after",
+			deferred: 1,
+		},
+	} {
+		host := name + ".unit.com"
+		wait, source := repairWait(host, test.err, test.backoff)
+		assert.Equalf(t, test.wait, wait, "test '%s' wait mismatch", name)
+		assert.Equalf(t, test.source, source, "test '%s' source mismatch", name)
+		assert.Equalf(t, test.deferred, testutil.ToFloat64(registrationsDeferred.WithLabelValues(host)), "test '%s' deferred mismatch", name)
+		registrationsDeferred.DeleteLabelValues(host)
+	}
+}
@@ -1,36 +1,59 @@
 package argotunnel
 
 import (
+	"context"
 	"fmt"
 	networkingv1 "k8s.io/api/networking/v1"
+	"net"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cloudflare/cloudflare-ingress-controller/internal/cloudflare"
 	"github.com/cloudflare/cloudflare-ingress-controller/internal/k8s"
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
+// externalNameLookupTimeout bounds the check of an ExternalName origin
+const externalNameLookupTimeout = 5 * time.Second
+
 type translator interface {
-	handleResource(kind, key string) (err error)
+	handleResource(ctx context.Context, kind, key string) (err error)
 	waitForCacheSync(stopCh <-chan struct{}) (ok bool)
 	run(stopCh <-chan struct{}) (err error)
 }
 
-func newTranslator(informers informerset, log *logrus.Logger, opts options) translator {
+func newTranslator(informers informerset, router tunnelRouter, recorder record.EventRecorder, webhook *eventWebhook, log *logrus.Logger, opts options) translator {
 	return &syncTranslator{
 		informers: informers,
-		router:    newTunnelRouter(log, opts),
+		router:    router,
+		recorder:  recorder,
+		webhook:   webhook,
+		status:    newIngressStatusWriter(opts.statusClient, log),
 		log:       log,
 		options:   opts,
+		backends:  missingBackends,
+		rejected:  newPolicyRejectionTracker(),
 	}
 }
 
 type syncTranslator struct {
-	informers informerset
-	router    tunnelRouter
-	log       *logrus.Logger
-	options   options
+	informers  informerset
+	router     tunnelRouter
+	recorder   record.EventRecorder
+	webhook    *eventWebhook
+	status     *ingressStatusWriter
+	log        *logrus.Logger
+	options    options
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	backends   *backendTracker
+	rejected   *policyRejectionTracker
 }
 
 func (t *syncTranslator) run(stopCh <-chan struct{}) (err error) {
@@ -43,26 +66,29 @@ func (t *syncTranslator) waitForCacheSync(stopCh <-chan struct{}) (ok bool) {
 	return
 }
 
-func (t *syncTranslator) handleResource(kind, key string) (err error) {
-	handlerFuncs := map[string]func(kind, key string) error{
-		endpointKind: t.handleEndpoint,
-		ingressKind:  t.handleIngress,
-		secretKind:   t.handleByKind,
-		serviceKind:  t.handleByKind,
+// handleResource reconciles the resource of the key, ctx bounds the
+// reconcile
+func (t *syncTranslator) handleResource(ctx context.Context, kind, key string) (err error) {
+	handlerFuncs := map[string]func(ctx context.Context, kind, key string) error{
+		configMapKind: t.handleConfigMap,
+		endpointKind:  t.handleEndpoint,
+		ingressKind:   t.handleIngress,
+		secretKind:    t.handleByKind,
+		serviceKind:   t.handleByKind,
 	}
 	if handlerFunc, ok := handlerFuncs[kind]; ok {
-		err = handlerFunc(kind, key)
+		err = handlerFunc(ctx, kind, key)
 	} else {
 		err = fmt.Errorf("unexpected kind (%q) in key (%q)", kind, key)
 	}
 	return
 }
 
-func (t *syncTranslator) handleEndpoint(kind, key string) (err error) {
+func (t *syncTranslator) handleEndpoint(ctx context.Context, kind, key string) (err error) {
 	_, exists, err := t.informers.endpoint.GetIndexer().GetByKey(key)
 	if err == nil {
 		if exists {
-			err = t.updateByKind(serviceKind, key)
+			err = t.updateByKind(ctx, serviceKind, key)
 		} else {
 			err = t.deleteByKind(serviceKind, key)
 		}
@@ -70,7 +96,48 @@ func (t *syncTranslator) handleEndpoint(kind, key string) (err error) {
 	return
 }
 
-func (t *syncTranslator) handleByKind(kind, key string) (err error) {
+// handleConfigMap rebuilds the routes referencing the ConfigMap, a
+// missing ConfigMap falls back to the default maintenance response.
+// Annotation defaults rebuild every route they may apply to.
+func (t *syncTranslator) handleConfigMap(ctx context.Context, kind, key string) (err error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+	if t.isAnnotationDefaults(name) {
+		return t.updateByAnnotationDefaults(ctx, namespace, name)
+	}
+	err = t.updateByKind(ctx, kind, key)
+	return
+}
+
+// updateByAnnotationDefaults rebuilds the routes of the ingresses the
+// defaults ConfigMap applies to, every ingress for the defaults ConfigMap
+// and those of its namespace for a namesake
+func (t *syncTranslator) updateByAnnotationDefaults(ctx context.Context, namespace, name string) (err error) {
+	t.log.Debugf("translator update annotation defaults: %s", itemKeyFunc(namespace, name))
+	all := t.options.annotationDefaults.namespace == namespace
+	var states []*ingressState
+	for _, obj := range t.informers.ingress.GetStore().List() {
+		ing := obj.(*networkingv1.Ingress)
+		if (!all && ing.Namespace != namespace) || !matchIngressClass(ing, t.options.ingressClass, t.options.adoptUnclassed) {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if state := t.getIngressState(ctx, ing); state != nil {
+			states = append(states, state)
+		}
+	}
+	if len(states) == 0 {
+		return
+	}
+	err = t.reconcileStates(ctx, configMapKind, namespace, name, states)
+	return
+}
+
+func (t *syncTranslator) handleByKind(ctx context.Context, kind, key string) (err error) {
 	indexer, err := t.informers.getKindIndexer(kind)
 	if err != nil {
 		return
@@ -78,7 +145,7 @@ func (t *syncTranslator) handleByKind(kind, key string) (err error) {
 	_, exists, err := indexer.GetByKey(key)
 	if err == nil {
 		if exists {
-			err = t.updateByKind(kind, key)
+			err = t.updateByKind(ctx, kind, key)
 		} else {
 			err = t.deleteByKind(kind, key)
 		}
@@ -86,7 +153,7 @@ func (t *syncTranslator) handleByKind(kind, key string) (err error) {
 	return
 }
 
-func (t *syncTranslator) updateByKind(kind, key string) (err error) {
+func (t *syncTranslator) updateByKind(ctx context.Context, kind, key string) (err error) {
 	t.log.Debugf("translator update %s: %s", kind, key)
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -100,13 +167,16 @@ func (t *syncTranslator) updateByKind(kind, key string) (err error) {
 		return
 	}
 
-	routes := make([]*tunnelRoute, 0, len(objs))
+	states := make([]*ingressState, 0, len(objs))
 	for _, obj := range objs {
-		if route := t.getRouteFromIngress(obj.(*networkingv1.Ingress)); route != nil {
-			routes = append(routes, route)
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if state := t.getIngressState(ctx, obj.(*networkingv1.Ingress)); state != nil {
+			states = append(states, state)
 		}
 	}
-	t.router.updateByKindRoutes(kind, namespace, name, routes)
+	err = t.reconcileStates(ctx, kind, namespace, name, states)
 	return
 }
 
@@ -116,6 +186,9 @@ func (t *syncTranslator) deleteByKind(kind, key string) (err error) {
 	if err != nil {
 		return
 	}
+	if kind == secretKind {
+		t.eventfSecretDeleted(key)
+	}
 
 	keys, err := t.informers.ingress.GetIndexer().IndexKeys(kind, key)
 	if err != nil {
@@ -123,30 +196,104 @@ func (t *syncTranslator) deleteByKind(kind, key string) (err error) {
 	} else if len(keys) == 0 {
 		return
 	}
+	if kind == serviceKind {
+		if err = t.deferBackendRemoval(namespace, name); err != nil {
+			return
+		}
+	}
 	err = t.router.deleteByKindKeys(kind, namespace, name, keys)
 	return
 }
 
-func (t *syncTranslator) handleIngress(kind, key string) (err error) {
+// deferBackendRemoval keeps the tunnels of a deleted backend service
+// through the grace, the key is requeued until the grace elapses. The
+// ingresses are told once the grace starts, and once the tunnels go.
+func (t *syncTranslator) deferBackendRemoval(namespace, name string) (err error) {
+	grace := t.options.missingBackendGrace
+	if grace <= 0 || t.backends == nil {
+		return
+	}
+	key := itemKeyFunc(namespace, name)
+	m, first := t.backends.mark(namespace, name)
+	if m.removed {
+		return
+	}
+	if remaining := t.backends.remaining(m, grace); remaining > 0 {
+		if first {
+			t.log.Infof("translator backend missing: %s, keeping tunnels for %s", key, grace)
+			t.eventfByKind(serviceKind, key, v1.EventTypeWarning, eventReasonBackendMissing, "backend service %q missing, tunnels kept for %s before removal", key, grace)
+		}
+		return &requeueError{after: remaining}
+	}
+	t.backends.removed(namespace, name)
+	t.log.Infof("translator backend missing: %s, grace elapsed, removing tunnels", key)
+	t.eventfByKind(serviceKind, key, v1.EventTypeNormal, eventReasonRouteRemoved, "backend service %q missing past the %s grace, tunnels removed", key, grace)
+	return
+}
+
+// eventfByKind records an event on each ingress referencing the resource
+func (t *syncTranslator) eventfByKind(kind, key, eventtype, reason, messageFmt string, args ...interface{}) {
+	objs, err := t.informers.ingress.GetIndexer().ByIndex(kind, key)
+	if err != nil {
+		return
+	}
+	for _, obj := range objs {
+		t.eventf(obj.(*networkingv1.Ingress), eventtype, reason, messageFmt, args...)
+	}
+}
+
+// eventfSecretDeleted records a warning on each ingress with hosts still
+// routed through the deleted origin secret
+func (t *syncTranslator) eventfSecretDeleted(key string) {
+	for _, usage := range getSecretUsages(t.router.tunnels()) {
+		if usage.Secret != key {
+			continue
+		}
+		hosts := map[string][]string{}
+		for _, route := range usage.Routes {
+			hosts[route.Ingress] = append(hosts[route.Ingress], route.Hostname)
+		}
+		for ingkey, hostnames := range hosts {
+			t.log.Warnf("translator secret deleted while referenced: %s, ingress: %s, hosts: %v", key, ingkey, hostnames)
+			obj, exists, err := t.informers.ingress.GetIndexer().GetByKey(ingkey)
+			if err != nil || !exists {
+				continue
+			}
+			t.eventf(obj.(*networkingv1.Ingress), v1.EventTypeWarning, eventReasonSecretDeleted, "origin certificate secret %q deleted while hosts %s route through it", key, strings.Join(hostnames, ", "))
+		}
+	}
+}
+
+func (t *syncTranslator) handleIngress(ctx context.Context, kind, key string) (err error) {
 	obj, exists, err := t.informers.ingress.GetIndexer().GetByKey(key)
 	if err == nil {
-		if exists {
-			err = t.updateIngress(key, obj.(*networkingv1.Ingress))
+		if ing, _ := obj.(*networkingv1.Ingress); exists && matchIngressClass(ing, t.options.ingressClass, t.options.adoptUnclassed) {
+			err = t.updateIngress(ctx, key, ing)
 		} else {
+			// a deleted ingress, or one no longer matching the class
+			// after a reload, releases its tunnels
 			err = t.deleteIngress(key)
 		}
 	}
 	return
 }
 
-func (t *syncTranslator) updateIngress(key string, ing *networkingv1.Ingress) (err error) {
+func (t *syncTranslator) updateIngress(ctx context.Context, key string, ing *networkingv1.Ingress) (err error) {
 	t.log.Debugf("translator update ingress: %s", key)
-	if route := t.getRouteFromIngress(ing); route != nil {
-		err = t.router.updateRoute(route)
+	if state := t.getIngressState(ctx, ing); state != nil {
+		err = t.reconcileStates(ctx, ingressKind, ing.Namespace, ing.Name, []*ingressState{state})
+		if err == nil && state.requeue > 0 {
+			err = &requeueError{after: state.requeue}
+		}
 	}
 	return
 }
 
+// reconciled records the successful reconcile of the ingress of a route
+func reconciled(route *tunnelRoute) {
+	ingressLastReconcile.WithLabelValues(route.namespace, route.name).SetToCurrentTime()
+}
+
 func (t *syncTranslator) deleteIngress(key string) (err error) {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -154,18 +301,56 @@ func (t *syncTranslator) deleteIngress(key string) (err error) {
 	}
 
 	t.log.Debugf("translator delete ingress: %s", key)
+	t.status.forget(namespace, name)
+	t.rejected.forget(key)
+	ingressLastReconcile.DeleteLabelValues(namespace, name)
 	err = t.router.deleteByRoute(namespace, name)
 	return
 }
 
-func (t *syncTranslator) getRouteFromIngress(ing *networkingv1.Ingress) (r *tunnelRoute) {
+// getRouteFromIngress derives the tunnels of an ingress, without the
+// status and events of the reconcile
+func (t *syncTranslator) getRouteFromIngress(ctx context.Context, ing *networkingv1.Ingress) (r *tunnelRoute) {
+	if s := t.getIngressState(ctx, ing); s != nil {
+		r = s.route
+	}
+	return
+}
+
+// getIngressState derives the desired state of an ingress, side effects
+// are left to the sub-reconcilers, ctx bounds the origin lookups
+func (t *syncTranslator) getIngressState(ctx context.Context, ing *networkingv1.Ingress) (s *ingressState) {
 	// TODO: update function to allow specific failure detection for testing
 	switch {
 	case ing == nil:
 		return
+	case isACMESolver(ing):
+		// served by the tunnel of the host, see acmeResolver
+		return
 	}
 
-	opts := collectTunnelOptions(parseIngressTunnelOptions(ing))
+	s = newIngressState(ing)
+	ing = withAnnotationDefaults(ing, t.getAnnotationDefaults(ing.Namespace))
+	if keys := getOversizedAnnotations(ing); len(keys) > 0 {
+		ing = ing.DeepCopy()
+		for _, key := range keys {
+			t.log.Errorf("translator annotation too large on ingress: %s, annotation: %s, bytes: %d", itemKeyFunc(ing.Namespace, ing.Name), key, len(ing.Annotations[key]))
+			s.eventf(v1.EventTypeWarning, eventReasonAnnotationTooLarge, "annotation %q is %d bytes, over the %d byte limit, ignored", key, len(ing.Annotations[key]), annotationValueLimit)
+			delete(ing.Annotations, key)
+		}
+	}
+
+	tunnelOpts := append(defaultTunnelOptions(t.options), parseIngressTunnelOptions(ing)...)
+	opts := collectTunnelOptions(tunnelOpts)
+	if opts.maintenance {
+		opts = collectTunnelOptions(append(tunnelOpts, t.getMaintenanceResponse(ing)))
+	}
+	if origin, ok := t.getFallbackOrigin(ing); ok {
+		fallbackOrigin(origin)(&opts)
+	}
+	if headers, ok := t.getResponseHeaders(ing); ok {
+		responseHeaders(headers)(&opts)
+	}
 	hostsecret := make(map[string]*resource)
 	for _, tls := range ing.Spec.TLS {
 		for _, host := range tls.Hosts {
@@ -175,19 +360,76 @@ func (t *syncTranslator) getRouteFromIngress(ing *networkingv1.Ingress) (r *tunn
 			}
 		}
 	}
+	drained, next := drainedHosts(ing, time.Now())
+	s.requeue = next
 	linkmap := tunnelRouteLinkMap{}
 	ingkey := itemKeyFunc(ing.Namespace, ing.Name)
-	for _, rule := range ing.Spec.Rules {
-		if rule.HTTP == nil || len(rule.Host) == 0 {
+	// a host pinned to an edge region is not served outside of it, the
+	// tunnels of the ingress are removed
+	var regionErr error
+	if region, ok := ing.Annotations[annotationIngressEdgeRegion]; ok {
+		regionErr = validateEdgeRegion(region)
+		t.log.Errorf("translator edge region refused on ingress: %s, region: %s: %v", ingkey, region, regionErr)
+		s.eventf(v1.EventTypeWarning, eventReasonEdgeRegionRefused, "annotation %q refused, %v, the tunnels of the ingress are not created", annotationIngressEdgeRegion, regionErr)
+	}
+	for i, rule := range ing.Spec.Rules {
+		if regionErr != nil || (rule.HTTP == nil && opts.redirectCode == 0) {
 			continue
 		}
 		host := rule.Host
+		if len(host) == 0 {
+			if host = getDefaultHost(ing, t.options.defaultHostname); len(host) == 0 {
+				t.log.Warnf("translator rule without host skipped on ingress: %s, rule: %d", ingkey, i)
+				s.eventf(v1.EventTypeWarning, eventReasonRuleSkipped, "rule %d has no host, and neither a single spec.tls host nor a default hostname serves it", i)
+				continue
+			}
+			t.log.Debugf("translator rule without host on ingress: %s, rule: %d, serving host: %s", ingkey, i, host)
+		}
+		if ok, reason := t.options.hostPolicy.Allows(host); !ok {
+			// a rejection is terminal until the ingress changes
+			if t.rejected.reject(ingkey, ing.Generation, host) {
+				t.log.Errorf("translator host rejected by policy on ingress: %s, host: %s, reason: %s", ingkey, host, reason)
+				s.eventf(v1.EventTypeWarning, eventReasonHostRejected, "host %q rejected by hostname policy (%s)", host, reason)
+				policyRejections.WithLabelValues(reason).Inc()
+			}
+			continue
+		}
+		t.rejected.allow(ingkey, host)
+		if _, ok := hostsecret[host]; t.options.requireTLS && !ok {
+			t.log.Infof("translator host not listed in tls on ingress: %s, host: %s", ingkey, host)
+			s.eventf(v1.EventTypeWarning, eventReasonHostRejected, "host %q not listed in the tls section, required by the controller", host)
+			continue
+		}
+		if !t.options.shard.owns(host) {
+			t.log.Debugf("translator host owned by another shard on ingress: %s, host: %s", ingkey, host)
+			continue
+		}
+		if successor, ok := t.getClassSuccessor(ing, host); ok {
+			t.log.Infof("translator host handed over to the current ingress class on ingress: %s, host: %s, successor: %s", ingkey, host, successor)
+			s.eventf(v1.EventTypeNormal, eventReasonHostSuperseded, "host %q served by ingress %s of the current ingress class", host, successor)
+			continue
+		}
+		if opts.redirectCode != 0 {
+			if target, loop := t.getRedirectLoop(host, opts.redirectURL); loop {
+				t.log.Errorf("translator redirect loop on ingress: %s, host: %s, target: %s", ingkey, host, target)
+				s.eventf(v1.EventTypeWarning, eventReasonRedirectLoop, "host %q redirect to %q refused, the target redirects back", host, target)
+				continue
+			}
+		}
+		// a drained host answers with the maintenance response until the
+		// drain ends, the other hosts of the ingress are left alone
+		opts := opts
+		if drained[host] && !opts.maintenance {
+			t.log.Infof("translator host drained on ingress: %s, host: %s", ingkey, host)
+			maintenance(true)(&opts)
+			t.getMaintenanceResponse(ing)(&opts)
+		}
 		secret := func() *resource {
-			if r, ok := hostsecret[rule.Host]; ok {
+			if r, ok := hostsecret[host]; ok {
 				return r
-			} else if r, ok := t.options.originSecrets[rule.Host]; ok {
+			} else if r, ok := t.options.originSecrets[host]; ok {
 				return r
-			} else if r, ok := getDomainSecret(rule.Host, t.options.domainSecrets); ok {
+			} else if r, ok := getDomainSecret(host, t.options.domainSecrets); ok {
 				return r
 			} else if t.options.secret != nil {
 				return t.options.secret
@@ -202,44 +444,102 @@ func (t *syncTranslator) getRouteFromIngress(ing *networkingv1.Ingress) (r *tunn
 			var exists bool
 			if secret == nil {
 				t.log.Errorf("translator secret not defined on ingress: %s, host: %s", ingkey, host)
+				s.fail(statusReasonSecretMissing, "host %s has no origin certificate secret", host)
 				continue
 			}
 			cert, exists, err = t.getVerifiedCert(secret.namespace, secret.name, host)
-			if err != nil {
+			if routed, ok := t.getRoutedCert(ing, host, *secret); err != nil && ok {
+				t.log.Warnf("translator secret refresh invalid on ingress: %s, host: %s, err: %v, keeping the running certificate", ingkey, host, err)
+				s.eventf(v1.EventTypeWarning, eventReasonCredentialInvalid, "host %q keeps its running origin certificate, secret '%s' is invalid: %v", host, itemKeyFunc(secret.namespace, secret.name), err)
+				credentialRefreshFailures.Inc()
+				cert, err = routed, nil
+			} else if err != nil {
 				t.log.Errorf("translator secret issue on ingress: %s, host: %s, err: %v", ingkey, host, err)
+				s.fail(statusReasonSecretMissing, "host %s: %v", host, err)
 				continue
 			} else if !exists {
 				t.log.Errorf("translator secret missing cert on ingress: %s, host: %s", ingkey, host)
+				s.fail(statusReasonSecretMissing, "host %s: secret '%s' missing cert", host, itemKeyFunc(secret.namespace, secret.name))
 				continue
 			}
 		}
 
+		// redirect, the origin is never dialed and the backend is optional
+		if opts.redirectCode != 0 {
+			rule := tunnelRule{
+				host: host,
+				service: resource{
+					namespace: ing.Namespace,
+					name:      getRedirectBackend(rule),
+				},
+				secret: *secret,
+			}
+			t.log.Debugf("translator attach redirect tunnel: %s, rule: %+v", ingkey, rule)
+			linkmap[rule] = newTunnelLink(rule, cert, opts, nil, t.acmeResolver(), t.linkStatusReporter(ing), t.linkEventNotifier(ing), t.log)
+			continue
+		}
+
 		for _, path := range rule.HTTP.Paths {
 			// ingress
-			if len(path.Path) > 0 && path.Path != "/" {
-				t.log.Errorf("translator path routing not supported on ingress: %s, host: %s, path: %+v", ingkey, host, path)
-				continue
+			pathOpts := opts
+			if hasRewriteCaptures(opts.rewriteTarget) {
+				pattern, ok := getPathPattern(path, len(rule.HTTP.Paths))
+				if !ok {
+					t.log.Errorf("translator path pattern not supported on ingress: %s, host: %s, path: %+v", ingkey, host, path)
+					continue
+				}
+				pathOpts.pathPattern = pattern
+			} else {
+				prefix, ok := getPathPrefix(path, len(rule.HTTP.Paths), opts)
+				if !ok {
+					t.log.Errorf("translator path routing not supported on ingress: %s, host: %s, path: %+v", ingkey, host, path)
+					continue
+				}
+				pathOpts.pathPrefix = prefix
 			}
 			if len(path.Backend.Service.Name) == 0 {
 				t.log.Errorf("translator service empty on ingress: %s, host: %s, path: %+v", ingkey, host, path)
+				s.fail(statusReasonBackendMissing, "host %s has no backend service", host)
 				continue
 			}
 
 			// service
 			var port int32
+			externalName := t.getExternalName(ing.Namespace, path.Backend.Service.Name)
 			{
 				var err error
 				var exists bool
-				port, exists, err = t.getVerifiedPort(ing.Namespace, path.Backend.Service.Name, path.Backend.Service.Port)
+				port, exists, err = t.getVerifiedPort(ctx, ing.Namespace, path.Backend.Service.Name, path.Backend.Service.Port, opts.unreadyEndpoints)
 				if err != nil {
-					t.log.Errorf("translator service issue on ingress: %s, host: %s, path: %+v, err: %q", ingkey, host, path, err)
-					continue
+					if grace, ok := t.getGraceRule(ing, host, path.Backend.Service.Name); ok {
+						port, externalName = grace.port, grace.externalName
+						t.log.Infof("translator service backend missing on ingress: %s, host: %s, path: %+v, err: %q, keeping tunnel through the grace", ingkey, host, path, err)
+					} else if port, exists = t.getMissingBackendPort(ing.Namespace, path.Backend.Service.Name, path.Backend.Service.Port); exists {
+						t.log.Infof("translator service backend missing on ingress: %s, host: %s, path: %+v, err: %q, creating tunnel", ingkey, host, path, err)
+					} else {
+						t.log.Errorf("translator service issue on ingress: %s, host: %s, path: %+v, err: %q", ingkey, host, path, err)
+						s.fail(statusReasonBackendMissing, "host %s: %v", host, err)
+						continue
+					}
+					s.fail(statusReasonBackendMissing, "host %s: %v", host, err)
 				} else if !exists {
 					t.log.Errorf("translator service missing port on ingress: %s, host: %s, path: %+v", ingkey, host, path)
+					s.fail(statusReasonBackendMissing, "host %s: service '%s' missing port", host, itemKeyFunc(ing.Namespace, path.Backend.Service.Name))
 					continue
+				} else {
+					t.backends.clear(ing.Namespace, path.Backend.Service.Name)
 				}
 			}
 
+			// the appProtocol of the service port takes precedence over the
+			// proto annotation, the origin-scheme annotation over both
+			if proto, ok := t.getAppProtocol(ing.Namespace, path.Backend.Service.Name, path.Backend.Service.Port); ok {
+				pathOpts.proto = proto
+			}
+			if len(opts.originScheme) > 0 {
+				pathOpts.proto = opts.originScheme
+			}
+
 			// attach rule|link to route
 			rule := tunnelRule{
 				host: host,
@@ -248,34 +548,253 @@ func (t *syncTranslator) getRouteFromIngress(ing *networkingv1.Ingress) (r *tunn
 					namespace: ing.Namespace,
 					name:      path.Backend.Service.Name,
 				},
-				secret: *secret,
+				secret:       *secret,
+				externalName: externalName,
+			}
+			resolve := t.endpointResolver(opts.unreadyEndpoints)
+			if len(rule.externalName) > 0 {
+				resolve = nil // an external name has no endpoints
 			}
 			t.log.Debugf("translator attach tunnel: %s, rule: %+v", ingkey, rule)
-			linkmap[rule] = newTunnelLink(rule, cert, opts)
+			linkmap[rule] = newTunnelLink(rule, cert, pathOpts, resolve, t.acmeResolver(), t.linkStatusReporter(ing), t.linkEventNotifier(ing), t.log)
 		}
 	}
-	r = &tunnelRoute{
-		name:      ing.Name,
-		namespace: ing.Namespace,
-		links:     linkmap,
+	s.route = &tunnelRoute{
+		name:            ing.Name,
+		namespace:       ing.Namespace,
+		generation:      ing.Generation,
+		resourceVersion: ing.ResourceVersion,
+		created:         ing.CreationTimestamp.Time,
+		annotations:     getControllerAnnotations(ing),
+		links:           linkmap,
 	}
 	return
 }
 
+// getRedirectBackend returns the first backend service of a redirected
+// rule, it only labels the tunnel
+func getRedirectBackend(rule networkingv1.IngressRule) string {
+	if rule.HTTP != nil {
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil && len(path.Backend.Service.Name) > 0 {
+				return path.Backend.Service.Name
+			}
+		}
+	}
+	return ""
+}
+
+// getClassSuccessor returns an ingress of the current class serving the
+// host of an ingress of a legacy class. The legacy ingress keeps the host
+// until the tunnel of the successor is connected, the host is handed over
+// without a teardown in between.
+func (t *syncTranslator) getClassSuccessor(ing *networkingv1.Ingress, host string) (successor string, ok bool) {
+	classes := parseIngressClasses(t.options.ingressClass)
+	if len(classes) < 2 {
+		return
+	}
+	if class, claimed := parseIngressClass(ing); !claimed || class == classes[0] {
+		return
+	}
+	for _, obj := range t.informers.ingress.GetIndexer().List() {
+		other, isIng := obj.(*networkingv1.Ingress)
+		if !isIng {
+			continue
+		}
+		if class, claimed := parseIngressClass(other); !claimed || class != classes[0] {
+			continue
+		}
+		for _, link := range t.router.routeLinks(other.Namespace, other.Name) {
+			if strings.EqualFold(link.host(), host) && len(link.connections()) > 0 {
+				return itemKeyFunc(other.Namespace, other.Name), true
+			}
+		}
+	}
+	return
+}
+
+// getRedirectLoop reports the target of a redirect when it is the host
+// itself, or a host of an ingress of the controller redirecting back.
+func (t *syncTranslator) getRedirectLoop(host, redirectURL string) (target string, loop bool) {
+	target = getRedirectHost(redirectURL)
+	if target == strings.ToLower(host) {
+		return target, true
+	}
+	for _, obj := range t.informers.ingress.GetIndexer().List() {
+		other, ok := obj.(*networkingv1.Ingress)
+		if !ok || !matchIngressClass(other, t.options.ingressClass, t.options.adoptUnclassed) {
+			continue
+		}
+		if _, otherURL, ok := parseMetaRedirect(other); !ok || getRedirectHost(otherURL) != strings.ToLower(host) {
+			continue
+		}
+		for _, rule := range other.Spec.Rules {
+			otherHost := rule.Host
+			if len(otherHost) == 0 {
+				otherHost = getDefaultHost(other, t.options.defaultHostname)
+			}
+			if strings.ToLower(otherHost) == target {
+				return target, true
+			}
+		}
+	}
+	return target, false
+}
+
+func getRedirectHost(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// getDefaultHost returns the host serving the rules of an ingress that
+// omit one: the host of the tls section when it lists a single host,
+// otherwise the controller default hostname.
+func getDefaultHost(ing *networkingv1.Ingress, defaultHostname string) string {
+	var host string
+	for _, tls := range ing.Spec.TLS {
+		for _, h := range tls.Hosts {
+			if len(host) > 0 && h != host {
+				return defaultHostname
+			}
+			host = h
+		}
+	}
+	if len(host) > 0 {
+		return host
+	}
+	return defaultHostname
+}
+
+// getPathPrefix returns the prefix served by a host path. Paths other
+// than the root are only supported as the single prefix of a host with
+// a rewrite target.
+func getPathPrefix(path networkingv1.HTTPIngressPath, paths int, opts tunnelOptions) (prefix string, ok bool) {
+	if len(path.Path) == 0 || path.Path == "/" {
+		return "", true
+	}
+	if len(opts.rewriteTarget) == 0 || paths > 1 {
+		return "", false
+	}
+	if path.PathType != nil && *path.PathType == networkingv1.PathTypeExact {
+		return "", false
+	}
+	return normalizePath(path.Path), true
+}
+
+func (t *syncTranslator) eventf(ing *networkingv1.Ingress, eventtype, reason, messageFmt string, args ...interface{}) {
+	if t.recorder != nil {
+		t.recorder.Eventf(ing, eventtype, reason, messageFmt, args...)
+	}
+}
+
+func (t *syncTranslator) getMaintenanceResponse(ing *networkingv1.Ingress) tunnelOption {
+	status, body := maintenanceStatusDefault, maintenanceBodyDefault
+	if name, ok := parseMaintenanceConfigMap(ing); ok {
+		key := itemKeyFunc(ing.Namespace, name)
+		obj, exists, err := t.informers.configMap.GetIndexer().GetByKey(key)
+		if err != nil {
+			t.log.Errorf("translator maintenance configmap issue on ingress: %s, configmap: %s, err: %v", itemKeyFunc(ing.Namespace, ing.Name), key, err)
+		} else if !exists {
+			t.log.Errorf("translator maintenance configmap missing on ingress: %s, configmap: %s", itemKeyFunc(ing.Namespace, ing.Name), key)
+		} else {
+			status, body = k8s.GetConfigMapResponse(obj.(*v1.ConfigMap), status, body)
+		}
+	}
+	return maintenanceResponse(status, body)
+}
+
+// getResponseHeaders resolves the response headers of an ingress, a
+// missing Secret sets no headers. A Secret is read on each reconcile, its
+// changes apply on the next resync.
+func (t *syncTranslator) getResponseHeaders(ing *networkingv1.Ingress) (headers string, ok bool) {
+	inline, name, ok := parseResponseHeaders(ing)
+	if !ok {
+		return "", false
+	}
+	if len(name) == 0 {
+		return formatHeaderLines(inline), true
+	}
+	ingkey, key := itemKeyFunc(ing.Namespace, ing.Name), itemKeyFunc(ing.Namespace, name)
+	obj, exists, err := t.informers.secret.GetIndexer().GetByKey(key)
+	if err != nil {
+		t.log.Errorf("translator response headers secret issue on ingress: %s, secret: %s, err: %v", ingkey, key, err)
+		return "", false
+	} else if !exists {
+		t.log.Errorf("translator response headers secret missing on ingress: %s, secret: %s", ingkey, key)
+		return "", false
+	}
+	h := http.Header{}
+	for name, value := range obj.(*v1.Secret).Data {
+		if !addHeader(h, name, strings.TrimSpace(string(value))) {
+			t.log.Warnf("translator response header invalid on ingress: %s, secret: %s, header: %q", ingkey, key, name)
+		}
+	}
+	return formatHeaderLines(h), len(h) > 0
+}
+
+// getFallbackOrigin resolves the fallback service of an ingress to its
+// cluster address. Endpoints are not required, an unreachable fallback
+// fails the request as the origin would have.
+func (t *syncTranslator) getFallbackOrigin(ing *networkingv1.Ingress) (origin string, ok bool) {
+	name, port, ok := parseFallbackService(ing)
+	if !ok {
+		return "", false
+	}
+	ingkey, key := itemKeyFunc(ing.Namespace, ing.Name), itemKeyFunc(ing.Namespace, name)
+	obj, exists, err := t.informers.service.GetIndexer().GetByKey(key)
+	if err != nil {
+		t.log.Errorf("translator fallback service issue on ingress: %s, service: %s, err: %v", ingkey, key, err)
+		return "", false
+	} else if !exists {
+		t.log.Errorf("translator fallback service missing on ingress: %s, service: %s", ingkey, key)
+		return "", false
+	}
+	svcport, exists := k8s.GetServicePort(obj.(*v1.Service), port, v1.ProtocolTCP)
+	if !exists {
+		t.log.Errorf("translator fallback service missing port on ingress: %s, service: %s, port: %+v", ingkey, key, port)
+		return "", false
+	}
+	return fmt.Sprintf("%s.%s:%d", name, ing.Namespace, svcport.Port), true
+}
+
 func (t *syncTranslator) getVerifiedCert(namespace, name, host string) (cert []byte, exists bool, err error) {
 	key := itemKeyFunc(namespace, name)
 	obj, exists, err := t.informers.secret.GetIndexer().GetByKey(key)
 	if err != nil {
 		return
 	} else if !exists {
+		if ns := t.options.watchNamespace; len(ns) > 0 && namespace != ns {
+			err = fmt.Errorf("secret '%s' is outside the watched namespace '%s'", key, ns)
+			return
+		}
 		err = fmt.Errorf("secret '%s' does not exist", key)
 		return
 	}
 
 	cert, exists = k8s.GetSecretCert(obj.(*v1.Secret))
-	if !exists {
-		err = fmt.Errorf("secret '%s' missing 'cert.pem'", key)
-		return
+	if exists {
+		var encodings int
+		if cert, encodings, err = cloudflare.DecodeOriginCert(cert); err != nil {
+			err = fmt.Errorf("secret '%s' 'cert.pem' %v", key, err)
+			return
+		} else if encodings > 0 {
+			t.log.Warnf("translator origin certificate base64 encoded in secret: %s, encodings: %d, decoded, set 'cert.pem' under stringData, or encode it once under data", key, encodings)
+		}
+	} else {
+		tlscert, tlskey, token, ok := k8s.GetSecretTLS(obj.(*v1.Secret))
+		if !ok {
+			err = fmt.Errorf("secret '%s' missing 'cert.pem' or 'tls.crt' and 'tls.key'", key)
+			return
+		}
+		cert, err = cloudflare.AssembleOriginCert(tlscert, tlskey, token)
+		if err != nil {
+			err = fmt.Errorf("secret '%s' %v", key, err)
+			return
+		}
+		exists = true
 	}
 
 	err = verifyCertForHost(cert, host)
@@ -285,7 +804,130 @@ func (t *syncTranslator) getVerifiedCert(namespace, name, host string) (cert []b
 	return
 }
 
-func (t *syncTranslator) getVerifiedPort(namespace, name string, port networkingv1.ServiceBackendPort) (val int32, exists bool, err error) {
+// linkStatusReporter records the conditions reported by the tunnels
+// of the ingress, a tunnel that stopped repairing raises an event
+func (t *syncTranslator) linkStatusReporter(ing *networkingv1.Ingress) linkStatusFunc {
+	namespace, name, uid := ing.Namespace, ing.Name, ing.UID
+	return func(host string, cond ingressCondition) {
+		switch cond.reason {
+		case statusReasonRepairsExhausted:
+			t.eventf(ing, v1.EventTypeWarning, eventReasonTunnelFailed, "tunnel for host %q stopped repairing, retried on the next resync", host)
+		case statusReasonClockSkewed:
+			t.eventf(ing, v1.EventTypeWarning, eventReasonClockSkewed, "%s", cond.message)
+		case statusReasonReadinessTimeout:
+			t.eventf(ing, v1.EventTypeWarning, eventReasonReadinessTimeout, "%s", cond.message)
+		}
+		t.status.writeHost(namespace, name, uid, host, cond)
+	}
+}
+
+// linkEventNotifier posts the connection events of the tunnels of the
+// ingress to the event webhook, nil without a webhook
+func (t *syncTranslator) linkEventNotifier(ing *networkingv1.Ingress) linkEventFunc {
+	if t.webhook == nil {
+		return nil
+	}
+	namespace, name := ing.Namespace, ing.Name
+	return func(host, event, message string) {
+		t.webhook.send(webhookEvent{
+			Event:     event,
+			Hostname:  host,
+			Namespace: namespace,
+			Ingress:   name,
+			Message:   message,
+			Timestamp: time.Now().UTC(),
+		})
+	}
+}
+
+// acmeResolver finds the cert-manager solvers of the http-01
+// challenges on each request, a solver serves for its lifetime
+func (t *syncTranslator) acmeResolver() acmeResolver {
+	return t.resolveACMESolver
+}
+
+// resolveACMESolver returns the '<service>.<namespace>:<port>' of the
+// solver ingress serving the challenge path of the host
+func (t *syncTranslator) resolveACMESolver(host, path string) (origin string, ok bool) {
+	objs, err := t.informers.ingress.GetIndexer().ByIndex(acmeSolverIndex, strings.ToLower(host))
+	if err != nil {
+		return
+	}
+	for _, obj := range objs {
+		ing := obj.(*networkingv1.Ingress)
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil || !strings.EqualFold(rule.Host, host) {
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				if p.Backend.Service == nil || !matchACMEPath(p, path) {
+					continue
+				}
+				key := itemKeyFunc(ing.Namespace, p.Backend.Service.Name)
+				svcobj, exists, err := t.informers.service.GetIndexer().GetByKey(key)
+				if err != nil || !exists {
+					continue
+				}
+				if svcport, exists := k8s.GetServicePort(svcobj.(*v1.Service), p.Backend.Service.Port, v1.ProtocolTCP); exists {
+					return fmt.Sprintf("%s.%s:%d", p.Backend.Service.Name, ing.Namespace, svcport.Port), true
+				}
+			}
+		}
+	}
+	return
+}
+
+// matchACMEPath matches the challenge path against a solver path, the
+// solver paths are exact unless typed as a prefix
+func matchACMEPath(p networkingv1.HTTPIngressPath, path string) bool {
+	if p.PathType != nil && *p.PathType == networkingv1.PathTypePrefix {
+		return strings.HasPrefix(path, p.Path)
+	}
+	return path == p.Path
+}
+
+// endpointResolver resolves the endpoints of tunnels in the endpoint
+// upstream mode, with the unready endpoints override of the ingress
+func (t *syncTranslator) endpointResolver(unready string) endpointResolver {
+	return func(namespace, name string, port int32) []string {
+		return t.resolveEndpoints(namespace, name, port, unready)
+	}
+}
+
+// resolveEndpoints lists the ready endpoint addresses of the service
+// port, and the not-ready addresses when included
+func (t *syncTranslator) resolveEndpoints(namespace, name string, port int32, unready string) (addrs []string) {
+	key := itemKeyFunc(namespace, name)
+	obj, exists, err := t.informers.service.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+	svc := obj.(*v1.Service)
+	svcport, exists := k8s.GetServicePort(svc, networkingv1.ServiceBackendPort{Number: port}, v1.ProtocolTCP)
+	if !exists {
+		return
+	}
+	obj, exists, err = t.informers.endpoint.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+	addrs = k8s.GetEndpointsAddresses(obj.(*v1.Endpoints), intstr.FromString(svcport.Name), v1.ProtocolTCP, includeUnready(svc, unready))
+	return
+}
+
+// includeUnready decides whether the not-ready endpoints of a service
+// serve requests, following publishNotReadyAddresses unless overridden
+func includeUnready(svc *v1.Service, unready string) bool {
+	switch unready {
+	case unreadyEndpointsInclude:
+		return true
+	case unreadyEndpointsExclude:
+		return false
+	}
+	return svc.Spec.PublishNotReadyAddresses
+}
+
+func (t *syncTranslator) getVerifiedPort(ctx context.Context, namespace, name string, port networkingv1.ServiceBackendPort, unready string) (val int32, exists bool, err error) {
 	key := itemKeyFunc(namespace, name)
 	obj, exists, err := t.informers.service.GetIndexer().GetByKey(key)
 	if err != nil {
@@ -295,12 +937,23 @@ func (t *syncTranslator) getVerifiedPort(namespace, name string, port networking
 		return
 	}
 
-	svcport, exists := k8s.GetServicePort(obj.(*v1.Service), port, v1.ProtocolTCP)
+	svc := obj.(*v1.Service)
+	svcport, exists := k8s.GetServicePort(svc, port, v1.ProtocolTCP)
 	if !exists {
 		err = fmt.Errorf("service '%s' missing port '%s'", key, GetBackendPort(port))
 		return
 	}
 
+	if svc.Spec.Type == v1.ServiceTypeExternalName {
+		if err = t.resolveExternalName(ctx, svc.Spec.ExternalName); err != nil {
+			exists = false
+			err = fmt.Errorf("service '%s' external name '%s' does not resolve: %v", key, svc.Spec.ExternalName, err)
+			return
+		}
+		val = svcport.Port
+		return
+	}
+
 	obj, exists, err = t.informers.endpoint.GetIndexer().GetByKey(key)
 	if err != nil {
 		return
@@ -309,7 +962,7 @@ func (t *syncTranslator) getVerifiedPort(namespace, name string, port networking
 		return
 	}
 
-	exists = k8s.HasEndpointsAddresses(obj.(*v1.Endpoints))
+	exists = k8s.HasEndpointsAddresses(obj.(*v1.Endpoints), includeUnready(svc, unready))
 	if !exists {
 		err = fmt.Errorf("endpoints '%s' missing subsets for port '%s'", key, GetBackendPort(port))
 		return
@@ -319,6 +972,112 @@ func (t *syncTranslator) getVerifiedPort(namespace, name string, port networking
 	return
 }
 
+// getExternalName returns the external name of an ExternalName service,
+// the origin host of its tunnels
+func (t *syncTranslator) getExternalName(namespace, name string) string {
+	obj, exists, err := t.informers.service.GetIndexer().GetByKey(itemKeyFunc(namespace, name))
+	if err != nil || !exists {
+		return ""
+	}
+	if svc := obj.(*v1.Service); svc.Spec.Type == v1.ServiceTypeExternalName {
+		return svc.Spec.ExternalName
+	}
+	return ""
+}
+
+// appProtocols maps the appProtocol of a service port to the origin
+// protocol
+var appProtocols = map[string]string{
+	"http":              ProtoHTTP,
+	"https":             ProtoHTTPS,
+	"h2c":               ProtoH2C,
+	"grpc":              ProtoH2C,
+	"kubernetes.io/h2c": ProtoH2C,
+	"kubernetes.io/ws":  ProtoHTTP,
+	"kubernetes.io/wss": ProtoHTTPS,
+}
+
+// getAppProtocol derives the origin protocol from the appProtocol of a
+// service port, false when unset or unknown
+func (t *syncTranslator) getAppProtocol(namespace, name string, port networkingv1.ServiceBackendPort) (proto string, ok bool) {
+	obj, exists, err := t.informers.service.GetIndexer().GetByKey(itemKeyFunc(namespace, name))
+	if err != nil || !exists {
+		return
+	}
+	svcport, exists := k8s.GetServicePort(obj.(*v1.Service), port, v1.ProtocolTCP)
+	if !exists || svcport.AppProtocol == nil {
+		return
+	}
+	proto, ok = appProtocols[strings.ToLower(*svcport.AppProtocol)]
+	return
+}
+
+// resolveExternalName checks the external name of a service resolves,
+// within the reconcile ctx
+func (t *syncTranslator) resolveExternalName(ctx context.Context, name string) error {
+	lookup := t.lookupHost
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	ctx, cancel := context.WithTimeout(ctx, externalNameLookupTimeout)
+	defer cancel()
+	_, err := lookup(ctx, name)
+	return err
+}
+
+// getGraceRule returns the routed rule of a backend kept through the
+// missing backend grace, a deleted service no longer resolves its port
+func (t *syncTranslator) getGraceRule(ing *networkingv1.Ingress, host, name string) (rule tunnelRule, ok bool) {
+	if t.router == nil || !t.backends.inGrace(ing.Namespace, name, t.options.missingBackendGrace) {
+		return
+	}
+	svc := resource{namespace: ing.Namespace, name: name}
+	for _, l := range t.router.routeLinks(ing.Namespace, ing.Name) {
+		if r := l.routeRule(); r.host == host && r.service == svc {
+			return r, true
+		}
+	}
+	return
+}
+
+// getRoutedCert returns the origin certificate of a routed tunnel of the
+// host from the secret, kept when a rotation of the secret is invalid. A
+// deleted secret keeps nothing.
+func (t *syncTranslator) getRoutedCert(ing *networkingv1.Ingress, host string, secret resource) (cert []byte, ok bool) {
+	if t.router == nil || !t.options.keepCredential {
+		return
+	}
+	if _, exists, err := t.informers.secret.GetIndexer().GetByKey(itemKeyFunc(secret.namespace, secret.name)); err != nil || !exists {
+		return
+	}
+	for _, l := range t.router.routeLinks(ing.Namespace, ing.Name) {
+		if r := l.routeRule(); r.host == host && r.secret == secret {
+			return l.originCert(), true
+		}
+	}
+	return
+}
+
+// getMissingBackendPort resolves the port of a backend without ready
+// endpoints, a service that does not exist yet uses the port number of
+// the backend. A service missing the port is never allowed.
+func (t *syncTranslator) getMissingBackendPort(namespace, name string, port networkingv1.ServiceBackendPort) (val int32, ok bool) {
+	if !t.options.allowMissing {
+		return
+	}
+	obj, exists, err := t.informers.service.GetIndexer().GetByKey(itemKeyFunc(namespace, name))
+	if err != nil {
+		return
+	} else if !exists {
+		return port.Number, port.Number > 0
+	}
+	svcport, ok := k8s.GetServicePort(obj.(*v1.Service), port, v1.ProtocolTCP)
+	if ok {
+		val = svcport.Port
+	}
+	return
+}
+
 func GetBackendPort(port networkingv1.ServiceBackendPort) string {
 	if port.Number != 0 {
 		return strconv.Itoa(int(port.Number))
@@ -1,17 +1,27 @@
 package argotunnel
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	networkingv1 "k8s.io/api/networking/v1"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
 	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestHandleResource(t *testing.T) {
@@ -115,7 +125,7 @@ func TestHandleResource(t *testing.T) {
 	} {
 		logger, hook := logtest.NewNullLogger()
 		test.tr.log = logger
-		out := test.tr.handleResource(test.kind, test.key)
+		out := test.tr.handleResource(context.Background(), test.kind, test.key)
 		assert.Equalf(t, test.out, out, "test '%s' error mismatch", name)
 		hook.Reset()
 		assert.Nil(t, hook.LastEntry())
@@ -210,6 +220,11 @@ func TestHandleByKind(t *testing.T) {
 					}(),
 					service: &mockSharedIndexInformer{},
 				},
+				router: func() tunnelRouter {
+					r := &mockTunnelRouter{}
+					r.On("tunnels").Return([]tunnelStatus{})
+					return r
+				}(),
 			},
 			kind: "secret",
 			key:  "unit/sec-a",
@@ -218,7 +233,7 @@ func TestHandleByKind(t *testing.T) {
 	} {
 		logger, hook := logtest.NewNullLogger()
 		test.tr.log = logger
-		out := test.tr.handleByKind(test.kind, test.key)
+		out := test.tr.handleByKind(context.Background(), test.kind, test.key)
 		assert.Equalf(t, test.out, out, "test '%s' error mismatch", name)
 		hook.Reset()
 		assert.Nil(t, hook.LastEntry())
@@ -315,7 +330,7 @@ func TestHandleEndpoint(t *testing.T) {
 	} {
 		logger, hook := logtest.NewNullLogger()
 		test.tr.log = logger
-		out := test.tr.handleEndpoint(test.kind, test.key)
+		out := test.tr.handleEndpoint(context.Background(), test.kind, test.key)
 		assert.Equalf(t, test.out, out, "test '%s' error mismatch", name)
 		hook.Reset()
 		assert.Nil(t, hook.LastEntry())
@@ -483,7 +498,7 @@ func TestGetRouteFromIngress(t *testing.T) {
 		logger, hook := logtest.NewNullLogger()
 		test.tr.log = logger
 		out := func() (r *tunnelRoute) {
-			if r = test.tr.getRouteFromIngress(test.ing); r != nil {
+			if r = test.tr.getRouteFromIngress(context.Background(), test.ing); r != nil {
 				l := tunnelRouteLinkMap{}
 				for k := range r.links {
 					l[k] = nil
@@ -498,6 +513,610 @@ func TestGetRouteFromIngress(t *testing.T) {
 	}
 }
 
+func TestGetRouteFromIngressHostPolicy(t *testing.T) {
+	t.Parallel()
+	policy, _ := NewHostnamePolicy([]string{".unit.com"}, nil)
+	recorder := record.NewFakeRecorder(1)
+	tr := newMockedSyncTranslator()
+	tr.recorder = recorder
+	tr.options.hostPolicy = policy
+
+	logger, hook := logtest.NewNullLogger()
+	tr.log = logger
+	out := reconcileRouteEvents(tr, &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unit",
+			Namespace: "unit",
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "a.test.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "svc-a",
+											Port: networkingv1.ServiceBackendPort{
+												Name: "http",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Equalf(t, &tunnelRoute{
+		name:      "unit",
+		namespace: "unit",
+		links:     tunnelRouteLinkMap{},
+	}, out, "test host policy route mismatch")
+	assert.Equalf(t, logrus.ErrorLevel, hook.LastEntry().Level, "test host policy log level mismatch")
+	assert.Equalf(t, `Warning HostRejected host "a.test.com" rejected by hostname policy (not-allowed)`, <-recorder.Events, "test host policy event mismatch")
+}
+
+func TestGetRouteFromIngressHostPolicyOnce(t *testing.T) {
+	policy, _ := NewHostnamePolicy(nil, []string{".denied.com"})
+	recorder := record.NewFakeRecorder(10)
+	tr := newMockedSyncTranslator()
+	tr.recorder = recorder
+	tr.options.hostPolicy = policy
+	tr.rejected = newPolicyRejectionTracker()
+	logger, _ := logtest.NewNullLogger()
+	tr.log = logger
+
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "unit",
+			Namespace:  "unit",
+			Generation: 1,
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "a.denied.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{},
+					},
+				},
+			},
+		},
+	}
+	before := testutil.ToFloat64(policyRejections.WithLabelValues(policyReasonDenied))
+	// resyncs of a generation report the rejection once, a new
+	// generation reports it again
+	for _, test := range []struct {
+		generation int64
+		events     int
+	}{
+		{generation: 1, events: 1},
+		{generation: 1, events: 1},
+		{generation: 2, events: 2},
+	} {
+		ing.Generation = test.generation
+		reconcileRouteEvents(tr, ing)
+		assert.Equalf(t, test.events, len(recorder.Events), "test generation %d events mismatch", test.generation)
+		assert.Equalf(t, float64(test.events), testutil.ToFloat64(policyRejections.WithLabelValues(policyReasonDenied))-before, "test generation %d rejections mismatch", test.generation)
+	}
+}
+
+func TestEventfSecretDeleted(t *testing.T) {
+	t.Parallel()
+	router := &mockTunnelRouter{}
+	router.On("tunnels").Return([]tunnelStatus{
+		{Hostname: "a.unit.com", Ingress: "unit/a", Secret: "unit/sec"},
+		{Hostname: "b.unit.com", Ingress: "unit/a", Secret: "unit/sec"},
+		{Hostname: "c.unit.com", Ingress: "unit/c", Secret: "argo/default"},
+	})
+	recorder := record.NewFakeRecorder(2)
+	logger, _ := logtest.NewNullLogger()
+	tr := &syncTranslator{
+		informers: informerset{
+			ingress: newStaticInformer(new(networkingv1.Ingress),
+				&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "a"}},
+				&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "c"}},
+			),
+		},
+		router:   router,
+		recorder: recorder,
+		log:      logger,
+	}
+	tr.eventfSecretDeleted("unit/sec")
+	assert.Equalf(t, 1, len(recorder.Events), "test secret deleted events mismatch")
+	assert.Equalf(t, `Warning SecretDeleted origin certificate secret "unit/sec" deleted while hosts a.unit.com, b.unit.com route through it`, <-recorder.Events, "test secret deleted event mismatch")
+
+	tr.eventfSecretDeleted("unit/unused")
+	assert.Equalf(t, 0, len(recorder.Events), "test unused secret events mismatch")
+}
+
+func TestGetRouteFromIngressEdgeRegion(t *testing.T) {
+	t.Parallel()
+	recorder := record.NewFakeRecorder(1)
+	tr := newMockedSyncTranslator()
+	tr.recorder = recorder
+
+	logger, hook := logtest.NewNullLogger()
+	tr.log = logger
+	out := reconcileRouteEvents(tr, &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unit",
+			Namespace: "unit",
+			Annotations: map[string]string{
+				annotationIngressEdgeRegion: "eu",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "a.unit.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "svc-a",
+											Port: networkingv1.ServiceBackendPort{
+												Name: "http",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Equalf(t, &tunnelRoute{
+		name:      "unit",
+		namespace: "unit",
+		annotations: map[string]string{
+			annotationIngressEdgeRegion: "eu",
+		},
+		links: tunnelRouteLinkMap{},
+	}, out, "test edge region route mismatch")
+	assert.Equalf(t, logrus.ErrorLevel, hook.LastEntry().Level, "test edge region log level mismatch")
+	assert.Equalf(t, `Warning EdgeRegionRefused annotation "argo.cloudflare.com/edge-region" refused, edge region "eu" is not supported, tunnels only discover the global edge, the tunnels of the ingress are not created`, <-recorder.Events, "test edge region event mismatch")
+}
+
+func TestGetRouteFromIngressRequireTLS(t *testing.T) {
+	t.Parallel()
+	recorder := record.NewFakeRecorder(1)
+	tr := newMockedSyncTranslator()
+	tr.recorder = recorder
+	tr.options.requireTLS = true
+
+	logger, hook := logtest.NewNullLogger()
+	tr.log = logger
+	out := reconcileRouteEvents(tr, &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unit",
+			Namespace: "unit",
+		},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{
+				{
+					Hosts:      []string{"b.unit.com"},
+					SecretName: "sec-b",
+				},
+			},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "a.unit.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "svc-a",
+											Port: networkingv1.ServiceBackendPort{
+												Name: "http",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Equalf(t, &tunnelRoute{
+		name:      "unit",
+		namespace: "unit",
+		links:     tunnelRouteLinkMap{},
+	}, out, "test require tls route mismatch")
+	assert.Equalf(t, logrus.InfoLevel, hook.LastEntry().Level, "test require tls log level mismatch")
+	assert.Equalf(t, `Warning HostRejected host "a.unit.com" not listed in the tls section, required by the controller`, <-recorder.Events, "test require tls event mismatch")
+}
+
+func TestGetRouteFromIngressHostless(t *testing.T) {
+	t.Parallel()
+	backend := func(name string) *networkingv1.HTTPIngressRuleValue {
+		return &networkingv1.HTTPIngressRuleValue{
+			Paths: []networkingv1.HTTPIngressPath{
+				{
+					Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{
+							Name: name,
+							Port: networkingv1.ServiceBackendPort{Name: "http"},
+						},
+					},
+				},
+			},
+		}
+	}
+	// hosted and host-less rules, a host-less rule without paths
+	rules := []networkingv1.IngressRule{
+		{Host: "a.unit.com", IngressRuleValue: networkingv1.IngressRuleValue{HTTP: backend("svc-a")}},
+		{IngressRuleValue: networkingv1.IngressRuleValue{HTTP: backend("svc-b")}},
+		{},
+	}
+	secrets, services, endpoints := []runtime.Object{}, []runtime.Object{}, []runtime.Object{}
+	for _, s := range []string{"a", "b", "c"} {
+		secrets = append(secrets, &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "sec-" + s},
+			Data:       map[string][]byte{"cert.pem": genCertforHost(s + ".unit.com")},
+		})
+		services = append(services, &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "svc-" + s},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{{Name: "http", Port: 8080, Protocol: v1.ProtocolTCP}},
+			},
+		})
+		endpoints = append(endpoints, &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "svc-" + s},
+			Subsets: []v1.EndpointSubset{
+				{
+					Addresses: []v1.EndpointAddress{{IP: "1.1.1.1"}},
+					Ports:     []v1.EndpointPort{{Name: "http", Port: 9090, Protocol: v1.ProtocolTCP}},
+				},
+			},
+		})
+	}
+	rule := func(host, svc, sec string) tunnelRule {
+		return tunnelRule{
+			host:    host,
+			port:    8080,
+			service: resource{namespace: "unit", name: svc},
+			secret:  resource{namespace: "unit", name: sec},
+		}
+	}
+	for name, test := range map[string]struct {
+		tls             []networkingv1.IngressTLS
+		defaultHostname string
+		rules           []tunnelRule
+		events          []string
+	}{
+		"hostless-skipped": {
+			tls: []networkingv1.IngressTLS{
+				{Hosts: []string{"a.unit.com"}, SecretName: "sec-a"},
+				{Hosts: []string{"b.unit.com"}, SecretName: "sec-b"},
+			},
+			rules: []tunnelRule{
+				rule("a.unit.com", "svc-a", "sec-a"),
+			},
+			events: []string{
+				"Warning RuleSkipped rule 1 has no host, and neither a single spec.tls host nor a default hostname serves it",
+			},
+		},
+		"hostless-default-hostname": {
+			tls: []networkingv1.IngressTLS{
+				{Hosts: []string{"a.unit.com"}, SecretName: "sec-a"},
+				{Hosts: []string{"b.unit.com"}, SecretName: "sec-b"},
+			},
+			defaultHostname: "c.unit.com",
+			rules: []tunnelRule{
+				rule("a.unit.com", "svc-a", "sec-a"),
+				rule("c.unit.com", "svc-b", "sec-c"),
+			},
+		},
+		"hostless-tls-host": {
+			tls: []networkingv1.IngressTLS{
+				{Hosts: []string{"b.unit.com"}, SecretName: "sec-b"},
+			},
+			defaultHostname: "c.unit.com",
+			rules: []tunnelRule{
+				rule("a.unit.com", "svc-a", "sec-a"),
+				rule("b.unit.com", "svc-b", "sec-b"),
+			},
+		},
+	} {
+		recorder := record.NewFakeRecorder(10)
+		logger, _ := logtest.NewNullLogger()
+		tr := &syncTranslator{
+			informers: informerset{
+				configMap: newStaticInformer(new(v1.ConfigMap)),
+				endpoint:  newStaticInformer(new(v1.Endpoints), endpoints...),
+				ingress:   newStaticInformer(new(networkingv1.Ingress)),
+				secret:    newStaticInformer(new(v1.Secret), secrets...),
+				service:   newStaticInformer(new(v1.Service), services...),
+			},
+			log:      logger,
+			recorder: recorder,
+			options: options{
+				defaultHostname: test.defaultHostname,
+				originSecrets: map[string]*resource{
+					"a.unit.com": {namespace: "unit", name: "sec-a"},
+					"c.unit.com": {namespace: "unit", name: "sec-c"},
+				},
+			},
+		}
+		out := reconcileRouteEvents(tr, &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "unit", Namespace: "unit"},
+			Spec:       networkingv1.IngressSpec{TLS: test.tls, Rules: rules},
+		})
+		var outRules []tunnelRule
+		for r := range out.links {
+			outRules = append(outRules, r)
+		}
+		assert.ElementsMatchf(t, test.rules, outRules, "test '%s' rules mismatch", name)
+		close(recorder.Events)
+		var events []string
+		for e := range recorder.Events {
+			events = append(events, e)
+		}
+		assert.Equalf(t, test.events, events, "test '%s' events mismatch", name)
+	}
+}
+
+func TestGetRouteFromIngressRedirect(t *testing.T) {
+	t.Parallel()
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "sec-www"},
+		Data:       map[string][]byte{"cert.pem": genCertforHost("www.unit.com")},
+	}
+	redirectIngress := func(name, host, annotation, target string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "unit",
+				Name:      name,
+				Annotations: map[string]string{
+					annotationIngressClass: IngressClassDefault,
+					annotation:             target,
+				},
+			},
+			Spec: networkingv1.IngressSpec{
+				TLS:   []networkingv1.IngressTLS{{Hosts: []string{host}, SecretName: "sec-www"}},
+				Rules: []networkingv1.IngressRule{{Host: host}},
+			},
+		}
+	}
+	for name, test := range map[string]struct {
+		ing    *networkingv1.Ingress
+		others []runtime.Object
+		rules  []tunnelRule
+		events []string
+	}{
+		"redirect-without-backend": {
+			ing: redirectIngress("www", "www.unit.com", annotationIngressPermanentRedirect, "https://unit.com"),
+			others: []runtime.Object{
+				redirectIngress("legacy", "legacy.unit.com", annotationIngressPermanentRedirect, "https://www.unit.com"),
+			},
+			rules: []tunnelRule{
+				{
+					host:    "www.unit.com",
+					service: resource{namespace: "unit"},
+					secret:  resource{namespace: "unit", name: "sec-www"},
+				},
+			},
+		},
+		"redirect-to-itself": {
+			ing: redirectIngress("www", "www.unit.com", annotationIngressTemporalRedirect, "https://WWW.unit.com/new"),
+			events: []string{
+				`Warning RedirectLoop host "www.unit.com" redirect to "www.unit.com" refused, the target redirects back`,
+			},
+		},
+		"redirect-back": {
+			ing: redirectIngress("www", "www.unit.com", annotationIngressPermanentRedirect, "https://unit.com"),
+			others: []runtime.Object{
+				redirectIngress("apex", "unit.com", annotationIngressTemporalRedirect, "https://www.unit.com"),
+			},
+			events: []string{
+				`Warning RedirectLoop host "www.unit.com" redirect to "unit.com" refused, the target redirects back`,
+			},
+		},
+	} {
+		recorder := record.NewFakeRecorder(10)
+		logger, _ := logtest.NewNullLogger()
+		tr := &syncTranslator{
+			informers: informerset{
+				ingress: newStaticInformer(new(networkingv1.Ingress), append(test.others, test.ing)...),
+				secret:  newStaticInformer(new(v1.Secret), secret),
+			},
+			log:      logger,
+			recorder: recorder,
+			options:  options{ingressClass: IngressClassDefault},
+		}
+		out := reconcileRouteEvents(tr, test.ing)
+		var rules []tunnelRule
+		for r, l := range out.links {
+			rules = append(rules, r)
+			assert.Equalf(t, test.ing.Annotations[annotationIngressPermanentRedirect], l.options().redirectURL, "test '%s' redirect mismatch", name)
+		}
+		assert.Equalf(t, test.rules, rules, "test '%s' rules mismatch", name)
+		close(recorder.Events)
+		var events []string
+		for e := range recorder.Events {
+			events = append(events, e)
+		}
+		assert.Equalf(t, test.events, events, "test '%s' events mismatch", name)
+	}
+}
+
+func TestUpdateIngressReconciled(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		err        error
+		reconciled bool
+	}{
+		"reconcile-success": {
+			err:        nil,
+			reconciled: true,
+		},
+		"reconcile-failure": {
+			err:        fmt.Errorf("short-circuit"),
+			reconciled: false,
+		},
+	} {
+		logger, _ := logtest.NewNullLogger()
+		r := &mockTunnelRouter{}
+		r.On("updateRoute", mock.Anything).Return(test.err)
+		tr := &syncTranslator{
+			router:  r,
+			log:     logger,
+			options: collectOptions(nil),
+		}
+		before := float64(time.Now().Unix())
+		err := tr.updateIngress(context.Background(), "unit/"+name, &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: name},
+		})
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+		last := testutil.ToFloat64(ingressLastReconcile.WithLabelValues("unit", name))
+		assert.Equalf(t, test.reconciled, last >= before, "test '%s' reconciled mismatch", name)
+
+		r.On("deleteByRoute", "unit", name).Return(nil)
+		assert.Nilf(t, tr.deleteIngress("unit/"+name), "test '%s' delete error mismatch", name)
+		assert.Falsef(t, ingressLastReconcile.DeleteLabelValues("unit", name), "test '%s' delete mismatch", name)
+	}
+}
+
+func TestGetPathPrefix(t *testing.T) {
+	t.Parallel()
+	prefix, exact := networkingv1.PathTypePrefix, networkingv1.PathTypeExact
+	for name, test := range map[string]struct {
+		path   networkingv1.HTTPIngressPath
+		paths  int
+		opts   tunnelOptions
+		prefix string
+		ok     bool
+	}{
+		"root-path": {
+			path:  networkingv1.HTTPIngressPath{Path: "/"},
+			paths: 1,
+			ok:    true,
+		},
+		"sub-path-no-rewrite": {
+			path:  networkingv1.HTTPIngressPath{Path: "/app", PathType: &prefix},
+			paths: 1,
+			ok:    false,
+		},
+		"sub-path-rewrite": {
+			path:   networkingv1.HTTPIngressPath{Path: "/app/./", PathType: &prefix},
+			paths:  1,
+			opts:   tunnelOptions{rewriteTarget: "/"},
+			prefix: "/app/",
+			ok:     true,
+		},
+		"sub-path-rewrite-many-paths": {
+			path:  networkingv1.HTTPIngressPath{Path: "/app", PathType: &prefix},
+			paths: 2,
+			opts:  tunnelOptions{rewriteTarget: "/"},
+			ok:    false,
+		},
+		"sub-path-rewrite-exact": {
+			path:  networkingv1.HTTPIngressPath{Path: "/app", PathType: &exact},
+			paths: 1,
+			opts:  tunnelOptions{rewriteTarget: "/"},
+			ok:    false,
+		},
+	} {
+		out, ok := getPathPrefix(test.path, test.paths, test.opts)
+		assert.Equalf(t, test.prefix, out, "test '%s' prefix mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' ok mismatch", name)
+	}
+}
+
+func TestGetMaintenanceResponse(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		annotations map[string]string
+		configMap   interface{}
+		exists      bool
+		err         error
+		out         tunnelOptions
+	}{
+		"maintenance-default": {
+			annotations: map[string]string{},
+			out: tunnelOptions{
+				maintenanceStatus: maintenanceStatusDefault,
+				maintenanceBody:   maintenanceBodyDefault,
+			},
+		},
+		"maintenance-configmap": {
+			annotations: map[string]string{
+				annotationIngressMaintenanceConfig: "cm-a",
+			},
+			configMap: &v1.ConfigMap{
+				Data: map[string]string{
+					"body.html": "<h1>unit</h1>",
+					"status":    "200",
+				},
+			},
+			exists: true,
+			out: tunnelOptions{
+				maintenanceStatus: 200,
+				maintenanceBody:   "<h1>unit</h1>",
+			},
+		},
+		"maintenance-configmap-missing": {
+			annotations: map[string]string{
+				annotationIngressMaintenanceConfig: "cm-a",
+			},
+			configMap: struct{}{},
+			exists:    false,
+			out: tunnelOptions{
+				maintenanceStatus: maintenanceStatusDefault,
+				maintenanceBody:   maintenanceBodyDefault,
+			},
+		},
+		"maintenance-configmap-err": {
+			annotations: map[string]string{
+				annotationIngressMaintenanceConfig: "cm-a",
+			},
+			configMap: struct{}{},
+			err:       fmt.Errorf("short-circuit"),
+			out: tunnelOptions{
+				maintenanceStatus: maintenanceStatusDefault,
+				maintenanceBody:   maintenanceBodyDefault,
+			},
+		},
+	} {
+		tr := newMockedSyncTranslator()
+		tr.informers.configMap = func() cache.SharedIndexInformer {
+			i := &mockSharedIndexInformer{}
+			i.On("GetIndexer").Return(func() cache.Indexer {
+				idx := &mockIndexer{}
+				idx.On("GetByKey", "unit/cm-a").Return(test.configMap, test.exists, test.err)
+				return idx
+			}())
+			return i
+		}()
+		logger, _ := logtest.NewNullLogger()
+		tr.log = logger
+		opt := tr.getMaintenanceResponse(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "ing-a",
+				Namespace:   "unit",
+				Annotations: test.annotations,
+			},
+		})
+		out := tunnelOptions{}
+		opt(&out)
+		assert.Equalf(t, test.out, out, "test '%s' options mismatch", name)
+	}
+}
+
 func TestGetVerifiedCert(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
@@ -532,6 +1151,33 @@ func TestGetVerifiedCert(t *testing.T) {
 			exists: false,
 			err:    fmt.Errorf("secret 'unit/sec-a' does not exist"),
 		},
+		"secret-outside-watch-namespace": {
+			tr: &syncTranslator{
+				informers: informerset{
+					secret: func() cache.SharedIndexInformer {
+						i := &mockSharedIndexInformer{}
+						i.On("GetIndexer").Return(func() cache.Indexer {
+							idx := &mockIndexer{}
+							idx.On("GetByKey", "unit/sec-a").Return(&v1.Secret{}, false, nil)
+							return idx
+						}())
+						return i
+					}(),
+				},
+				router: &mockTunnelRouter{},
+				options: options{
+					watchNamespace: "watched",
+				},
+			},
+			secret: resource{
+				namespace: "unit",
+				name:      "sec-a",
+			},
+			host:   "a.unit.com",
+			cert:   nil,
+			exists: false,
+			err:    fmt.Errorf("secret 'unit/sec-a' is outside the watched namespace 'watched'"),
+		},
 		"secret-lookup-error": {
 			tr: &syncTranslator{
 				informers: informerset{
@@ -580,7 +1226,7 @@ func TestGetVerifiedCert(t *testing.T) {
 			host:   "a.unit.com",
 			cert:   nil,
 			exists: false,
-			err:    fmt.Errorf("secret 'unit/sec-a' missing 'cert.pem'"),
+			err:    fmt.Errorf("secret 'unit/sec-a' missing 'cert.pem' or 'tls.crt' and 'tls.key'"),
 		},
 		"secret-okay": {
 			tr: &syncTranslator{
@@ -622,6 +1268,105 @@ func TestGetVerifiedCert(t *testing.T) {
 	}
 }
 
+func TestGetVerifiedCertSecretLayout(t *testing.T) {
+	t.Parallel()
+	pemCert := genCertforHost("a.unit.com")
+	tlsCert, tlsKey := genKeyPairforHost("a.unit.com")
+	_, otherKey := genKeyPairforHost("a.unit.com")
+	for name, test := range map[string]struct {
+		data   map[string][]byte
+		exists bool
+		err    error
+		check  func(cert []byte) bool
+	}{
+		"secret-cert-pem": {
+			data: map[string][]byte{
+				"cert.pem": pemCert,
+			},
+			exists: true,
+			check: func(cert []byte) bool {
+				return bytes.Equal(pemCert, cert)
+			},
+		},
+		"secret-tls": {
+			data: map[string][]byte{
+				"tls.crt": tlsCert,
+				"tls.key": tlsKey,
+			},
+			exists: true,
+			check: func(cert []byte) bool {
+				return bytes.Equal(append(append([]byte{}, tlsKey...), tlsCert...), cert)
+			},
+		},
+		"secret-cert-pem-and-tls": {
+			data: map[string][]byte{
+				"cert.pem": pemCert,
+				"tls.crt":  tlsCert,
+				"tls.key":  tlsKey,
+			},
+			exists: true,
+			check: func(cert []byte) bool {
+				return bytes.Equal(pemCert, cert)
+			},
+		},
+		"secret-cert-pem-base64": {
+			data: map[string][]byte{
+				"cert.pem": []byte(base64.StdEncoding.EncodeToString(pemCert)),
+			},
+			exists: true,
+			check: func(cert []byte) bool {
+				return bytes.Equal(bytes.TrimSpace(pemCert), cert)
+			},
+		},
+		"secret-cert-pem-der": {
+			data: map[string][]byte{
+				"cert.pem": func() []byte {
+					block, _ := pem.Decode(pemCert)
+					return block.Bytes
+				}(),
+			},
+			exists: true,
+			err:    fmt.Errorf("secret 'unit/sec-a' 'cert.pem' origin certificate is DER encoded, convert it to PEM, e.g. 'openssl x509 -inform der -outform pem'"),
+			check: func(cert []byte) bool {
+				return len(cert) == 0
+			},
+		},
+		"secret-tls-key-mismatch": {
+			data: map[string][]byte{
+				"tls.crt": tlsCert,
+				"tls.key": otherKey,
+			},
+			exists: false,
+			err:    fmt.Errorf("secret 'unit/sec-a' certificate and key are not a valid origin certificate: tls: private key does not match public key"),
+			check: func(cert []byte) bool {
+				return len(cert) == 0
+			},
+		},
+	} {
+		logger, _ := logtest.NewNullLogger()
+		tr := &syncTranslator{
+			informers: informerset{
+				secret: func() cache.SharedIndexInformer {
+					i := &mockSharedIndexInformer{}
+					i.On("GetIndexer").Return(func() cache.Indexer {
+						idx := &mockIndexer{}
+						idx.On("GetByKey", "unit/sec-a").Return(&v1.Secret{
+							Data: test.data,
+						}, true, nil)
+						return idx
+					}())
+					return i
+				}(),
+			},
+			log: logger,
+		}
+		cert, exists, err := tr.getVerifiedCert("unit", "sec-a", "a.unit.com")
+		assert.Equalf(t, test.exists, exists, "test '%s' exists mismatch", name)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+		assert.Truef(t, test.check(cert), "test '%s' cert mismatch", name)
+	}
+}
+
 func TestGetVerifiedPort(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
@@ -916,16 +1661,584 @@ func TestGetVerifiedPort(t *testing.T) {
 			err:    nil,
 		},
 	} {
-		out, exists, err := test.tr.getVerifiedPort(test.service.namespace, test.service.name, test.port)
+		out, exists, err := test.tr.getVerifiedPort(context.Background(), test.service.namespace, test.service.name, test.port, "")
 		assert.Equalf(t, test.out, out, "test '%s' port mismatch", name)
 		assert.Equalf(t, test.exists, exists, "test '%s' exists mismatch", name)
 		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
 	}
 }
 
+func TestGetFallbackOrigin(t *testing.T) {
+	t.Parallel()
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "maintenance"},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Name: "http", Port: 8080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	for name, test := range map[string]struct {
+		annotation string
+		origin     string
+		ok         bool
+	}{
+		"fallback-unset": {
+			annotation: "",
+			ok:         false,
+		},
+		"fallback-service-missing": {
+			annotation: "missing:8080",
+			ok:         false,
+		},
+		"fallback-port-missing": {
+			annotation: "maintenance:9090",
+			ok:         false,
+		},
+		"fallback-port-number": {
+			annotation: "maintenance:8080",
+			origin:     "maintenance.unit:8080",
+			ok:         true,
+		},
+		"fallback-port-name": {
+			annotation: "maintenance:http",
+			origin:     "maintenance.unit:8080",
+			ok:         true,
+		},
+	} {
+		logger, _ := logtest.NewNullLogger()
+		tr := &syncTranslator{
+			informers: informerset{
+				service: newStaticInformer(new(v1.Service), svc),
+			},
+			log: logger,
+		}
+		origin, ok := tr.getFallbackOrigin(&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "unit",
+				Name:        "ing",
+				Annotations: map[string]string{annotationIngressFallbackService: test.annotation},
+			},
+		})
+		assert.Equalf(t, test.origin, origin, "test '%s' origin mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' found mismatch", name)
+	}
+}
+
+func TestResolveACMESolver(t *testing.T) {
+	t.Parallel()
+	exact := networkingv1.PathTypeExact
+	solver := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "unit",
+			Name:        "cm-acme-http-solver-abc",
+			Annotations: map[string]string{annotationIngressClass: "unit"},
+			Labels:      map[string]string{labelACMESolver: "true"},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "a.unit.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/.well-known/acme-challenge/token",
+									PathType: &exact,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "cm-acme-http-solver-xyz",
+											Port: networkingv1.ServiceBackendPort{Number: 8089},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "cm-acme-http-solver-xyz"},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Port: 8089, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	for name, test := range map[string]struct {
+		host   string
+		path   string
+		origin string
+		ok     bool
+	}{
+		"solver-match": {
+			host:   "a.unit.com",
+			path:   "/.well-known/acme-challenge/token",
+			origin: "cm-acme-http-solver-xyz.unit:8089",
+			ok:     true,
+		},
+		"solver-match-host-case": {
+			host:   "A.unit.com",
+			path:   "/.well-known/acme-challenge/token",
+			origin: "cm-acme-http-solver-xyz.unit:8089",
+			ok:     true,
+		},
+		"solver-other-token": {
+			host: "a.unit.com",
+			path: "/.well-known/acme-challenge/other",
+			ok:   false,
+		},
+		"solver-other-host": {
+			host: "b.unit.com",
+			path: "/.well-known/acme-challenge/token",
+			ok:   false,
+		},
+	} {
+		ingress := cache.NewSharedIndexInformer(&cache.ListWatch{}, new(networkingv1.Ingress), 0, cache.Indexers{
+			acmeSolverIndex: ingressACMESolverIndexFunc("unit", false),
+		})
+		ingress.GetIndexer().Add(solver)
+		tr := &syncTranslator{
+			informers: informerset{
+				ingress: ingress,
+				service: newStaticInformer(new(v1.Service), svc),
+			},
+		}
+		origin, ok := tr.resolveACMESolver(test.host, test.path)
+		assert.Equalf(t, test.origin, origin, "test '%s' origin mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' found mismatch", name)
+	}
+}
+
+func TestGetRouteFromIngressACMESolver(t *testing.T) {
+	t.Parallel()
+	tr := &syncTranslator{
+		options: collectOptions(nil),
+	}
+	route := tr.getRouteFromIngress(context.Background(), &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "unit",
+			Name:      "cm-acme-http-solver-abc",
+			Labels:    map[string]string{labelACMESolver: "true"},
+		},
+	})
+	assert.Nilf(t, route, "test solver route mismatch")
+}
+
+func TestGetVerifiedPortExternalName(t *testing.T) {
+	t.Parallel()
+	svc := &v1.Service{
+		Spec: v1.ServiceSpec{
+			Type:         v1.ServiceTypeExternalName,
+			ExternalName: "api.saas.com",
+			Ports: []v1.ServicePort{
+				{
+					Name:     "https",
+					Port:     443,
+					Protocol: v1.ProtocolTCP,
+				},
+			},
+		},
+	}
+	for name, test := range map[string]struct {
+		lookup   func(ctx context.Context, host string) ([]string, error)
+		canceled bool
+		port     networkingv1.ServiceBackendPort
+		out      int32
+		exists   bool
+		err      error
+	}{
+		"external-name-resolves": {
+			lookup: func(ctx context.Context, host string) ([]string, error) { return []string{"1.1.1.1"}, nil },
+			port:   networkingv1.ServiceBackendPort{Name: "https"},
+			out:    443,
+			exists: true,
+		},
+		"external-name-does-not-resolve": {
+			lookup: func(ctx context.Context, host string) ([]string, error) { return nil, fmt.Errorf("no such host") },
+			port:   networkingv1.ServiceBackendPort{Number: 443},
+			out:    0,
+			exists: false,
+			err:    fmt.Errorf("service 'unit/svc-a' external name 'api.saas.com' does not resolve: no such host"),
+		},
+		"external-name-reconcile-canceled": {
+			lookup: func(ctx context.Context, host string) ([]string, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			canceled: true,
+			port:     networkingv1.ServiceBackendPort{Number: 443},
+			out:      0,
+			exists:   false,
+			err:      fmt.Errorf("service 'unit/svc-a' external name 'api.saas.com' does not resolve: context canceled"),
+		},
+		"external-name-missing-port": {
+			lookup: func(ctx context.Context, host string) ([]string, error) { return []string{"1.1.1.1"}, nil },
+			port:   networkingv1.ServiceBackendPort{Number: 8443},
+			out:    0,
+			exists: false,
+			err:    fmt.Errorf("service 'unit/svc-a' missing port '8443'"),
+		},
+	} {
+		tr := &syncTranslator{
+			informers: informerset{
+				service: newStaticInformer(new(v1.Service), func() *v1.Service {
+					s := svc.DeepCopy()
+					s.Namespace, s.Name = "unit", "svc-a"
+					return s
+				}()),
+			},
+			lookupHost: test.lookup,
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if test.canceled {
+			cancel()
+		}
+		out, exists, err := tr.getVerifiedPort(ctx, "unit", "svc-a", test.port, "")
+		cancel()
+		assert.Equalf(t, test.out, out, "test '%s' port mismatch", name)
+		assert.Equalf(t, test.exists, exists, "test '%s' exists mismatch", name)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, "api.saas.com", tr.getExternalName("unit", "svc-a"), "test '%s' external name mismatch", name)
+	}
+}
+
+func TestGetRouteFromIngressAppProtocol(t *testing.T) {
+	t.Parallel()
+	appProtocol := func(s string) *string { return &s }
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "sec-a"},
+		Data:       map[string][]byte{"cert.pem": genCertforHost("a.unit.com")},
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "svc-a"},
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{{IP: "1.1.1.1"}},
+				Ports:     []v1.EndpointPort{{Name: "http", Port: 9090, Protocol: v1.ProtocolTCP}},
+			},
+		},
+	}
+	for name, test := range map[string]struct {
+		appProtocol  *string
+		annotation   string
+		originScheme string
+		proto        string
+		url          string
+	}{
+		"default": {
+			url: "svc-a.unit:8080",
+		},
+		"annotation": {
+			annotation: ProtoHTTPS,
+			proto:      ProtoHTTPS,
+			url:        "https://svc-a.unit:8080",
+		},
+		"app-protocol-over-annotation": {
+			appProtocol: appProtocol("kubernetes.io/h2c"),
+			annotation:  ProtoHTTPS,
+			proto:       ProtoH2C,
+			url:         "http://svc-a.unit:8080",
+		},
+		"app-protocol-grpc": {
+			appProtocol: appProtocol("grpc"),
+			proto:       ProtoH2C,
+			url:         "http://svc-a.unit:8080",
+		},
+		"app-protocol-wss": {
+			appProtocol: appProtocol("kubernetes.io/wss"),
+			proto:       ProtoHTTPS,
+			url:         "https://svc-a.unit:8080",
+		},
+		"app-protocol-unknown": {
+			appProtocol: appProtocol("example.com/custom"),
+			annotation:  ProtoHTTPS,
+			proto:       ProtoHTTPS,
+			url:         "https://svc-a.unit:8080",
+		},
+		"origin-scheme-over-annotation": {
+			annotation:   ProtoHTTPS,
+			originScheme: ProtoHTTP,
+			proto:        ProtoHTTP,
+			url:          "http://svc-a.unit:8080",
+		},
+		"origin-scheme-over-app-protocol": {
+			appProtocol:  appProtocol("kubernetes.io/wss"),
+			originScheme: ProtoHTTP,
+			proto:        ProtoHTTP,
+			url:          "http://svc-a.unit:8080",
+		},
+	} {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "svc-a"},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{{Name: "http", Port: 8080, Protocol: v1.ProtocolTCP, AppProtocol: test.appProtocol}},
+			},
+		}
+		ing := &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "unit"},
+			Spec: networkingv1.IngressSpec{
+				TLS: []networkingv1.IngressTLS{{Hosts: []string{"a.unit.com"}, SecretName: "sec-a"}},
+				Rules: []networkingv1.IngressRule{
+					{
+						Host: "a.unit.com",
+						IngressRuleValue: networkingv1.IngressRuleValue{
+							HTTP: &networkingv1.HTTPIngressRuleValue{
+								Paths: []networkingv1.HTTPIngressPath{
+									{
+										Backend: networkingv1.IngressBackend{
+											Service: &networkingv1.IngressServiceBackend{
+												Name: "svc-a",
+												Port: networkingv1.ServiceBackendPort{Name: "http"},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		ing.Annotations = map[string]string{}
+		if len(test.annotation) > 0 {
+			ing.Annotations[annotationIngressProto] = test.annotation
+		}
+		if len(test.originScheme) > 0 {
+			ing.Annotations[annotationIngressOriginScheme] = test.originScheme
+		}
+		logger, _ := logtest.NewNullLogger()
+		tr := &syncTranslator{
+			informers: informerset{
+				configMap: newStaticInformer(new(v1.ConfigMap)),
+				endpoint:  newStaticInformer(new(v1.Endpoints), endpoints),
+				ingress:   newStaticInformer(new(networkingv1.Ingress)),
+				secret:    newStaticInformer(new(v1.Secret), secret),
+				service:   newStaticInformer(new(v1.Service), svc),
+			},
+			log:     logger,
+			options: collectOptions(nil),
+		}
+		route := tr.getRouteFromIngress(context.Background(), ing)
+		assert.Equalf(t, 1, len(route.links), "test '%s' links mismatch", name)
+		for _, link := range route.links {
+			assert.Equalf(t, test.proto, link.options().proto, "test '%s' proto mismatch", name)
+			assert.Equalf(t, test.url, link.originURL(), "test '%s' origin url mismatch", name)
+		}
+	}
+}
+
+func TestGetRouteFromIngressCredentialRefresh(t *testing.T) {
+	t.Parallel()
+	running := genCertforHost("a.unit.com")
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "svc-a"},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Name: "http", Port: 8080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "svc-a"},
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{{IP: "1.1.1.1"}},
+				Ports:     []v1.EndpointPort{{Name: "http", Port: 8080, Protocol: v1.ProtocolTCP}},
+			},
+		},
+	}
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "ing-a"},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{{Hosts: []string{"a.unit.com"}, SecretName: "sec-a"}},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "a.unit.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "svc-a",
+											Port: networkingv1.ServiceBackendPort{Name: "http"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	rotated := genCertforHost("a.unit.com")
+	for name, test := range map[string]struct {
+		secret *v1.Secret
+		keep   bool
+		cert   []byte
+		events int
+	}{
+		"rotation-valid": {
+			secret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "sec-a"},
+				Data:       map[string][]byte{"cert.pem": rotated},
+			},
+			keep: true,
+			cert: rotated,
+		},
+		"rotation-bad-pem": {
+			secret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "sec-a"},
+				Data:       map[string][]byte{"cert.pem": []byte("not a pem")},
+			},
+			keep:   true,
+			cert:   running,
+			events: 1,
+		},
+		"rotation-wrong-host": {
+			secret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "sec-a"},
+				Data:       map[string][]byte{"cert.pem": genCertforHost("b.unit.com")},
+			},
+			keep:   true,
+			cert:   running,
+			events: 1,
+		},
+		"rotation-invalid-not-kept": {
+			secret: &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "sec-a"},
+				Data:       map[string][]byte{"cert.pem": []byte("not a pem")},
+			},
+		},
+		"secret-deleted": {
+			keep: true,
+		},
+	} {
+		link := &mockTunnelLink{}
+		link.On("routeRule").Return(tunnelRule{
+			host:    "a.unit.com",
+			port:    8080,
+			service: resource{namespace: "unit", name: "svc-a"},
+			secret:  resource{namespace: "unit", name: "sec-a"},
+		})
+		link.On("originCert").Return(running)
+		router := &mockTunnelRouter{}
+		router.On("routeLinks", "unit", "ing-a").Return([]tunnelLink{link})
+		secrets := newStaticInformer(new(v1.Secret))
+		if test.secret != nil {
+			secrets = newStaticInformer(new(v1.Secret), test.secret)
+		}
+		logger, _ := logtest.NewNullLogger()
+		tr := &syncTranslator{
+			informers: informerset{
+				configMap: newStaticInformer(new(v1.ConfigMap)),
+				endpoint:  newStaticInformer(new(v1.Endpoints), endpoints),
+				ingress:   newStaticInformer(new(networkingv1.Ingress)),
+				secret:    secrets,
+				service:   newStaticInformer(new(v1.Service), svc),
+			},
+			router:  router,
+			log:     logger,
+			options: collectOptions([]Option{KeepCredentialOnRefreshFailure(test.keep)}),
+		}
+		s := tr.getIngressState(context.Background(), ing)
+		assert.Equalf(t, test.events, len(s.events), "test '%s' events mismatch", name)
+		if test.cert == nil {
+			assert.Emptyf(t, s.route.links, "test '%s' links mismatch", name)
+			continue
+		}
+		assert.Equalf(t, 1, len(s.route.links), "test '%s' links mismatch", name)
+		for _, l := range s.route.links {
+			assert.Equalf(t, test.cert, l.originCert(), "test '%s' cert mismatch", name)
+		}
+	}
+}
+
+func TestGetMissingBackendPort(t *testing.T) {
+	t.Parallel()
+	serviceInformer := func(svc *v1.Service, exists bool) cache.SharedIndexInformer {
+		i := &mockSharedIndexInformer{}
+		i.On("GetIndexer").Return(func() cache.Indexer {
+			idx := &mockIndexer{}
+			idx.On("GetByKey", "unit/svc-a").Return(svc, exists, nil)
+			return idx
+		}())
+		return i
+	}
+	svc := &v1.Service{
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Name:       "port-a",
+					Port:       8080,
+					TargetPort: intstr.FromInt(9090),
+					Protocol:   v1.ProtocolTCP,
+				},
+			},
+		},
+	}
+	for name, test := range map[string]struct {
+		allow    bool
+		informer cache.SharedIndexInformer
+		port     networkingv1.ServiceBackendPort
+		out      int32
+		ok       bool
+	}{
+		"missing-not-allowed": {
+			allow:    false,
+			informer: serviceInformer(&v1.Service{}, false),
+			port:     networkingv1.ServiceBackendPort{Number: 8080},
+			out:      0,
+			ok:       false,
+		},
+		"service-does-not-exist-port-number": {
+			allow:    true,
+			informer: serviceInformer(&v1.Service{}, false),
+			port:     networkingv1.ServiceBackendPort{Number: 8080},
+			out:      8080,
+			ok:       true,
+		},
+		"service-does-not-exist-port-name": {
+			allow:    true,
+			informer: serviceInformer(&v1.Service{}, false),
+			port:     networkingv1.ServiceBackendPort{Name: "port-a"},
+			out:      0,
+			ok:       false,
+		},
+		"service-exists-port-name": {
+			allow:    true,
+			informer: serviceInformer(svc, true),
+			port:     networkingv1.ServiceBackendPort{Name: "port-a"},
+			out:      8080,
+			ok:       true,
+		},
+		"service-exists-missing-port": {
+			allow:    true,
+			informer: serviceInformer(svc, true),
+			port:     networkingv1.ServiceBackendPort{Number: 8443},
+			out:      0,
+			ok:       false,
+		},
+	} {
+		tr := &syncTranslator{
+			informers: informerset{
+				service: test.informer,
+			},
+			options: collectOptions([]Option{
+				AllowMissingBackend(test.allow),
+			}),
+		}
+		out, ok := tr.getMissingBackendPort("unit", "svc-a", test.port)
+		assert.Equalf(t, test.out, out, "test '%s' port mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' condition mismatch", name)
+	}
+}
+
 func newMockedSyncTranslator() *syncTranslator {
 	return &syncTranslator{
 		informers: informerset{
+			configMap: func() cache.SharedIndexInformer {
+				i := &mockSharedIndexInformer{}
+				return i
+			}(),
 			endpoint: func() cache.SharedIndexInformer {
 				i := &mockSharedIndexInformer{}
 				return i
@@ -954,8 +2267,11 @@ type mockTranslator struct {
 	mock.Mock
 }
 
-func (t *mockTranslator) handleResource(kind, key string) error {
-	args := t.Called(kind, key)
+func (t *mockTranslator) handleResource(ctx context.Context, kind, key string) error {
+	args := t.Called(ctx, kind, key)
+	if f, ok := args.Get(0).(func(ctx context.Context, kind, key string) error); ok {
+		return f(ctx, kind, key)
+	}
 	return args.Error(0)
 }
 func (t *mockTranslator) waitForCacheSync(stopCh <-chan struct{}) bool {
@@ -966,3 +2282,248 @@ func (t *mockTranslator) run(stopCh <-chan struct{}) error {
 	args := t.Called(stopCh)
 	return args.Error(0)
 }
+
+func TestUnreadyEndpointsRollout(t *testing.T) {
+	t.Parallel()
+	subset := func(ready, unready []string) []v1.EndpointSubset {
+		s := v1.EndpointSubset{
+			Ports: []v1.EndpointPort{{Name: "http", Port: 9090, Protocol: v1.ProtocolTCP}},
+		}
+		for _, ip := range ready {
+			s.Addresses = append(s.Addresses, v1.EndpointAddress{IP: ip})
+		}
+		for _, ip := range unready {
+			s.NotReadyAddresses = append(s.NotReadyAddresses, v1.EndpointAddress{IP: ip})
+		}
+		return []v1.EndpointSubset{s}
+	}
+	// a statefulset rollout, pods move between the ready and not-ready subsets
+	phases := [][]v1.EndpointSubset{
+		subset(nil, []string{"10.0.0.1", "10.0.0.2"}),
+		subset([]string{"10.0.0.1"}, []string{"10.0.0.2"}),
+		subset([]string{"10.0.0.1", "10.0.0.2"}, nil),
+		subset([]string{"10.0.0.2"}, []string{"10.0.0.1"}),
+	}
+	for name, test := range map[string]struct {
+		publish bool
+		unready string
+		addrs   [][]string
+	}{
+		"service-ready-only": {
+			publish: false,
+			addrs: [][]string{
+				nil,
+				{"10.0.0.1:9090"},
+				{"10.0.0.1:9090", "10.0.0.2:9090"},
+				{"10.0.0.2:9090"},
+			},
+		},
+		"service-publish-not-ready": {
+			publish: true,
+			addrs: [][]string{
+				{"10.0.0.1:9090", "10.0.0.2:9090"},
+				{"10.0.0.1:9090", "10.0.0.2:9090"},
+				{"10.0.0.1:9090", "10.0.0.2:9090"},
+				{"10.0.0.2:9090", "10.0.0.1:9090"},
+			},
+		},
+		"annotation-include": {
+			publish: false,
+			unready: unreadyEndpointsInclude,
+			addrs: [][]string{
+				{"10.0.0.1:9090", "10.0.0.2:9090"},
+				{"10.0.0.1:9090", "10.0.0.2:9090"},
+				{"10.0.0.1:9090", "10.0.0.2:9090"},
+				{"10.0.0.2:9090", "10.0.0.1:9090"},
+			},
+		},
+		"annotation-exclude": {
+			publish: true,
+			unready: unreadyEndpointsExclude,
+			addrs: [][]string{
+				nil,
+				{"10.0.0.1:9090"},
+				{"10.0.0.1:9090", "10.0.0.2:9090"},
+				{"10.0.0.2:9090"},
+			},
+		},
+	} {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "svc-a", Namespace: "unit"},
+			Spec: v1.ServiceSpec{
+				Ports:                    []v1.ServicePort{{Name: "http", Port: 8080, Protocol: v1.ProtocolTCP}},
+				PublishNotReadyAddresses: test.publish,
+			},
+		}
+		for i, phase := range phases {
+			ep := &v1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Name: "svc-a", Namespace: "unit"},
+				Subsets:    phase,
+			}
+			tr := &syncTranslator{
+				informers: informerset{
+					endpoint: newStaticInformer(new(v1.Endpoints), ep),
+					service:  newStaticInformer(new(v1.Service), svc),
+				},
+			}
+			addrs := tr.endpointResolver(test.unready)("unit", "svc-a", 8080)
+			assert.Equalf(t, test.addrs[i], addrs, "test '%s' phase %d addresses mismatch", name, i)
+			_, exists, _ := tr.getVerifiedPort(context.Background(), "unit", "svc-a", networkingv1.ServiceBackendPort{Number: 8080}, test.unready)
+			assert.Equalf(t, len(test.addrs[i]) > 0, exists, "test '%s' phase %d exists mismatch", name, i)
+		}
+	}
+}
+
+func TestGetIngressStateAnnotationTooLarge(t *testing.T) {
+	t.Parallel()
+	tr := newMockedSyncTranslator()
+	tr.log, _ = logtest.NewNullLogger()
+	blob := strings.Repeat("x", annotationValueLimit+1)
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unit",
+			Namespace: "unit",
+			Annotations: map[string]string{
+				annotationIngressMaintenance: "true",
+				annotationIngressTag:         blob,
+			},
+		},
+	}
+	state := tr.getIngressState(context.Background(), ing)
+	assert.Equalf(t, []ingressEvent{
+		{
+			eventtype: v1.EventTypeWarning,
+			reason:    eventReasonAnnotationTooLarge,
+			message:   fmt.Sprintf("annotation %q is %d bytes, over the %d byte limit, ignored", annotationIngressTag, annotationValueLimit+1, annotationValueLimit),
+		},
+	}, state.events, "test annotation too large events mismatch")
+	assert.Equalf(t, blob, ing.Annotations[annotationIngressTag], "test annotation too large cache mismatch")
+	assert.Equalf(t, ing, state.ing, "test annotation too large event object mismatch")
+}
+
+func TestHandleIngressClass(t *testing.T) {
+	t.Parallel()
+	ing := func(name, class string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "unit",
+				Name:        name,
+				Annotations: map[string]string{annotationIngressClass: class},
+			},
+		}
+	}
+	for name, test := range map[string]struct {
+		class string
+		key   string
+		call  string
+	}{
+		"matching-class": {
+			key:  "unit/ing-a",
+			call: "updateRoute",
+		},
+		"other-class": {
+			key:  "unit/ing-b",
+			call: "deleteByRoute",
+		},
+		"deleted": {
+			key:  "unit/ing-c",
+			call: "deleteByRoute",
+		},
+		"legacy-class": {
+			class: "argo-a,argo-b",
+			key:   "unit/ing-b",
+			call:  "updateRoute",
+		},
+	} {
+		class := test.class
+		if len(class) == 0 {
+			class = "argo-a"
+		}
+		logger, _ := logtest.NewNullLogger()
+		router := &mockTunnelRouter{}
+		router.On("updateRoute", mock.Anything).Return(nil)
+		router.On("deleteByRoute", "unit", mock.Anything).Return(nil)
+		tr := &syncTranslator{
+			informers: informerset{
+				ingress: newStaticInformer(new(networkingv1.Ingress), ing("ing-a", "argo-a"), ing("ing-b", "argo-b")),
+			},
+			router:  router,
+			log:     logger,
+			options: options{ingressClass: class},
+		}
+		assert.Nilf(t, tr.handleIngress(context.Background(), ingressKind, test.key), "test '%s' error mismatch", name)
+		router.AssertNumberOfCalls(t, test.call, 1)
+	}
+}
+
+func TestGetClassSuccessor(t *testing.T) {
+	t.Parallel()
+	ing := func(name, class, host string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "unit",
+				Name:        name,
+				Annotations: map[string]string{annotationIngressClass: class},
+			},
+			Spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{Host: host}},
+			},
+		}
+	}
+	link := func(host string, connections ...tunnelConnection) tunnelLink {
+		l := &mockTunnelLink{}
+		l.On("host").Return(host)
+		l.On("connections").Return(connections)
+		return l
+	}
+	legacy := ing("ing-legacy", "argo-tunnel", "a.unit.com")
+	current := ing("ing-current", "cloudflare", "a.unit.com")
+	for name, test := range map[string]struct {
+		class     string
+		ing       *networkingv1.Ingress
+		links     []tunnelLink
+		successor string
+		ok        bool
+	}{
+		"single-class": {
+			class: "argo-tunnel",
+			ing:   legacy,
+			links: []tunnelLink{link("a.unit.com", tunnelConnection{ID: "0"})},
+		},
+		"current-class": {
+			class: "cloudflare,argo-tunnel",
+			ing:   current,
+			links: []tunnelLink{link("a.unit.com", tunnelConnection{ID: "0"})},
+		},
+		"successor-connected": {
+			class:     "cloudflare,argo-tunnel",
+			ing:       legacy,
+			links:     []tunnelLink{link("A.unit.com", tunnelConnection{ID: "0"})},
+			successor: "unit/ing-current",
+			ok:        true,
+		},
+		"successor-connecting": {
+			class: "cloudflare,argo-tunnel",
+			ing:   legacy,
+			links: []tunnelLink{link("a.unit.com")},
+		},
+		"successor-other-host": {
+			class: "cloudflare,argo-tunnel",
+			ing:   legacy,
+			links: []tunnelLink{link("b.unit.com", tunnelConnection{ID: "0"})},
+		},
+	} {
+		router := &mockTunnelRouter{}
+		router.On("routeLinks", "unit", "ing-current").Return(test.links)
+		tr := &syncTranslator{
+			informers: informerset{
+				ingress: newStaticInformer(new(networkingv1.Ingress), legacy, current),
+			},
+			router:  router,
+			options: options{ingressClass: test.class},
+		}
+		successor, ok := tr.getClassSuccessor(test.ing, "a.unit.com")
+		assert.Equalf(t, test.successor, successor, "test '%s' successor mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' ok mismatch", name)
+	}
+}
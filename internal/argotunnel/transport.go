@@ -0,0 +1,449 @@
+package argotunnel
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	headerCfConnectingIP  = "Cf-Connecting-Ip"
+	headerCfRay           = "Cf-Ray"
+	headerXForwardedFor   = "X-Forwarded-For"
+	headerXForwardedProto = "X-Forwarded-Proto"
+	headerXRealIP         = "X-Real-Ip"
+
+	// metricsCodeError labels requests failing without a response
+	metricsCodeError = "error"
+
+	// rayExemplarMaxLen bounds the Ray ID kept as a latency exemplar, the
+	// exemplar labels are limited to 128 runes
+	rayExemplarMaxLen = 64
+
+	// acmeChallengePrefix is the path of http-01 challenges
+	acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+	maintenanceStatusDefault = http.StatusServiceUnavailable
+	maintenanceBodyDefault   = "<html><body><h1>503 Service Unavailable</h1><p>Down for maintenance.</p></body></html>\n"
+)
+
+var errBodyTooLarge = fmt.Errorf("request body too large")
+
+// endpointResolver lists the '<ip>:<port>' endpoint addresses serving a service port
+type endpointResolver func(namespace, name string, port int32) []string
+
+// acmeResolver returns the '<host>:<port>' of the cert-manager solver
+// serving an http-01 challenge path of a host
+type acmeResolver func(host, path string) (origin string, ok bool)
+
+// newLinkRoundTripper wraps the origin transport with the request
+// handling configured for the tunnel, http-01 challenges are answered
+// by their solver ahead of it.
+func newLinkRoundTripper(transport http.RoundTripper, rule tunnelRule, options tunnelOptions, resolve endpointResolver, acme acmeResolver, log *logrus.Logger) http.RoundTripper {
+	origin := transport
+	if options.proto == ProtoH2C {
+		origin = newH2CTransport(rule.host)
+	}
+	rt := newOriginRoundTripper(origin, rule, options, resolve, log)
+	if acme != nil {
+		return &acmeRoundTripper{next: rt, transport: transport, host: rule.host, resolve: acme}
+	}
+	return rt
+}
+
+// newOriginRoundTripper wraps the origin transport with the request
+// handling configured for the tunnel.
+func newOriginRoundTripper(transport http.RoundTripper, rule tunnelRule, options tunnelOptions, resolve endpointResolver, log *logrus.Logger) (rt http.RoundTripper) {
+	if options.maintenance {
+		return newMaintenanceRoundTripper(rule, options)
+	}
+	if options.redirectCode != 0 {
+		return newRedirectRoundTripper(rule, options)
+	}
+
+	rt = transport
+	var fallback *fallbackRoundTripper
+	if len(options.fallbackOrigin) > 0 {
+		fallback = &fallbackRoundTripper{next: transport, origin: options.fallbackOrigin, labels: metricsLabels(rule, options)}
+	}
+	if options.upstreamMode == UpstreamModeEndpoint && resolve != nil {
+		rt = &endpointRoundTripper{next: rt, rule: rule, resolve: resolve, fallback: fallback}
+	}
+	if fallback != nil {
+		rt = &unreachableRoundTripper{next: rt, fallback: fallback}
+	}
+	rt = &missingBackendRoundTripper{next: rt, rule: rule, backends: missingBackends, fallback: fallback}
+	if !options.noForwardedHeaders {
+		rt = &forwardedRoundTripper{next: rt}
+	}
+	if len(options.responseHeaders) > 0 {
+		rt = newResponseHeadersRoundTripper(rt, options)
+	}
+	rt = newStreamRoundTripper(rt, rule, options)
+	if options.maxBodyBytes > 0 {
+		rt = &bodyLimitRoundTripper{next: rt, limit: int64(options.maxBodyBytes)}
+	}
+	if len(options.pathPattern) > 0 || len(options.pathPrefix) > 0 || len(options.rewriteTarget) > 0 {
+		rt = newRewriteRoundTripper(rt, options)
+	}
+	rt = newMetricsRoundTripper(rt, rule, options, log)
+	if options.maxConcurrentRequests > 0 {
+		rt = newConcurrencyRoundTripper(rt, rule, options)
+	}
+	if inject := newInjectRoundTripper(rt, options); inject != nil {
+		rt = inject
+	}
+	return
+}
+
+// forwardedRoundTripper normalizes the client forwarding headers
+// from the address reported by the edge. Inbound forwarding headers
+// are not trusted, the edge passes along whatever the client sent.
+type forwardedRoundTripper struct {
+	next http.RoundTripper
+}
+
+func (rt *forwardedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.Header.Del(headerXForwardedFor)
+	r.Header.Del(headerXRealIP)
+	if ip := r.Header.Get(headerCfConnectingIP); len(ip) > 0 {
+		r.Header.Set(headerXForwardedFor, ip)
+		r.Header.Set(headerXRealIP, ip)
+	}
+	r.Header.Set(headerXForwardedProto, "https")
+	return rt.next.RoundTrip(r)
+}
+
+// bodyLimitRoundTripper rejects requests with a body larger than
+// the limit. A declared length is rejected before dialing the origin,
+// a streamed body is cut off once it exceeds the limit.
+type bodyLimitRoundTripper struct {
+	next  http.RoundTripper
+	limit int64
+}
+
+func (rt *bodyLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.ContentLength > rt.limit {
+		closeRequestBody(req)
+		return newBodyLimitResponse(req, rt.limit), nil
+	}
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength >= 0 {
+		return rt.next.RoundTrip(req)
+	}
+
+	body := &limitedBody{body: req.Body, remaining: rt.limit}
+	r := req.Clone(req.Context())
+	r.Body = body
+	res, err := rt.next.RoundTrip(r)
+	if body.isExceeded() {
+		if res != nil {
+			res.Body.Close()
+		}
+		return newBodyLimitResponse(req, rt.limit), nil
+	}
+	return res, err
+}
+
+func newBodyLimitResponse(req *http.Request, limit int64) *http.Response {
+	return newSyntheticPageResponse(req, syntheticPage{
+		status:  http.StatusRequestEntityTooLarge,
+		reason:  syntheticReasonBodyTooLarge,
+		message: fmt.Sprintf("The request body exceeds %d bytes.", limit),
+	})
+}
+
+// newSyntheticResponse answers a request without reaching the origin
+func newSyntheticResponse(req *http.Request, status int, contentType, body string) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type": {contentType},
+		},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// limitedBody fails reads once the body grows past the remaining bytes.
+// The transport reads the body on its own goroutine, so exceeded is
+// accessed atomically.
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	exceeded  int32
+}
+
+func (b *limitedBody) Read(p []byte) (n int, err error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err = b.body.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		atomic.StoreInt32(&b.exceeded, 1)
+		return 0, errBodyTooLarge
+	}
+	return
+}
+
+func (b *limitedBody) isExceeded() bool {
+	return atomic.LoadInt32(&b.exceeded) == 1
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+// endpointRoundTripper sends requests directly to the service endpoints,
+// bypassing the cluster address. Endpoints are resolved on each request
+// and picked in turn, so endpoint changes never restart the tunnel.
+type endpointRoundTripper struct {
+	next     http.RoundTripper
+	rule     tunnelRule
+	resolve  endpointResolver
+	fallback *fallbackRoundTripper
+	counter  uint32
+}
+
+func (rt *endpointRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	addrs := rt.resolve(rt.rule.service.namespace, rt.rule.service.name, rt.rule.port)
+	if len(addrs) == 0 {
+		if rt.fallback != nil {
+			return rt.fallback.RoundTrip(req)
+		}
+		closeRequestBody(req)
+		return newSyntheticPageResponse(req, syntheticPage{
+			status:     http.StatusServiceUnavailable,
+			reason:     syntheticReasonNoEndpoints,
+			message:    "No endpoints are available for this service.",
+			retryAfter: syntheticRetryAfter,
+		}), nil
+	}
+	i := atomic.AddUint32(&rt.counter, 1)
+	r := req.Clone(req.Context())
+	r.URL.Host = addrs[int(i%uint32(len(addrs)))]
+	return rt.next.RoundTrip(r)
+}
+
+// acmeRoundTripper sends http-01 challenges to the cert-manager solver
+// of the host while the solver exists, other requests, and challenges
+// without a solver, take the tunnel request handling.
+type acmeRoundTripper struct {
+	next      http.RoundTripper
+	transport http.RoundTripper
+	host      string
+	resolve   acmeResolver
+}
+
+func (rt *acmeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, acmeChallengePrefix) {
+		if origin, ok := rt.resolve(rt.host, req.URL.Path); ok {
+			r := req.Clone(req.Context())
+			r.URL.Scheme, r.URL.Host = "http", origin
+			return rt.transport.RoundTrip(r)
+		}
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// unreachableRoundTripper sends requests the origin cannot be reached
+// for to the fallback origin. A request body is only sent again when it
+// can be replayed, otherwise the origin error is returned.
+type unreachableRoundTripper struct {
+	next     http.RoundTripper
+	fallback *fallbackRoundTripper
+}
+
+func (rt *unreachableRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := rt.next.RoundTrip(req)
+	if err == nil || req.Context().Err() != nil {
+		return res, err
+	}
+	r := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return res, err
+		}
+		body, berr := req.GetBody()
+		if berr != nil {
+			return res, err
+		}
+		r.Body = body
+	}
+	return rt.fallback.RoundTrip(r)
+}
+
+// fallbackRoundTripper sends requests to the fallback origin, in place
+// of the origin address.
+type fallbackRoundTripper struct {
+	next   http.RoundTripper
+	origin string
+	labels []string
+}
+
+func (rt *fallbackRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Host = rt.origin
+	fallbackRequests.WithLabelValues(rt.labels...).Inc()
+	return rt.next.RoundTrip(r)
+}
+
+// maintenanceRoundTripper answers every request with the maintenance
+// response, the origin is never dialed.
+type maintenanceRoundTripper struct {
+	status int
+	body   string
+	labels []string
+}
+
+func newMaintenanceRoundTripper(rule tunnelRule, options tunnelOptions) *maintenanceRoundTripper {
+	rt := &maintenanceRoundTripper{
+		status: options.maintenanceStatus,
+		body:   options.maintenanceBody,
+		labels: metricsLabels(rule, options),
+	}
+	if rt.status == 0 {
+		rt.status, rt.body = maintenanceStatusDefault, maintenanceBodyDefault
+	}
+	return rt
+}
+
+func (rt *maintenanceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	closeRequestBody(req)
+	maintenanceResponses.WithLabelValues(rt.labels...).Inc()
+	return newSyntheticPageResponse(req, syntheticPage{
+		status:  rt.status,
+		reason:  syntheticReasonMaintenance,
+		message: "Down for maintenance.",
+		html:    rt.body,
+	}), nil
+}
+
+// redirectRoundTripper answers every request with a redirect, the origin
+// is never dialed. A redirect url without a path or query keeps the path
+// and query of the request.
+type redirectRoundTripper struct {
+	code   int
+	target *url.URL
+	keep   bool
+	labels []string
+}
+
+func newRedirectRoundTripper(rule tunnelRule, options tunnelOptions) *redirectRoundTripper {
+	target, _ := url.Parse(options.redirectURL)
+	if target == nil {
+		target = &url.URL{}
+	}
+	return &redirectRoundTripper{
+		code:   options.redirectCode,
+		target: target,
+		keep:   (len(target.Path) == 0 || target.Path == "/") && len(target.RawQuery) == 0,
+		labels: metricsLabels(rule, options),
+	}
+}
+
+func (rt *redirectRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	closeRequestBody(req)
+	location := *rt.target
+	if rt.keep {
+		location.Path, location.RawPath, location.RawQuery = req.URL.Path, req.URL.RawPath, req.URL.RawQuery
+	}
+	redirectResponses.WithLabelValues(rt.labels...).Inc()
+	return newSyntheticPageResponse(req, syntheticPage{
+		status:   rt.code,
+		reason:   syntheticReasonRedirect,
+		location: location.String(),
+	}), nil
+}
+
+// metricsRoundTripper records the requests proxied to the origin,
+// labeled by hostname and the service serving it. The Ray ID of a request
+// is kept as the exemplar of its latency, and logged with the request.
+type metricsRoundTripper struct {
+	next      http.RoundTripper
+	hostname  string
+	namespace string
+	service   string
+	log       *logrus.Logger
+}
+
+func newMetricsRoundTripper(next http.RoundTripper, rule tunnelRule, options tunnelOptions, log *logrus.Logger) *metricsRoundTripper {
+	labels := metricsLabels(rule, options)
+	return &metricsRoundTripper{
+		next:      next,
+		hostname:  labels[0],
+		namespace: labels[1],
+		service:   labels[2],
+		log:       log,
+	}
+}
+
+// metricsLabels are the hostname, namespace, and service labels of a tunnel
+func metricsLabels(rule tunnelRule, options tunnelOptions) []string {
+	service := rule.service.name
+	if len(options.targetService) > 0 {
+		service = options.targetService
+	}
+	return []string{rule.host, rule.service.namespace, service}
+}
+
+func (rt *metricsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := rt.next.RoundTrip(req)
+	code := metricsCodeError
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	originRequests.WithLabelValues(rt.hostname, rt.namespace, rt.service, code).Inc()
+	ray := req.Header.Get(headerCfRay)
+	duration := time.Since(start)
+	observeWithRay(originRequestDuration.WithLabelValues(rt.hostname, rt.namespace, rt.service), duration.Seconds(), ray)
+	entry := rt.log.WithFields(logrus.Fields{
+		"hostname": rt.hostname,
+		"method":   req.Method,
+		"path":     req.URL.Path,
+		"status":   code,
+		"duration": duration.String(),
+		"cf_ray":   ray,
+	})
+	if err != nil {
+		entry.Errorf("origin request failed: %v", err)
+	} else {
+		entry.Infof("origin request")
+	}
+	return res, err
+}
+
+// observeWithRay observes the value with the Ray ID as its exemplar,
+// where the observer supports exemplars and the ID fits in one
+func observeWithRay(o prometheus.Observer, v float64, ray string) {
+	if e, ok := o.(prometheus.ExemplarObserver); ok && len(ray) > 0 && len(ray) <= rayExemplarMaxLen && utf8.ValidString(ray) {
+		e.ObserveWithExemplar(v, prometheus.Labels{"cf_ray": ray})
+		return
+	}
+	o.Observe(v)
+}
@@ -0,0 +1,761 @@
+package argotunnel
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestForwardedRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  http.Header
+		out http.Header
+	}{
+		"no-headers": {
+			in: http.Header{},
+			out: http.Header{
+				headerXForwardedProto: {"https"},
+			},
+		},
+		"connecting-ip": {
+			in: http.Header{
+				headerCfConnectingIP: {"1.1.1.1"},
+			},
+			out: http.Header{
+				headerCfConnectingIP:  {"1.1.1.1"},
+				headerXForwardedFor:   {"1.1.1.1"},
+				headerXForwardedProto: {"https"},
+				headerXRealIP:         {"1.1.1.1"},
+			},
+		},
+		"connecting-ip-spoofed-forwarded": {
+			in: http.Header{
+				headerCfConnectingIP:  {"1.1.1.1"},
+				headerXForwardedFor:   {"10.0.0.1, 1.1.1.1"},
+				headerXForwardedProto: {"http"},
+				headerXRealIP:         {"10.0.0.1"},
+			},
+			out: http.Header{
+				headerCfConnectingIP:  {"1.1.1.1"},
+				headerXForwardedFor:   {"1.1.1.1"},
+				headerXForwardedProto: {"https"},
+				headerXRealIP:         {"1.1.1.1"},
+			},
+		},
+		"no-connecting-ip-spoofed-forwarded": {
+			in: http.Header{
+				headerXForwardedFor: {"10.0.0.1", "10.0.0.2"},
+				headerXRealIP:       {"10.0.0.1"},
+			},
+			out: http.Header{
+				headerXForwardedProto: {"https"},
+			},
+		},
+	} {
+		var out http.Header
+		rt := &forwardedRoundTripper{
+			next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				out = req.Header
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			}),
+		}
+		req, _ := http.NewRequest(http.MethodGet, "http://unit.com", nil)
+		req.Header = test.in
+		_, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.out, out, "test '%s' header mismatch", name)
+	}
+}
+
+func TestNewLinkRoundTripper(t *testing.T) {
+	t.Parallel()
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, nil
+	})
+	for name, test := range map[string]struct {
+		opts      tunnelOptions
+		forwarded bool
+	}{
+		"forwarded-headers-default": {
+			opts:      tunnelOptions{},
+			forwarded: true,
+		},
+		"forwarded-headers-disabled": {
+			opts: tunnelOptions{
+				noForwardedHeaders: true,
+			},
+			forwarded: false,
+		},
+	} {
+		log, _ := logtest.NewNullLogger()
+		rt, metrics := newLinkRoundTripper(transport, tunnelRule{}, test.opts, nil, nil, log).(*metricsRoundTripper)
+		assert.Truef(t, metrics, "test '%s' metrics mismatch", name)
+		stream, ok := rt.next.(*streamRoundTripper)
+		assert.Truef(t, ok, "test '%s' stream mismatch", name)
+		_, forwarded := stream.next.(*forwardedRoundTripper)
+		assert.Equalf(t, test.forwarded, forwarded, "test '%s' forwarded mismatch", name)
+	}
+}
+
+func TestNewLinkRoundTripperH2C(t *testing.T) {
+	t.Parallel()
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, nil
+	})
+	for name, test := range map[string]struct {
+		proto string
+		h2c   bool
+	}{
+		"http": {
+			proto: ProtoHTTP,
+		},
+		"h2c": {
+			proto: ProtoH2C,
+			h2c:   true,
+		},
+	} {
+		log, _ := logtest.NewNullLogger()
+		rt := newLinkRoundTripper(transport, tunnelRule{}, tunnelOptions{proto: test.proto, noForwardedHeaders: true}, nil, nil, log).(*metricsRoundTripper)
+		stream := rt.next.(*streamRoundTripper)
+		missing := stream.next.(*missingBackendRoundTripper)
+		_, h2c := missing.next.(*http2.Transport)
+		assert.Equalf(t, test.h2c, h2c, "test '%s' h2c mismatch", name)
+	}
+}
+
+func TestH2CTransport(t *testing.T) {
+	t.Parallel()
+	origin := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}), &http2.Server{}))
+	defer origin.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, origin.URL, nil)
+	res, err := newH2CTransport("unit.com").RoundTrip(req)
+	assert.Nilf(t, err, "test h2c error mismatch")
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	assert.Equalf(t, "HTTP/2.0", string(body), "test h2c proto mismatch")
+}
+
+func TestNewLinkRoundTripperMaintenance(t *testing.T) {
+	t.Parallel()
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("origin dialed")
+	})
+	log, _ := logtest.NewNullLogger()
+	rt := newLinkRoundTripper(transport, tunnelRule{host: "a.unit.com"}, tunnelOptions{maintenance: true}, nil, nil, log)
+	_, ok := rt.(*maintenanceRoundTripper)
+	assert.Truef(t, ok, "test maintenance round tripper mismatch")
+}
+
+func TestMaintenanceRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		rule   tunnelRule
+		opts   tunnelOptions
+		status int
+		body   string
+	}{
+		"maintenance-default": {
+			rule: tunnelRule{
+				host:    "default.maintenance.unit.com",
+				service: resource{namespace: "unit", name: "svc"},
+			},
+			opts: tunnelOptions{
+				maintenance: true,
+			},
+			status: maintenanceStatusDefault,
+			body:   maintenanceBodyDefault,
+		},
+		"maintenance-configured": {
+			rule: tunnelRule{
+				host:    "configured.maintenance.unit.com",
+				service: resource{namespace: "unit", name: "svc"},
+			},
+			opts: tunnelOptions{
+				maintenance:       true,
+				maintenanceStatus: http.StatusOK,
+				maintenanceBody:   "<h1>unit</h1>",
+			},
+			status: http.StatusOK,
+			body:   "<h1>unit</h1>",
+		},
+	} {
+		rt := newMaintenanceRoundTripper(test.rule, test.opts)
+		req, _ := http.NewRequest(http.MethodGet, "http://unit.com", nil)
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.status, res.StatusCode, "test '%s' status mismatch", name)
+		body, _ := io.ReadAll(res.Body)
+		assert.Equalf(t, test.body, string(body), "test '%s' body mismatch", name)
+		count := testutil.ToFloat64(maintenanceResponses.WithLabelValues(test.rule.host, "unit", "svc"))
+		assert.Equalf(t, float64(1), count, "test '%s' count mismatch", name)
+	}
+}
+
+func TestRedirectRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		code     int
+		url      string
+		req      string
+		location string
+	}{
+		"redirect-keep-path": {
+			code:     http.StatusMovedPermanently,
+			url:      "https://example.com",
+			req:      "http://svc.unit:80/a/b?c=d",
+			location: "https://example.com/a/b?c=d",
+		},
+		"redirect-keep-path-slash": {
+			code:     http.StatusFound,
+			url:      "https://example.com:8443/",
+			req:      "http://svc.unit:80/a",
+			location: "https://example.com:8443/a",
+		},
+		"redirect-fixed-url": {
+			code:     http.StatusMovedPermanently,
+			url:      "https://example.com/landing",
+			req:      "http://svc.unit:80/a?c=d",
+			location: "https://example.com/landing",
+		},
+	} {
+		rule := tunnelRule{host: "www." + name + ".com", service: resource{namespace: "unit", name: "svc"}}
+		log, _ := logtest.NewNullLogger()
+		rt := newLinkRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			t.Errorf("test '%s' origin dialed", name)
+			return nil, fmt.Errorf("origin dialed")
+		}), rule, tunnelOptions{redirectCode: test.code, redirectURL: test.url}, nil, nil, log)
+		req, _ := http.NewRequest(http.MethodGet, test.req, nil)
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.code, res.StatusCode, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.location, res.Header.Get("Location"), "test '%s' location mismatch", name)
+		count := testutil.ToFloat64(redirectResponses.WithLabelValues(rule.host, "unit", "svc"))
+		assert.Equalf(t, float64(1), count, "test '%s' count mismatch", name)
+	}
+}
+
+func TestEndpointRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		addrs []string
+		hosts []string
+		code  int
+	}{
+		"no-endpoints": {
+			addrs: nil,
+			hosts: []string{},
+			code:  http.StatusServiceUnavailable,
+		},
+		"one-endpoint": {
+			addrs: []string{"10.0.0.1:8080"},
+			hosts: []string{"10.0.0.1:8080", "10.0.0.1:8080"},
+			code:  http.StatusOK,
+		},
+		"many-endpoints": {
+			addrs: []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+			hosts: []string{"10.0.0.2:8080", "10.0.0.1:8080"},
+			code:  http.StatusOK,
+		},
+	} {
+		hosts := []string{}
+		rt := &endpointRoundTripper{
+			next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				hosts = append(hosts, req.URL.Host)
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			}),
+			rule: tunnelRule{
+				service: resource{namespace: "unit", name: "svc"},
+				port:    80,
+			},
+			resolve: func(namespace, name string, port int32) []string {
+				return test.addrs
+			},
+		}
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest(http.MethodGet, "http://svc.unit:80", nil)
+			res, err := rt.RoundTrip(req)
+			assert.Nilf(t, err, "test '%s' error mismatch", name)
+			assert.Equalf(t, test.code, res.StatusCode, "test '%s' status mismatch", name)
+		}
+		assert.Equalf(t, test.hosts, hosts, "test '%s' hosts mismatch", name)
+	}
+}
+
+func TestUnreachableRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		err       error
+		body      io.Reader
+		replay    bool
+		hosts     []string
+		code      int
+		fallbacks float64
+	}{
+		"origin-reached": {
+			err:   nil,
+			hosts: []string{"svc.unit:80"},
+			code:  http.StatusOK,
+		},
+		"origin-unreachable": {
+			err:       fmt.Errorf("dial tcp: connection refused"),
+			hosts:     []string{"svc.unit:80", "fallback.unit:8080"},
+			code:      http.StatusServiceUnavailable,
+			fallbacks: 1,
+		},
+		"origin-unreachable-replayable-body": {
+			err:       fmt.Errorf("dial tcp: connection refused"),
+			body:      strings.NewReader("replay"),
+			replay:    true,
+			hosts:     []string{"svc.unit:80", "fallback.unit:8080"},
+			code:      http.StatusServiceUnavailable,
+			fallbacks: 1,
+		},
+		"origin-unreachable-streamed-body": {
+			err:   fmt.Errorf("dial tcp: connection refused"),
+			body:  strings.NewReader("stream"),
+			hosts: []string{"svc.unit:80"},
+		},
+	} {
+		host := "a." + name + ".com"
+		hosts := []string{}
+		transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			hosts = append(hosts, req.URL.Host)
+			if req.URL.Host == "fallback.unit:8080" {
+				if req.Body != nil {
+					b, _ := io.ReadAll(req.Body)
+					assert.Equalf(t, "replay", string(b), "test '%s' body mismatch", name)
+				}
+				return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+			}
+			if test.err != nil {
+				return nil, test.err
+			}
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		})
+		rt := &unreachableRoundTripper{
+			next:     transport,
+			fallback: &fallbackRoundTripper{next: transport, origin: "fallback.unit:8080", labels: []string{host, "unit", "svc"}},
+		}
+		req, _ := http.NewRequest(http.MethodPost, "http://svc.unit:80", test.body)
+		if test.body != nil && !test.replay {
+			req.GetBody = nil
+		}
+		res, err := rt.RoundTrip(req)
+		assert.Equalf(t, test.hosts, hosts, "test '%s' hosts mismatch", name)
+		if test.code == 0 {
+			assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+		} else {
+			assert.Nilf(t, err, "test '%s' error mismatch", name)
+			assert.Equalf(t, test.code, res.StatusCode, "test '%s' status mismatch", name)
+		}
+		count := testutil.ToFloat64(fallbackRequests.WithLabelValues(host, "unit", "svc"))
+		assert.Equalf(t, test.fallbacks, count, "test '%s' fallback count mismatch", name)
+	}
+}
+
+func TestEndpointRoundTripperFallback(t *testing.T) {
+	t.Parallel()
+	hosts := []string{}
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	rt := &endpointRoundTripper{
+		next: transport,
+		rule: tunnelRule{
+			service: resource{namespace: "unit", name: "svc"},
+			port:    80,
+		},
+		resolve: func(namespace, name string, port int32) []string {
+			return nil
+		},
+		fallback: &fallbackRoundTripper{next: transport, origin: "fallback.unit:8080", labels: []string{"a.endpoint-fallback.com", "unit", "svc"}},
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://svc.unit:80", nil)
+	res, err := rt.RoundTrip(req)
+	assert.Nilf(t, err, "test endpoint fallback error mismatch")
+	assert.Equalf(t, http.StatusOK, res.StatusCode, "test endpoint fallback status mismatch")
+	assert.Equalf(t, []string{"fallback.unit:8080"}, hosts, "test endpoint fallback hosts mismatch")
+}
+
+func TestBodyLimitRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		body   string
+		length int64
+		limit  int64
+		called bool
+		code   int
+	}{
+		"declared-under-limit": {
+			body:   "0123456789",
+			length: 10,
+			limit:  10,
+			called: true,
+			code:   http.StatusOK,
+		},
+		"declared-over-limit": {
+			body:   "0123456789",
+			length: 10,
+			limit:  9,
+			called: false,
+			code:   http.StatusRequestEntityTooLarge,
+		},
+		"streamed-under-limit": {
+			body:   "0123456789",
+			length: -1,
+			limit:  10,
+			called: true,
+			code:   http.StatusOK,
+		},
+		"streamed-over-limit": {
+			body:   "0123456789",
+			length: -1,
+			limit:  9,
+			called: true,
+			code:   http.StatusRequestEntityTooLarge,
+		},
+	} {
+		called := false
+		rt := &bodyLimitRoundTripper{
+			next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				called = true
+				if _, err := io.ReadAll(req.Body); err != nil {
+					return nil, err
+				}
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			}),
+			limit: test.limit,
+		}
+		req, _ := http.NewRequest(http.MethodPost, "http://unit.com", io.NopCloser(strings.NewReader(test.body)))
+		req.ContentLength = test.length
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		assert.Equalf(t, test.called, called, "test '%s' origin mismatch", name)
+		assert.Equalf(t, test.code, res.StatusCode, "test '%s' status mismatch", name)
+	}
+}
+
+func TestMetricsRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		rule    tunnelRule
+		opts    tunnelOptions
+		res     *http.Response
+		err     error
+		service string
+		code    string
+	}{
+		"metrics-status": {
+			rule: tunnelRule{
+				host:    "status.unit.com",
+				service: resource{namespace: "unit", name: "svc"},
+			},
+			res:     &http.Response{StatusCode: http.StatusNotFound},
+			service: "svc",
+			code:    "404",
+		},
+		"metrics-error": {
+			rule: tunnelRule{
+				host:    "error.unit.com",
+				service: resource{namespace: "unit", name: "svc"},
+			},
+			err:     fmt.Errorf("unit error"),
+			service: "svc",
+			code:    metricsCodeError,
+		},
+		"metrics-target-service": {
+			rule: tunnelRule{
+				host:    "target.unit.com",
+				service: resource{namespace: "unit", name: "svc"},
+			},
+			opts: tunnelOptions{
+				targetService: "deploy",
+			},
+			res:     &http.Response{StatusCode: http.StatusOK},
+			service: "deploy",
+			code:    "200",
+		},
+	} {
+		log, _ := logtest.NewNullLogger()
+		rt := newMetricsRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return test.res, test.err
+		}), test.rule, test.opts, log)
+		req, _ := http.NewRequest(http.MethodGet, "http://unit.com", nil)
+		res, err := rt.RoundTrip(req)
+		assert.Equalf(t, test.res, res, "test '%s' response mismatch", name)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+		count := testutil.ToFloat64(originRequests.WithLabelValues(test.rule.host, test.rule.service.namespace, test.service, test.code))
+		assert.Equalf(t, float64(1), count, "test '%s' count mismatch", name)
+	}
+}
+
+func TestMetricsRoundTripperRay(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		ray    string
+		err    error
+		level  logrus.Level
+		msg    string
+		fields logrus.Fields
+	}{
+		"ok": {
+			ray:    "7d1f3a2b4c5e6f70-LAX",
+			level:  logrus.InfoLevel,
+			msg:    "origin request",
+			fields: logrus.Fields{"hostname": "ray.unit.com", "method": "GET", "path": "/a", "status": "200", "cf_ray": "7d1f3a2b4c5e6f70-LAX"},
+		},
+		"failed": {
+			ray:    "7d1f3a2b4c5e6f70-LAX",
+			err:    fmt.Errorf("dial tcp: connection refused"),
+			level:  logrus.ErrorLevel,
+			msg:    "origin request failed: dial tcp: connection refused",
+			fields: logrus.Fields{"hostname": "ray.unit.com", "method": "GET", "path": "/a", "status": metricsCodeError, "cf_ray": "7d1f3a2b4c5e6f70-LAX"},
+		},
+		"failed-without-ray": {
+			err:    fmt.Errorf("dial tcp: connection refused"),
+			level:  logrus.ErrorLevel,
+			msg:    "origin request failed: dial tcp: connection refused",
+			fields: logrus.Fields{"hostname": "ray.unit.com", "method": "GET", "path": "/a", "status": metricsCodeError, "cf_ray": ""},
+		},
+	} {
+		log, hook := logtest.NewNullLogger()
+		rt := newMetricsRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if test.err != nil {
+				return nil, test.err
+			}
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		}), tunnelRule{host: "ray.unit.com", service: resource{namespace: "unit", name: "svc"}}, tunnelOptions{}, log)
+		req, _ := http.NewRequest(http.MethodGet, "http://ray.unit.com/a", nil)
+		if len(test.ray) > 0 {
+			req.Header.Set(headerCfRay, test.ray)
+		}
+		rt.RoundTrip(req)
+		entries := hook.AllEntries()
+		if assert.Equalf(t, 1, len(entries), "test '%s' logs mismatch", name) {
+			assert.Equalf(t, test.level, entries[0].Level, "test '%s' level mismatch", name)
+			assert.Equalf(t, test.msg, entries[0].Message, "test '%s' message mismatch", name)
+			_, ok := entries[0].Data["duration"]
+			assert.Truef(t, ok, "test '%s' duration mismatch", name)
+			delete(entries[0].Data, "duration")
+			assert.Equalf(t, test.fields, entries[0].Data, "test '%s' fields mismatch", name)
+		}
+	}
+}
+
+type exemplarObserver struct {
+	value    float64
+	exemplar prometheus.Labels
+}
+
+func (o *exemplarObserver) Observe(v float64) {
+	o.value = v
+}
+
+func (o *exemplarObserver) ObserveWithExemplar(v float64, e prometheus.Labels) {
+	o.value, o.exemplar = v, e
+}
+
+func TestObserveWithRay(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		ray string
+		out prometheus.Labels
+	}{
+		"ray": {
+			ray: "7d1f3a2b4c5e6f70-LAX",
+			out: prometheus.Labels{"cf_ray": "7d1f3a2b4c5e6f70-LAX"},
+		},
+		"no-ray": {},
+		"ray-too-long": {
+			ray: strings.Repeat("7", rayExemplarMaxLen+1),
+		},
+		"ray-invalid-utf8": {
+			ray: "\xff",
+		},
+	} {
+		o := &exemplarObserver{}
+		observeWithRay(o, 0.25, test.ray)
+		assert.Equalf(t, 0.25, o.value, "test '%s' value mismatch", name)
+		assert.Equalf(t, test.out, o.exemplar, "test '%s' exemplar mismatch", name)
+	}
+}
+
+func TestRayPropagation(t *testing.T) {
+	t.Parallel()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Proto, r.Header.Get(headerCfRay))
+	})
+	for name, test := range map[string]struct {
+		origin *httptest.Server
+		proto  string
+		out    string
+	}{
+		"http1": {
+			origin: httptest.NewServer(handler),
+			proto:  ProtoHTTP,
+			out:    "HTTP/1.1 7d1f3a2b4c5e6f70-LAX",
+		},
+		"h2c": {
+			origin: httptest.NewServer(h2c.NewHandler(handler, &http2.Server{})),
+			proto:  ProtoH2C,
+			out:    "HTTP/2.0 7d1f3a2b4c5e6f70-LAX",
+		},
+	} {
+		log, _ := logtest.NewNullLogger()
+		rt := newLinkRoundTripper(http.DefaultTransport, tunnelRule{host: "ray.unit.com"}, tunnelOptions{proto: test.proto}, nil, nil, log)
+		req, _ := http.NewRequest(http.MethodGet, test.origin.URL, nil)
+		req.Header.Set(headerCfRay, "7d1f3a2b4c5e6f70-LAX")
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		if err == nil {
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.Equalf(t, test.out, string(body), "test '%s' ray mismatch", name)
+		}
+		test.origin.Close()
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestStreamRoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		opts  tunnelOptions
+		limit int
+	}{
+		"stream-buffer-default": {
+			opts:  tunnelOptions{},
+			limit: StreamBufferBytesDefault,
+		},
+		"stream-buffer-set": {
+			opts: tunnelOptions{
+				streamBufferBytes: 4,
+			},
+			limit: 4,
+		},
+	} {
+		var reqBody io.ReadCloser
+		rt := newStreamRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			reqBody = req.Body
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("0123456789"))}, nil
+		}), tunnelRule{host: name + ".unit.com"}, test.opts)
+		assert.Equalf(t, test.limit, rt.limit, "test '%s' limit mismatch", name)
+
+		req, _ := http.NewRequest(http.MethodPost, "http://unit.com", strings.NewReader("0123456789"))
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		_, ok := reqBody.(*streamBody)
+		assert.Truef(t, ok, "test '%s' request body mismatch", name)
+		_, ok = res.Body.(*streamBody)
+		assert.Truef(t, ok, "test '%s' response body mismatch", name)
+		body, err := io.ReadAll(res.Body)
+		assert.Nilf(t, err, "test '%s' read error mismatch", name)
+		assert.Equalf(t, "0123456789", string(body), "test '%s' body mismatch", name)
+	}
+}
+
+func TestACMERoundTripper(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		path   string
+		solver bool
+		host   string
+	}{
+		"challenge-solved": {
+			path:   "/.well-known/acme-challenge/token",
+			solver: true,
+			host:   "solver.unit:8089",
+		},
+		"challenge-unsolved": {
+			path:   "/.well-known/acme-challenge/token",
+			solver: false,
+			host:   "svc.unit:80",
+		},
+		"request-passed": {
+			path:   "/app",
+			solver: true,
+			host:   "svc.unit:80",
+		},
+	} {
+		var host string
+		transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			host = req.URL.Host
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		})
+		log, _ := logtest.NewNullLogger()
+		rt := newLinkRoundTripper(transport, tunnelRule{host: name + ".unit.com"}, tunnelOptions{maintenance: true}, nil, func(h, path string) (string, bool) {
+			return "solver.unit:8089", test.solver
+		}, log)
+		req, _ := http.NewRequest(http.MethodGet, "http://svc.unit:80"+test.path, nil)
+		res, err := rt.RoundTrip(req)
+		assert.Nilf(t, err, "test '%s' error mismatch", name)
+		if test.host == "solver.unit:8089" {
+			assert.Equalf(t, test.host, host, "test '%s' host mismatch", name)
+			assert.Equalf(t, http.StatusOK, res.StatusCode, "test '%s' status mismatch", name)
+		} else {
+			assert.Equalf(t, "", host, "test '%s' host mismatch", name)
+			assert.Equalf(t, maintenanceStatusDefault, res.StatusCode, "test '%s' status mismatch", name)
+		}
+	}
+}
+
+func TestStreamBody(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		limit  int
+		hold   time.Duration
+		reads  []int
+		stalls float64
+	}{
+		"read-capped": {
+			limit:  4,
+			reads:  []int{4, 4, 2},
+			stalls: 0,
+		},
+		"read-stalled": {
+			limit:  8,
+			hold:   20 * time.Millisecond,
+			reads:  []int{8, 2},
+			stalls: 2,
+		},
+	} {
+		host := name + ".unit.com"
+		b := newStreamBody(io.NopCloser(strings.NewReader("0123456789")), test.limit, host, streamDirectionResponse)
+		b.threshold = 10 * time.Millisecond
+		p := make([]byte, 16)
+		reads := []int{}
+		for {
+			n, err := b.Read(p)
+			if err != nil {
+				break
+			}
+			reads = append(reads, n)
+			time.Sleep(test.hold)
+		}
+		assert.Equalf(t, test.reads, reads, "test '%s' reads mismatch", name)
+		stalls := testutil.ToFloat64(streamStalls.WithLabelValues(host, streamDirectionResponse))
+		assert.Equalf(t, test.stalls, stalls, "test '%s' stalls mismatch", name)
+	}
+}
@@ -2,6 +2,7 @@ package argotunnel
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -11,13 +12,17 @@ import (
 	"net/http"
 	"reflect"
 	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudflare/cloudflare-ingress-controller/internal/cloudflare"
 	"github.com/cloudflare/cloudflared/origin"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
 	utilrand "k8s.io/apimachinery/pkg/util/rand"
 )
 
@@ -28,8 +33,31 @@ const (
 	RepairJitterDefault = 0.5
 	// RepairStepsDefault the default exponential steps used during repair
 	RepairStepsDefault = 4
+	// RepairCyclesDefault the default repair cycles before a tunnel stops repairing, 0 repairs forever
+	RepairCyclesDefault = 0
 	// TagLimitDefault the default number of unique tags
 	TagLimitDefault = 32
+	// ReadinessGateTimeoutDefault the default period the first registration of a new host waits on its readiness probe
+	ReadinessGateTimeoutDefault = 2 * time.Minute
+
+	// ProtoHTTP proxies requests to the origin over http
+	ProtoHTTP = "http"
+	// ProtoHTTPS proxies requests to the origin over https
+	ProtoHTTPS = "https"
+	// ProtoH2C proxies requests to the origin over cleartext http/2
+	ProtoH2C = "h2c"
+	// ProtoTCP streams raw tcp to the origin, refused by ValidateDefaultProto
+	ProtoTCP = "tcp"
+
+	// UpstreamModeService proxies requests to the service cluster address
+	UpstreamModeService = "service"
+	// UpstreamModeEndpoint proxies requests directly to the service endpoints
+	UpstreamModeEndpoint = "endpoint"
+
+	// unreadyEndpointsInclude and unreadyEndpointsExclude override the
+	// publishNotReadyAddresses of the backend service
+	unreadyEndpointsInclude = "include"
+	unreadyEndpointsExclude = "exclude"
 
 	serverName = "cftunnel.com"
 )
@@ -54,6 +82,36 @@ func SetRepairBackoff(delay time.Duration, jitter float64, steps uint) {
 	})
 }
 
+var repairBreaker = struct {
+	cycles     uint
+	setBreaker sync.Once
+}{
+	cycles: RepairCyclesDefault,
+}
+
+// SetRepairBreaker configures the repair cycles, of repair steps each,
+// after which tunnels stop repairing until retried
+func SetRepairBreaker(cycles uint) {
+	repairBreaker.setBreaker.Do(func() {
+		repairBreaker.cycles = cycles
+	})
+}
+
+var readinessGate = struct {
+	timeout    time.Duration
+	setTimeout sync.Once
+}{
+	timeout: ReadinessGateTimeoutDefault,
+}
+
+// SetReadinessGateTimeout configures how long the first registration of
+// a new host waits on its readiness probe before registering anyway
+func SetReadinessGateTimeout(timeout time.Duration) {
+	readinessGate.setTimeout.Do(func() {
+		readinessGate.timeout = timeout
+	})
+}
+
 var tagConfig = struct {
 	limit  int
 	setTag sync.Once
@@ -69,29 +127,57 @@ func SetTagLimit(limit int) {
 }
 
 type tunnelRoute struct {
-	name      string
-	namespace string
-	links     tunnelRouteLinkMap
+	name            string
+	namespace       string
+	generation      int64
+	resourceVersion string
+	created         time.Time
+	annotations     map[string]string
+	links           tunnelRouteLinkMap
+}
+
+// sortedLinks lists the links of the route by hostname and service
+func (r *tunnelRoute) sortedLinks() []tunnelLink {
+	links := make([]tunnelLink, 0, len(r.links))
+	for _, link := range r.links {
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		a, b := links[i].routeRule(), links[j].routeRule()
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		return itemKeyFunc(a.service.namespace, a.service.name) < itemKeyFunc(b.service.namespace, b.service.name)
+	})
+	return links
 }
 
 type tunnelRule struct {
-	service resource
-	secret  resource
-	host    string
-	port    int32
+	service      resource
+	secret       resource
+	host         string
+	port         int32
+	externalName string // origin host of an ExternalName service
 }
 
 type tunnelRouteLinkMap map[tunnelRule]tunnelLink
 
+// linkStatusFunc is notified of the condition of the tunnel of a host,
+// an empty condition reports a healthy tunnel
+type linkStatusFunc func(host string, cond ingressCondition)
+
 type tunnelLink interface {
 	host() string
 	routeRule() tunnelRule
 	originURL() string
 	originCert() []byte
 	options() tunnelOptions
+	connections() []tunnelConnection
+	tripped() bool
 	equal(other tunnelLink) bool
 	start() error
 	stop() error
+	retire(successor tunnelLink) error
 }
 
 type syncTunnelLink struct {
@@ -100,10 +186,21 @@ type syncTunnelLink struct {
 	cert    []byte
 	opts    tunnelOptions
 	config  *origin.TunnelConfig
+	proxy   *lifecycleRoundTripper
 	errCh   chan error
 	quitCh  chan struct{}
 	stopCh  chan struct{}
 	repiars uint
+	backoff int64
+	colos   *coloTracker
+	ready   int32
+	fails   uint32
+	open    int32
+	gated   int32
+	since   time.Time
+	up      int32
+	report  linkStatusFunc
+	notify  linkEventFunc
 	log     *logrus.Logger
 }
 
@@ -127,6 +224,15 @@ func (l *syncTunnelLink) options() tunnelOptions {
 	return l.opts
 }
 
+func (l *syncTunnelLink) connections() []tunnelConnection {
+	return l.colos.connections()
+}
+
+// tripped reports whether the link stopped repairing
+func (l *syncTunnelLink) tripped() bool {
+	return atomic.LoadInt32(&l.open) == 1
+}
+
 func (l *syncTunnelLink) equal(other tunnelLink) bool {
 	if l.rule.host != other.host() {
 		return false
@@ -156,49 +262,155 @@ func (l *syncTunnelLink) start() (err error) {
 	}
 
 	l.log.Infof("link start host: %s, origin: %s", l.host(), l.originURL())
+	l.config.Metrics = acquireTunnelMetrics(l.rule.host, l.log)
 	l.stopCh = make(chan struct{})
 	l.quitCh = make(chan struct{})
+	if l.proxy != nil {
+		l.proxy.open()
+	}
+	atomic.StoreUint32(&l.fails, 0)
+	atomic.StoreInt32(&l.open, 0)
+	atomic.StoreInt32(&l.up, 0)
+	tunnelBreakerOpen.WithLabelValues(l.rule.host).Set(0)
 	go repairFunc(l)()
 	go launchFunc(l)()
 	return
 }
 
 func (l *syncTunnelLink) stop() (err error) {
+	if l.halt("stop") {
+		tunnelOriginReady.DeleteLabelValues(l.rule.host)
+		tunnelBreakerOpen.DeleteLabelValues(l.rule.host)
+		l.setStatus(ingressCondition{})
+	}
+	return
+}
+
+// retire stops a link replaced by a successor serving the same host,
+// the host metrics and status are left to the successor
+func (l *syncTunnelLink) retire(successor tunnelLink) (err error) {
+	if l.halt("retire") {
+		if s, ok := successor.(*syncTunnelLink); ok {
+			s.colos.publish()
+		}
+	}
+	return
+}
+
+// halt closes the link, false when it was not running
+func (l *syncTunnelLink) halt(action string) bool {
 	if l.stopCh == nil {
-		return nil
+		return false
 	}
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	if l.stopCh == nil {
-		return nil
+		return false
 	}
 
-	l.log.Infof("link stop host: %s, origin: %s", l.host(), l.originURL())
+	l.log.Infof("link %s host: %s, origin: %s", action, l.host(), l.originURL())
 	close(l.quitCh)
 	close(l.stopCh)
 	l.quitCh = nil
 	l.stopCh = nil
-	return
+	if l.proxy != nil {
+		// sealed here, so a start right after opens a fresh cycle
+		if c := l.proxy.seal(); c != nil {
+			go c.drain(l.drainTimeout())
+		}
+	}
+	l.colos.reset()
+	releaseTunnelMetrics(l.rule.host)
+	return true
+}
+
+// drainTimeout bounds the drain of the requests in flight once the link
+// is stopped, the grace period when set
+func (l *syncTunnelLink) drainTimeout() time.Duration {
+	if l.opts.gracePeriod > 0 {
+		return l.opts.gracePeriod
+	}
+	return linkDrainTimeout
+}
+
+// setStatus reports the condition of the link
+func (l *syncTunnelLink) setStatus(cond ingressCondition) {
+	if l.report != nil {
+		l.report(l.rule.host, cond)
+	}
+}
+
+// setReady marks whether the origin passed the readiness probe, a
+// ready link is reported healthy once connected.
+func (l *syncTunnelLink) setReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&l.ready, 1)
+		tunnelOriginReady.WithLabelValues(l.rule.host).Set(1)
+	} else {
+		atomic.StoreInt32(&l.ready, 0)
+		tunnelOriginReady.WithLabelValues(l.rule.host).Set(0)
+	}
 }
 
-func newTunnelLink(rule tunnelRule, cert []byte, options tunnelOptions) tunnelLink {
-	return &syncTunnelLink{
+// gateFirstStart holds the next registration of the link until its origin
+// is ready, the initial delay counts from since
+func gateFirstStart(link tunnelLink, since time.Time) {
+	if l, ok := link.(*syncTunnelLink); ok {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.since = since
+		atomic.StoreInt32(&l.gated, 1)
+	}
+}
+
+// notifyEvent reports a connection event of the link
+func (l *syncTunnelLink) notifyEvent(event, message string) {
+	if l.notify != nil {
+		l.notify(l.rule.host, event, message)
+	}
+}
+
+func (l *syncTunnelLink) connected() {
+	atomic.StoreUint32(&l.fails, 0)
+	atomic.StoreInt64(&l.backoff, 0)
+	if atomic.CompareAndSwapInt32(&l.up, 0, 1) {
+		l.notifyEvent(webhookEventConnected, "")
+	}
+	if atomic.LoadInt32(&l.ready) == 1 {
+		l.setStatus(ingressCondition{})
+	}
+}
+
+func newTunnelLink(rule tunnelRule, cert []byte, options tunnelOptions, resolve endpointResolver, acme acmeResolver, report linkStatusFunc, notify linkEventFunc, log *logrus.Logger) tunnelLink {
+	colos := newColoTracker(rule.host, log)
+	config := newLinkTunnelConfig(rule, cert, options, resolve, acme, log)
+	config.Logger = newLinkLogger(log, colos)
+	proxy := newLifecycleRoundTripper(config.HTTPTransport, rule.host)
+	config.HTTPTransport = proxy
+	l := &syncTunnelLink{
 		rule:   rule,
 		cert:   cert,
 		opts:   options,
-		config: newLinkTunnelConfig(rule, cert, options),
+		config: config,
+		proxy:  proxy,
 		errCh:  make(chan error),
-		log:    logrus.StandardLogger(),
+		colos:  colos,
+		report: report,
+		notify: notify,
+		log:    log,
 	}
+	colos.onConnect = l.connected
+	return l
 }
 
-func newLinkTunnelConfig(rule tunnelRule, cert []byte, options tunnelOptions) *origin.TunnelConfig {
+func newLinkTunnelConfig(rule tunnelRule, cert []byte, options tunnelOptions, resolve endpointResolver, acme acmeResolver, log *logrus.Logger) *origin.TunnelConfig {
 	httpTransport := newLinkHTTPTransport()
+	httpTransport.DialContext = chaosConfig.injector.delayDial(rule.host, httpTransport.DialContext)
 	return &origin.TunnelConfig{
-		EdgeAddrs:  []string{}, // load default values later, see github.com/cloudflare/cloudflared/blob/master/origin/discovery.go#
-		OriginUrl:  getOriginURL(rule),
+		EdgeAddrs:  edgeProxy.addrs(parseEdgeAddrs(options.edgeAddrs), logrus.StandardLogger()), // empty loads default values later, see github.com/cloudflare/cloudflared/blob/master/origin/discovery.go#
+		OriginUrl:  getOriginURL(rule, originURLProto(options.proto)),
 		Hostname:   rule.host,
 		OriginCert: cert,
 		TlsConfig: &tls.Config{
@@ -215,7 +427,7 @@ func newLinkTunnelConfig(rule tunnelRule, cert []byte, options tunnelOptions) *o
 		LBPool:            options.lbPool,
 		Tags:              parseTags(options.tags, tagConfig.limit),
 		HAConnections:     options.haConnections,
-		HTTPTransport:     httpTransport,
+		HTTPTransport:     newLinkRoundTripper(httpTransport, rule, options, resolve, acme, log),
 		Metrics:           metricsConfig.metrics,
 		MetricsUpdateFreq: metricsConfig.updateFrequency,
 		// todo: alter logger creation to allow easy disable for tests
@@ -247,11 +459,61 @@ func newLinkHTTPTransport() *http.Transport {
 	}
 }
 
-func getOriginURL(rule tunnelRule) (url string) {
+// newH2CTransport speaks cleartext http/2 to the origin of the host,
+// e.g. grpc
+func newH2CTransport(host string) *http2.Transport {
+	dial := chaosConfig.injector.delayDial(host, (&net.Dialer{
+		Timeout:   time.Second * 30,
+		KeepAlive: time.Second * 30,
+	}).DialContext)
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(context.Background(), network, addr)
+		},
+	}
+}
+
+func getOriginURL(rule tunnelRule, proto string) (url string) {
 	url = fmt.Sprintf("%s.%s:%d", rule.service.name, rule.service.namespace, rule.port)
+	if len(rule.externalName) > 0 {
+		url = fmt.Sprintf("%s:%d", rule.externalName, rule.port)
+	}
+	if len(proto) > 0 {
+		url = proto + "://" + url
+	}
 	return
 }
 
+// originURLProto is the scheme of the origin url, h2c is cleartext http
+// spoken by the origin transport
+func originURLProto(proto string) string {
+	if proto == ProtoH2C {
+		return ProtoHTTP
+	}
+	return proto
+}
+
+// ValidateDefaultProto checks the origin protocol is proxied by the
+// tunnels. The vendored cloudflared origin only proxies http and https,
+// tcp needs a later cloudflared release.
+func ValidateDefaultProto(s string) error {
+	switch s {
+	case "", ProtoHTTP, ProtoHTTPS:
+		return nil
+	case ProtoTCP:
+		return fmt.Errorf("origin protocol %q is not supported, tunnels only proxy %s or %s origins", s, ProtoHTTP, ProtoHTTPS)
+	}
+	return fmt.Errorf("unknown origin protocol %q, expected one of: %s, %s, %s", s, ProtoHTTP, ProtoHTTPS, ProtoTCP)
+}
+
+func parseEdgeAddrs(s string) []string {
+	if len(s) == 0 {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
 func parseTags(s string, n int) []pogs.Tag {
 	if len(s) == 0 || n == 0 {
 		return []pogs.Tag{}
@@ -347,8 +609,115 @@ func launchFunc(l *syncTunnelLink) func() {
 				errCh <- e
 			}
 		}()
-		errCh <- origin.StartTunnelDaemon(cfg, stopCh, make(chan struct{}))
+		if atomic.CompareAndSwapInt32(&l.gated, 1, 0) && !waitForGate(l, readinessGate.timeout, stopCh) {
+			return
+		}
+		if len(l.opts.readinessPath) > 0 && !l.opts.maintenance && l.opts.redirectCode == 0 {
+			l.setReady(false)
+			l.setStatus(ingressCondition{
+				reason:  statusReasonOriginNotReady,
+				message: fmt.Sprintf("host %s: waiting on readiness probe %s", l.rule.host, l.opts.readinessPath),
+			})
+			go probeFunc(l, stopCh)()
+		} else {
+			l.setReady(true)
+		}
+		errCh <- startTunnelDaemon(l.rule.host, cfg, stopCh)
+	}
+}
+
+// startTunnelDaemon runs the origin daemon of the host until stopped, with
+// the chaos faults injected
+func startTunnelDaemon(host string, cfg *origin.TunnelConfig, stopCh <-chan struct{}) error {
+	if err := chaosConfig.injector.failRegistration(host); err != nil {
+		return err
+	}
+	daemonCh, dropped := chaosConfig.injector.dropTunnel(host, stopCh)
+	err := origin.StartTunnelDaemon(cfg, daemonCh, make(chan struct{}))
+	if dropErr := dropped(); dropErr != nil {
+		return dropErr
+	}
+	return err
+}
+
+// probeFunc waits on the origin readiness probe before the link is
+// reported healthy, traffic is proxied meanwhile.
+func probeFunc(l *syncTunnelLink, stopCh <-chan struct{}) func() {
+	return func() {
+		if !waitForOrigin(l.config.OriginUrl, l.opts.readinessPath, readinessProbeInterval, stopCh) {
+			return
+		}
+		l.log.WithFields(logrus.Fields{
+			"origin":   l.config.OriginUrl,
+			"hostname": l.rule.host,
+		}).Infof("origin passed readiness probe %s", l.opts.readinessPath)
+		l.setReady(true)
+		l.setStatus(ingressCondition{})
+	}
+}
+
+// waitForGate holds the first registration of a new host until the
+// initial delay since the ingress creation elapsed, and the origin passed
+// its readiness probe or the gate timed out. False when stopped first.
+func waitForGate(l *syncTunnelLink, timeout time.Duration, stopCh <-chan struct{}) bool {
+	if l.opts.maintenance || l.opts.redirectCode != 0 {
+		return true
+	}
+	l.mu.RLock()
+	delay := time.Until(l.since.Add(l.opts.initialDelay))
+	l.mu.RUnlock()
+	path := l.opts.readinessPath
+	if delay <= 0 && len(path) == 0 {
+		return true
 	}
+
+	l.setReady(false)
+	l.setStatus(ingressCondition{
+		reason:  statusReasonOriginNotReady,
+		message: fmt.Sprintf("host %s: waiting on the origin before the first registration", l.rule.host),
+	})
+	if delay > 0 {
+		select {
+		case <-stopCh:
+			return false
+		case <-time.After(delay):
+		}
+	}
+	if len(path) == 0 || timeout <= 0 {
+		return true
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	gateCh := make(chan struct{})
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	go func() {
+		select {
+		case <-stopCh:
+		case <-timer.C:
+		case <-done:
+			return
+		}
+		close(gateCh)
+	}()
+	if waitForOrigin(l.config.OriginUrl, path, readinessProbeInterval, gateCh) {
+		return true
+	}
+	select {
+	case <-stopCh:
+		return false
+	default:
+	}
+	l.log.WithFields(logrus.Fields{
+		"origin":   l.config.OriginUrl,
+		"hostname": l.rule.host,
+	}).Warnf("origin did not pass readiness probe %s within %s, registering anyway", path, timeout)
+	l.setStatus(ingressCondition{
+		reason:  statusReasonReadinessTimeout,
+		message: fmt.Sprintf("host %s: registered before the origin passed readiness probe %s within %s", l.rule.host, path, timeout),
+	})
+	return true
 }
 
 func repairFunc(l *syncTunnelLink) func() {
@@ -356,7 +725,7 @@ func repairFunc(l *syncTunnelLink) func() {
 	errCh := l.errCh
 	quitCh := l.quitCh
 	return func() {
-		log := logrus.StandardLogger()
+		log := ll.log
 		for {
 			select {
 			case <-quitCh:
@@ -371,13 +740,35 @@ func repairFunc(l *syncTunnelLink) func() {
 							"origin":   ll.config.OriginUrl,
 							"hostname": ll.rule.host,
 						}).Errorf("link exited with error (%s) '%v', repairing ...", reflect.TypeOf(err), err)
+						if atomic.CompareAndSwapInt32(&ll.up, 1, 0) {
+							ll.notifyEvent(webhookEventDisconnected, err.Error())
+						}
+						fails := atomic.AddUint32(&ll.fails, 1)
+						if repairExhausted(fails, repairBackoff.steps, repairBreaker.cycles) {
+							log.WithFields(logrus.Fields{
+								"origin":   ll.config.OriginUrl,
+								"hostname": ll.rule.host,
+							}).Errorf("link repair stopped after %d attempts, retry on next resync", fails)
+							ll.notifyEvent(webhookEventRepairFailed, fmt.Sprintf("repair stopped after %d attempts: %v", fails, err))
+							atomic.StoreInt32(&ll.open, 1)
+							tunnelBreakerOpen.WithLabelValues(ll.rule.host).Set(1)
+							ll.setStatus(ingressCondition{
+								reason:  statusReasonRepairsExhausted,
+								message: fmt.Sprintf("host %s: repair stopped after %d attempts: %v", ll.rule.host, fails, err),
+							})
+							return
+						}
+						ll.setStatus(linkFailure(ll, err))
 
-						// linear back-off on runtime error
-						delay := repairDelay(ll.repiars, repairBackoff.delay, repairBackoff.jitter, repairBackoff.steps)
+						// back-off of the repair strategy on runtime error,
+						// raised to the delay asked by a throttling edge
+						backoff := getRepairStrategy().next(ll.repiars, time.Duration(atomic.LoadInt64(&ll.backoff)))
+						atomic.StoreInt64(&ll.backoff, int64(backoff))
+						delay, source := repairWait(ll.rule.host, err, backoff)
 						log.WithFields(logrus.Fields{
 							"origin":   ll.config.OriginUrl,
 							"hostname": ll.rule.host,
-						}).Infof("link repair starts in %v", delay)
+						}).Infof("link repair starts in %v, delay from %s", delay, source)
 
 						select {
 						case <-quitCh:
@@ -405,6 +796,7 @@ func repairFunc(l *syncTunnelLink) func() {
 						// reset config runtime state during repair.
 						ll.config.IncidentLookup = origin.NewIncidentLookup()
 						ll.config.CloseConnOnce = &sync.Once{}
+						ll.colos.reset()
 						ll.stopCh = make(chan struct{})
 						ll.repiars++
 						go launchFunc(ll)()
@@ -415,13 +807,44 @@ func repairFunc(l *syncTunnelLink) func() {
 	}
 }
 
-func repairDelay(step uint, delay time.Duration, jitter float64, steps uint) time.Duration {
-	d := delay
-	if steps > 0 {
-		d = (1 << (step % steps)) * delay
+// linkFailure classifies a link error into the condition of its host,
+// errors of the clock skew class are checked against the system clock
+func linkFailure(l *syncTunnelLink, err error) ingressCondition {
+	if isClockSkewError(err) {
+		skew, exceeded, checkErr := clockSkew.exceeded()
+		if checkErr != nil {
+			l.log.WithFields(logrus.Fields{
+				"hostname": l.rule.host,
+			}).Warnf("link clock skew check failed: %v", checkErr)
+		} else if exceeded {
+			msg := fmt.Sprintf("system clock skewed by %s; tunnel registration will fail", skew)
+			l.log.WithFields(logrus.Fields{
+				"hostname": l.rule.host,
+			}).Error(msg)
+			return ingressCondition{
+				reason:  statusReasonClockSkewed,
+				message: fmt.Sprintf("host %s: %s", l.rule.host, msg),
+			}
+		}
 	}
-	if jitter > 0 {
-		d += time.Duration(rand.Float64() * jitter * float64(delay))
+	return ingressCondition{
+		reason:  statusReasonTunnelFailed,
+		message: fmt.Sprintf("host %s: %v", l.rule.host, err),
 	}
-	return d
+}
+
+// repairExhausted reports whether consecutive failures used up the
+// repair cycles, 0 cycles never exhausts
+func repairExhausted(fails uint32, steps, cycles uint) bool {
+	if cycles == 0 {
+		return false
+	}
+	if steps == 0 {
+		steps = 1
+	}
+	return uint(fails) >= steps*cycles
+}
+
+func repairDelay(step uint, delay time.Duration, jitter float64, steps uint) time.Duration {
+	return newRepairStrategy(RepairStrategyExponential, delay, jitter, steps, rand.Float64).next(step, 0)
 }
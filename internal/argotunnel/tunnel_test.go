@@ -9,11 +9,15 @@ import (
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/cloudflare/cloudflared/origin"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -166,11 +170,111 @@ func TestTunnelLinkEquals(t *testing.T) {
 	}
 }
 
+func TestTunnelLinkReadiness(t *testing.T) {
+	t.Parallel()
+	reports := []ingressCondition{}
+	l := newTunnelLink(tunnelRule{host: "ready.unit.com"}, nil, tunnelOptions{}, nil, nil, func(host string, cond ingressCondition) {
+		reports = append(reports, cond)
+	}, nil, logrus.StandardLogger()).(*syncTunnelLink)
+
+	l.setReady(false)
+	l.connected()
+	assert.Equalf(t, []ingressCondition{}, reports, "test not ready reports mismatch")
+	assert.Equalf(t, float64(0), testutil.ToFloat64(tunnelOriginReady.WithLabelValues("ready.unit.com")), "test not ready gauge mismatch")
+
+	l.setReady(true)
+	l.connected()
+	assert.Equalf(t, []ingressCondition{{}}, reports, "test ready reports mismatch")
+	assert.Equalf(t, float64(1), testutil.ToFloat64(tunnelOriginReady.WithLabelValues("ready.unit.com")), "test ready gauge mismatch")
+}
+
+func TestWaitForGate(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	stopped := make(chan struct{})
+	close(stopped)
+
+	waiting := ingressCondition{reason: statusReasonOriginNotReady, message: "host gate.unit.com: waiting on the origin before the first registration"}
+	for name, test := range map[string]struct {
+		opts    tunnelOptions
+		since   time.Duration
+		origin  string
+		stopCh  chan struct{}
+		out     bool
+		reports []ingressCondition
+	}{
+		"gate-none": {
+			out:     true,
+			reports: []ingressCondition{},
+		},
+		"gate-delay-elapsed": {
+			opts:    tunnelOptions{initialDelay: time.Minute},
+			since:   -time.Hour,
+			out:     true,
+			reports: []ingressCondition{},
+		},
+		"gate-delay": {
+			opts:    tunnelOptions{initialDelay: 10 * time.Millisecond},
+			out:     true,
+			reports: []ingressCondition{waiting},
+		},
+		"gate-delay-stopped": {
+			opts:    tunnelOptions{initialDelay: time.Minute},
+			stopCh:  stopped,
+			out:     false,
+			reports: []ingressCondition{waiting},
+		},
+		"gate-maintenance": {
+			opts:    tunnelOptions{initialDelay: time.Minute, readinessPath: "/", maintenance: true},
+			out:     true,
+			reports: []ingressCondition{},
+		},
+		"gate-probe-ready": {
+			opts:    tunnelOptions{readinessPath: "/"},
+			origin:  srv.URL,
+			out:     true,
+			reports: []ingressCondition{waiting},
+		},
+		"gate-probe-timeout": {
+			opts:   tunnelOptions{readinessPath: ReadinessProbeTCP},
+			origin: "http://127.0.0.1:1",
+			out:    true,
+			reports: []ingressCondition{waiting, {
+				reason:  statusReasonReadinessTimeout,
+				message: "host gate.unit.com: registered before the origin passed readiness probe tcp within 20ms",
+			}},
+		},
+		"gate-probe-stopped": {
+			opts:    tunnelOptions{readinessPath: ReadinessProbeTCP},
+			origin:  "http://127.0.0.1:1",
+			stopCh:  stopped,
+			out:     false,
+			reports: []ingressCondition{waiting},
+		},
+	} {
+		reports := []ingressCondition{}
+		l := newTunnelLink(tunnelRule{host: "gate.unit.com"}, nil, test.opts, nil, nil, func(host string, cond ingressCondition) {
+			reports = append(reports, cond)
+		}, nil, logrus.StandardLogger()).(*syncTunnelLink)
+		l.config.OriginUrl = test.origin
+		gateFirstStart(l, time.Now().Add(test.since))
+		stopCh := test.stopCh
+		if stopCh == nil {
+			stopCh = make(chan struct{})
+		}
+		out := waitForGate(l, 20*time.Millisecond, stopCh)
+		assert.Equalf(t, test.out, out, "test '%s' registration mismatch", name)
+		assert.Equalf(t, test.reports, reports, "test '%s' reports mismatch", name)
+	}
+}
+
 func TestGetOriginUrl(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
-		rule tunnelRule
-		url  string
+		rule  tunnelRule
+		proto string
+		url   string
 	}{
 		"empty-rule": {
 			rule: tunnelRule{},
@@ -204,12 +308,77 @@ func TestGetOriginUrl(t *testing.T) {
 			},
 			url: "unit-n.unit-ns:8080",
 		},
+		"okay-proto-http": {
+			rule: tunnelRule{
+				service: resource{
+					namespace: "unit-ns",
+					name:      "unit-n",
+				},
+				port: 8080,
+			},
+			proto: ProtoHTTP,
+			url:   "http://unit-n.unit-ns:8080",
+		},
+		"okay-proto-https": {
+			rule: tunnelRule{
+				service: resource{
+					namespace: "unit-ns",
+					name:      "unit-n",
+				},
+				port: 8443,
+			},
+			proto: ProtoHTTPS,
+			url:   "https://unit-n.unit-ns:8443",
+		},
+		"okay-external-name": {
+			rule: tunnelRule{
+				service: resource{
+					namespace: "unit-ns",
+					name:      "unit-n",
+				},
+				port:         443,
+				externalName: "api.saas.com",
+			},
+			proto: ProtoHTTPS,
+			url:   "https://api.saas.com:443",
+		},
 	} {
-		url := getOriginURL(test.rule)
+		url := getOriginURL(test.rule, test.proto)
 		assert.Equalf(t, test.url, url, "test '%s' url mismatch", name)
 	}
 }
 
+func TestValidateDefaultProto(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  string
+		err string
+	}{
+		"empty": {},
+		"http": {
+			in: ProtoHTTP,
+		},
+		"https": {
+			in: ProtoHTTPS,
+		},
+		"tcp-unsupported": {
+			in:  ProtoTCP,
+			err: `origin protocol "tcp" is not supported, tunnels only proxy http or https origins`,
+		},
+		"unknown": {
+			in:  "udp",
+			err: `unknown origin protocol "udp", expected one of: http, https, tcp`,
+		},
+	} {
+		err := ValidateDefaultProto(test.in)
+		if len(test.err) == 0 {
+			assert.Nilf(t, err, "test '%s' error mismatch", name)
+		} else if assert.NotNilf(t, err, "test '%s' error mismatch", name) {
+			assert.Equalf(t, test.err, err.Error(), "test '%s' error mismatch", name)
+		}
+	}
+}
+
 func TestVerifyCertForHost(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
@@ -246,6 +415,30 @@ func TestVerifyCertForHost(t *testing.T) {
 	}
 }
 
+func TestParseEdgeAddrs(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in  string
+		out []string
+	}{
+		"empty": {
+			in:  "",
+			out: []string{},
+		},
+		"one-addr": {
+			in:  "edge.test.com:7844",
+			out: []string{"edge.test.com:7844"},
+		},
+		"many-addrs": {
+			in:  "edge-a.test.com:7844,198.41.192.7:7844",
+			out: []string{"edge-a.test.com:7844", "198.41.192.7:7844"},
+		},
+	} {
+		out := parseEdgeAddrs(test.in)
+		assert.Equalf(t, test.out, out, "test '%s' value mismatch", name)
+	}
+}
+
 func TestParseTags(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
@@ -403,6 +596,44 @@ func TestRepairDelay(t *testing.T) {
 	}
 }
 
+func TestRepairExhausted(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		fails  uint32
+		steps  uint
+		cycles uint
+		out    bool
+	}{
+		"cycles-disabled": {
+			fails:  1000,
+			steps:  4,
+			cycles: 0,
+			out:    false,
+		},
+		"cycles-remaining": {
+			fails:  7,
+			steps:  4,
+			cycles: 2,
+			out:    false,
+		},
+		"cycles-exhausted": {
+			fails:  8,
+			steps:  4,
+			cycles: 2,
+			out:    true,
+		},
+		"steps-disabled": {
+			fails:  3,
+			steps:  0,
+			cycles: 3,
+			out:    true,
+		},
+	} {
+		out := repairExhausted(test.fails, test.steps, test.cycles)
+		assert.Equalf(t, test.out, out, "test '%s' exhausted mismatch", name)
+	}
+}
+
 func TestSetTagLimit(t *testing.T) {
 	tagLimit := tagConfig.limit
 	limits := []int{
@@ -420,7 +651,27 @@ func TestSetTagLimit(t *testing.T) {
 	assert.NotEqualf(t, tagConfig.limit, tagLimit, "test repair delay does not match default")
 }
 
+func TestSetRepairBreaker(t *testing.T) {
+	cycles := []uint{
+		3,
+		2,
+		1,
+	}
+
+	for _, n := range cycles {
+		SetRepairBreaker(n)
+	}
+
+	assert.Equalf(t, cycles[0], repairBreaker.cycles, "test repair cycles matches first set")
+	assert.NotEqualf(t, uint(RepairCyclesDefault), repairBreaker.cycles, "test repair cycles does not match default")
+}
+
 func genCertforHost(host string) (cert []byte) {
+	cert, _ = genKeyPairforHost(host)
+	return
+}
+
+func genKeyPairforHost(host string) (cert, key []byte) {
 	template := x509.Certificate{
 		SerialNumber: func() (n *big.Int) {
 			list := new(big.Int).Lsh(big.NewInt(1), 128)
@@ -449,11 +700,16 @@ func genCertforHost(host string) (cert []byte) {
 	pub = &priv.(*ecdsa.PrivateKey).PublicKey
 
 	rawBytes, _ := x509.CreateCertificate(rand.Reader, &template, &template, pub, priv)
-	pemBytes := pem.EncodeToMemory(&pem.Block{
+	cert = pem.EncodeToMemory(&pem.Block{
 		Type:  "CERTIFICATE",
 		Bytes: rawBytes,
 	})
-	return pemBytes
+	keyBytes, _ := x509.MarshalECPrivateKey(priv.(*ecdsa.PrivateKey))
+	key = pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyBytes,
+	})
+	return
 }
 
 type mockTunnelLink struct {
@@ -480,6 +736,14 @@ func (l *mockTunnelLink) options() tunnelOptions {
 	args := l.Called()
 	return args.Get(0).(tunnelOptions)
 }
+func (l *mockTunnelLink) connections() []tunnelConnection {
+	args := l.Called()
+	return args.Get(0).([]tunnelConnection)
+}
+func (l *mockTunnelLink) tripped() bool {
+	args := l.Called()
+	return args.Bool(0)
+}
 func (l *mockTunnelLink) equal(obj tunnelLink) bool {
 	args := l.Called(obj)
 	return args.Get(0).(bool)
@@ -488,6 +752,10 @@ func (l *mockTunnelLink) start() error {
 	args := l.Called()
 	return args.Error(0)
 }
+func (l *mockTunnelLink) retire(successor tunnelLink) error {
+	args := l.Called(successor)
+	return args.Error(0)
+}
 func (l *mockTunnelLink) stop() error {
 	args := l.Called()
 	return args.Error(0)
@@ -0,0 +1,127 @@
+package argotunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	webhookEventConnected    = "connected"
+	webhookEventDisconnected = "disconnected"
+	webhookEventRepairFailed = "repair-failed"
+
+	webhookResultSent    = "sent"
+	webhookResultFailed  = "failed"
+	webhookResultDropped = "dropped"
+
+	// webhookQueueSize bounds the events waiting on the webhook, an event
+	// past it is dropped rather than blocking the tunnel
+	webhookQueueSize = 256
+	// webhookAttempts bounds the posts of an event
+	webhookAttempts   = 3
+	webhookRetryDelay = time.Second
+	webhookTimeout    = 5 * time.Second
+)
+
+// webhookEvent is the payload posted on a tunnel event
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	Hostname  string    `json:"hostname"`
+	Namespace string    `json:"namespace"`
+	Ingress   string    `json:"ingress"`
+	Message   string    `json:"message,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// linkEventFunc receives the connection events of a tunnel
+type linkEventFunc func(host, event, message string)
+
+// eventWebhook posts the tunnel events to a url. Events are queued, a
+// full queue drops them, and posted in order by a single sender.
+type eventWebhook struct {
+	url        string
+	client     *http.Client
+	queue      chan webhookEvent
+	retryDelay time.Duration
+	log        *logrus.Logger
+}
+
+// newEventWebhook returns the webhook of the url, nil without a url
+func newEventWebhook(url string, log *logrus.Logger) *eventWebhook {
+	if len(url) == 0 {
+		return nil
+	}
+	return &eventWebhook{
+		url:        url,
+		client:     &http.Client{Timeout: webhookTimeout},
+		queue:      make(chan webhookEvent, webhookQueueSize),
+		retryDelay: webhookRetryDelay,
+		log:        log,
+	}
+}
+
+// send queues the event without waiting on the webhook
+func (w *eventWebhook) send(e webhookEvent) {
+	select {
+	case w.queue <- e:
+	default:
+		webhookEvents.WithLabelValues(e.Event, webhookResultDropped).Inc()
+		w.log.Warnf("event webhook queue full, dropped event: %s, host: %s", e.Event, e.Hostname)
+	}
+}
+
+func (w *eventWebhook) run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case e := <-w.queue:
+			w.deliver(e, stopCh)
+		}
+	}
+}
+
+// deliver posts the event, retrying a failed post up to the attempts
+func (w *eventWebhook) deliver(e webhookEvent, stopCh <-chan struct{}) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		w.log.Errorf("event webhook cannot encode event: %s, host: %s, err: %v", e.Event, e.Hostname, err)
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		if err = w.post(body); err == nil {
+			webhookEvents.WithLabelValues(e.Event, webhookResultSent).Inc()
+			return
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(time.Duration(attempt) * w.retryDelay):
+		}
+	}
+	webhookEvents.WithLabelValues(e.Event, webhookResultFailed).Inc()
+	w.log.Errorf("event webhook failed after %d attempts, event: %s, host: %s, err: %v", webhookAttempts, e.Event, e.Hostname, err)
+}
+
+func (w *eventWebhook) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
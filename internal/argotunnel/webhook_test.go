@@ -0,0 +1,133 @@
+package argotunnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEventWebhookDeliver(t *testing.T) {
+	for name, test := range map[string]struct {
+		codes  []int
+		event  string
+		posts  int
+		sent   float64
+		failed float64
+	}{
+		"sent": {
+			codes: []int{http.StatusOK},
+			event: webhookEventConnected,
+			posts: 1,
+			sent:  1,
+		},
+		"sent-after-retry": {
+			codes: []int{http.StatusInternalServerError, http.StatusNoContent},
+			event: webhookEventDisconnected,
+			posts: 2,
+			sent:  1,
+		},
+		"failed-after-attempts": {
+			codes:  []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable},
+			event:  webhookEventRepairFailed,
+			posts:  webhookAttempts,
+			failed: 1,
+		},
+	} {
+		var mu sync.Mutex
+		var posts []webhookEvent
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			var e webhookEvent
+			json.NewDecoder(r.Body).Decode(&e)
+			assert.Equalf(t, "application/json", r.Header.Get("Content-Type"), "test '%s' content type mismatch", name)
+			w.WriteHeader(test.codes[len(posts)])
+			posts = append(posts, e)
+		}))
+
+		logger, _ := logtest.NewNullLogger()
+		w := newEventWebhook(srv.URL, logger)
+		w.retryDelay = time.Millisecond
+		e := webhookEvent{
+			Event:     test.event,
+			Hostname:  "a.unit.com",
+			Namespace: "unit",
+			Ingress:   "ing-a",
+			Timestamp: time.Date(2024, 1, 1, 14, 32, 0, 0, time.UTC),
+		}
+		w.deliver(e, make(chan struct{}))
+		srv.Close()
+
+		assert.Equalf(t, test.posts, len(posts), "test '%s' posts mismatch", name)
+		for _, post := range posts {
+			assert.Equalf(t, e, post, "test '%s' payload mismatch", name)
+		}
+		assert.Equalf(t, test.sent, testutil.ToFloat64(webhookEvents.WithLabelValues(test.event, webhookResultSent)), "test '%s' sent mismatch", name)
+		assert.Equalf(t, test.failed, testutil.ToFloat64(webhookEvents.WithLabelValues(test.event, webhookResultFailed)), "test '%s' failed mismatch", name)
+		webhookEvents.DeleteLabelValues(test.event, webhookResultSent)
+		webhookEvents.DeleteLabelValues(test.event, webhookResultFailed)
+	}
+}
+
+func TestEventWebhookSendDropped(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	w := &eventWebhook{
+		queue: make(chan webhookEvent, 1),
+		log:   logger,
+	}
+	w.send(webhookEvent{Event: webhookEventConnected, Hostname: "a.unit.com"})
+	w.send(webhookEvent{Event: webhookEventConnected, Hostname: "b.unit.com"})
+	assert.Equalf(t, 1, len(w.queue), "test queue length mismatch")
+	assert.Equalf(t, "a.unit.com", (<-w.queue).Hostname, "test queued event mismatch")
+	assert.Equalf(t, float64(1), testutil.ToFloat64(webhookEvents.WithLabelValues(webhookEventConnected, webhookResultDropped)), "test dropped mismatch")
+	assert.Equalf(t, 1, len(hook.Entries), "test dropped log mismatch")
+	webhookEvents.DeleteLabelValues(webhookEventConnected, webhookResultDropped)
+}
+
+func TestNewEventWebhook(t *testing.T) {
+	t.Parallel()
+	logger, _ := logtest.NewNullLogger()
+	assert.Nilf(t, newEventWebhook("", logger), "test 'no-url' webhook mismatch")
+	assert.NotNilf(t, newEventWebhook("http://127.0.0.1:1/hook", logger), "test 'url' webhook mismatch")
+
+	tr := &syncTranslator{log: logger}
+	ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: "unit", Name: "ing-a"}}
+	assert.Nilf(t, tr.linkEventNotifier(ing), "test 'no-webhook' notifier mismatch")
+
+	tr.webhook = newEventWebhook("http://127.0.0.1:1/hook", logger)
+	tr.linkEventNotifier(ing)("a.unit.com", webhookEventConnected, "")
+	e := <-tr.webhook.queue
+	assert.Equalf(t, webhookEventConnected, e.Event, "test 'webhook' event mismatch")
+	assert.Equalf(t, "a.unit.com", e.Hostname, "test 'webhook' hostname mismatch")
+	assert.Equalf(t, "unit", e.Namespace, "test 'webhook' namespace mismatch")
+	assert.Equalf(t, "ing-a", e.Ingress, "test 'webhook' ingress mismatch")
+	assert.Falsef(t, e.Timestamp.IsZero(), "test 'webhook' timestamp mismatch")
+}
+
+func TestLinkConnectedEvent(t *testing.T) {
+	t.Parallel()
+	var events []string
+	l := newTunnelLink(tunnelRule{host: "event.unit.com"}, nil, tunnelOptions{}, nil, nil, nil, func(host, event, message string) {
+		events = append(events, host+" "+event)
+	}, logrus.StandardLogger()).(*syncTunnelLink)
+
+	l.connected()
+	l.connected()
+	assert.Equalf(t, []string{"event.unit.com connected"}, events, "test connected events mismatch")
+
+	// a link connecting again after a failure notifies again
+	atomic.StoreInt32(&l.up, 0)
+	l.connected()
+	assert.Equalf(t, []string{"event.unit.com connected", "event.unit.com connected"}, events, "test reconnected events mismatch")
+}
@@ -1,6 +1,8 @@
 package argotunnel
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,11 +11,22 @@ import (
 	"k8s.io/client-go/util/workqueue"
 )
 
+// requeueError asks for the key to be processed again after a delay,
+// the key has not failed and keeps its requeue budget
+type requeueError struct {
+	after time.Duration
+}
+
+func (e *requeueError) Error() string {
+	return fmt.Sprintf("requeue after %s", e.after)
+}
+
 type worker struct {
 	queue      workqueue.RateLimitingInterface
 	translator translator
 	log        *logrus.Logger
 	options    options
+	release    func()
 }
 
 func (w *worker) run(stopCh <-chan struct{}) error {
@@ -24,6 +37,11 @@ func (w *worker) run(stopCh <-chan struct{}) error {
 	if !w.translator.waitForCacheSync(stopCh) {
 		return fmt.Errorf("timed out waiting for informer caches to sync")
 	}
+	if w.release != nil {
+		// the initial sync is queued once every ingress is known, so
+		// the higher priorities are reconciled first
+		w.release()
+	}
 	w.log.Debugf("spawning argo-tunnel workers...")
 	// TODO: convert to semaphore pattern
 	for i := 0; i < w.options.workers; i++ {
@@ -46,8 +64,27 @@ func (w *worker) processNextItem() bool {
 	}
 	defer w.queue.Done(key)
 
-	if err := w.sync(key.(string)); err == nil {
+	// a reconcile past the timeout is canceled, it holds its worker until
+	// it returns so the reconciles never exceed the workers
+	ctx := context.Background()
+	if w.options.reconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.options.reconcileTimeout)
+		defer cancel()
+	}
+	err := w.sync(ctx, key.(string))
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		kind, _, _ := splitKindMetaKey(key.(string))
+		reconcileTimeouts.WithLabelValues(kind).Inc()
+		w.log.Errorf("worker reconcile timed out after %s, key: %s", w.options.reconcileTimeout, key)
+	}
+
+	var requeue *requeueError
+	if err == nil {
+		w.queue.Forget(key)
+	} else if errors.As(err, &requeue) {
 		w.queue.Forget(key)
+		w.queue.AddAfter(key, requeue.after)
 	} else if w.queue.NumRequeues(key) < w.options.requeueLimit {
 		w.queue.AddRateLimited(key)
 	} else {
@@ -56,10 +93,10 @@ func (w *worker) processNextItem() bool {
 	return true
 }
 
-func (w *worker) sync(key string) error {
+func (w *worker) sync(ctx context.Context, key string) error {
 	kind, metakey, err := splitKindMetaKey(key)
 	if err != nil {
 		return err
 	}
-	return w.translator.handleResource(kind, metakey)
+	return w.translator.handleResource(ctx, kind, metakey)
 }
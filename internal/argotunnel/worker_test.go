@@ -1,13 +1,17 @@
 package argotunnel
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"k8s.io/client-go/util/workqueue"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestSync(t *testing.T) {
@@ -29,7 +33,7 @@ func TestSync(t *testing.T) {
 			w: worker{
 				translator: func() translator {
 					t := &mockTranslator{}
-					t.On("handleResource", "kind", "namespace/name").Return(nil)
+					t.On("handleResource", mock.Anything, "kind", "namespace/name").Return(nil)
 					return t
 				}(),
 				queue: &mockQueue{},
@@ -41,7 +45,7 @@ func TestSync(t *testing.T) {
 		logger, hook := logtest.NewNullLogger()
 		test.w.log = logger
 
-		err := test.w.sync(test.key)
+		err := test.w.sync(context.Background(), test.key)
 		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
 		hook.Reset()
 		assert.Nil(t, hook.LastEntry())
@@ -100,11 +104,32 @@ func TestProcessNextItem(t *testing.T) {
 			},
 			out: true,
 		},
+		"process-sync-requeue-after": {
+			w: worker{
+				translator: func() translator {
+					t := &mockTranslator{}
+					t.On("handleResource", mock.Anything, "kind", "namespace/name").Return(&requeueError{after: time.Minute})
+					return t
+				}(),
+				queue: func() workqueue.RateLimitingInterface {
+					q := &mockQueue{}
+					q.On("Get").Return("kind/namespace/name", false)
+					q.On("Done", "kind/namespace/name").Return()
+					q.On("Forget", "kind/namespace/name").Return()
+					q.On("AddAfter", "kind/namespace/name", time.Minute).Return()
+					return q
+				}(),
+				options: options{
+					requeueLimit: 2,
+				},
+			},
+			out: true,
+		},
 		"process-sync-okay": {
 			w: worker{
 				translator: func() translator {
 					t := &mockTranslator{}
-					t.On("handleResource", "kind", "namespace/name").Return(nil)
+					t.On("handleResource", mock.Anything, "kind", "namespace/name").Return(nil)
 					return t
 				}(),
 				queue: func() workqueue.RateLimitingInterface {
@@ -130,3 +155,75 @@ func TestProcessNextItem(t *testing.T) {
 		assert.Nil(t, hook.LastEntry())
 	}
 }
+
+func TestProcessNextItemTimeout(t *testing.T) {
+	for name, test := range map[string]struct {
+		hang     time.Duration
+		requeues int
+		timedOut bool
+		requeued bool
+	}{
+		"reconcile-within-timeout": {
+			hang: 0,
+		},
+		"reconcile-past-timeout": {
+			hang:     time.Minute,
+			timedOut: true,
+			requeued: true,
+		},
+		"reconcile-past-timeout-requeue-limit": {
+			hang:     time.Minute,
+			requeues: 2,
+			timedOut: true,
+		},
+	} {
+		key := "timeout" + name + "/namespace/name"
+		kind, metakey, _ := splitKindMetaKey(key)
+		tr := &mockTranslator{}
+		tr.On("handleResource", mock.Anything, kind, metakey).Return(func(ctx context.Context, kind, key string) error {
+			select {
+			case <-time.After(test.hang):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		q := &mockQueue{}
+		q.On("Get").Return(key, false)
+		q.On("Done", key).Return()
+		q.On("Forget", key).Return()
+		q.On("NumRequeues", key).Return(test.requeues)
+		q.On("AddRateLimited", key).Return()
+		logger, _ := logtest.NewNullLogger()
+		w := worker{
+			translator: tr,
+			queue:      q,
+			log:        logger,
+			options: options{
+				reconcileTimeout: 10 * time.Millisecond,
+				requeueLimit:     2,
+			},
+		}
+
+		start := time.Now()
+		out := w.processNextItem()
+		held := time.Since(start)
+		assert.Truef(t, out, "test '%s' condition mismatch", name)
+		// the canceled reconcile returns, the worker is not held past it
+		assert.Lessf(t, int64(held), int64(time.Second), "test '%s' worker held mismatch", name)
+		q.AssertCalled(t, "Done", key)
+		if test.requeued {
+			q.AssertCalled(t, "AddRateLimited", key)
+			q.AssertNotCalled(t, "Forget", key)
+		} else {
+			q.AssertCalled(t, "Forget", key)
+			q.AssertNotCalled(t, "AddRateLimited", key)
+		}
+		timeouts := float64(0)
+		if test.timedOut {
+			timeouts = 1
+		}
+		assert.Equalf(t, timeouts, testutil.ToFloat64(reconcileTimeouts.WithLabelValues(kind)), "test '%s' timeouts mismatch", name)
+		reconcileTimeouts.DeleteLabelValues(kind)
+	}
+}
@@ -1,7 +1,13 @@
 package cloudflare
 
 import (
+	"bytes"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
 )
 
 // TODO: remove the Origin CA root certs when migrated to Authenticated Origin Pull certs
@@ -94,3 +100,74 @@ func GetCloudflareRootCA() *x509.CertPool {
 		return ca
 	}()
 }
+
+const (
+	pemTypeCertificate = "CERTIFICATE"
+	pemTypeToken       = "ARGO TUNNEL TOKEN"
+)
+
+// maxOriginCertEncodings bounds the base64 layers removed from an origin
+// certificate
+const maxOriginCertEncodings = 2
+
+// DecodeOriginCert returns the PEM of an origin certificate read from a
+// secret, and the base64 layers removed to reach it. A PEM pasted already
+// encoded under 'data' rather than 'stringData' is left base64 encoded
+// once the api decodes the secret. A DER certificate is refused with the
+// conversion to PEM.
+func DecodeOriginCert(val []byte) (cert []byte, encodings int, err error) {
+	for cert = bytes.TrimSpace(val); ; encodings++ {
+		if block, _ := pem.Decode(cert); block != nil {
+			if encodings == 0 {
+				cert = val
+			}
+			return cert, encodings, nil
+		}
+		if _, derErr := x509.ParseCertificate(cert); derErr == nil {
+			return nil, encodings, fmt.Errorf("origin certificate is DER encoded, convert it to PEM, e.g. 'openssl x509 -inform der -outform pem'")
+		}
+		if encodings == maxOriginCertEncodings {
+			break
+		}
+		decoded, decodeErr := base64.StdEncoding.DecodeString(string(cert))
+		if decodeErr != nil {
+			break
+		}
+		cert = bytes.TrimSpace(decoded)
+	}
+	return nil, encodings, fmt.Errorf("origin certificate is not PEM encoded, expected '-----BEGIN' blocks")
+}
+
+// AssembleOriginCert builds the origin certificate PEM expected by
+// cloudflared, the private key, the certificate chain, and the optional
+// tunnel token, from a tls certificate and key. A token that is not PEM
+// encoded is wrapped in an 'ARGO TUNNEL TOKEN' block.
+func AssembleOriginCert(cert, key, token []byte) ([]byte, error) {
+	if _, err := tls.X509KeyPair(cert, key); err != nil {
+		return nil, fmt.Errorf("certificate and key are not a valid origin certificate: %v", err)
+	}
+
+	var buf bytes.Buffer
+	for _, src := range [][]byte{key, cert} {
+		for rest := src; ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			if block.Type == pemTypeCertificate || strings.HasSuffix(block.Type, "PRIVATE KEY") {
+				pem.Encode(&buf, block)
+			}
+		}
+	}
+
+	if token = bytes.TrimSpace(token); len(token) > 0 {
+		block, _ := pem.Decode(token)
+		if block == nil {
+			block = &pem.Block{Type: pemTypeToken, Bytes: token}
+		} else if block.Type != pemTypeToken {
+			return nil, fmt.Errorf("tunnel token has unexpected pem type %q", block.Type)
+		}
+		pem.Encode(&buf, block)
+	}
+	return buf.Bytes(), nil
+}
@@ -1,9 +1,19 @@
 package cloudflare
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 	"testing"
+	"time"
 
-	"crypto/x509"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,3 +34,126 @@ func TestGetCloudflareRootCA(t *testing.T) {
 		assert.Equalf(t, test.out, out, "test '%s' options mismatch", name)
 	}
 }
+
+func TestDecodeOriginCert(t *testing.T) {
+	t.Parallel()
+	cert, _ := genKeyPair()
+	block, _ := pem.Decode(cert)
+	der := block.Bytes
+	encode := func(b []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(b))
+	}
+	for name, test := range map[string]struct {
+		in        []byte
+		out       []byte
+		encodings int
+		err       error
+	}{
+		"raw-pem": {
+			in:  cert,
+			out: cert,
+		},
+		"single-encoded": {
+			in:        encode(cert),
+			out:       bytes.TrimSpace(cert),
+			encodings: 1,
+		},
+		"double-encoded": {
+			in:        encode(encode(cert)),
+			out:       bytes.TrimSpace(cert),
+			encodings: 2,
+		},
+		"triple-encoded": {
+			in:        encode(encode(encode(cert))),
+			encodings: 2,
+			err:       fmt.Errorf("origin certificate is not PEM encoded, expected '-----BEGIN' blocks"),
+		},
+		"der": {
+			in:  der,
+			err: fmt.Errorf("origin certificate is DER encoded, convert it to PEM, e.g. 'openssl x509 -inform der -outform pem'"),
+		},
+		"encoded-der": {
+			in:        encode(der),
+			encodings: 1,
+			err:       fmt.Errorf("origin certificate is DER encoded, convert it to PEM, e.g. 'openssl x509 -inform der -outform pem'"),
+		},
+		"not-pem": {
+			in:  []byte("not a certificate"),
+			err: fmt.Errorf("origin certificate is not PEM encoded, expected '-----BEGIN' blocks"),
+		},
+	} {
+		out, encodings, err := DecodeOriginCert(test.in)
+		assert.Equalf(t, test.out, out, "test '%s' cert mismatch", name)
+		assert.Equalf(t, test.encodings, encodings, "test '%s' encodings mismatch", name)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+	}
+}
+
+func TestAssembleOriginCert(t *testing.T) {
+	t.Parallel()
+	cert, key := genKeyPair()
+	_, otherKey := genKeyPair()
+	token := pem.EncodeToMemory(&pem.Block{Type: "ARGO TUNNEL TOKEN", Bytes: []byte("unit-token")})
+	for name, test := range map[string]struct {
+		cert  []byte
+		key   []byte
+		token []byte
+		out   []byte
+		err   error
+	}{
+		"no-token": {
+			cert: cert,
+			key:  key,
+			out:  append(append([]byte{}, key...), cert...),
+		},
+		"pem-token": {
+			cert:  cert,
+			key:   key,
+			token: token,
+			out:   append(append(append([]byte{}, key...), cert...), token...),
+		},
+		"raw-token": {
+			cert:  cert,
+			key:   key,
+			token: []byte("unit-token\n"),
+			out:   append(append(append([]byte{}, key...), cert...), token...),
+		},
+		"unexpected-token": {
+			cert:  cert,
+			key:   key,
+			token: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("unit-token")}),
+			err:   fmt.Errorf("tunnel token has unexpected pem type %q", "CERTIFICATE"),
+		},
+		"key-mismatch": {
+			cert: cert,
+			key:  otherKey,
+			err:  fmt.Errorf("certificate and key are not a valid origin certificate: %v", "tls: private key does not match public key"),
+		},
+		"no-cert": {
+			cert: []byte("unit-cert"),
+			key:  key,
+			err:  fmt.Errorf("certificate and key are not a valid origin certificate: %v", "tls: failed to find any PEM data in certificate input"),
+		},
+	} {
+		out, err := AssembleOriginCert(test.cert, test.key, test.token)
+		assert.Equalf(t, test.out, out, "test '%s' value mismatch", name)
+		assert.Equalf(t, test.err, err, "test '%s' error mismatch", name)
+	}
+}
+
+func genKeyPair() (cert, key []byte) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"Unit Co"},
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(12 * time.Hour),
+	}
+	raw, _ := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw})
+	raw, _ = x509.MarshalECPrivateKey(priv)
+	key = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: raw})
+	return
+}
@@ -107,6 +107,27 @@ type OriginSecret struct {
 	Namespace string `yaml:"namespace"`
 }
 
+// UnmarshalYAML reads a secret as a mapping or as a <namespace>/<name>
+// reference
+func (os *OriginSecret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ref string
+	if err := unmarshal(&ref); err == nil {
+		parts := strings.Split(ref, "/")
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("secret %q must be a <namespace>/<name> reference", ref)
+		}
+		os.Namespace, os.Name = parts[0], parts[1]
+		return nil
+	}
+	type plain OriginSecret
+	return unmarshal((*plain)(os))
+}
+
+// String returns the <namespace>/<name> reference of the secret
+func (os OriginSecret) String() string {
+	return os.Namespace + "/" + os.Name
+}
+
 // Validate the OriginSecret content
 func (os *OriginSecret) Validate() []error {
 	var errs []error
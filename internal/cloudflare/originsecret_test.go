@@ -210,6 +210,50 @@ func TestParse(t *testing.T) {
 			},
 			err: nil,
 		},
+		"obj-parse-reference": {
+			in: `groups:
+- hosts:
+  - abc.test.com
+  secret: test-a/secret-a
+- hosts:
+  - xyz.test.com
+  secret:
+    name: secret-b
+    namespace: test-b
+`,
+			out: &OriginSecrets{
+				Groups: []OriginSecretGroup{
+					{
+						Hosts: []string{
+							"abc.test.com",
+						},
+						Secret: OriginSecret{
+							Name:      "secret-a",
+							Namespace: "test-a",
+						},
+					},
+					{
+						Hosts: []string{
+							"xyz.test.com",
+						},
+						Secret: OriginSecret{
+							Name:      "secret-b",
+							Namespace: "test-b",
+						},
+					},
+				},
+			},
+			err: nil,
+		},
+		"obj-parse-reference-invalid": {
+			in: `groups:
+- hosts:
+  - abc.test.com
+  secret: secret-a
+`,
+			out: nil,
+			err: fmt.Errorf(`secret "secret-a" must be a <namespace>/<name> reference`),
+		},
 	} {
 		out, err := ParseOriginSecrets([]byte(test.in))
 		if err != nil {
@@ -1,6 +1,10 @@
 package k8s
 
 import (
+	"net"
+	"net/http"
+	"strconv"
+
 	"k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -9,20 +13,72 @@ import (
 const (
 	// CertPem is the string constant used to locate a secrets cert
 	CertPem = "cert.pem"
+	// TLSCert is the string constant used to locate a tls secrets certificate
+	TLSCert = v1.TLSCertKey
+	// TLSKey is the string constant used to locate a tls secrets private key
+	TLSKey = v1.TLSPrivateKeyKey
+	// ArgoToken is the string constant used to locate a tls secrets tunnel token
+	ArgoToken = "argo-token"
+	// ConfigMapBody is the string constant used to locate a configmaps response body
+	ConfigMapBody = "body.html"
+	// ConfigMapStatus is the string constant used to locate a configmaps response status
+	ConfigMapStatus = "status"
 )
 
-// HasEndpointsAddresses verifies addresses are available
-func HasEndpointsAddresses(ep *v1.Endpoints) (exists bool) {
+// GetConfigMapResponse extracts the 'body.html' and 'status' from a configmap,
+// keeping the given defaults for missing or invalid values
+func GetConfigMapResponse(cm *v1.ConfigMap, status int, body string) (int, string) {
+	if cm != nil {
+		if val, ok := cm.Data[ConfigMapBody]; ok {
+			body = val
+		}
+		if val, ok := cm.Data[ConfigMapStatus]; ok {
+			if i, err := strconv.Atoi(val); err == nil && len(http.StatusText(i)) > 0 {
+				status = i
+			}
+		}
+	}
+	return status, body
+}
+
+// HasEndpointsAddresses verifies ready addresses, or not-ready addresses when included, are available
+func HasEndpointsAddresses(ep *v1.Endpoints, includeUnready bool) (exists bool) {
 	if ep != nil {
 		for _, subset := range ep.Subsets {
 			if len(subset.Addresses) > 0 {
 				return true
 			}
+			if includeUnready && len(subset.NotReadyAddresses) > 0 {
+				return true
+			}
 		}
 	}
 	return
 }
 
+// GetEndpointsAddresses extracts the ready '<ip>:<port>' addresses serving the matching port,
+// followed by the not-ready addresses when included
+func GetEndpointsAddresses(ep *v1.Endpoints, port intstr.IntOrString, protocol v1.Protocol, includeUnready bool) (addrs []string) {
+	if ep != nil {
+		var unready []string
+		for _, subset := range ep.Subsets {
+			subsetEp := &v1.Endpoints{Subsets: []v1.EndpointSubset{subset}}
+			if subsetPort, exists := GetEndpointsPort(subsetEp, port, protocol); exists {
+				for _, addr := range subset.Addresses {
+					addrs = append(addrs, net.JoinHostPort(addr.IP, strconv.Itoa(int(subsetPort.Port))))
+				}
+				if includeUnready {
+					for _, addr := range subset.NotReadyAddresses {
+						unready = append(unready, net.JoinHostPort(addr.IP, strconv.Itoa(int(subsetPort.Port))))
+					}
+				}
+			}
+		}
+		addrs = append(addrs, unready...)
+	}
+	return
+}
+
 // GetEndpointsPort extracts the matching endpoints port
 func GetEndpointsPort(ep *v1.Endpoints, port intstr.IntOrString, protocol v1.Protocol) (val v1.EndpointPort, exists bool) {
 	if ep != nil {
@@ -53,6 +109,19 @@ func GetSecretCert(sec *v1.Secret) (cert []byte, exists bool) {
 	return
 }
 
+// GetSecretTLS extracts the 'tls.crt', 'tls.key', and optional
+// 'argo-token' from a secret
+func GetSecretTLS(sec *v1.Secret) (cert, key, token []byte, exists bool) {
+	if sec != nil {
+		var ok bool
+		cert, exists = sec.Data[TLSCert]
+		key, ok = sec.Data[TLSKey]
+		exists = exists && ok
+		token = sec.Data[ArgoToken]
+	}
+	return
+}
+
 // GetServicePort extracts the matching service port
 func GetServicePort(svc *v1.Service, port networkingv1.ServiceBackendPort, protocol v1.Protocol) (val v1.ServicePort, exists bool) {
 	if svc != nil {
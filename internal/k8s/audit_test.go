@@ -12,8 +12,9 @@ import (
 func TestHasEndpointsAddresses(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
-		obj *v1.Endpoints
-		ok  bool
+		obj     *v1.Endpoints
+		unready bool
+		ok      bool
 	}{
 		"endpoints-nil": {
 			obj: nil,
@@ -57,12 +58,97 @@ func TestHasEndpointsAddresses(t *testing.T) {
 			},
 			ok: true,
 		},
+		"endpoints-unready-excluded": {
+			obj: &v1.Endpoints{
+				Subsets: []v1.EndpointSubset{
+					{
+						NotReadyAddresses: []v1.EndpointAddress{
+							{IP: "1.1.1.1"},
+						},
+					},
+				},
+			},
+			unready: false,
+			ok:      false,
+		},
+		"endpoints-unready-included": {
+			obj: &v1.Endpoints{
+				Subsets: []v1.EndpointSubset{
+					{
+						NotReadyAddresses: []v1.EndpointAddress{
+							{IP: "1.1.1.1"},
+						},
+					},
+				},
+			},
+			unready: true,
+			ok:      true,
+		},
 	} {
-		ok := HasEndpointsAddresses(test.obj)
+		ok := HasEndpointsAddresses(test.obj, test.unready)
 		assert.Equalf(t, test.ok, ok, "test '%s' exists mismatch", name)
 	}
 }
 
+func TestGetEndpointsAddresses(t *testing.T) {
+	t.Parallel()
+	ep := &v1.Endpoints{
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{
+					{IP: "10.0.0.1"},
+					{IP: "10.0.0.2"},
+				},
+				NotReadyAddresses: []v1.EndpointAddress{
+					{IP: "10.0.0.4"},
+				},
+				Ports: []v1.EndpointPort{
+					{Name: "http", Port: 8080, Protocol: v1.ProtocolTCP},
+				},
+			},
+			{
+				Addresses: []v1.EndpointAddress{
+					{IP: "10.0.0.3"},
+				},
+				Ports: []v1.EndpointPort{
+					{Name: "metrics", Port: 9090, Protocol: v1.ProtocolTCP},
+				},
+			},
+		},
+	}
+	for name, test := range map[string]struct {
+		in      *v1.Endpoints
+		port    intstr.IntOrString
+		unready bool
+		out     []string
+	}{
+		"endpoints-nil": {
+			in:   nil,
+			port: intstr.FromString("http"),
+			out:  nil,
+		},
+		"endpoints-no-port": {
+			in:   ep,
+			port: intstr.FromString("grpc"),
+			out:  nil,
+		},
+		"endpoints-has-port": {
+			in:   ep,
+			port: intstr.FromString("http"),
+			out:  []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+		},
+		"endpoints-has-port-unready": {
+			in:      ep,
+			port:    intstr.FromString("http"),
+			unready: true,
+			out:     []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.4:8080"},
+		},
+	} {
+		out := GetEndpointsAddresses(test.in, test.port, v1.ProtocolTCP, test.unready)
+		assert.Equalf(t, test.out, out, "test '%s' value mismatch", name)
+	}
+}
+
 func TestGetEndpointsPort(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
@@ -344,6 +430,50 @@ func TestGetEndpointsPort(t *testing.T) {
 	}
 }
 
+func TestGetConfigMapResponse(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in     *v1.ConfigMap
+		status int
+		body   string
+	}{
+		"configmap-nil": {
+			in:     nil,
+			status: 503,
+			body:   "default",
+		},
+		"configmap-empty": {
+			in:     &v1.ConfigMap{},
+			status: 503,
+			body:   "default",
+		},
+		"configmap-has-response": {
+			in: &v1.ConfigMap{
+				Data: map[string]string{
+					"body.html": "<h1>maintenance</h1>",
+					"status":    "200",
+				},
+			},
+			status: 200,
+			body:   "<h1>maintenance</h1>",
+		},
+		"configmap-invalid-status": {
+			in: &v1.ConfigMap{
+				Data: map[string]string{
+					"body.html": "<h1>maintenance</h1>",
+					"status":    "999",
+				},
+			},
+			status: 503,
+			body:   "<h1>maintenance</h1>",
+		},
+	} {
+		status, body := GetConfigMapResponse(test.in, 503, "default")
+		assert.Equalf(t, test.status, status, "test '%s' status mismatch", name)
+		assert.Equalf(t, test.body, body, "test '%s' body mismatch", name)
+	}
+}
+
 func TestGetSecretCert(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {
@@ -386,6 +516,61 @@ func TestGetSecretCert(t *testing.T) {
 	}
 }
 
+func TestGetSecretTLS(t *testing.T) {
+	t.Parallel()
+	for name, test := range map[string]struct {
+		in    *v1.Secret
+		cert  []byte
+		key   []byte
+		token []byte
+		ok    bool
+	}{
+		"secret-nil": {
+			in: nil,
+			ok: false,
+		},
+		"secret-no-key": {
+			in: &v1.Secret{
+				Data: map[string][]byte{
+					"tls.crt": []byte("fake-cert"),
+				},
+			},
+			cert: []byte("fake-cert"),
+			ok:   false,
+		},
+		"secret-has-tls": {
+			in: &v1.Secret{
+				Data: map[string][]byte{
+					"tls.crt": []byte("fake-cert"),
+					"tls.key": []byte("fake-key"),
+				},
+			},
+			cert: []byte("fake-cert"),
+			key:  []byte("fake-key"),
+			ok:   true,
+		},
+		"secret-has-tls-token": {
+			in: &v1.Secret{
+				Data: map[string][]byte{
+					"tls.crt":    []byte("fake-cert"),
+					"tls.key":    []byte("fake-key"),
+					"argo-token": []byte("fake-token"),
+				},
+			},
+			cert:  []byte("fake-cert"),
+			key:   []byte("fake-key"),
+			token: []byte("fake-token"),
+			ok:    true,
+		},
+	} {
+		cert, key, token, ok := GetSecretTLS(test.in)
+		assert.Equalf(t, test.cert, cert, "test '%s' cert mismatch", name)
+		assert.Equalf(t, test.key, key, "test '%s' key mismatch", name)
+		assert.Equalf(t, test.token, token, "test '%s' token mismatch", name)
+		assert.Equalf(t, test.ok, ok, "test '%s' condition mismatch", name)
+	}
+}
+
 func TestGetServicePort(t *testing.T) {
 	t.Parallel()
 	for name, test := range map[string]struct {